@@ -15,13 +15,19 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"net"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/uswitch/k8sc/official"
 	http "github.com/uswitch/kiam/pkg/aws/metadata"
+	"github.com/uswitch/kiam/pkg/k8s"
 	kiamserver "github.com/uswitch/kiam/pkg/server"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 type agentCommand struct {
@@ -31,53 +37,228 @@ type agentCommand struct {
 	clientOptions
 	*http.ServerOptions
 
-	iptables       bool
-	iptablesRemove bool
-	hostIP         string
-	hostInterface  string
+	iptables                   bool
+	iptablesRemove             bool
+	interceptBackend           string
+	interceptReconcileInterval time.Duration
+	hostIP                     string
+	hostIPv6                   string
+	hostInterfaces             []string
+	interceptSourceCIDRs       []string
+	trustedProxyCIDRs          []string
+	proxyCacheTTLs             []string
+
+	sidecar             bool
+	podIP               string
+	podUID              string
+	serviceAccountToken string
+
+	containerCredentials         bool
+	containerCredentialsAudience string
+
+	nodeCredentialsNamespaces []string
+	nodeCredentialsSelector   string
+
+	rateLimit      float64
+	rateLimitBurst int
+
+	cache       bool
+	cacheConfig kiamserver.ClientCacheConfig
+	watchPods   bool
+	watchNode   bool
+	nodeName    string
+	kubeConfig  string
 }
 
 func (cmd *agentCommand) Bind(parser parser) {
 	cmd.logOptions.bind(parser)
 	cmd.telemetryOptions.bind(parser)
-	cmd.tlsOptions.bind(parser)
+	cmd.tlsOptions.bindOptionalCertificate(parser)
 	cmd.clientOptions.bind(parser)
 
 	cmd.ServerOptions = http.DefaultOptions()
 
 	parser.Flag("port", "HTTP port").Default("3100").IntVar(&cmd.ListenPort)
+	parser.Flag("listen-host", "Address to listen on. Defaults to all addresses, or 127.0.0.1 with --sidecar.").StringVar(&cmd.ListenHost)
 	parser.Flag("allow-ip-query", "Allow client IP to be specified with ?ip. Development use only.").Default("false").BoolVar(&cmd.AllowIPQuery)
 	parser.Flag("allow-route-regexp", "Only routes matching this regular expression will be proxied").Default("^$").RegexpVar(&cmd.AllowRouteRegexp)
+	parser.Flag("allow-route-regexp-limit", "Proxy routes matching this regular expression by the iam.amazonaws.com/allow-route-regexp annotation of the requesting pod, or its namespace, rather than --allow-route-regexp. Set it to --allow-route-regexp to only let annotations narrow the proxied routes. Annotations are ignored when unset. Without --cache, each proxied request within the limit requests the pod's annotations from the server.").RegexpVar(&cmd.AllowRouteRegexpLimit)
+	parser.Flag("list-all-roles", "List all the roles in pods' iam.amazonaws.com/roles annotation at iam/security-credentials/, one per line after the default role, rather than only the default role. Some SDKs, such as botocore, can't parse the listing.").Default("false").BoolVar(&cmd.ListAllRoles)
+	parser.Flag("region-annotation", "Answer placement/region with the iam.amazonaws.com/region annotation of the requesting pod, or its namespace, when it has one. Without --cache, each placement/region request requests the pod's annotations from the server.").Default("false").BoolVar(&cmd.RegionAnnotation)
+	parser.Flag("proxy-cache-ttl", "Cache proxied responses for paths matching a regular expression for a duration, given as PATTERN=TTL such as ^/latest/meta-data/placement/=1h. May be repeated; the first matching pattern is used. Credentials are never cached.").StringsVar(&cmd.proxyCacheTTLs)
+	parser.Flag("imdsv2", "How IMDSv2 session tokens are handled. proxy forwards token requests to the instance metadata service; optional issues and validates per-pod tokens; required also rejects requests without a token.").Default(http.IMDSv2Proxy).EnumVar(&cmd.IMDSv2, http.IMDSv2Proxy, http.IMDSv2Optional, http.IMDSv2Required)
+
+	parser.Flag("identify-host-network-pods", "Identify requests from hostNetwork pods, which share the node's IP, by the pod owning the connection. Requires hostPID.").Default("false").BoolVar(&cmd.IdentifyHostNetworkPods)
+	parser.Flag("proc-root", "Path of the host's /proc, used to identify hostNetwork pods.").Default(cmd.ProcRoot).StringVar(&cmd.ProcRoot)
+	parser.Flag("proxy-protocol", "Identify clients of connections from trusted proxies, such as sidecars capturing metadata requests, by their PROXY protocol (v1 or v2) header.").Default("false").BoolVar(&cmd.ProxyProtocol)
+	parser.Flag("trust-forwarded-for", "Identify clients of requests from trusted proxies by their X-Forwarded-For header.").Default("false").BoolVar(&cmd.TrustForwardedFor)
+	parser.Flag("trusted-proxy-cidr", "Address range of proxies trusted to identify clients with --proxy-protocol or --trust-forwarded-for. May be repeated.").StringsVar(&cmd.trustedProxyCIDRs)
 
-	parser.Flag("iptables", "Add IPTables rules").Default("false").BoolVar(&cmd.iptables)
-	parser.Flag("iptables-remove", "Remove iptables rules at shutdown").Default("true").BoolVar(&cmd.iptablesRemove)
+	parser.Flag("iptables", "Add rules intercepting metadata requests, using the --intercept-backend").Default("false").BoolVar(&cmd.iptables)
+	parser.Flag("iptables-remove", "Remove interception rules at shutdown").Default("true").BoolVar(&cmd.iptablesRemove)
+	parser.Flag("intercept-backend", "How metadata requests are intercepted: iptables, nftables, or auto to use nftables when the host's iptables is the nf_tables variant, and iptables otherwise.").Default(interceptAuto).EnumVar(&cmd.interceptBackend, interceptAuto, interceptIPTables, interceptNFTables)
+	parser.Flag("intercept-reconcile-interval", "How often the interception rules are checked, and re-added when they're missing or changed. 0 disables.").Default("10s").DurationVar(&cmd.interceptReconcileInterval)
 	parser.Flag("host", "Host IP address.").Envar("HOST_IP").Required().StringVar(&cmd.hostIP)
-	parser.Flag("host-interface", "Network interface for pods to configure IPTables.").Default("docker0").StringVar(&cmd.hostInterface)
+	parser.Flag("host-ipv6", "Host IPv6 address, on dual-stack nodes whose --host is IPv4. IPv6 metadata requests are redirected to it.").Envar("HOST_IPV6").StringVar(&cmd.hostIPv6)
+	parser.Flag("host-interface", "Network interface pod traffic originates from, or is excluded from when prefixed with !. A trailing + matches any suffix, as in cali+. May be repeated.").Default("docker0").StringsVar(&cmd.hostInterfaces)
+	parser.Flag("intercept-source-cidr", "Only intercept metadata requests from this source range. May be repeated; address families without a range are intercepted from any source.").StringsVar(&cmd.interceptSourceCIDRs)
+
+	parser.Flag("sidecar", "Run as a sidecar in the pod, rather than a DaemonSet intercepting requests: requests are identified as from the pod and the server is authenticated with the pod's service account token, without a client certificate.").Default("false").BoolVar(&cmd.sidecar)
+	parser.Flag("pod-ip", "IP address of the pod, with --sidecar. Set from the downward API's status.podIP.").Envar("POD_IP").StringVar(&cmd.podIP)
+	parser.Flag("pod-uid", "UID of the pod, with --sidecar. Set from the downward API's metadata.uid.").Envar("POD_UID").StringVar(&cmd.podUID)
+	parser.Flag("service-account-token", "Path of the pod's projected service account token, issued for the server's --agent-service-account-token-audience, with --sidecar.").Default("/var/run/secrets/kiam/serviceaccount/token").StringVar(&cmd.serviceAccountToken)
+
+	parser.Flag("container-credentials", "Serve credentials at /v1/credentials to SDKs configured with AWS_CONTAINER_CREDENTIALS_FULL_URI and AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE, identifying pods by their service account token. Can't be used with --imdsv2=required. With --iptables requests for 169.254.170.23 are intercepted too. Requires permission to create TokenReviews.").Default("false").BoolVar(&cmd.containerCredentials)
+	parser.Flag("container-credentials-audience", "Audience of the projected service account tokens pods authenticate with for --container-credentials.").Default("kiam").StringVar(&cmd.containerCredentialsAudience)
+
+	parser.Flag("node-credentials-namespace", "Pass credentials requests from pods in this namespace through to the instance metadata service, returning the node's instance profile credentials. May be repeated. Requires --node-name and permission to list and watch pods.").StringsVar(&cmd.nodeCredentialsNamespaces)
+	parser.Flag("node-credentials-selector", "Pass credentials requests from pods matching this label selector through to the instance metadata service, returning the node's instance profile credentials. Requires --node-name and permission to list and watch pods.").StringVar(&cmd.nodeCredentialsSelector)
+
+	parser.Flag("rate-limit", "Requests per second each pod may make for roles, credentials, session tokens and proxied paths, beyond which it's answered with 429 Too Many Requests. 0 disables. Throttled requests are counted by namespace when the node's pods are watched, for --cache-watch-pods or node credentials passthrough.").Default("0").Float64Var(&cmd.rateLimit)
+	parser.Flag("rate-limit-burst", "Requests each pod may make at once, above --rate-limit.").Default("20").IntVar(&cmd.rateLimitBurst)
+
+	cmd.cacheConfig = kiamserver.DefaultClientCacheConfig()
+	parser.Flag("cache", "Cache roles and credentials from the server, and use them while the server can't be reached. Use with --cache-watch-pods, otherwise a pod reusing a recently deleted pod's IP may be answered with its cached role and credentials.").Default("false").BoolVar(&cmd.cache)
+	parser.Flag("cache-role-ttl", "How long a pod's cached role is used before it's requested again.").Default(cmd.cacheConfig.RoleTTL.String()).DurationVar(&cmd.cacheConfig.RoleTTL)
+	parser.Flag("cache-role-max-stale", "How much longer a pod's cached role is used while the server can't be reached.").Default(cmd.cacheConfig.RoleMaxStale.String()).DurationVar(&cmd.cacheConfig.RoleMaxStale)
+	parser.Flag("cache-credentials-refresh", "How soon before their expiry cached credentials are requested again. Should be less than the server's --session-refresh.").Default(cmd.cacheConfig.CredentialsRefresh.String()).DurationVar(&cmd.cacheConfig.CredentialsRefresh)
+	parser.Flag("cache-request-timeout", "Timeout for requests to the server made on behalf of cache callers.").Default(cmd.cacheConfig.RequestTimeout.String()).DurationVar(&cmd.cacheConfig.RequestTimeout)
+	parser.Flag("cache-watch-pods", "Watch pods on this node, invalidating the cache as they're deleted, and namespaces, invalidating the cache for their pods as their policy changes. Requires permission to list and watch pods and namespaces.").Default("false").BoolVar(&cmd.watchPods)
+	parser.Flag("watch-node-credentials", "Receive the roles and credentials of pods on this node from the server as they change, rather than requesting them. Requires --cache, and the server to bind agents to their nodes with --agent-node-identity.").Default("false").BoolVar(&cmd.watchNode)
+	parser.Flag("node-name", "Name of the node the agent runs on.").Envar("NODE_NAME").StringVar(&cmd.nodeName)
+	parser.Flag("kubeconfig", "Path to .kube/config (or empty for in-cluster)").Default("").StringVar(&cmd.kubeConfig)
+}
+
+// configureSidecar identifies requests as from the pod the agent runs in, and
+// checks no options that need the node are set.
+func (opts *agentCommand) configureSidecar() error {
+	if opts.podIP == "" || opts.podUID == "" {
+		return fmt.Errorf("--pod-ip and --pod-uid are required with --sidecar")
+	}
+	if net.ParseIP(opts.podIP) == nil {
+		return fmt.Errorf("pod-ip isn't an IP address: %s", opts.podIP)
+	}
+	if opts.iptables || opts.watchPods || opts.watchNode || opts.IdentifyHostNetworkPods || opts.NodeCredentials != nil {
+		return fmt.Errorf("--iptables, --cache-watch-pods, --watch-node-credentials, --identify-host-network-pods and --node-credentials-namespace or --node-credentials-selector can't be used with --sidecar")
+	}
+
+	opts.PodIP = net.ParseIP(opts.podIP).String()
+	opts.PodUID = opts.podUID
+	if opts.ListenHost == "" {
+		opts.ListenHost = "127.0.0.1"
+	}
+	return nil
 }
 
 // run is the actual run implementation.
 func (opts *agentCommand) run() error {
 	opts.configureLogger()
 
-	if opts.iptables {
-		log.Infof("configuring iptables")
-		rules := newIPTablesRules(opts.hostIP, opts.ListenPort, opts.hostInterface)
-		err := rules.Add()
+	for _, cidr := range opts.trustedProxyCIDRs {
+		_, trusted, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy cidr: %s", err.Error())
+		}
+		opts.TrustedProxies = append(opts.TrustedProxies, trusted)
+	}
+
+	for _, value := range opts.proxyCacheTTLs {
+		ttl, err := http.ParseProxyCacheTTL(value)
+		if err != nil {
+			return fmt.Errorf("invalid proxy cache ttl: %s", err.Error())
+		}
+		opts.ProxyCacheTTLs = append(opts.ProxyCacheTTLs, ttl)
+	}
+
+	if len(opts.nodeCredentialsNamespaces) > 0 || opts.nodeCredentialsSelector != "" {
+		selector, err := labels.Parse(opts.nodeCredentialsSelector)
+		if err != nil {
+			return fmt.Errorf("invalid node credentials selector: %s", err.Error())
+		}
+		opts.NodeCredentials = &http.NodeCredentialsAllowlist{Namespaces: opts.nodeCredentialsNamespaces, Selector: selector}
+	}
+
+	if opts.rateLimit < 0 || opts.rateLimitBurst < 1 {
+		return fmt.Errorf("--rate-limit can't be negative, and --rate-limit-burst must be at least 1")
+	}
+	if opts.rateLimit > 0 {
+		opts.RateLimit = &http.RateLimit{QPS: float32(opts.rateLimit), Burst: opts.rateLimitBurst}
+	}
+
+	if opts.sidecar {
+		err := opts.configureSidecar()
 		if err != nil {
-			log.Errorf("error configuring iptables: %s", err.Error())
 			return err
 		}
-		if opts.iptablesRemove {
-			defer func() {
-				log.Infof("undoing iptables changes")
-				rules.Remove()
-			}()
+	} else if opts.certificatePath == "" || opts.keyPath == "" {
+		return fmt.Errorf("--cert and --key are required unless running with --sidecar")
+	}
+
+	if opts.containerCredentials {
+		kubeClient, err := official.NewClient(opts.kubeConfig)
+		if err != nil {
+			return err
 		}
+		// the server's reviewer, caching reviews by the token's hash
+		opts.ContainerCredentials = kiamserver.NewKubernetesTokenReviewer(kubeClient, opts.containerCredentialsAudience)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	if opts.iptables {
+		hosts := []string{opts.hostIP}
+		if opts.hostIPv6 != "" {
+			if !isIPv6(opts.hostIPv6) {
+				return fmt.Errorf("host-ipv6 isn't an IPv6 address: %s", opts.hostIPv6)
+			}
+			hosts = append(hosts, opts.hostIPv6)
+		}
+		config := interceptConfig{
+			hosts:          hosts,
+			kiamPort:       opts.ListenPort,
+			hostInterfaces: opts.hostInterfaces,
+			sourceCIDRs:    opts.interceptSourceCIDRs,
+
+			containerCredentials: opts.containerCredentials,
+		}
+		rules, err := newInterceptor(opts.interceptBackend, config)
+		if err != nil {
+			return err
+		}
+		log.Infof("configuring interception of metadata requests")
+		err = rules.Add()
+		if err != nil {
+			log.Errorf("error configuring interception: %s", err.Error())
+			return err
+		}
+
+		reconciled := make(chan struct{})
+		go func() {
+			defer close(reconciled)
+			if opts.interceptReconcileInterval > 0 {
+				reconcileInterception(ctx, rules, opts.interceptReconcileInterval)
+			}
+		}()
+		defer func() {
+			// stop reconciling so the rules aren't re-added once removed
+			cancel()
+			<-reconciled
+			if opts.iptablesRemove {
+				log.Infof("undoing interception changes")
+				if err := rules.Remove(); err != nil {
+					log.Errorf("error removing interception rules: %s", err.Error())
+				}
+			}
+		}()
+
+		err = verifyInterception(rules)
+		if err != nil {
+			log.Errorf("error verifying interception: %s", err.Error())
+			return err
+		}
+	}
+
 	go opts.telemetryOptions.start(ctx, "agent")
 
 	stopChan := make(chan os.Signal, 8)
@@ -87,7 +268,13 @@ func (opts *agentCommand) run() error {
 	defer cancelCtxGateway()
 
 	b := kiamserver.NewKiamGatewayBuilder().WithAddress(opts.serverAddress).WithKeepAlive(opts.keepaliveParams)
-	_, err := b.WithTLS(opts.certificatePath, opts.keyPath, opts.caPath)
+	var err error
+	if opts.sidecar {
+		_, err = b.WithServerCA(opts.caPath)
+		b.WithServiceAccountToken(opts.serviceAccountToken)
+	} else {
+		_, err = b.WithTLS(opts.certificatePath, opts.keyPath, opts.caPath)
+	}
 	if err != nil {
 		log.Errorf("error configuring TLS: %s", err.Error())
 		return err
@@ -100,7 +287,43 @@ func (opts *agentCommand) run() error {
 	}
 	defer gateway.Close()
 
-	server, err := http.NewWebServer(opts.ServerOptions, gateway)
+	var client kiamserver.Client = gateway
+	var released func(ip string)
+	if opts.cache {
+		cachingClient := kiamserver.NewCachingClient(gateway, opts.cacheConfig)
+		if opts.watchPods {
+			released = cachingClient.Invalidate
+		} else if !opts.sidecar {
+			log.Warnf("caching without --cache-watch-pods, pods reusing a deleted pod's ip may be answered with its cached role and credentials")
+		}
+		if opts.watchNode {
+			if opts.nodeName == "" {
+				return fmt.Errorf("--node-name is required to watch node credentials")
+			}
+			go cachingClient.WatchNode(ctx, gateway, opts.nodeName)
+		}
+		client = cachingClient
+	} else if opts.watchNode {
+		return fmt.Errorf("--watch-node-credentials requires --cache")
+	}
+
+	if released != nil || opts.NodeCredentials != nil {
+		watcher, err := opts.watchNodePods(ctx, released)
+		if err != nil {
+			log.Errorf("error watching pods: %s", err.Error())
+			return err
+		}
+		if opts.NodeCredentials != nil {
+			opts.NodeCredentials.Pods = watcher
+		}
+		// throttled requests are counted by namespace when pods are already
+		// watched
+		if opts.RateLimit != nil {
+			opts.RateLimit.Pods = watcher
+		}
+	}
+
+	server, err := http.NewWebServer(opts.ServerOptions, client)
 	if err != nil {
 		log.Errorf("error creating agent http server: %s", err.Error())
 		return err
@@ -129,6 +352,63 @@ func (opts *agentCommand) run() error {
 	return nil
 }
 
+// watchNodePods watches the pods scheduled to this node, so that requests can
+// be matched to them, calling released, when set, with the IP addresses of
+// pods as they're deleted, completed or change role, or their namespace's
+// permitted or default roles change.
+func (opts *agentCommand) watchNodePods(ctx context.Context, released func(ip string)) (*k8s.PodIPWatcher, error) {
+	if opts.nodeName == "" {
+		return nil, fmt.Errorf("--node-name is required to watch pods")
+	}
+
+	kubeClient, err := official.NewClient(opts.kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// resyncs aren't needed: only changes invalidate the cache
+	source := k8s.NewNodeListWatch(kubeClient, k8s.ResourcePods, opts.nodeName)
+	watcher := k8s.NewPodIPWatcher(source, 0, released)
+	if err := watcher.Run(ctx); err != nil {
+		return nil, err
+	}
+	if released == nil {
+		return watcher, nil
+	}
+
+	namespaces := k8s.NewNamespaceCache(k8s.NewListWatch(kubeClient, k8s.ResourceNamespaces), 0)
+	if err := namespaces.Run(ctx); err != nil {
+		return nil, err
+	}
+	go releaseChangedNamespaces(ctx, namespaces, watcher, released)
+
+	return watcher, nil
+}
+
+// releaseChangedNamespaces calls released with the IP addresses of the node's
+// pods in namespaces whose permitted or default roles change, so that their
+// cached roles and credentials are requested from the server again.
+func releaseChangedNamespaces(ctx context.Context, namespaces *k8s.NamespaceCache, watcher *k8s.PodIPWatcher, released func(ip string)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case change := <-namespaces.PermittedChanges():
+			namespace := change.Namespace.GetName()
+			pods, err := watcher.ListPodsInNamespace(namespace)
+			if err != nil {
+				log.WithField("namespace.name", namespace).Errorf("error listing pods in changed namespace: %s", err.Error())
+				continue
+			}
+			for _, pod := range pods {
+				for _, ip := range k8s.PodIPs(pod) {
+					released(ip)
+				}
+			}
+		}
+	}
+}
+
 func (opts *agentCommand) Run() {
 	if err := opts.run(); err != nil {
 		log.Fatalf("fatal error: %s", err.Error())
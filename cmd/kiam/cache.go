@@ -0,0 +1,110 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	kiamserver "github.com/uswitch/kiam/pkg/server"
+	pb "github.com/uswitch/kiam/proto"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+type cacheCommand struct {
+	logOptions
+	tlsOptions
+	clientOptions
+	timeout time.Duration
+
+	evictRole      string
+	evictNamespace string
+	evictAll       bool
+}
+
+func (cmd *cacheCommand) Bind(parser *kingpin.CmdClause) {
+	cmd.logOptions.bind(parser)
+	cmd.tlsOptions.bind(parser)
+	cmd.clientOptions.bind(parser)
+
+	parser.Flag("timeout", "Timeout for admin requests").Default("5s").DurationVar(&cmd.timeout)
+
+	parser.Command("list", "list the credentials cached by the server")
+
+	evict := parser.Command("evict", "evict credentials cached by the server")
+	evict.Flag("role", "Evict credentials for the role").StringVar(&cmd.evictRole)
+	evict.Flag("namespace", "Evict credentials for roles used by Pods in the namespace").StringVar(&cmd.evictNamespace)
+	evict.Flag("all", "Evict all credentials").BoolVar(&cmd.evictAll)
+}
+
+func (cmd *cacheCommand) gateway() (*kiamserver.KiamGateway, error) {
+	ctxGateway, cancelCtxGateway := context.WithTimeout(context.Background(), cmd.timeoutKiamGateway)
+	defer cancelCtxGateway()
+
+	b, err := kiamserver.NewKiamGatewayBuilder().WithAddress(cmd.serverAddress).WithKeepAlive(cmd.keepaliveParams).WithTLS(cmd.certificatePath, cmd.keyPath, cmd.caPath)
+	if err != nil {
+		return nil, err
+	}
+	return b.Build(ctxGateway)
+}
+
+func (cmd *cacheCommand) List() {
+	cmd.configureLogger()
+
+	gateway, err := cmd.gateway()
+	if err != nil {
+		log.Fatalf("error creating server gateway: %s", err.Error())
+	}
+	defer gateway.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cmd.timeout)
+	defer cancel()
+
+	cached, err := gateway.ListCachedCredentials(ctx)
+	if err != nil {
+		log.Fatalf("error listing cached credentials: %s", err.Error())
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ROLE ARN\tSESSION NAME\tEXPIRATION\tLAST UPDATED")
+	for _, c := range cached {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.RoleArn, c.SessionName, c.Expiration, c.LastUpdated)
+	}
+	w.Flush()
+}
+
+func (cmd *cacheCommand) Evict() {
+	cmd.configureLogger()
+
+	gateway, err := cmd.gateway()
+	if err != nil {
+		log.Fatalf("error creating server gateway: %s", err.Error())
+	}
+	defer gateway.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cmd.timeout)
+	defer cancel()
+
+	req := &pb.EvictCachedCredentialsRequest{Role: cmd.evictRole, Namespace: cmd.evictNamespace, All: cmd.evictAll}
+	evicted, err := gateway.EvictCachedCredentials(ctx, req)
+	if err != nil {
+		log.Fatalf("error evicting cached credentials: %s", err.Error())
+	}
+
+	fmt.Printf("evicted %d cached credentials\n", evicted)
+}
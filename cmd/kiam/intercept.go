@@ -0,0 +1,227 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	interceptAuto     = "auto"
+	interceptIPTables = "iptables"
+	interceptNFTables = "nftables"
+)
+
+// interceptor redirects pods' requests for the metadata service to the agent.
+type interceptor interface {
+	// Add installs the rules, replacing any left by a previous agent.
+	Add() error
+	// Verify checks the rules are installed.
+	Verify() error
+	// Remove uninstalls the rules.
+	Remove() error
+}
+
+// interceptConfig describes which requests are redirected to the agent.
+type interceptConfig struct {
+	// hosts are the agent's addresses, one per address family intercepted.
+	hosts    []string
+	kiamPort int
+	// hostInterfaces are patterns of the interfaces pod traffic originates
+	// from, or is excluded from when prefixed with !. A trailing + matches
+	// any suffix.
+	hostInterfaces []string
+	// sourceCIDRs restrict interception to requests from the ranges. Address
+	// families without a range are intercepted from any source.
+	sourceCIDRs []string
+	// containerCredentials also intercepts requests for the container
+	// credentials address.
+	containerCredentials bool
+}
+
+func (c interceptConfig) validate() error {
+	if len(c.hostInterfaces) == 0 {
+		return fmt.Errorf("at least one host interface must be specified")
+	}
+	for _, pattern := range c.hostInterfaces {
+		if strings.TrimPrefix(pattern, "!") == "" {
+			return fmt.Errorf("invalid host interface: %q", pattern)
+		}
+	}
+	for _, cidr := range c.sourceCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid source cidr: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// includedInterfaces returns the patterns of the interfaces to intercept
+// requests from. When there are none, requests from all interfaces not
+// excluded are intercepted.
+func (c interceptConfig) includedInterfaces() []string {
+	var included []string
+	for _, pattern := range c.hostInterfaces {
+		if !strings.HasPrefix(pattern, "!") {
+			included = append(included, pattern)
+		}
+	}
+	return included
+}
+
+// excludedInterfaces returns the patterns of the interfaces not to intercept
+// requests from, without their ! prefix.
+func (c interceptConfig) excludedInterfaces() []string {
+	var excluded []string
+	for _, pattern := range c.hostInterfaces {
+		if strings.HasPrefix(pattern, "!") {
+			excluded = append(excluded, strings.TrimPrefix(pattern, "!"))
+		}
+	}
+	return excluded
+}
+
+// sourceCIDRsFor returns the source ranges in the host's address family.
+// Ranges are normalised to their network address, as iptables and nftables
+// list them, so that the rules are verified.
+func (c interceptConfig) sourceCIDRsFor(host string) []string {
+	var cidrs []string
+	for _, cidr := range c.sourceCIDRs {
+		ip, ipnet, _ := net.ParseCIDR(cidr)
+		if (ip.To4() == nil) == isIPv6(host) {
+			cidrs = append(cidrs, ipnet.String())
+		}
+	}
+	return cidrs
+}
+
+// destinations returns the addresses intercepted in the host's address family.
+func (c interceptConfig) destinations(host string) []string {
+	if isIPv6(host) {
+		if c.containerCredentials {
+			return []string{metadataAddressIPv6, containerCredentialsAddressIPv6}
+		}
+		return []string{metadataAddressIPv6}
+	}
+	if c.containerCredentials {
+		return []string{metadataAddress, containerCredentialsAddress}
+	}
+	return []string{metadataAddress}
+}
+
+// kiamAddress returns host:port, bracketing IPv6 hosts.
+func (c interceptConfig) kiamAddress(host string) string {
+	return net.JoinHostPort(host, strconv.Itoa(c.kiamPort))
+}
+
+// newInterceptor returns the backend's interceptor, detecting which to use
+// when the backend is interceptAuto.
+func newInterceptor(backend string, config interceptConfig) (interceptor, error) {
+	err := config.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if backend == interceptAuto {
+		backend = detectInterceptBackend()
+		log.Infof("detected %s for intercepting metadata requests", backend)
+	}
+
+	switch backend {
+	case interceptIPTables:
+		return newIPTablesRules(config), nil
+	case interceptNFTables:
+		return newNFTablesRules(config), nil
+	default:
+		return nil, fmt.Errorf("unknown intercept backend: %s", backend)
+	}
+}
+
+// detectInterceptBackend uses nftables when the host's iptables is the
+// nftables variant, and iptables otherwise: rules added natively with nftables
+// to a host whose rules are managed with legacy iptables, such as kube-proxy's,
+// may not be evaluated as expected.
+func detectInterceptBackend() string {
+	out, err := exec.Command("iptables", "--version").Output()
+	if err != nil {
+		if _, lookErr := exec.LookPath("nft"); lookErr == nil {
+			log.Warnf("error detecting iptables variant, using nftables: %s", err.Error())
+			return interceptNFTables
+		}
+		return interceptIPTables
+	}
+	return interceptBackendForIPTables(string(out))
+}
+
+// interceptBackendForIPTables returns the backend for the output of iptables
+// --version: nftables for the nf_tables variant, otherwise iptables.
+func interceptBackendForIPTables(version string) string {
+	if strings.Contains(version, "(nf_tables)") {
+		return interceptNFTables
+	}
+	return interceptIPTables
+}
+
+// verifyInterception checks the rules are installed, recording whether they
+// are.
+func verifyInterception(rules interceptor) error {
+	err := rules.Verify()
+	if err != nil {
+		interceptionActive.Set(0)
+		return err
+	}
+	interceptionActive.Set(1)
+	return nil
+}
+
+// reconcileInterception re-adds the rules whenever they're found missing or
+// changed, for example after the host's rules are flushed, until the context
+// is cancelled.
+func reconcileInterception(ctx context.Context, rules interceptor, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := verifyInterception(rules)
+		if err == nil {
+			continue
+		}
+
+		log.Warnf("metadata requests aren't intercepted, re-adding rules: %s", err.Error())
+		interceptionRepaired.Inc()
+		err = rules.Add()
+		if err != nil {
+			log.Errorf("error re-adding interception rules: %s", err.Error())
+			continue
+		}
+		err = verifyInterception(rules)
+		if err != nil {
+			log.Errorf("error verifying interception: %s", err.Error())
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestValidatesInterceptConfig(t *testing.T) {
+	var tests = []struct {
+		name   string
+		config interceptConfig
+		valid  bool
+	}{
+		{"SingleInterface", interceptConfig{hostInterfaces: []string{"docker0"}}, true},
+		{"MultipleInterfaces", interceptConfig{hostInterfaces: []string{"cali+", "eni+", "!eth0"}}, true},
+		{"NoInterfaces", interceptConfig{}, false},
+		{"EmptyExcludedInterface", interceptConfig{hostInterfaces: []string{"!"}}, false},
+		{"SourceCIDRs", interceptConfig{hostInterfaces: []string{"cali+"}, sourceCIDRs: []string{"10.0.0.0/8", "fd00::/8"}}, true},
+		{"InvalidSourceCIDR", interceptConfig{hostInterfaces: []string{"cali+"}, sourceCIDRs: []string{"10.0.0.0"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.validate()
+			if tt.valid && err != nil {
+				t.Error("expected valid config, was", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("expected invalid config")
+			}
+		})
+	}
+}
+
+func TestSourceCIDRsForHostAddressFamily(t *testing.T) {
+	config := interceptConfig{sourceCIDRs: []string{"10.0.0.0/8", "fd00::/8", "192.168.0.0/16"}}
+
+	var tests = []struct {
+		host     string
+		expected []string
+	}{
+		{"10.0.0.1", []string{"10.0.0.0/8", "192.168.0.0/16"}},
+		{"fd00::1", []string{"fd00::/8"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			cidrs := config.sourceCIDRsFor(tt.host)
+			if !reflect.DeepEqual(cidrs, tt.expected) {
+				t.Errorf("expected %v, was %v", tt.expected, cidrs)
+			}
+		})
+	}
+
+	if cidrs := (interceptConfig{sourceCIDRs: []string{"10.0.0.0/8"}}).sourceCIDRsFor("fd00::1"); len(cidrs) != 0 {
+		t.Error("expected no ranges for address family without any, was", cidrs)
+	}
+}
+
+func TestNormalisesSourceCIDRs(t *testing.T) {
+	config := interceptConfig{kiamPort: 8181, hostInterfaces: []string{"cali+"}, sourceCIDRs: []string{"10.0.0.1/8", "fd00::1/8"}}
+
+	if cidrs := config.sourceCIDRsFor("10.0.0.1"); !reflect.DeepEqual(cidrs, []string{"10.0.0.0/8"}) {
+		t.Error("expected network address, was", cidrs)
+	}
+	if cidrs := config.sourceCIDRsFor("fd00::1"); !reflect.DeepEqual(cidrs, []string{"fd00::/8"}) {
+		t.Error("expected network address, was", cidrs)
+	}
+
+	listed := []string{`iifname "cali*" ip saddr 10.0.0.0/8 ip daddr 169.254.169.254 tcp dport 80 dnat to 10.0.0.1:8181`}
+	if err := verifyNFTRules(listed, newNFTablesRules(config).rules("10.0.0.1")); err != nil {
+		t.Error("expected listed nftables rules to be verified, was", err)
+	}
+}
+
+func TestDetectsInterceptBackendFromIPTablesVariant(t *testing.T) {
+	var tests = []struct {
+		version  string
+		expected string
+	}{
+		{"iptables v1.8.7 (nf_tables)", interceptNFTables},
+		{"iptables v1.8.7 (legacy)", interceptIPTables},
+		{"iptables v1.6.1", interceptIPTables},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			if backend := interceptBackendForIPTables(tt.version); backend != tt.expected {
+				t.Errorf("expected %s, was %s", tt.expected, backend)
+			}
+		})
+	}
+}
+
+// stubInterceptor fails verification until its rules are added again.
+type stubInterceptor struct {
+	mu        sync.Mutex
+	installed bool
+	adds      int
+}
+
+func (i *stubInterceptor) Add() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.installed = true
+	i.adds++
+	return nil
+}
+
+func (i *stubInterceptor) Verify() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if !i.installed {
+		return fmt.Errorf("rules missing")
+	}
+	return nil
+}
+
+func (i *stubInterceptor) Remove() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.installed = false
+	return nil
+}
+
+func (i *stubInterceptor) addCount() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.adds
+}
+
+func TestReconcilesMissingInterceptionRules(t *testing.T) {
+	rules := &stubInterceptor{installed: true}
+	repaired := promtestutil.ToFloat64(interceptionRepaired)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reconcileInterception(ctx, rules, 10*time.Millisecond)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if adds := rules.addCount(); adds != 0 {
+		t.Error("expected installed rules not to be re-added, was", adds)
+	}
+
+	// flushed by an administrator
+	rules.Remove()
+	deadline := time.Now().Add(5 * time.Second)
+	for rules.addCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if adds := rules.addCount(); adds != 1 {
+		t.Error("expected missing rules to be re-added once, was", adds)
+	}
+	if count := promtestutil.ToFloat64(interceptionRepaired) - repaired; count != 1 {
+		t.Error("expected repair counted, was", count)
+	}
+	if active := promtestutil.ToFloat64(interceptionActive); active != 1 {
+		t.Error("expected interception reported active, was", active)
+	}
+}
@@ -15,6 +15,7 @@ package main
 
 import (
 	"fmt"
+	"net"
 	"strings"
 	"time"
 
@@ -22,73 +23,361 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// rules redirect requests for the metadata service to the agent, for each
+// address family the agent's host has an address in. The rules are kept in a
+// chain owned by the agent, jumped to from the start of nat PREROUTING.
 type rules struct {
-	host          string
-	kiamPort      int
-	hostInterface string
+	interceptConfig
 }
 
 const (
-	metadataAddress = "169.254.169.254"
+	metadataAddress     = "169.254.169.254"
+	metadataAddressIPv6 = "fd00:ec2::254"
+
+	// the container credentials addresses SDKs accept over plain HTTP
+	containerCredentialsAddress     = "169.254.170.23"
+	containerCredentialsAddressIPv6 = "fd00:ec2::23"
+
+	natTable   = "nat"
+	preRouting = "PREROUTING"
+	kiamChain  = "KIAM-PREROUTING"
 )
 
-func newIPTablesRules(host string, kiamPort int, hostInterface string) *rules {
-	return &rules{host: host, kiamPort: kiamPort, hostInterface: hostInterface}
+var jumpSpec = []string{"-j", kiamChain}
+
+func newIPTablesRules(config interceptConfig) *rules {
+	return &rules{interceptConfig: config}
+}
+
+func isIPv6(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.To4() == nil
 }
 
+// Add creates the chain and jump, replacing the chain's rules and moving the
+// jump to the start of PREROUTING when they've been changed.
 func (r *rules) Add() error {
-	ipt, err := iptables.New()
-	if err != nil {
-		return err
+	for _, host := range r.hosts {
+		ipt, err := newIPTables(host)
+		if err != nil {
+			return err
+		}
+
+		// previous versions added the rule to PREROUTING
+		if legacySpec := r.legacyRuleSpec(host); legacySpec != nil {
+			err = deleteAll(ipt, preRouting, legacySpec)
+			if err != nil {
+				return err
+			}
+		}
+
+		if r.verifyChain(ipt, host) != nil {
+			// creates the chain when it's missing
+			err = ipt.ClearChain(natTable, kiamChain)
+			if err != nil {
+				return err
+			}
+			for _, spec := range r.ruleSpecs(host) {
+				err = ipt.Append(natTable, kiamChain, spec...)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if verifyJump(ipt) != nil {
+			err = deleteAll(ipt, preRouting, jumpSpec)
+			if err != nil {
+				return err
+			}
+			err = ipt.Insert(natTable, preRouting, 1, jumpSpec...)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// newIPTables returns iptables or, for IPv6 hosts, ip6tables.
+func newIPTables(host string) (*iptables.IPTables, error) {
+	if isIPv6(host) {
+		return iptables.NewWithProtocol(iptables.ProtocolIPv6)
+	}
+	return iptables.New()
+}
+
+func iptablesCommand(host string) string {
+	if isIPv6(host) {
+		return "ip6tables"
 	}
+	return "iptables"
+}
+
+// ruleSpecs returns the chain's rules: requests from excluded interfaces
+// return before a rule redirecting requests for each included interface and
+// source range.
+func (r *rules) ruleSpecs(host string) [][]string {
+	var specs [][]string
+	for _, iface := range r.excludedInterfaces() {
+		specs = append(specs, []string{"-i", iface, "-j", "RETURN"})
+	}
+
+	ifaces := r.includedInterfaces()
+	if len(ifaces) == 0 {
+		ifaces = []string{""}
+	}
+	sources := r.sourceCIDRsFor(host)
+	if len(sources) == 0 {
+		sources = []string{""}
+	}
+	for _, destination := range r.destinations(host) {
+		for _, iface := range ifaces {
+			for _, source := range sources {
+				specs = append(specs, r.redirectSpec(host, destination, iface, source))
+			}
+		}
+	}
+
+	return specs
+}
 
-	return ipt.AppendUnique("nat", "PREROUTING", r.ruleSpec()...)
+// redirectSpec returns the rule redirecting requests for the destination from
+// the interface and source range, either of which may be empty to match any.
+func (r *rules) redirectSpec(host, destination, iface, source string) []string {
+	spec := []string{
+		"-p", "tcp",
+		"-d", destination,
+		"--dport", "80",
+	}
+	if source != "" {
+		spec = append(spec, "-s", source)
+	}
+	if iface != "" {
+		spec = append(spec, "-i", iface)
+	}
+	return append(spec, "-j", "DNAT", "--to-destination", r.kiamAddress(host))
 }
 
-func (r *rules) ruleSpec() []string {
-	rules := []string{
+// legacyRuleSpec returns the rule previous versions added to PREROUTING for a
+// single interface, or nil when there's more than one.
+func (r *rules) legacyRuleSpec(host string) []string {
+	if len(r.hostInterfaces) != 1 {
+		return nil
+	}
+
+	destination := metadataAddress
+	if isIPv6(host) {
+		destination = metadataAddressIPv6
+	}
+
+	spec := []string{
 		"-p", "tcp",
-		"-d", metadataAddress,
+		"-d", destination,
 		"--dport", "80",
 		"-j", "DNAT",
-		"--to-destination", r.kiamAddress(),
+		"--to-destination", r.kiamAddress(host),
+	}
+	if strings.HasPrefix(r.hostInterfaces[0], "!") {
+		spec = append(spec, "!")
+	}
+	return append(spec, "-i", strings.TrimPrefix(r.hostInterfaces[0], "!"))
+}
+
+// Verify checks the chain contains exactly the rules, in order, and that the
+// jump to it is the first rule in PREROUTING.
+func (r *rules) Verify() error {
+	for _, host := range r.hosts {
+		ipt, err := newIPTables(host)
+		if err != nil {
+			return err
+		}
+
+		err = r.verifyChain(ipt, host)
+		if err != nil {
+			return fmt.Errorf("%s: %s", iptablesCommand(host), err.Error())
+		}
+		err = verifyJump(ipt)
+		if err != nil {
+			return fmt.Errorf("%s: %s", iptablesCommand(host), err.Error())
+		}
 	}
-	if strings.HasPrefix(r.hostInterface, "!") {
-		rules = append(rules, "!")
+	return nil
+}
+
+func (r *rules) verifyChain(ipt *iptables.IPTables, host string) error {
+	rules, err := listRules(ipt, kiamChain)
+	if err != nil {
+		return err
 	}
-	rules = append(rules, "-i", strings.TrimPrefix(r.hostInterface, "!"))
+	err = verifyIPTablesRules(rules, r.listedRules(host))
+	if err != nil {
+		return fmt.Errorf("%s %s", kiamChain, err.Error())
+	}
+	return nil
+}
 
+// verifyIPTablesRules checks the listed rules are the expected rules, in
+// order.
+func verifyIPTablesRules(listed, expected []string) error {
+	if len(listed) != len(expected) {
+		return fmt.Errorf("contains %d rules, expected %d", len(listed), len(expected))
+	}
+	for i := range expected {
+		if listed[i] != expected[i] {
+			return fmt.Errorf("rule %d is %q, expected %q", i+1, listed[i], expected[i])
+		}
+	}
+	return nil
+}
+
+// listedRules returns the chain's rules as iptables lists them.
+func (r *rules) listedRules(host string) []string {
+	var rules []string
+	for _, spec := range r.ruleSpecs(host) {
+		rules = append(rules, listedRule(spec))
+	}
 	return rules
 }
 
+// listedRule returns the rule spec as iptables lists it: addresses, then
+// interface, protocol and the tcp match --dport loads, then the target.
+// Addresses are listed with their prefix length.
+func listedRule(spec []string) string {
+	options := make(map[string]string)
+	for i := 0; i+1 < len(spec); i += 2 {
+		options[spec[i]] = spec[i+1]
+	}
+
+	rule := []string{"-A", kiamChain}
+	if source, ok := options["-s"]; ok {
+		rule = append(rule, "-s", source)
+	}
+	if destination, ok := options["-d"]; ok {
+		rule = append(rule, "-d", hostCIDR(destination))
+	}
+	if iface, ok := options["-i"]; ok {
+		rule = append(rule, "-i", iface)
+	}
+	if protocol, ok := options["-p"]; ok {
+		rule = append(rule, "-p", protocol, "-m", protocol, "--dport", options["--dport"])
+	}
+	rule = append(rule, "-j", options["-j"])
+	if destination, ok := options["--to-destination"]; ok {
+		rule = append(rule, "--to-destination", destination)
+	}
+	return strings.Join(rule, " ")
+}
+
+// hostCIDR returns the address as a single host range.
+func hostCIDR(address string) string {
+	if isIPv6(address) {
+		return address + "/128"
+	}
+	return address + "/32"
+}
+
+func verifyJump(ipt *iptables.IPTables) error {
+	rules, err := listRules(ipt, preRouting)
+	if err != nil {
+		return err
+	}
+	jump := fmt.Sprintf("-A %s %s", preRouting, strings.Join(jumpSpec, " "))
+	if len(rules) == 0 || rules[0] != jump {
+		return fmt.Errorf("jump to %s isn't the first rule in %s", kiamChain, preRouting)
+	}
+	return nil
+}
+
+// listRules returns the chain's rules, omitting its policy and definition.
+func listRules(ipt *iptables.IPTables, chain string) ([]string, error) {
+	listed, err := ipt.List(natTable, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]string, 0, len(listed))
+	for _, rule := range listed {
+		if strings.HasPrefix(rule, "-A ") {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// deleteAll deletes every copy of the rule from the chain.
+func deleteAll(ipt *iptables.IPTables, chain string, rulespec []string) error {
+	for {
+		exists, err := ipt.Exists(natTable, chain, rulespec...)
+		if err != nil || !exists {
+			return err
+		}
+		err = ipt.Delete(natTable, chain, rulespec...)
+		if err != nil {
+			return err
+		}
+	}
+}
+
 var (
 	retryInterval = time.Millisecond * 500
 	maxAttempts   = 30
 )
 
+// Remove deletes the chain and jump, returning an error when they couldn't be
+// deleted once retries are exhausted.
 func (r *rules) Remove() error {
-	ipt, err := iptables.New()
-	if err != nil {
-		return err
+	for _, host := range r.hosts {
+		ipt, err := newIPTables(host)
+		if err != nil {
+			return err
+		}
+		err = r.remove(ipt, host)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+func (r *rules) remove(ipt *iptables.IPTables, host string) error {
+	command := iptablesCommand(host)
 
 	var attempt int
 	for {
-		if attempt >= maxAttempts {
-			log.Errorf("failed to remove iptables rule, retries exhausted: %s", err.Error())
-			break
+		err := removeChain(ipt)
+		if err == nil {
+			log.Infof("%s rules were successfully removed", command)
+			return nil
 		}
-		if err := ipt.Delete("nat", "PREROUTING", r.ruleSpec()...); err == nil {
-			log.Info("iptables rule was successfully removed")
-			break
+		attempt++
+		if attempt >= maxAttempts {
+			return fmt.Errorf("failed to remove %s rules, retries exhausted: %s", command, err.Error())
 		}
-		log.Warnf("failed to remove iptables rule, will retry: %s", err.Error())
+		log.Warnf("failed to remove %s rules, will retry: %s", command, err.Error())
 		time.Sleep(retryInterval)
-		attempt++
 	}
-	return nil
 }
 
-func (r *rules) kiamAddress() string {
-	return fmt.Sprintf("%s:%d", r.host, r.kiamPort)
+func removeChain(ipt *iptables.IPTables) error {
+	err := deleteAll(ipt, preRouting, jumpSpec)
+	if err != nil {
+		return err
+	}
+
+	chains, err := ipt.ListChains(natTable)
+	if err != nil {
+		return err
+	}
+	for _, chain := range chains {
+		if chain != kiamChain {
+			continue
+		}
+		err = ipt.ClearChain(natTable, kiamChain)
+		if err != nil {
+			return err
+		}
+		return ipt.DeleteChain(natTable, kiamChain)
+	}
+	return nil
 }
@@ -0,0 +1,152 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIPTablesRuleSpecs(t *testing.T) {
+	var tests = []struct {
+		name     string
+		config   interceptConfig
+		host     string
+		expected [][]string
+	}{
+		{
+			name:   "SingleInterface",
+			config: interceptConfig{kiamPort: 8181, hostInterfaces: []string{"docker0"}},
+			host:   "10.0.0.1",
+			expected: [][]string{
+				{"-p", "tcp", "-d", "169.254.169.254", "--dport", "80", "-i", "docker0", "-j", "DNAT", "--to-destination", "10.0.0.1:8181"},
+			},
+		},
+		{
+			name:   "MultipleInterfacesAndExclusions",
+			config: interceptConfig{kiamPort: 8181, hostInterfaces: []string{"cali+", "!eth0", "eni+"}},
+			host:   "10.0.0.1",
+			expected: [][]string{
+				{"-i", "eth0", "-j", "RETURN"},
+				{"-p", "tcp", "-d", "169.254.169.254", "--dport", "80", "-i", "cali+", "-j", "DNAT", "--to-destination", "10.0.0.1:8181"},
+				{"-p", "tcp", "-d", "169.254.169.254", "--dport", "80", "-i", "eni+", "-j", "DNAT", "--to-destination", "10.0.0.1:8181"},
+			},
+		},
+		{
+			name:   "MultipleSourceCIDRs",
+			config: interceptConfig{kiamPort: 8181, hostInterfaces: []string{"!eth0"}, sourceCIDRs: []string{"10.1.0.0/16", "10.2.0.0/16", "fd00::/8"}},
+			host:   "10.0.0.1",
+			expected: [][]string{
+				{"-i", "eth0", "-j", "RETURN"},
+				{"-p", "tcp", "-d", "169.254.169.254", "--dport", "80", "-s", "10.1.0.0/16", "-j", "DNAT", "--to-destination", "10.0.0.1:8181"},
+				{"-p", "tcp", "-d", "169.254.169.254", "--dport", "80", "-s", "10.2.0.0/16", "-j", "DNAT", "--to-destination", "10.0.0.1:8181"},
+			},
+		},
+		{
+			name:   "IPv6",
+			config: interceptConfig{kiamPort: 8181, hostInterfaces: []string{"cali+"}, sourceCIDRs: []string{"10.1.0.0/16", "fd00::/8"}},
+			host:   "fd00::1",
+			expected: [][]string{
+				{"-p", "tcp", "-d", "fd00:ec2::254", "--dport", "80", "-s", "fd00::/8", "-i", "cali+", "-j", "DNAT", "--to-destination", "[fd00::1]:8181"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			specs := newIPTablesRules(tt.config).ruleSpecs(tt.host)
+			if !reflect.DeepEqual(specs, tt.expected) {
+				t.Errorf("unexpected rules:\n%q\nexpected:\n%q", specs, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIPTablesLegacyRuleSpec(t *testing.T) {
+	var tests = []struct {
+		name     string
+		config   interceptConfig
+		host     string
+		expected []string
+	}{
+		{
+			name:     "Interface",
+			config:   interceptConfig{kiamPort: 8181, hostInterfaces: []string{"cali+"}},
+			host:     "10.0.0.1",
+			expected: []string{"-p", "tcp", "-d", "169.254.169.254", "--dport", "80", "-j", "DNAT", "--to-destination", "10.0.0.1:8181", "-i", "cali+"},
+		},
+		{
+			name:     "ExcludedInterface",
+			config:   interceptConfig{kiamPort: 8181, hostInterfaces: []string{"!eth0"}},
+			host:     "10.0.0.1",
+			expected: []string{"-p", "tcp", "-d", "169.254.169.254", "--dport", "80", "-j", "DNAT", "--to-destination", "10.0.0.1:8181", "!", "-i", "eth0"},
+		},
+		{
+			name:     "IPv6",
+			config:   interceptConfig{kiamPort: 8181, hostInterfaces: []string{"cali+"}},
+			host:     "fd00::1",
+			expected: []string{"-p", "tcp", "-d", "fd00:ec2::254", "--dport", "80", "-j", "DNAT", "--to-destination", "[fd00::1]:8181", "-i", "cali+"},
+		},
+		{
+			name:   "MultipleInterfaces",
+			config: interceptConfig{kiamPort: 8181, hostInterfaces: []string{"cali+", "eni+"}},
+			host:   "10.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := newIPTablesRules(tt.config).legacyRuleSpec(tt.host)
+			if !reflect.DeepEqual(spec, tt.expected) {
+				t.Errorf("unexpected rule:\n%q\nexpected:\n%q", spec, tt.expected)
+			}
+		})
+	}
+}
+
+func TestVerifiesListedIPTablesRules(t *testing.T) {
+	listed := []string{
+		"-A KIAM-PREROUTING -i eth0 -j RETURN",
+		"-A KIAM-PREROUTING -s 10.1.0.0/16 -d 169.254.169.254/32 -i cali+ -p tcp -m tcp --dport 80 -j DNAT --to-destination 10.0.0.1:8181",
+	}
+	expected := newIPTablesRules(interceptConfig{kiamPort: 8181, hostInterfaces: []string{"cali+", "!eth0"}, sourceCIDRs: []string{"10.1.0.0/16"}}).listedRules("10.0.0.1")
+
+	if err := verifyIPTablesRules(listed, expected); err != nil {
+		t.Error("expected listed rules to be verified, was", err)
+	}
+
+	listedIPv6 := []string{"-A KIAM-PREROUTING -d fd00:ec2::254/128 -i cali+ -p tcp -m tcp --dport 80 -j DNAT --to-destination [fd00::1]:8181"}
+	expectedIPv6 := newIPTablesRules(interceptConfig{kiamPort: 8181, hostInterfaces: []string{"cali+"}}).listedRules("fd00::1")
+	if err := verifyIPTablesRules(listedIPv6, expectedIPv6); err != nil {
+		t.Error("expected listed IPv6 rules to be verified, was", err)
+	}
+
+	var tests = []struct {
+		name   string
+		listed []string
+	}{
+		{"Missing", listed[:1]},
+		{"Reordered", []string{listed[1], listed[0]}},
+		{"ChangedInterface", []string{listed[0], "-A KIAM-PREROUTING -s 10.1.0.0/16 -d 169.254.169.254/32 -i eni+ -p tcp -m tcp --dport 80 -j DNAT --to-destination 10.0.0.1:8181"}},
+		{"ChangedSource", []string{listed[0], "-A KIAM-PREROUTING -s 10.0.0.0/8 -d 169.254.169.254/32 -i cali+ -p tcp -m tcp --dport 80 -j DNAT --to-destination 10.0.0.1:8181"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyIPTablesRules(tt.listed, expected); err == nil {
+				t.Error("expected changed rules not to be verified")
+			}
+		})
+	}
+}
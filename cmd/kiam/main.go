@@ -29,6 +29,9 @@ func main() {
 	var health healthCommand
 	health.Bind(rootParser.Command("health", "run the health check"))
 
+	var cache cacheCommand
+	cache.Bind(rootParser.Command("cache", "inspect and evict the server's credentials cache"))
+
 	switch kingpin.Parse() {
 	case "agent":
 		agent.Run()
@@ -36,6 +39,10 @@ func main() {
 		server.Run()
 	case "health":
 		health.Run()
+	case "cache list":
+		cache.List()
+	case "cache evict":
+		cache.Evict()
 	}
 }
 
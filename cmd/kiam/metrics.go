@@ -0,0 +1,42 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	interceptionActive = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "kiam",
+			Subsystem: "agent",
+			Name:      "interception_active",
+			Help:      "Whether the rules intercepting metadata requests were in place when last checked (1) or not (0)",
+		},
+	)
+	interceptionRepaired = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "kiam",
+			Subsystem: "agent",
+			Name:      "interception_repairs_total",
+			Help:      "Number of times the rules intercepting metadata requests were found missing or changed and re-added",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(interceptionActive)
+	prometheus.MustRegister(interceptionRepaired)
+}
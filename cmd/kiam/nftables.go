@@ -0,0 +1,222 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	nftTable = "kiam"
+	nftChain = "prerouting"
+)
+
+// nftRules redirect requests for the metadata service to the agent with
+// nftables. The rules are kept in a table owned by the agent, so removing them
+// doesn't disturb other rules.
+type nftRules struct {
+	interceptConfig
+}
+
+func newNFTablesRules(config interceptConfig) *nftRules {
+	return &nftRules{interceptConfig: config}
+}
+
+// nftFamily returns the nftables family of the host's address.
+func nftFamily(host string) string {
+	if isIPv6(host) {
+		return "ip6"
+	}
+	return "ip"
+}
+
+func (r *nftRules) Add() error {
+	var script strings.Builder
+	for _, host := range r.hosts {
+		family := nftFamily(host)
+		// the table is created when missing and emptied, so that the rules
+		// are replaced atomically
+		fmt.Fprintf(&script, "add table %s %s\n", family, nftTable)
+		fmt.Fprintf(&script, "flush table %s %s\n", family, nftTable)
+		fmt.Fprintf(&script, "add chain %s %s %s { type nat hook prerouting priority -100; policy accept; }\n", family, nftTable, nftChain)
+		for _, rule := range r.rules(host) {
+			fmt.Fprintf(&script, "add rule %s %s %s %s\n", family, nftTable, nftChain, rule)
+		}
+	}
+
+	_, err := nft(script.String(), "-f", "-")
+	return err
+}
+
+// rules returns the chain's rules: requests from excluded interfaces return
+// before a rule redirecting requests for each included interface and source
+// range.
+func (r *nftRules) rules(host string) []string {
+	var rules []string
+	for _, iface := range r.excludedInterfaces() {
+		rules = append(rules, fmt.Sprintf("iifname %q return", nftInterface(iface)))
+	}
+
+	ifaces := r.includedInterfaces()
+	if len(ifaces) == 0 {
+		ifaces = []string{""}
+	}
+	sources := r.sourceCIDRsFor(host)
+	if len(sources) == 0 {
+		sources = []string{""}
+	}
+	for _, destination := range r.destinations(host) {
+		for _, iface := range ifaces {
+			for _, source := range sources {
+				rules = append(rules, r.redirectRule(host, destination, iface, source))
+			}
+		}
+	}
+
+	return rules
+}
+
+// redirectRule returns the rule redirecting requests for the destination from
+// the interface and source range, either of which may be empty to match any.
+func (r *nftRules) redirectRule(host, destination, iface, source string) string {
+	family := nftFamily(host)
+
+	var matches []string
+	if iface != "" {
+		matches = append(matches, fmt.Sprintf("iifname %q", nftInterface(iface)))
+	}
+	if source != "" {
+		matches = append(matches, fmt.Sprintf("%s saddr %s", family, source))
+	}
+	matches = append(matches, fmt.Sprintf("%s daddr %s tcp dport 80 dnat to %s", family, destination, r.kiamAddress(host)))
+
+	return strings.Join(matches, " ")
+}
+
+// nftInterface converts iptables' interface wildcard, +, to nftables', *.
+func nftInterface(pattern string) string {
+	if strings.HasSuffix(pattern, "+") {
+		return strings.TrimSuffix(pattern, "+") + "*"
+	}
+	return pattern
+}
+
+// Verify checks each chain contains exactly the rules, in order.
+func (r *nftRules) Verify() error {
+	for _, host := range r.hosts {
+		family := nftFamily(host)
+		out, err := nft("", "list", "chain", family, nftTable, nftChain)
+		if err != nil {
+			return err
+		}
+		err = verifyNFTRules(parseNFTChainRules(out), r.rules(host))
+		if err != nil {
+			return fmt.Errorf("%s %s %s: %s", family, nftTable, nftChain, err.Error())
+		}
+	}
+	return nil
+}
+
+// verifyNFTRules checks the listed rules are the expected rules, in order.
+func verifyNFTRules(listed, expected []string) error {
+	if len(listed) != len(expected) {
+		return fmt.Errorf("contains %d rules, expected %d", len(listed), len(expected))
+	}
+	for i := range expected {
+		if listed[i] != expected[i] {
+			return fmt.Errorf("rule %d is %q, expected %q", i+1, listed[i], expected[i])
+		}
+	}
+	return nil
+}
+
+// parseNFTChainRules returns the rules of the chain listed by nft list chain,
+// omitting the chain's definition.
+func parseNFTChainRules(out string) []string {
+	var rules []string
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "", line == "}":
+		case strings.HasPrefix(line, "table "), strings.HasPrefix(line, "chain "):
+		case strings.HasPrefix(line, "type "):
+		default:
+			rules = append(rules, line)
+		}
+	}
+	return rules
+}
+
+// Remove deletes the tables, returning an error when they couldn't be deleted
+// once retries are exhausted. Tables that are already gone are removed.
+func (r *nftRules) Remove() error {
+	for _, host := range r.hosts {
+		family := nftFamily(host)
+
+		var attempt int
+		for {
+			_, err := nft("", "delete", "table", family, nftTable)
+			if err == nil {
+				log.Infof("nftables %s table was successfully removed", family)
+				break
+			}
+			if isNFTMissing(err) {
+				log.Infof("nftables %s table was already removed", family)
+				break
+			}
+			if !isNFTBusy(err) {
+				return fmt.Errorf("failed to remove nftables %s table: %s", family, err.Error())
+			}
+			attempt++
+			if attempt >= maxAttempts {
+				return fmt.Errorf("failed to remove nftables %s table, retries exhausted: %s", family, err.Error())
+			}
+			log.Warnf("failed to remove nftables %s table, will retry: %s", family, err.Error())
+			time.Sleep(retryInterval)
+		}
+	}
+	return nil
+}
+
+// isNFTMissing returns whether nft failed as the table doesn't exist.
+func isNFTMissing(err error) bool {
+	return strings.Contains(err.Error(), "No such file or directory")
+}
+
+// isNFTBusy returns whether nft failed as the table was in use, which may
+// succeed when retried.
+func isNFTBusy(err error) bool {
+	return strings.Contains(err.Error(), "Device or resource busy")
+}
+
+// nft runs the nft command, with the script as its input.
+func nft(script string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("nft", args...)
+	cmd.Stdin = strings.NewReader(script)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("nft %s: %s: %s", strings.Join(args, " "), err.Error(), strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNFTInterface(t *testing.T) {
+	var tests = []struct {
+		pattern  string
+		expected string
+	}{
+		{"docker0", "docker0"},
+		{"cali+", "cali*"},
+		{"eni+", "eni*"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			if iface := nftInterface(tt.pattern); iface != tt.expected {
+				t.Errorf("expected %s, was %s", tt.expected, iface)
+			}
+		})
+	}
+}
+
+func TestNFTRules(t *testing.T) {
+	var tests = []struct {
+		name     string
+		config   interceptConfig
+		host     string
+		expected []string
+	}{
+		{
+			name:   "SingleInterface",
+			config: interceptConfig{kiamPort: 8181, hostInterfaces: []string{"cali+"}},
+			host:   "10.0.0.1",
+			expected: []string{
+				`iifname "cali*" ip daddr 169.254.169.254 tcp dport 80 dnat to 10.0.0.1:8181`,
+			},
+		},
+		{
+			name:   "ExcludedInterface",
+			config: interceptConfig{kiamPort: 8181, hostInterfaces: []string{"!eth0"}},
+			host:   "10.0.0.1",
+			expected: []string{
+				`iifname "eth0" return`,
+				`ip daddr 169.254.169.254 tcp dport 80 dnat to 10.0.0.1:8181`,
+			},
+		},
+		{
+			name:   "MultipleInterfacesAndSourceCIDRs",
+			config: interceptConfig{kiamPort: 8181, hostInterfaces: []string{"cali+", "eni+", "!eth0"}, sourceCIDRs: []string{"10.1.0.0/16", "10.2.0.0/16"}},
+			host:   "10.0.0.1",
+			expected: []string{
+				`iifname "eth0" return`,
+				`iifname "cali*" ip saddr 10.1.0.0/16 ip daddr 169.254.169.254 tcp dport 80 dnat to 10.0.0.1:8181`,
+				`iifname "cali*" ip saddr 10.2.0.0/16 ip daddr 169.254.169.254 tcp dport 80 dnat to 10.0.0.1:8181`,
+				`iifname "eni*" ip saddr 10.1.0.0/16 ip daddr 169.254.169.254 tcp dport 80 dnat to 10.0.0.1:8181`,
+				`iifname "eni*" ip saddr 10.2.0.0/16 ip daddr 169.254.169.254 tcp dport 80 dnat to 10.0.0.1:8181`,
+			},
+		},
+		{
+			name:   "IPv6SourceCIDRs",
+			config: interceptConfig{kiamPort: 8181, hostInterfaces: []string{"cali+"}, sourceCIDRs: []string{"10.1.0.0/16", "fd00::/8"}},
+			host:   "fd00::1",
+			expected: []string{
+				`iifname "cali*" ip6 saddr fd00::/8 ip6 daddr fd00:ec2::254 tcp dport 80 dnat to [fd00::1]:8181`,
+			},
+		},
+		{
+			name:   "IPv6",
+			config: interceptConfig{kiamPort: 8181, hostInterfaces: []string{"cali+"}},
+			host:   "fd00::1",
+			expected: []string{
+				`iifname "cali*" ip6 daddr fd00:ec2::254 tcp dport 80 dnat to [fd00::1]:8181`,
+			},
+		},
+		{
+			name:   "ContainerCredentials",
+			config: interceptConfig{kiamPort: 8181, hostInterfaces: []string{"cali+"}, containerCredentials: true},
+			host:   "10.0.0.1",
+			expected: []string{
+				`iifname "cali*" ip daddr 169.254.169.254 tcp dport 80 dnat to 10.0.0.1:8181`,
+				`iifname "cali*" ip daddr 169.254.170.23 tcp dport 80 dnat to 10.0.0.1:8181`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := newNFTablesRules(tt.config).rules(tt.host)
+			if !reflect.DeepEqual(rules, tt.expected) {
+				t.Errorf("unexpected rules:\n%q\nexpected:\n%q", rules, tt.expected)
+			}
+		})
+	}
+}
+
+func TestVerifiesListedNFTRules(t *testing.T) {
+	listed := `table ip kiam {
+	chain prerouting {
+		type nat hook prerouting priority dstnat; policy accept;
+		iifname "eth0" return
+		iifname "cali*" ip daddr 169.254.169.254 tcp dport 80 dnat to 10.0.0.1:8181
+	}
+}
+`
+	expected := newNFTablesRules(interceptConfig{kiamPort: 8181, hostInterfaces: []string{"cali+", "!eth0"}}).rules("10.0.0.1")
+
+	rules := parseNFTChainRules(listed)
+	if err := verifyNFTRules(rules, expected); err != nil {
+		t.Error("expected listed rules to be verified, was", err)
+	}
+
+	var tests = []struct {
+		name   string
+		listed []string
+	}{
+		{"Missing", rules[:1]},
+		{"Reordered", []string{rules[1], rules[0]}},
+		{"ChangedInterface", []string{rules[0], `iifname "eni*" ip daddr 169.254.169.254 tcp dport 80 dnat to 10.0.0.1:8181`}},
+		{"ChangedSource", []string{rules[0], `iifname "cali*" ip saddr 10.0.0.0/8 ip daddr 169.254.169.254 tcp dport 80 dnat to 10.0.0.1:8181`}},
+		{"ReplacedReturn", []string{`iifname "cali*" return`, rules[1]}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyNFTRules(tt.listed, expected); err == nil {
+				t.Error("expected changed rules not to be verified")
+			}
+		})
+	}
+}
+
+func TestClassifiesNFTRemoveErrors(t *testing.T) {
+	var tests = []struct {
+		name    string
+		err     error
+		missing bool
+		busy    bool
+	}{
+		{"Missing", errors.New("nft delete table ip kiam: exit status 1: Error: Could not process rule: No such file or directory"), true, false},
+		{"Busy", errors.New("nft delete table ip kiam: exit status 1: Error: Could not process rule: Device or resource busy"), false, true},
+		{"Other", errors.New("nft delete table ip kiam: exit status 1: Error: Could not process rule: Operation not permitted"), false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if missing := isNFTMissing(tt.err); missing != tt.missing {
+				t.Errorf("expected missing %t, was %t", tt.missing, missing)
+			}
+			if busy := isNFTBusy(tt.err); busy != tt.busy {
+				t.Errorf("expected busy %t, was %t", tt.busy, busy)
+			}
+		})
+	}
+}
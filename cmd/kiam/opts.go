@@ -88,6 +88,14 @@ func (o *tlsOptions) bind(parser parser) {
 	parser.Flag("ca", "CA certificate path").Required().ExistingFileVar(&o.caPath)
 }
 
+// bindOptionalCertificate binds the flags without requiring a client
+// certificate, for commands that can authenticate otherwise.
+func (o *tlsOptions) bindOptionalCertificate(parser parser) {
+	parser.Flag("cert", "Certificate path").ExistingFileVar(&o.certificatePath)
+	parser.Flag("key", "Key path").ExistingFileVar(&o.keyPath)
+	parser.Flag("ca", "CA certificate path").Required().ExistingFileVar(&o.caPath)
+}
+
 type clientOptions struct {
 	serverAddress        string
 	serverAddressRefresh time.Duration
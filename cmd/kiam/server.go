@@ -51,9 +51,11 @@ func (o *serverOptions) bind(parser parser) {
 	parser.Flag("bind", "gRPC bind address").Default("localhost:9610").StringVar(&o.BindAddress)
 	parser.Flag("kubeconfig", "Path to .kube/config (or empty for in-cluster)").Default("").StringVar(&o.KubeConfig)
 	parser.Flag("sync", "Pod cache sync interval").Default("1m").DurationVar(&o.PodSyncInterval)
+	parser.Flag("pod-wait", "How long requests from pods that aren't cached yet wait for them, within the request's deadline, before they're listed from the API server by primary IP. 0 disables.").Default("2s").DurationVar(&o.PodWaitTimeout)
 	parser.Flag("role-base-arn", "Base ARN for roles. e.g. arn:aws:iam::123456789:role/").StringVar(&o.RoleBaseARN)
 	parser.Flag("role-base-arn-autodetect", "Use EC2 metadata service to detect ARN prefix.").BoolVar(&o.AutoDetectBaseARN)
 	parser.Flag("disable-strict-namespace-regexp", "Disable default strict namespace regexp when matching roles.").BoolVar(&o.DisableStrictNamespaceRegexp)
+	parser.Flag("default-role", "Role assumed by pods without a role annotation in namespaces without a default role annotation. Namespace policy still applies; disabled when unset.").StringVar(&o.DefaultRole)
 	parser.Flag("session", "Session name used when creating STS Tokens.").Default("kiam").StringVar(&o.SessionName)
 	parser.Flag("session-duration", "Requested session duration for STS Tokens.").Default("15m").DurationVar(&o.SessionDuration)
 	parser.Flag("session-refresh", "How soon STS Tokens should be refreshed before their expiration.").Default("5m").DurationVar(&o.SessionRefresh)
@@ -64,6 +66,17 @@ func (o *serverOptions) bind(parser parser) {
 	parser.Flag("grpc-max-connection-idle-duration", "gRPC max connection idle").Default("15m").DurationVar(&o.KeepaliveParams.MaxConnectionIdle)
 	parser.Flag("grpc-max-connection-age-duration", "gRPC max connection age").Default("15m").DurationVar(&o.KeepaliveParams.MaxConnectionAge)
 	parser.Flag("grpc-max-connection-age-grace-duration", "gRPC max connection age grace").Default("15m").DurationVar(&o.KeepaliveParams.MaxConnectionAgeGrace)
+	parser.Flag("admin-identity", "Client certificate common name or DNS name permitted to use the admin API. May be repeated; the admin API is disabled when unset.").StringsVar(&o.AdminIdentities)
+	parser.Flag("agent-node-identity", "Where agents' client certificates identify their node: none, cn (common name) or san (DNS names). When set, agents are only returned roles and credentials for pods on their node.").Default(serv.NodeIdentityNone).EnumVar(&o.NodeIdentity.Source, serv.NodeIdentityNone, serv.NodeIdentityCommonName, serv.NodeIdentityDNSName)
+	parser.Flag("agent-node-identity-prefix", "Prefix of the agent certificate names identifying their node, removed to give the node name. Names without the prefix are ignored.").StringVar(&o.NodeIdentity.Prefix)
+	parser.Flag("agent-service-account-token-audience", "Audience of the projected service account tokens sidecar agents, without a client certificate, authenticate with. Sidecar agents are only returned roles and credentials for their own pod. Requires permission to create TokenReviews; disabled when unset.").StringVar(&o.ServiceAccountToken.Audience)
+	parser.Flag("prefetch-leader-elect", "Only prefetch credentials on the server holding the prefetch Lease. Other servers fetch credentials on demand.").BoolVar(&o.LeaderElection.Enabled)
+	parser.Flag("prefetch-leader-elect-namespace", "Namespace of the prefetch leader election Lease.").Envar("POD_NAMESPACE").Default("kube-system").StringVar(&o.LeaderElection.Namespace)
+	parser.Flag("prefetch-leader-elect-lease", "Name of the prefetch leader election Lease.").Default("kiam-server-prefetch").StringVar(&o.LeaderElection.LeaseName)
+	parser.Flag("prefetch-leader-elect-identity", "Identity of this server in the prefetch leader election. Defaults to the hostname.").Envar("POD_NAME").StringVar(&o.LeaderElection.Identity)
+	parser.Flag("prefetch-leader-elect-lease-duration", "Duration followers wait before attempting to acquire an unrenewed prefetch Lease.").Default("15s").DurationVar(&o.LeaderElection.LeaseDuration)
+	parser.Flag("prefetch-leader-elect-renew-deadline", "Duration the leader retries renewing the prefetch Lease before giving it up.").Default("10s").DurationVar(&o.LeaderElection.RenewDeadline)
+	parser.Flag("prefetch-leader-elect-retry-period", "Interval between prefetch leader election attempts.").Default("2s").DurationVar(&o.LeaderElection.RetryPeriod)
 }
 
 func (cmd *serverCommand) Run() {
@@ -77,6 +90,14 @@ func (cmd *serverCommand) Run() {
 		log.Fatal("session-duration should be at least 15 minutes")
 	}
 
+	if cmd.LeaderElection.Enabled && cmd.LeaderElection.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Fatal("error determining prefetch leader election identity: ", err.Error())
+		}
+		cmd.LeaderElection.Identity = hostname
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	cmd.telemetryOptions.start(ctx, "server")
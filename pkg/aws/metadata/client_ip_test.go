@@ -16,6 +16,24 @@ func TestParseAddress(t *testing.T) {
 	}
 }
 
+func TestParseIPv6Address(t *testing.T) {
+	ip, err := ParseClientIP("[fd00:0:0:0::a]:9000")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if ip != "fd00::a" {
+		t.Error("incorrect ip, was", ip)
+	}
+}
+
+func TestParseAddressWithoutPort(t *testing.T) {
+	_, err := ParseClientIP("fd00::a")
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
 func getBlankClientIP(_ *http.Request) (string, error) {
 	return "", nil
 }
@@ -0,0 +1,133 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.com/uswitch/kiam/pkg/server"
+)
+
+const containerCredentialsPath = "/v1/credentials"
+
+// containerCredentials is the container credentials provider's response, as
+// served by ECS and EKS Pod Identity.
+type containerCredentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	Token           string
+	Expiration      string
+	AccountId       string `json:",omitempty"`
+}
+
+// containerCredentialsHandler serves credentials to SDKs configured with
+// AWS_CONTAINER_CREDENTIALS_FULL_URI and AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE.
+// The pod is identified by the service account token sent as the request's
+// authorization, rather than its IP address.
+type containerCredentialsHandler struct {
+	client   server.Client
+	reviewer server.TokenReviewer
+}
+
+func (h *containerCredentialsHandler) Install(router *mux.Router) {
+	router.Handle(containerCredentialsPath, adapt(withMeter("containerCredentials", h))).Methods(http.MethodGet)
+}
+
+func (h *containerCredentialsHandler) Handle(ctx context.Context, w http.ResponseWriter, req *http.Request) (int, error) {
+	timer := prometheus.NewTimer(handlerTimer.WithLabelValues("containerCredentials"))
+	defer timer.ObserveDuration()
+
+	// SDKs send the token file's contents as the header
+	token := strings.TrimSpace(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+	if token == "" {
+		containerTokenRejected.Inc()
+		return http.StatusUnauthorized, fmt.Errorf("authorization token required")
+	}
+	uid, err := h.reviewer.PodUID(ctx, token)
+	if err != nil {
+		containerTokenRejected.Inc()
+		return http.StatusUnauthorized, err
+	}
+	// requests the agent identified, by their socket or as a sidecar's, must
+	// be from the token's pod
+	if identified := server.PodUIDFromContext(ctx); identified != "" && identified != uid {
+		containerTokenRejected.Inc()
+		log.WithFields(requestFields(req)).WithField("pod.uid", identified).Warnf("rejected service account token issued for pod %s", uid)
+		return http.StatusForbidden, fmt.Errorf("service account token wasn't issued for the requesting pod")
+	}
+	log.WithFields(requestFields(req)).WithField("pod.uid", uid).Debugf("identified by service account token")
+
+	// the server finds the pod by uid alone
+	ctx = server.WithPodUID(ctx, uid)
+	roles, err := findPodRoles(ctx, h.client, "")
+	if err != nil {
+		findRoleError.WithLabelValues("containerCredentials").Inc()
+		return http.StatusInternalServerError, err
+	}
+	role := roles.Default()
+	if role == "" {
+		emptyRole.WithLabelValues("containerCredentials").Inc()
+		return http.StatusNotFound, EmptyRoleError
+	}
+
+	credentials, err := fetchCredentials(ctx, h.client, "", role)
+	if err != nil {
+		credentialFetchError.WithLabelValues("containerCredentials").Inc()
+		return http.StatusInternalServerError, fmt.Errorf("error fetching credentials: %s", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&containerCredentials{
+		AccessKeyId:     credentials.AccessKeyId,
+		SecretAccessKey: credentials.SecretAccessKey,
+		Token:           credentials.Token,
+		Expiration:      credentials.Expiration,
+		AccountId:       roleAccountID(roles.ARN, role),
+	})
+	if err != nil {
+		credentialEncodeError.WithLabelValues("containerCredentials").Inc()
+		return http.StatusInternalServerError, fmt.Errorf("error encoding credentials: %s", err.Error())
+	}
+
+	success.WithLabelValues("containerCredentials").Inc()
+	return http.StatusOK, nil
+}
+
+// roleAccountID returns the account of the role's ARN, as resolved by the
+// server. Servers that don't send the ARN leave only roles annotated with an
+// ARN with a known account.
+func roleAccountID(arn, role string) string {
+	if arn == "" {
+		arn = role
+	}
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" {
+		return ""
+	}
+	return parts[4]
+}
+
+func newContainerCredentialsHandler(client server.Client, reviewer server.TokenReviewer) *containerCredentialsHandler {
+	return &containerCredentialsHandler{
+		client:   client,
+		reviewer: reviewer,
+	}
+}
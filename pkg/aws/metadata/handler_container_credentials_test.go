@@ -0,0 +1,169 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/gorilla/mux"
+	"github.com/uswitch/kiam/pkg/aws/sts"
+	"github.com/uswitch/kiam/pkg/server"
+	st "github.com/uswitch/kiam/pkg/testutil/server"
+)
+
+type stubTokenReviewer map[string]string
+
+func (r stubTokenReviewer) PodUID(_ context.Context, token string) (string, error) {
+	uid, ok := r[token]
+	if !ok {
+		return "", fmt.Errorf("token not authenticated")
+	}
+	return uid, nil
+}
+
+// podUIDClient records the pod UID and IP roles are requested for.
+type podUIDClient struct {
+	*st.StubClient
+	uid string
+	ip  string
+}
+
+func (c *podUIDClient) GetRoles(ctx context.Context, ip string) (*server.PodRoles, error) {
+	c.uid = server.PodUIDFromContext(ctx)
+	c.ip = ip
+	return c.StubClient.GetRoles(ctx, ip)
+}
+
+func serveContainerCredentials(client server.Client, token string) *httptest.ResponseRecorder {
+	return serveContainerCredentialsWithContext(context.Background(), client, token)
+}
+
+func serveContainerCredentialsWithContext(ctx context.Context, client server.Client, token string) *httptest.ResponseRecorder {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	r, _ := http.NewRequest("GET", "/v1/credentials", nil)
+	if token != "" {
+		r.Header.Set("Authorization", token)
+	}
+	rr := httptest.NewRecorder()
+
+	handler := newContainerCredentialsHandler(client, stubTokenReviewer{"pod-token": "pod-uid"})
+	router := mux.NewRouter()
+	handler.Install(router)
+	router.ServeHTTP(rr, r.WithContext(ctx))
+	return rr
+}
+
+func TestReturnsContainerCredentials(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	client := &podUIDClient{StubClient: st.NewStubClient().WithRoles(st.GetRoleResult{Role: "arn:aws:iam::123456789012:role/app"}).WithCredentials(st.GetCredentialsResult{Credentials: &sts.Credentials{AccessKeyId: "A1", SecretAccessKey: "S1", Token: "T1", Expiration: "2020-01-01T00:00:00Z"}})}
+	rr := serveContainerCredentials(client, "pod-token")
+
+	if rr.Code != http.StatusOK {
+		t.Fatal("unexpected status, was", rr.Code, rr.Body.String())
+	}
+	if client.uid != "pod-uid" || client.ip != "" {
+		t.Errorf("expected role requested by pod uid alone, was uid %q ip %q", client.uid, client.ip)
+	}
+
+	var creds map[string]string
+	err := json.NewDecoder(rr.Body).Decode(&creds)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := map[string]string{
+		"AccessKeyId":     "A1",
+		"SecretAccessKey": "S1",
+		"Token":           "T1",
+		"Expiration":      "2020-01-01T00:00:00Z",
+		"AccountId":       "123456789012",
+	}
+	for key, value := range expected {
+		if creds[key] != value {
+			t.Errorf("unexpected %s, was %q", key, creds[key])
+		}
+	}
+}
+
+func TestRejectsContainerCredentialsWithoutValidToken(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	client := &podUIDClient{StubClient: st.NewStubClient().WithRoles(st.GetRoleResult{Role: "role"})}
+	for _, token := range []string{"", "other-token"} {
+		rr := serveContainerCredentials(client, token)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected unauthorized with token %q, was %d", token, rr.Code)
+		}
+	}
+	if client.uid != "" {
+		t.Error("expected no role requested")
+	}
+}
+
+func TestRejectsContainerCredentialsForOtherIdentifiedPod(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	client := &podUIDClient{StubClient: st.NewStubClient().WithRoles(st.GetRoleResult{Role: "role"}).WithCredentials(st.GetCredentialsResult{Credentials: &sts.Credentials{AccessKeyId: "A1"}})}
+	rr := serveContainerCredentialsWithContext(server.WithPodUID(context.Background(), "other-uid"), client, "pod-token")
+	if rr.Code != http.StatusForbidden {
+		t.Error("expected forbidden, was", rr.Code)
+	}
+	if client.uid != "" {
+		t.Error("expected no role requested")
+	}
+
+	rr = serveContainerCredentialsWithContext(server.WithPodUID(context.Background(), "pod-uid"), client, "pod-token")
+	if rr.Code != http.StatusOK {
+		t.Error("unexpected status, was", rr.Code, rr.Body.String())
+	}
+}
+
+func TestReturnsAccountOfResolvedRoleName(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	client := st.NewStubClient().WithRoles(st.GetRoleResult{Role: "app"}).WithARN("arn:aws:iam::123456789012:role/app").WithCredentials(st.GetCredentialsResult{Credentials: &sts.Credentials{AccessKeyId: "A1"}})
+	rr := serveContainerCredentials(client, "pod-token")
+	if rr.Code != http.StatusOK {
+		t.Fatal("unexpected status, was", rr.Code, rr.Body.String())
+	}
+
+	var creds map[string]string
+	if err := json.NewDecoder(rr.Body).Decode(&creds); err != nil {
+		t.Fatal(err.Error())
+	}
+	if creds["AccountId"] != "123456789012" {
+		t.Error("unexpected account, was", creds["AccountId"])
+	}
+}
+
+func TestParsesRoleAccountID(t *testing.T) {
+	if id := roleAccountID("", "arn:aws:iam::123456789012:role/path/app"); id != "123456789012" {
+		t.Error("unexpected account, was", id)
+	}
+	if id := roleAccountID("arn:aws:iam::123456789012:role/app", "app"); id != "123456789012" {
+		t.Error("unexpected account of resolved role, was", id)
+	}
+	if id := roleAccountID("", "app"); id != "" {
+		t.Error("expected no account for unresolved role name, was", id)
+	}
+}
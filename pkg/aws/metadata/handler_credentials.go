@@ -22,12 +22,19 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/uswitch/kiam/pkg/aws/sts"
 	"github.com/uswitch/kiam/pkg/server"
+	"k8s.io/apimachinery/pkg/util/cache"
 	"net/http"
+	"time"
 )
 
+// servedCredentialsMax is the number of pods' roles whose served credentials
+// are remembered.
+const servedCredentialsMax = 4096
+
 type credentialsHandler struct {
 	client      server.Client
 	getClientIP clientIPFunc
+	served      *servedCredentials
 }
 
 func (c *credentialsHandler) Install(router *mux.Router) {
@@ -49,12 +56,14 @@ func (c *credentialsHandler) Handle(ctx context.Context, w http.ResponseWriter,
 	}
 
 	requestedRole := mux.Vars(req)["role"]
-	credentials, err := c.fetchCredentials(ctx, ip, requestedRole)
+	credentials, err := fetchCredentials(ctx, c.client, ip, requestedRole)
 	if err != nil {
 		credentialFetchError.WithLabelValues("credentials").Inc()
 		return http.StatusInternalServerError, fmt.Errorf("error fetching credentials: %s", err)
 	}
 
+	c.served.add(ctx, ip, requestedRole, credentials)
+
 	err = json.NewEncoder(w).Encode(credentials)
 	if err != nil {
 		credentialEncodeError.WithLabelValues("credentials").Inc()
@@ -66,13 +75,13 @@ func (c *credentialsHandler) Handle(ctx context.Context, w http.ResponseWriter,
 	return http.StatusOK, nil
 }
 
-func (c *credentialsHandler) fetchCredentials(ctx context.Context, ip, requestedRole string) (*sts.Credentials, error) {
+func fetchCredentials(ctx context.Context, client server.Client, ip, requestedRole string) (*sts.Credentials, error) {
 	var creds *sts.Credentials
 	op := func() error {
 		var err error
-		creds, err = c.client.GetCredentials(ctx, ip, requestedRole)
+		creds, err = client.GetCredentials(ctx, ip, requestedRole)
 		if err != nil {
-			if err == server.ErrPolicyForbidden {
+			if err == server.ErrPolicyForbidden || err == server.ErrNodeMismatch || err == server.ErrServiceAccountMismatch {
 				return backoff.Permanent(err)
 			}
 			return err
@@ -90,6 +99,55 @@ func (c *credentialsHandler) fetchCredentials(ctx context.Context, ip, requested
 	return creds, nil
 }
 
+// servedCredentials remembers the credentials served for each pod's roles until
+// they expire, so that iam/info is answered consistently without fetching
+// them again.
+type servedCredentials struct {
+	credentials *cache.LRUExpireCache
+}
+
+func newServedCredentials() *servedCredentials {
+	return &servedCredentials{credentials: cache.NewLRUExpireCache(servedCredentialsMax)}
+}
+
+// servedCredentialsKey identifies the pod by its uid, when the agent
+// identified it, or ip.
+func servedCredentialsKey(ctx context.Context, ip, role string) string {
+	if uid := server.PodUIDFromContext(ctx); uid != "" {
+		return uid + "|" + role
+	}
+	return ip + "|" + role
+}
+
+func (s *servedCredentials) add(ctx context.Context, ip, role string, credentials *sts.Credentials) {
+	if s == nil {
+		return
+	}
+	expiration, err := time.Parse(time.RFC3339, credentials.Expiration)
+	if err != nil || !time.Now().Before(expiration) {
+		return
+	}
+	s.credentials.Add(servedCredentialsKey(ctx, ip, role), credentials, time.Until(expiration))
+}
+
+// get returns the unexpired credentials last served for the pod's role.
+func (s *servedCredentials) get(ctx context.Context, ip, role string) (*sts.Credentials, bool) {
+	if s == nil {
+		return nil, false
+	}
+	credentials, found := s.credentials.Get(servedCredentialsKey(ctx, ip, role))
+	if !found {
+		return nil, false
+	}
+	return credentials.(*sts.Credentials), true
+}
+
+// withServedCredentials remembers the credentials served.
+func (c *credentialsHandler) withServedCredentials(served *servedCredentials) *credentialsHandler {
+	c.served = served
+	return c
+}
+
 func newCredentialsHandler(client server.Client, getClientIP clientIPFunc) *credentialsHandler {
 	return &credentialsHandler{
 		client:      client,
@@ -0,0 +1,149 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metadata
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uswitch/kiam/pkg/server"
+)
+
+// iamInfo is the instance metadata service's iam/info response.
+type iamInfo struct {
+	Code               string
+	LastUpdated        string
+	InstanceProfileArn string
+	InstanceProfileId  string
+}
+
+// iamInfoHandler answers iam/info for the pod's default role, as though it
+// were the instance's profile, so that it's consistent with the credentials
+// served.
+type iamInfoHandler struct {
+	client      server.Client
+	getClientIP clientIPFunc
+	served      *servedCredentials
+}
+
+func (h *iamInfoHandler) Install(router *mux.Router) {
+	router.Handle("/{version}/meta-data/iam/info", adapt(withMeter("iamInfo", h))).Methods(http.MethodGet)
+}
+
+func (h *iamInfoHandler) Handle(ctx context.Context, w http.ResponseWriter, req *http.Request) (int, error) {
+	timer := prometheus.NewTimer(handlerTimer.WithLabelValues("iamInfo"))
+	defer timer.ObserveDuration()
+
+	err := req.ParseForm()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	ip, err := h.getClientIP(req)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	roles, err := findPodRoles(ctx, h.client, ip)
+	if err != nil {
+		findRoleError.WithLabelValues("iamInfo").Inc()
+		return http.StatusInternalServerError, err
+	}
+	role := roles.Default()
+	if role == "" {
+		emptyRole.WithLabelValues("iamInfo").Inc()
+		return http.StatusNotFound, EmptyRoleError
+	}
+
+	// servers that don't resolve the role's arn only send the annotation
+	roleARN := roles.ARN
+	if roleARN == "" {
+		roleARN = role
+	}
+	// there's no instance profile to describe without the role's arn
+	profileARN, err := instanceProfileARN(roleARN)
+	if err != nil {
+		return http.StatusNotFound, err
+	}
+
+	// credentials are only fetched when they haven't been served to the pod
+	credentials, found := h.served.get(ctx, ip, role)
+	if !found {
+		credentials, err = fetchCredentials(ctx, h.client, ip, role)
+		if err != nil {
+			credentialFetchError.WithLabelValues("iamInfo").Inc()
+			return http.StatusInternalServerError, fmt.Errorf("error fetching credentials: %s", err)
+		}
+		h.served.add(ctx, ip, role, credentials)
+	}
+
+	code := credentials.Code
+	if code == "" {
+		code = "Success"
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	err = json.NewEncoder(w).Encode(&iamInfo{
+		Code:               code,
+		LastUpdated:        credentials.LastUpdated,
+		InstanceProfileArn: profileARN,
+		InstanceProfileId:  instanceProfileID(profileARN),
+	})
+	if err != nil {
+		credentialEncodeError.WithLabelValues("iamInfo").Inc()
+		return http.StatusInternalServerError, fmt.Errorf("error encoding iam info: %s", err.Error())
+	}
+
+	success.WithLabelValues("iamInfo").Inc()
+	return http.StatusOK, nil
+}
+
+// instanceProfileARN returns the ARN of an instance profile named and pathed
+// as the role.
+func instanceProfileARN(roleARN string) (string, error) {
+	parts := strings.SplitN(roleARN, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || !strings.HasPrefix(parts[5], "role/") {
+		return "", fmt.Errorf("role %q isn't an arn", roleARN)
+	}
+	parts[5] = "instance-profile/" + strings.TrimPrefix(parts[5], "role/")
+	return strings.Join(parts, ":"), nil
+}
+
+// instanceProfileID returns a stable id for the instance profile, in the
+// form of an IAM unique id: AIPA followed by 17 characters.
+func instanceProfileID(profileARN string) string {
+	sum := sha256.Sum256([]byte(profileARN))
+	return "AIPA" + base32.StdEncoding.EncodeToString(sum[:])[:17]
+}
+
+// withServedCredentials answers with the credentials last served to the pod,
+// when they haven't expired.
+func (h *iamInfoHandler) withServedCredentials(served *servedCredentials) *iamInfoHandler {
+	h.served = served
+	return h
+}
+
+func newIAMInfoHandler(client server.Client, getClientIP clientIPFunc) *iamInfoHandler {
+	return &iamInfoHandler{
+		client:      client,
+		getClientIP: getClientIP,
+	}
+}
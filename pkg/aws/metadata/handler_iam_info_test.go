@@ -0,0 +1,130 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/gorilla/mux"
+	"github.com/uswitch/kiam/pkg/aws/sts"
+	"github.com/uswitch/kiam/pkg/server"
+	st "github.com/uswitch/kiam/pkg/testutil/server"
+)
+
+func serveIAMInfo(client server.Client) *httptest.ResponseRecorder {
+	return serveIAMInfoWithServedCredentials(client, nil)
+}
+
+func serveIAMInfoWithServedCredentials(client server.Client, served *servedCredentials) *httptest.ResponseRecorder {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	r, _ := http.NewRequest("GET", "/latest/meta-data/iam/info", nil)
+	rr := httptest.NewRecorder()
+
+	handler := newIAMInfoHandler(client, getBlankClientIP).withServedCredentials(served)
+	router := mux.NewRouter()
+	handler.Install(router)
+	router.ServeHTTP(rr, r.WithContext(ctx))
+	return rr
+}
+
+func TestReturnsIAMInfoForPodRole(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	client := st.NewStubClient().
+		WithRoles(st.GetRoleResult{Role: "arn:aws:iam::123456789012:role/path/app"}).
+		WithCredentials(st.GetCredentialsResult{Credentials: &sts.Credentials{AccessKeyId: "A1", LastUpdated: "2020-01-01T00:00:00Z"}})
+	rr := serveIAMInfo(client)
+	if rr.Code != http.StatusOK {
+		t.Fatal("unexpected status, was", rr.Code, rr.Body.String())
+	}
+
+	var info iamInfo
+	err := json.NewDecoder(rr.Body).Decode(&info)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if info.Code != "Success" || info.LastUpdated != "2020-01-01T00:00:00Z" {
+		t.Error("expected info consistent with credentials, was", info)
+	}
+	if info.InstanceProfileArn != "arn:aws:iam::123456789012:instance-profile/path/app" {
+		t.Error("unexpected instance profile arn, was", info.InstanceProfileArn)
+	}
+	if !strings.HasPrefix(info.InstanceProfileId, "AIPA") || len(info.InstanceProfileId) != 21 || info.InstanceProfileId != instanceProfileID(info.InstanceProfileArn) {
+		t.Error("unexpected instance profile id, was", info.InstanceProfileId)
+	}
+}
+
+func TestReturnsNotFoundIAMInfoWithoutRole(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	rr := serveIAMInfo(st.NewStubClient().WithRoles(st.GetRoleResult{Role: ""}))
+	if rr.Code != http.StatusNotFound {
+		t.Error("expected not found, was", rr.Code)
+	}
+}
+
+func TestReturnsNotFoundIAMInfoWithoutRoleARN(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	rr := serveIAMInfo(st.NewStubClient().WithRoles(st.GetRoleResult{Role: "app"}))
+	if rr.Code != http.StatusNotFound {
+		t.Error("expected not found, was", rr.Code)
+	}
+}
+
+func TestReturnsIAMInfoForServedCredentials(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	role := "arn:aws:iam::123456789012:role/app"
+	served := newServedCredentials()
+	expiration := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	served.add(context.Background(), "", role, &sts.Credentials{AccessKeyId: "A1", Expiration: expiration, LastUpdated: "2020-01-01T00:00:00Z"})
+
+	// credentials aren't fetched again
+	client := st.NewStubClient().
+		WithRoles(st.GetRoleResult{Role: role}).
+		WithCredentials(st.GetCredentialsResult{Credentials: &sts.Credentials{AccessKeyId: "A2", LastUpdated: "2020-01-02T00:00:00Z"}})
+	rr := serveIAMInfoWithServedCredentials(client, served)
+	if rr.Code != http.StatusOK {
+		t.Fatal("unexpected status, was", rr.Code, rr.Body.String())
+	}
+
+	var info iamInfo
+	err := json.NewDecoder(rr.Body).Decode(&info)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if info.LastUpdated != "2020-01-01T00:00:00Z" {
+		t.Error("expected info consistent with served credentials, was", info)
+	}
+}
+
+func TestDerivesInstanceProfileARN(t *testing.T) {
+	arn, err := instanceProfileARN("arn:aws:iam::123456789012:role/app")
+	if err != nil || arn != "arn:aws:iam::123456789012:instance-profile/app" {
+		t.Error("unexpected instance profile arn", arn, err)
+	}
+	if _, err := instanceProfileARN("app"); err == nil {
+		t.Error("expected error for role name")
+	}
+}
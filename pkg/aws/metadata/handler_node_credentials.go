@@ -0,0 +1,105 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metadata
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/uswitch/kiam/pkg/k8s"
+	"github.com/uswitch/kiam/pkg/server"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// nodeCredentialsPath matches the paths of the instance profile, its role and
+// credentials.
+var nodeCredentialsPath = regexp.MustCompile("^/[^/]+/meta-data/iam/(info|security-credentials(/.*)?)$")
+
+// PodFinder finds the pod making a request.
+type PodFinder interface {
+	GetPodByIP(ip string) (*v1.Pod, error)
+	GetPodByUID(uid string) (*v1.Pod, error)
+}
+
+// NodeCredentialsAllowlist selects the pods whose credentials requests are
+// passed through to the instance metadata service, so that they're returned
+// the node's instance profile credentials rather than a role's. Pods are
+// selected by their namespace or, when Selector isn't empty, their labels.
+type NodeCredentialsAllowlist struct {
+	Pods       PodFinder
+	Namespaces []string
+	Selector   labels.Selector
+}
+
+func (a *NodeCredentialsAllowlist) allows(pod *v1.Pod) bool {
+	for _, namespace := range a.Namespaces {
+		if pod.GetNamespace() == namespace {
+			return true
+		}
+	}
+	return a.Selector != nil && !a.Selector.Empty() && a.Selector.Matches(labels.Set(pod.GetLabels()))
+}
+
+// findPod returns the pod identified by the request's uid, for hostNetwork
+// pods identified by it, or its client's IP address.
+func (a *NodeCredentialsAllowlist) findPod(req *http.Request, getClientIP clientIPFunc) (*v1.Pod, error) {
+	if uid := server.PodUIDFromContext(req.Context()); uid != "" {
+		return a.Pods.GetPodByUID(uid)
+	}
+
+	err := req.ParseForm()
+	if err != nil {
+		return nil, err
+	}
+	ip, err := getClientIP(req)
+	if err != nil {
+		return nil, err
+	}
+	return a.Pods.GetPodByIP(ip)
+}
+
+// passthroughNodeCredentials proxies the credentials requests of allowlisted
+// pods to the instance metadata service. Requests from other pods, or that
+// can't be matched to a pod, are handled by kiam.
+func passthroughNodeCredentials(allowlist *NodeCredentialsAllowlist, getClientIP clientIPFunc, backingService http.Handler) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if !nodeCredentialsPath.MatchString(req.URL.Path) {
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			pod, err := allowlist.findPod(req, getClientIP)
+			if err != nil {
+				log.WithFields(requestFields(req)).Debugf("not passing node credentials through, error finding pod: %s", err.Error())
+				next.ServeHTTP(w, req)
+				return
+			}
+			if !allowlist.allows(pod) {
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			nodeCredentialsPassthrough.WithLabelValues(pod.GetNamespace()).Inc()
+			log.WithFields(requestFields(req)).WithFields(k8s.PodFields(pod)).Infof("passing node credentials request through to metadata service")
+
+			// as for proxied requests, no X-Forwarded-For header is added
+			req.RemoteAddr = ""
+			backingService.ServeHTTP(w, req)
+		})
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metadata
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/uswitch/kiam/pkg/aws/sts"
+	"github.com/uswitch/kiam/pkg/k8s"
+	"github.com/uswitch/kiam/pkg/testutil"
+	st "github.com/uswitch/kiam/pkg/testutil/server"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// stubPodFinder finds pods by their IP address.
+type stubPodFinder map[string]*v1.Pod
+
+func (f stubPodFinder) GetPodByIP(ip string) (*v1.Pod, error) {
+	pod, ok := f[ip]
+	if !ok {
+		return nil, k8s.ErrPodNotFound
+	}
+	return pod, nil
+}
+
+func (f stubPodFinder) GetPodByUID(uid string) (*v1.Pod, error) {
+	return nil, k8s.ErrPodNotFound
+}
+
+func newNodeCredentialsTestServer(t *testing.T, allowlist *NodeCredentialsAllowlist) (http.Handler, func()) {
+	backing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "node %s", req.URL.Path)
+	}))
+
+	client := st.NewStubClient().WithRoles(st.GetRoleResult{Role: "role"}).WithCredentials(st.GetCredentialsResult{Credentials: &sts.Credentials{AccessKeyId: "A1"}})
+	config := &ServerOptions{
+		MetadataEndpoint: backing.URL,
+		AllowIPQuery:     true,
+		AllowRouteRegexp: regexp.MustCompile("^$"),
+		IMDSv2:           IMDSv2Proxy,
+		NodeCredentials:  allowlist,
+	}
+	srv, err := buildHTTPServer(config, client)
+	if err != nil {
+		backing.Close()
+		t.Fatal(err)
+	}
+	return srv.Handler, backing.Close
+}
+
+func TestPassesNodeCredentialsThroughForAllowlistedPods(t *testing.T) {
+	system := testutil.NewPod("kube-system", "cni", "10.0.0.1", "Running")
+	labelled := testutil.NewPod("monitoring", "npd", "10.0.0.2", "Running")
+	labelled.Labels = map[string]string{"app": "node-problem-detector"}
+	other := testutil.NewPod("kube-system-other", "app", "10.0.0.3", "Running")
+
+	selector, _ := labels.Parse("app=node-problem-detector")
+	handler, closeBacking := newNodeCredentialsTestServer(t, &NodeCredentialsAllowlist{
+		Pods:       stubPodFinder{"10.0.0.1": system, "10.0.0.2": labelled, "10.0.0.3": other},
+		Namespaces: []string{"kube-system"},
+		Selector:   selector,
+	})
+	defer closeBacking()
+
+	before := readPrometheusCounterValue("kiam_metadata_node_credentials_passthrough_total", "namespace", "kube-system")
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		rr := request(handler, http.MethodGet, "/latest/meta-data/iam/security-credentials/?ip="+ip, "")
+		if rr.Code != http.StatusOK || rr.Body.String() != "node /latest/meta-data/iam/security-credentials/" {
+			t.Error("expected node role for allowlisted pod, was", rr.Code, rr.Body.String())
+		}
+	}
+
+	rr := request(handler, http.MethodGet, "/latest/meta-data/iam/security-credentials/node-role?ip=10.0.0.1", "")
+	if rr.Code != http.StatusOK || rr.Body.String() != "node /latest/meta-data/iam/security-credentials/node-role" {
+		t.Error("expected node credentials for allowlisted pod, was", rr.Code, rr.Body.String())
+	}
+
+	if after := readPrometheusCounterValue("kiam_metadata_node_credentials_passthrough_total", "namespace", "kube-system"); after-before != 2 {
+		t.Error("expected passthroughs to be counted, was", after-before)
+	}
+
+	for _, ip := range []string{"10.0.0.3", "10.0.0.4"} {
+		rr := request(handler, http.MethodGet, "/latest/meta-data/iam/security-credentials/role?ip="+ip, "")
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"AccessKeyId":"A1"`) {
+			t.Error("expected pod role credentials for other pods, was", rr.Code, rr.Body.String())
+		}
+	}
+
+	rr = request(handler, http.MethodGet, "/latest/meta-data/instance-id?ip=10.0.0.1", "")
+	if rr.Code != http.StatusNotFound {
+		t.Error("expected other paths to be blocked for allowlisted pods, was", rr.Code)
+	}
+}
+
+func TestEmptySelectorAllowsNoPods(t *testing.T) {
+	allowlist := &NodeCredentialsAllowlist{Selector: labels.Everything()}
+	if allowlist.allows(testutil.NewPod("ns", "name", "10.0.0.1", "Running")) {
+		t.Error("expected empty selector to allow no pods")
+	}
+}
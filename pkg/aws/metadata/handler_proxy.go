@@ -18,15 +18,64 @@ import (
 	"fmt"
 	"net/http"
 	"regexp"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/uswitch/kiam/pkg/server"
+	"k8s.io/apimachinery/pkg/util/cache"
+)
+
+const (
+	// annotatedRoutesMax is the number of pods' distinct annotated routes
+	// that are kept compiled.
+	annotatedRoutesMax = 256
+	annotatedRoutesTTL = time.Hour
 )
 
 type proxyHandler struct {
 	backingService   http.Handler
 	allowRouteRegexp *regexp.Regexp
+
+	// with a limit, paths matching it are proxied by the requesting pod's
+	// annotated routes, which are compiled once for all pods sharing them
+	limit           *regexp.Regexp
+	client          server.Client
+	getClientIP     clientIPFunc
+	annotatedRoutes *cache.LRUExpireCache
+}
+
+// routeMatcher is a route regexp, or the regexps a path must match each of.
+type routeMatcher interface {
+	MatchString(path string) bool
+	String() string
 }
 
+// allRoutes matches the paths matched by each of its regexps: a namespace's
+// annotated routes and those of its pod, which may only narrow them.
+type allRoutes []*regexp.Regexp
+
+func (r allRoutes) MatchString(path string) bool {
+	for _, regexp := range r {
+		if !regexp.MatchString(path) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r allRoutes) String() string {
+	regexps := make([]string, len(r))
+	for i, regexp := range r {
+		regexps[i] = regexp.String()
+	}
+	return strings.Join(regexps, " and ")
+}
+
+// blockAllRoutes matches no request path.
+var blockAllRoutes = regexp.MustCompile("^$")
+
 var tokenRouteRegexp = regexp.MustCompile("^/?[^/]+/api/token$")
 
 func (p *proxyHandler) Install(router *mux.Router) {
@@ -44,7 +93,15 @@ func (w *teeWriter) WriteHeader(statusCode int) {
 }
 
 func (p *proxyHandler) Handle(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
-	if p.allowRouteRegexp.MatchString(r.URL.Path) ||
+	return p.handle(ctx, w, r, nil)
+}
+
+// handle proxies the request when its path is allowed for the requesting pod.
+// Handlers that have already found the pod's overrides pass them, so they're
+// only looked up once; otherwise they're looked up when needed.
+func (p *proxyHandler) handle(ctx context.Context, w http.ResponseWriter, r *http.Request, overrides *server.PodOverrides) (int, error) {
+	allowRouteRegexp := p.podAllowRouteRegexp(ctx, r, overrides)
+	if allowRouteRegexp.MatchString(r.URL.Path) ||
 		// Always proxy through requests to pick up a session token
 		(r.Method == http.MethodPut && tokenRouteRegexp.MatchString(r.URL.Path)) {
 		writer := &teeWriter{w, http.StatusOK}
@@ -60,12 +117,72 @@ func (p *proxyHandler) Handle(ctx context.Context, w http.ResponseWriter, r *htt
 	}
 
 	proxyDenies.Inc()
-	return http.StatusNotFound, fmt.Errorf("request blocked by allow-route-regexp %q: %s", p.allowRouteRegexp, r.URL.Path)
+	return http.StatusNotFound, fmt.Errorf("request blocked by allow-route-regexp %q: %s", allowRouteRegexp, r.URL.Path)
+}
+
+// podAllowRouteRegexp returns the routes proxied for the requesting pod: its
+// namespace's annotated routes, narrowed by its own, when the path is within
+// the limit, and otherwise allowRouteRegexp. Pods that can't be found, such
+// as processes on the node, get allowRouteRegexp.
+func (p *proxyHandler) podAllowRouteRegexp(ctx context.Context, req *http.Request, overrides *server.PodOverrides) routeMatcher {
+	if p.limit == nil || !p.limit.MatchString(req.URL.Path) {
+		return p.allowRouteRegexp
+	}
+
+	if overrides == nil {
+		overrides = findPodOverrides(ctx, p.client, p.getClientIP, req)
+	}
+	if overrides.NamespaceAllowRouteRegexp == "" && overrides.PodAllowRouteRegexp == "" {
+		return p.allowRouteRegexp
+	}
+
+	logger := log.WithFields(requestFields(req))
+	// pods' routes narrow allowRouteRegexp when their namespace isn't
+	// annotated
+	routes := allRoutes{p.allowRouteRegexp}
+	if overrides.NamespaceAllowRouteRegexp != "" {
+		routes[0] = p.compileAnnotatedRoutes(overrides.NamespaceAllowRouteRegexp, logger)
+	}
+	if overrides.PodAllowRouteRegexp != "" {
+		routes = append(routes, p.compileAnnotatedRoutes(overrides.PodAllowRouteRegexp, logger))
+	}
+	if len(routes) == 1 {
+		return routes[0]
+	}
+	return routes
+}
+
+// compileAnnotatedRoutes compiles a namespace's or pod's annotated routes,
+// once for all the pods sharing them.
+func (p *proxyHandler) compileAnnotatedRoutes(annotated string, logger *log.Entry) *regexp.Regexp {
+	if compiled, found := p.annotatedRoutes.Get(annotated); found {
+		return compiled.(*regexp.Regexp)
+	}
+
+	compiled, err := regexp.Compile(annotated)
+	if err != nil {
+		// an annotation meant to narrow the routes mustn't widen them
+		logger.Warnf("blocking all routes, error parsing annotated routes %q: %s", annotated, err.Error())
+		compiled = blockAllRoutes
+	}
+	p.annotatedRoutes.Add(annotated, compiled, annotatedRoutesTTL)
+	return compiled
+}
+
+// withPodRoutes lets pods' and namespaces' annotated routes replace
+// allowRouteRegexp for paths matching the limit. Paths outside the limit are
+// never proxied by annotations.
+func (p *proxyHandler) withPodRoutes(client server.Client, getClientIP clientIPFunc, limit *regexp.Regexp) *proxyHandler {
+	p.client = client
+	p.getClientIP = getClientIP
+	p.limit = limit
+	p.annotatedRoutes = cache.NewLRUExpireCache(annotatedRoutesMax)
+	return p
 }
 
 func newProxyHandler(backingService http.Handler, allowRouteRegexp *regexp.Regexp) *proxyHandler {
 	if allowRouteRegexp.String() == "" {
-		allowRouteRegexp = regexp.MustCompile("^$")
+		allowRouteRegexp = blockAllRoutes
 	}
 	return &proxyHandler{
 		backingService:   backingService,
@@ -25,6 +25,7 @@ import (
 	"github.com/fortytw2/leaktest"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
+	st "github.com/uswitch/kiam/pkg/testutil/server"
 )
 
 const kRequestBlockedAllowFilter = "request blocked by allow-route-regexp"
@@ -163,3 +164,59 @@ func TestErrorReturned(t *testing.T) {
 		t.Error("unexpected status", rr.Code)
 	}
 }
+
+func performPodRoutesRequest(namespaceRoutes, podRoutes, path string) (int, *httptest.ResponseRecorder) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	var hits int
+	backingService := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	})
+	client := st.NewStubClient().WithRoles(st.GetRoleResult{Role: "role"}).WithAllowRouteRegexp(namespaceRoutes, podRoutes)
+	handler := newProxyHandler(backingService, regexp.MustCompile("^/latest/meta-data/placement/")).
+		withPodRoutes(client, getBlankClientIP, regexp.MustCompile("^/latest/(meta-data/placement/|user-data)"))
+	router := mux.NewRouter()
+	handler.Install(router)
+
+	r, _ := http.NewRequest("GET", path, nil)
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, r.WithContext(ctx))
+
+	return hits, rr
+}
+
+func TestProxiesPodAnnotatedRoutesWithinLimit(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	cases := []struct {
+		namespace string
+		pod       string
+		path      string
+		proxied   bool
+	}{
+		{"", "", "/latest/meta-data/placement/region", true},
+		{"", "", "/latest/user-data", false},
+		{"^/latest/user-data", "", "/latest/user-data", true},
+		{"^/latest/user-data", "", "/latest/meta-data/placement/region", false},
+		{".*", "", "/latest/meta-data/instance-id", false},
+		{"(", "", "/latest/meta-data/placement/region", false},
+		{"^/latest/user-data", "^/latest/meta-data/", "/latest/user-data", false},
+		{"^/latest/meta-data/", "^/latest/meta-data/placement/", "/latest/meta-data/placement/region", true},
+		{"^/latest/meta-data/", "^/latest/meta-data/placement/", "/latest/meta-data/ami-id", false},
+		{"^/latest/meta-data/", "(", "/latest/meta-data/placement/region", false},
+		{"", "^/latest/user-data", "/latest/user-data", false},
+		{"", "^/latest/meta-data/placement/availability-zone", "/latest/meta-data/placement/availability-zone", true},
+		{"", "^/latest/meta-data/placement/availability-zone", "/latest/meta-data/placement/region", false},
+	}
+	for _, c := range cases {
+		hits, rr := performPodRoutesRequest(c.namespace, c.pod, c.path)
+		if c.proxied && (hits != 1 || rr.Code != http.StatusOK) {
+			t.Errorf("expected %s proxied with namespace's %q and pod's %q, was %d", c.path, c.namespace, c.pod, rr.Code)
+		}
+		if !c.proxied && (hits != 0 || !strings.HasPrefix(rr.Body.String(), kRequestBlockedAllowFilter)) {
+			t.Errorf("expected %s blocked with namespace's %q and pod's %q, was %d", c.path, c.namespace, c.pod, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uswitch/kiam/pkg/server"
+)
+
+// regionHandler answers placement/region with the pod's, or its namespace's,
+// annotated region, for workloads targeting another region than the node's.
+// Requests from pods without one are proxied.
+type regionHandler struct {
+	client      server.Client
+	getClientIP clientIPFunc
+	proxy       *proxyHandler
+}
+
+func (h *regionHandler) Install(router *mux.Router) {
+	router.Handle("/{version}/meta-data/placement/region", adapt(withMeter("region", h))).Methods(http.MethodGet)
+}
+
+func (h *regionHandler) Handle(ctx context.Context, w http.ResponseWriter, req *http.Request) (int, error) {
+	timer := prometheus.NewTimer(handlerTimer.WithLabelValues("region"))
+	defer timer.ObserveDuration()
+
+	// the proxy uses the overrides found for the region, rather than
+	// looking them up again
+	overrides := findPodOverrides(ctx, h.client, h.getClientIP, req)
+	if overrides.Region == "" {
+		return h.proxy.handle(ctx, w, req, overrides)
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprint(w, overrides.Region)
+	success.WithLabelValues("region").Inc()
+	return http.StatusOK, nil
+}
+
+func newRegionHandler(client server.Client, getClientIP clientIPFunc, proxy *proxyHandler) *regionHandler {
+	return &regionHandler{
+		client:      client,
+		getClientIP: getClientIP,
+		proxy:       proxy,
+	}
+}
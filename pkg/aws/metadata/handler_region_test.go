@@ -0,0 +1,107 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metadata
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/gorilla/mux"
+	"github.com/uswitch/kiam/pkg/server"
+	st "github.com/uswitch/kiam/pkg/testutil/server"
+)
+
+func serveRegion(region string) (int, *httptest.ResponseRecorder) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	var hits int
+	backingService := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Write([]byte("node-region"))
+	})
+	client := st.NewStubClient().WithRoles(st.GetRoleResult{Role: "role"}).WithRegion(region)
+	proxy := newProxyHandler(backingService, regexp.MustCompile("^/latest/meta-data/placement/"))
+	router := mux.NewRouter()
+	newRegionHandler(client, getBlankClientIP, proxy).Install(router)
+	proxy.Install(router)
+
+	r, _ := http.NewRequest("GET", "/latest/meta-data/placement/region", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, r.WithContext(ctx))
+	return hits, rr
+}
+
+func TestReturnsAnnotatedRegion(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	hits, rr := serveRegion("eu-west-1")
+	if hits != 0 || rr.Code != http.StatusOK || rr.Body.String() != "eu-west-1" {
+		t.Error("expected annotated region, was", rr.Code, rr.Body.String())
+	}
+}
+
+func TestProxiesRegionWithoutAnnotation(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	hits, rr := serveRegion("")
+	if hits != 1 || rr.Body.String() != "node-region" {
+		t.Error("expected node's region proxied, was", rr.Code, rr.Body.String())
+	}
+}
+
+// countingClient counts the roles requested from the server.
+type countingClient struct {
+	*st.StubClient
+	roleRequests int
+}
+
+func (c *countingClient) GetRoles(ctx context.Context, ip string) (*server.PodRoles, error) {
+	c.roleRequests++
+	return c.StubClient.GetRoles(ctx, ip)
+}
+
+func TestLooksUpOverridesOnceWhenProxyingRegion(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	var hits int
+	backingService := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	})
+	client := &countingClient{StubClient: st.NewStubClient().WithRoles(st.GetRoleResult{Role: "role"}).WithAllowRouteRegexp("^/latest/meta-data/placement/region$", "")}
+	proxy := newProxyHandler(backingService, regexp.MustCompile("^$")).
+		withPodRoutes(client, getBlankClientIP, regexp.MustCompile("^/latest/meta-data/placement/"))
+	router := mux.NewRouter()
+	newRegionHandler(client, getBlankClientIP, proxy).Install(router)
+	proxy.Install(router)
+
+	r, _ := http.NewRequest("GET", "/latest/meta-data/placement/region", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, r.WithContext(ctx))
+
+	if hits != 1 {
+		t.Error("expected region proxied by the namespace's annotated routes, was", rr.Code, rr.Body.String())
+	}
+	if client.roleRequests != 1 {
+		t.Error("expected overrides looked up once, was", client.roleRequests)
+	}
+}
@@ -23,12 +23,14 @@ import (
 	"github.com/uswitch/kiam/pkg/server"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 )
 
 type roleHandler struct {
 	client      server.Client
 	getClientIP clientIPFunc
+	allRoles    bool
 }
 
 func trailingSlashSuffixRedirectHandler(rw http.ResponseWriter, req *http.Request) {
@@ -63,19 +65,24 @@ func (h *roleHandler) Handle(ctx context.Context, w http.ResponseWriter, req *ht
 		return http.StatusInternalServerError, err
 	}
 
-	role, err := findRole(ctx, h.client, ip)
+	roles, err := findRoles(ctx, h.client, ip)
 
 	if err != nil {
 		findRoleError.WithLabelValues("roleName").Inc()
 		return http.StatusInternalServerError, err
 	}
 
-	if role == "" {
+	if len(roles) == 0 {
 		emptyRole.WithLabelValues("roleName").Inc()
 		return http.StatusNotFound, EmptyRoleError
 	}
 
-	fmt.Fprint(w, role)
+	// SDKs, such as botocore, expect a single role unless they're listed
+	if !h.allRoles {
+		roles = roles[:1]
+	}
+	// one role per line, as the EC2 metadata API lists them; SDKs use the first
+	fmt.Fprint(w, strings.Join(roles, "\n"))
 	success.WithLabelValues("roleName").Inc()
 
 	return http.StatusOK, nil
@@ -85,15 +92,38 @@ const (
 	retryInterval = time.Millisecond * 5
 )
 
+// findRole returns the pod's default role, or an empty string when it has
+// none.
 func findRole(ctx context.Context, client server.Client, ip string) (string, error) {
+	roles, err := findRoles(ctx, client, ip)
+	if err != nil || len(roles) == 0 {
+		return "", err
+	}
+	return roles[0], nil
+}
+
+// findRoles returns all the pod's roles, the default role first.
+func findRoles(ctx context.Context, client server.Client, ip string) ([]string, error) {
+	roles, err := findPodRoles(ctx, client, ip)
+	if err != nil {
+		return nil, err
+	}
+	return roles.Roles, nil
+}
+
+// findPodRoles returns the pod's roles and the metadata served for it.
+func findPodRoles(ctx context.Context, client server.Client, ip string) (*server.PodRoles, error) {
 	logger := log.WithField("pod.ip", ip)
 
-	var role string
+	var roles *server.PodRoles
 	op := func() error {
 		var err error
-		role, err = client.GetRole(ctx, ip)
+		roles, err = client.GetRoles(ctx, ip)
 		if err != nil {
-			logger.Warnf("error finding role for pod: %s", err.Error())
+			logger.Warnf("error finding roles for pod: %s", err.Error())
+			if err == server.ErrNodeMismatch || err == server.ErrServiceAccountMismatch {
+				return backoff.Permanent(err)
+			}
 			return err
 		}
 		return nil
@@ -104,10 +134,37 @@ func findRole(ctx context.Context, client server.Client, ip string) (string, err
 
 	err := backoff.Retry(op, backoff.WithContext(strategy, ctx))
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// findPodOverrides returns the requesting pod's annotated overrides of the
+// metadata served, or none when it can't be found, such as for processes on
+// the node.
+func findPodOverrides(ctx context.Context, client server.Client, getClientIP clientIPFunc, req *http.Request) *server.PodOverrides {
+	err := req.ParseForm()
+	if err != nil {
+		return &server.PodOverrides{}
 	}
+	ip, err := getClientIP(req)
+	if err != nil {
+		return &server.PodOverrides{}
+	}
+
+	roles, err := client.GetRoles(ctx, ip)
+	if err != nil {
+		log.WithField("pod.ip", ip).Debugf("error finding annotated overrides for pod: %s", err.Error())
+		return &server.PodOverrides{}
+	}
+	return &roles.Overrides
+}
 
-	return role, nil
+// withAllRoles lists all the pod's roles, rather than only its default role.
+func (h *roleHandler) withAllRoles() *roleHandler {
+	h.allRoles = true
+	return h
 }
 
 func newRoleHandler(client server.Client, getClientIP clientIPFunc) *roleHandler {
@@ -93,6 +93,58 @@ func TestReturnRoleWhenClientResponds(t *testing.T) {
 	}
 }
 
+// rolesClient returns the pod's roles.
+type rolesClient struct {
+	*st.StubClient
+	roles []string
+}
+
+func (c *rolesClient) GetRoles(ctx context.Context, ip string) (*server.PodRoles, error) {
+	return &server.PodRoles{Roles: c.roles}, nil
+}
+
+func TestListsOnlyDefaultPodRole(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	r, _ := http.NewRequest("GET", "/latest/meta-data/iam/security-credentials/", nil)
+	rr := httptest.NewRecorder()
+	handler := newRoleHandler(&rolesClient{StubClient: st.NewStubClient(), roles: []string{"foo_role", "bar_role"}}, getBlankClientIP)
+	router := mux.NewRouter()
+	handler.Install(router)
+
+	router.ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusOK {
+		t.Error("expected 200 response, was", rr.Code)
+	}
+
+	body := rr.Body.String()
+	if body != "foo_role" {
+		t.Error("expected only default role, was", body)
+	}
+}
+
+func TestListsAllPodRoles(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	r, _ := http.NewRequest("GET", "/latest/meta-data/iam/security-credentials/", nil)
+	rr := httptest.NewRecorder()
+	handler := newRoleHandler(&rolesClient{StubClient: st.NewStubClient(), roles: []string{"foo_role", "bar_role"}}, getBlankClientIP).withAllRoles()
+	router := mux.NewRouter()
+	handler.Install(router)
+
+	router.ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusOK {
+		t.Error("expected 200 response, was", rr.Code)
+	}
+
+	body := rr.Body.String()
+	if body != "foo_role\nbar_role" {
+		t.Error("expected roles listed one per line, default first, was", body)
+	}
+}
+
 func TestReturnRoleWhenRetryingFollowingError(t *testing.T) {
 	defer leaktest.Check(t)()
 
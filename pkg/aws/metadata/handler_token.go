@@ -0,0 +1,148 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.com/uswitch/kiam/pkg/server"
+)
+
+// tokenHandler issues IMDSv2 session tokens for the requesting pod. As the
+// instance's metadata service does, requests with an X-Forwarded-For header
+// are rejected so tokens can't be requested through a proxy, unless they're
+// from a trusted proxy.
+type tokenHandler struct {
+	tokens         *sessionTokens
+	getClientIP    clientIPFunc
+	trustedProxies []*net.IPNet
+}
+
+func (h *tokenHandler) Install(router *mux.Router) {
+	router.Handle("/{version}/api/token", adapt(withMeter("token", h))).Methods(http.MethodPut)
+}
+
+func (h *tokenHandler) Handle(ctx context.Context, w http.ResponseWriter, req *http.Request) (int, error) {
+	timer := prometheus.NewTimer(handlerTimer.WithLabelValues("token"))
+	defer timer.ObserveDuration()
+
+	if _, forwarded := req.Header["X-Forwarded-For"]; forwarded && !h.fromTrustedProxy(req) {
+		return http.StatusForbidden, fmt.Errorf("token requests through a proxy aren't permitted")
+	}
+
+	err := req.ParseForm()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	seconds, err := strconv.Atoi(req.Header.Get(tokenTTLHeader))
+	if err != nil || seconds < 1 || time.Duration(seconds)*time.Second > maxTokenTTL {
+		return http.StatusBadRequest, fmt.Errorf("%s must be between 1 and %d seconds", tokenTTLHeader, int(maxTokenTTL.Seconds()))
+	}
+
+	ip, err := h.getClientIP(req)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	token := h.tokens.Issue(ip, server.PodUIDFromContext(ctx), time.Duration(seconds)*time.Second)
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set(tokenTTLHeader, strconv.Itoa(seconds))
+	fmt.Fprint(w, token)
+	success.WithLabelValues("token").Inc()
+
+	return http.StatusOK, nil
+}
+
+func (h *tokenHandler) fromTrustedProxy(req *http.Request) bool {
+	ip, err := ParseClientIP(req.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	return isTrustedProxy(net.ParseIP(ip), h.trustedProxies)
+}
+
+func newTokenHandler(tokens *sessionTokens, getClientIP clientIPFunc, trustedProxies []*net.IPNet) *tokenHandler {
+	return &tokenHandler{
+		tokens:         tokens,
+		getClientIP:    getClientIP,
+		trustedProxies: trustedProxies,
+	}
+}
+
+// requireSessionToken returns middleware that validates session tokens were
+// issued to the requesting pod, so must run after pods are identified.
+// Requests without a token are only permitted when required is false.
+func requireSessionToken(tokens *sessionTokens, getClientIP clientIPFunc, required bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			err := validateSessionToken(tokens, getClientIP, required, req)
+			if err != nil {
+				tokenDenies.Inc()
+				log.WithFields(requestFields(req)).Warnf("rejected request: %s", err.Error())
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+func validateSessionToken(tokens *sessionTokens, getClientIP clientIPFunc, required bool, req *http.Request) error {
+	token := req.Header.Get(tokenHeader)
+	if token == "" {
+		if required {
+			return ErrMissingToken
+		}
+		return nil
+	}
+
+	err := req.ParseForm()
+	if err != nil {
+		return err
+	}
+
+	ip, err := getClientIP(req)
+	if err != nil {
+		return err
+	}
+
+	return tokens.Validate(token, ip, server.PodUIDFromContext(req.Context()))
+}
+
+// withUpstreamToken replaces the pod's session token, which the instance's
+// metadata service wouldn't accept, with one requested by the agent.
+func withUpstreamToken(backingService http.Handler, upstream *upstreamToken) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.Header.Del(tokenHeader)
+
+		token, err := upstream.Get(req.Context())
+		if err != nil {
+			log.WithFields(requestFields(req)).Warnf("error requesting session token, proxying without: %s", err.Error())
+		} else {
+			req.Header.Set(tokenHeader, token)
+		}
+
+		backingService.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,261 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	st "github.com/uswitch/kiam/pkg/testutil/server"
+)
+
+func TestValidatesSessionTokens(t *testing.T) {
+	tokens, _ := newSessionTokens()
+	now := time.Now()
+	tokens.now = func() time.Time { return now }
+
+	token := tokens.Issue("10.0.0.1", "", time.Minute)
+	if err := tokens.Validate(token, "10.0.0.1", ""); err != nil {
+		t.Error("expected token to be valid, was", err)
+	}
+	if err := tokens.Validate(token, "10.0.0.2", ""); err != ErrInvalidToken {
+		t.Error("expected token issued to another ip to be invalid, was", err)
+	}
+	if err := tokens.Validate(token+"x", "10.0.0.1", ""); err != ErrInvalidToken {
+		t.Error("expected tampered token to be invalid, was", err)
+	}
+
+	if err := tokens.Validate(token, "10.0.0.1", "uid"); err != ErrInvalidToken {
+		t.Error("expected token issued to another pod with the ip to be invalid, was", err)
+	}
+
+	bound := tokens.Issue("10.0.0.1", "uid", time.Minute)
+	if err := tokens.Validate(bound, "10.0.0.1", "uid"); err != nil {
+		t.Error("expected token issued to pod uid to be valid, was", err)
+	}
+	if err := tokens.Validate(bound, "10.0.0.1", "other-uid"); err != ErrInvalidToken {
+		t.Error("expected token issued to another hostNetwork pod to be invalid, was", err)
+	}
+
+	other, _ := newSessionTokens()
+	if err := other.Validate(token, "10.0.0.1", ""); err != ErrInvalidToken {
+		t.Error("expected token issued by another agent to be invalid, was", err)
+	}
+
+	now = now.Add(time.Minute)
+	if err := tokens.Validate(token, "10.0.0.1", ""); err != ErrExpiredToken {
+		t.Error("expected token to have expired, was", err)
+	}
+}
+
+func newTokenTestServer(t *testing.T, mode string, metadata http.Handler) (http.Handler, func()) {
+	backing := httptest.NewServer(metadata)
+
+	client := st.NewStubClient().WithRoles(st.GetRoleResult{Role: "role"})
+	config := &ServerOptions{
+		MetadataEndpoint: backing.URL,
+		AllowIPQuery:     true,
+		AllowRouteRegexp: regexp.MustCompile("^/latest/meta-data/instance-id$"),
+		IMDSv2:           mode,
+	}
+	srv, err := buildHTTPServer(config, client)
+	if err != nil {
+		backing.Close()
+		t.Fatal(err)
+	}
+	return srv.Handler, backing.Close
+}
+
+func request(handler http.Handler, method, path, token string, headers ...string) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest(method, path, http.NoBody)
+	if token != "" {
+		r.Header.Set(tokenHeader, token)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, r)
+	return rr
+}
+
+func TestIssuesSessionTokens(t *testing.T) {
+	handler, closeBacking := newTokenTestServer(t, IMDSv2Optional, http.NotFoundHandler())
+	defer closeBacking()
+
+	rr := request(handler, http.MethodPut, "/latest/api/token?ip=10.0.0.1", "")
+	if rr.Code != http.StatusBadRequest {
+		t.Error("expected token request without ttl to be rejected, was", rr.Code)
+	}
+
+	rr = request(handler, http.MethodPut, "/latest/api/token?ip=10.0.0.1", "", tokenTTLHeader, "21601")
+	if rr.Code != http.StatusBadRequest {
+		t.Error("expected token request with ttl over 6 hours to be rejected, was", rr.Code)
+	}
+
+	rr = request(handler, http.MethodPut, "/latest/api/token?ip=10.0.0.1", "", tokenTTLHeader, "60")
+	if rr.Code != http.StatusOK {
+		t.Fatal("expected token to be issued, was", rr.Code)
+	}
+	if rr.Header().Get(tokenTTLHeader) != "60" {
+		t.Error("expected ttl header, was", rr.Header().Get(tokenTTLHeader))
+	}
+	token := rr.Body.String()
+
+	rr = request(handler, http.MethodGet, "/latest/meta-data/iam/security-credentials/?ip=10.0.0.1", token)
+	if rr.Code != http.StatusOK || rr.Body.String() != "role" {
+		t.Error("expected role with valid token, was", rr.Code, rr.Body.String())
+	}
+
+	rr = request(handler, http.MethodGet, "/latest/meta-data/iam/security-credentials/?ip=10.0.0.2", token)
+	if rr.Code != http.StatusUnauthorized {
+		t.Error("expected token issued to another pod to be rejected, was", rr.Code)
+	}
+
+	rr = request(handler, http.MethodGet, "/latest/meta-data/iam/security-credentials/?ip=10.0.0.2", "")
+	if rr.Code != http.StatusOK {
+		t.Error("expected request without token to be permitted when optional, was", rr.Code)
+	}
+}
+
+func TestRequiresSessionTokens(t *testing.T) {
+	handler, closeBacking := newTokenTestServer(t, IMDSv2Required, http.NotFoundHandler())
+	defer closeBacking()
+
+	rr := request(handler, http.MethodGet, "/latest/meta-data/iam/security-credentials/?ip=10.0.0.1", "")
+	if rr.Code != http.StatusUnauthorized {
+		t.Error("expected role request without token to be rejected, was", rr.Code)
+	}
+
+	rr = request(handler, http.MethodGet, "/latest/meta-data/iam/security-credentials/role?ip=10.0.0.1", "")
+	if rr.Code != http.StatusUnauthorized {
+		t.Error("expected credentials request without token to be rejected, was", rr.Code)
+	}
+
+	rr = request(handler, http.MethodGet, "/ping", "")
+	if rr.Code != http.StatusOK {
+		t.Error("expected ping without token to be permitted, was", rr.Code)
+	}
+}
+
+func TestProxiesWithUpstreamSessionToken(t *testing.T) {
+	var tokenRequests int
+	metadata := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && r.URL.Path == "/latest/api/token" {
+			tokenRequests++
+			fmt.Fprint(w, "upstream-token")
+			return
+		}
+		if r.Header.Get(tokenHeader) != "upstream-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "i-12345")
+	})
+	handler, closeBacking := newTokenTestServer(t, IMDSv2Required, metadata)
+	defer closeBacking()
+
+	token := request(handler, http.MethodPut, "/latest/api/token?ip=10.0.0.1", "", tokenTTLHeader, "60").Body.String()
+
+	for i := 0; i < 2; i++ {
+		rr := request(handler, http.MethodGet, "/latest/meta-data/instance-id?ip=10.0.0.1", token)
+		if rr.Code != http.StatusOK || rr.Body.String() != "i-12345" {
+			t.Error("expected proxied request with upstream token, was", rr.Code, rr.Body.String())
+		}
+	}
+
+	if tokenRequests != 1 {
+		t.Error("expected upstream token to be cached, requested", tokenRequests)
+	}
+}
+
+func TestRejectsForwardedTokenRequests(t *testing.T) {
+	tokens, _ := newSessionTokens()
+	_, trusted, _ := net.ParseCIDR("10.1.0.0/16")
+	handler := adapt(newTokenHandler(tokens, func(req *http.Request) (string, error) { return "10.0.0.1", nil }, []*net.IPNet{trusted}))
+
+	tokenRequest := func(remoteAddr string) int {
+		r, _ := http.NewRequest(http.MethodPut, "/latest/api/token", http.NoBody)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set(tokenTTLHeader, "60")
+		r.Header.Set("X-Forwarded-For", "10.0.0.1")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, r)
+		return rr.Code
+	}
+
+	if code := tokenRequest("10.0.0.1:1234"); code != http.StatusForbidden {
+		t.Error("expected forwarded token request to be forbidden, was", code)
+	}
+	if code := tokenRequest("10.1.0.1:1234"); code != http.StatusOK {
+		t.Error("expected token request forwarded by trusted proxy to be issued, was", code)
+	}
+}
+
+func TestRefreshesUpstreamTokenWithoutBlocking(t *testing.T) {
+	release := make(chan struct{})
+	var tokenRequests int
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		if tokenRequests > 1 {
+			<-release
+		}
+		fmt.Fprintf(w, "upstream-token-%d", tokenRequests)
+	}))
+	defer metadata.Close()
+	defer close(release)
+
+	upstream := newUpstreamToken(metadata.URL)
+	if token, err := upstream.Get(context.Background()); err != nil || token != "upstream-token-1" {
+		t.Fatal("expected upstream token, was", token, err)
+	}
+
+	// the token is due to be refreshed, but is still valid
+	upstream.mu.Lock()
+	upstream.expires = time.Now().Add(upstreamTokenRefresh / 2)
+	upstream.mu.Unlock()
+
+	refreshing := make(chan struct{})
+	go func() {
+		defer close(refreshing)
+		upstream.Get(context.Background())
+	}()
+	for {
+		upstream.mu.Lock()
+		started := upstream.refreshing
+		upstream.mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if token, err := upstream.Get(ctx); err != nil || token != "upstream-token-1" {
+		t.Error("expected cached token while refreshing, was", token, err)
+	}
+
+	release <- struct{}{}
+	<-refreshing
+	if token, _ := upstream.Get(context.Background()); token != "upstream-token-2" {
+		t.Error("expected refreshed token, was", token)
+	}
+}
@@ -86,6 +86,69 @@ var (
 			Help:      "Number of access requests to the proxy handler that were blocked by the regexp",
 		},
 	)
+
+	tokenDenies = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "kiam",
+			Subsystem: "metadata",
+			Name:      "session_token_rejections_total",
+			Help:      "Number of requests rejected because their session token was missing or invalid",
+		},
+	)
+
+	socketOwnerIdentified = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "kiam",
+			Subsystem: "metadata",
+			Name:      "socket_owner_identified_total",
+			Help:      "Number of requests from hostNetwork pods identified by the pod owning the connection",
+		},
+	)
+
+	containerTokenRejected = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "kiam",
+			Subsystem: "metadata",
+			Name:      "container_credentials_token_rejections_total",
+			Help:      "Number of container credentials requests rejected because their service account token was missing, invalid or issued for another pod",
+		},
+	)
+	nodeCredentialsPassthrough = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "kiam",
+			Subsystem: "metadata",
+			Name:      "node_credentials_passthrough_total",
+			Help:      "Number of credentials requests from allowlisted pods passed through to the instance metadata service",
+		},
+		[]string{"namespace"},
+	)
+
+	requestsThrottled = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "kiam",
+			Subsystem: "metadata",
+			Name:      "requests_throttled_total",
+			Help:      "Number of requests rejected because the pod exceeded its rate limit",
+		},
+		[]string{"namespace"},
+	)
+
+	proxyCacheHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "kiam",
+			Subsystem: "metadata",
+			Name:      "proxy_cache_hits_total",
+			Help:      "Number of proxied requests answered from the agent's cache",
+		},
+	)
+	proxyCacheMisses = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "kiam",
+			Subsystem: "metadata",
+			Name:      "proxy_cache_misses_total",
+			Help:      "Number of cacheable proxied requests sent to the instance metadata service",
+		},
+	)
 )
 
 func init() {
@@ -97,4 +160,11 @@ func init() {
 	prometheus.MustRegister(success)
 	prometheus.MustRegister(responses)
 	prometheus.MustRegister(proxyDenies)
+	prometheus.MustRegister(tokenDenies)
+	prometheus.MustRegister(socketOwnerIdentified)
+	prometheus.MustRegister(containerTokenRejected)
+	prometheus.MustRegister(nodeCredentialsPassthrough)
+	prometheus.MustRegister(requestsThrottled)
+	prometheus.MustRegister(proxyCacheHits)
+	prometheus.MustRegister(proxyCacheMisses)
 }
@@ -0,0 +1,159 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metadata
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/cache"
+)
+
+// proxyCacheMax bounds the proxied responses cached, evicting the least
+// recently used.
+const proxyCacheMax = 1024
+
+// uncachedRouteRegexp matches the paths of credentials and session tokens,
+// whose responses are never cached.
+var uncachedRouteRegexp = regexp.MustCompile("/(iam/security-credentials|identity-credentials)(/|$)|/api/token$")
+
+// ProxyCacheTTL caches proxied responses for paths matching Pattern for TTL.
+type ProxyCacheTTL struct {
+	Pattern *regexp.Regexp
+	TTL     time.Duration
+}
+
+// ParseProxyCacheTTL parses a PATTERN=TTL flag value, such as
+// ^/latest/meta-data/placement/=1h.
+func ParseProxyCacheTTL(value string) (*ProxyCacheTTL, error) {
+	separator := strings.LastIndex(value, "=")
+	if separator == -1 {
+		return nil, fmt.Errorf("expected PATTERN=TTL, was %q", value)
+	}
+
+	pattern, err := regexp.Compile(value[:separator])
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := time.ParseDuration(value[separator+1:])
+	if err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("ttl must be positive, was %s", ttl)
+	}
+
+	return &ProxyCacheTTL{Pattern: pattern, TTL: ttl}, nil
+}
+
+type cachedResponse struct {
+	header http.Header
+	body   []byte
+}
+
+func (c *cachedResponse) writeTo(w http.ResponseWriter) {
+	for name, values := range c.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(c.body)
+}
+
+// responseRecorder buffers the backing service's response so it can be
+// cached before being written.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   []byte
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	r.status = statusCode
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body = append(r.body, b...)
+	return len(b), nil
+}
+
+// responseCache caches the backing service's successful responses to GET
+// requests for paths with a TTL.
+type responseCache struct {
+	ttls      []*ProxyCacheTTL
+	responses *cache.LRUExpireCache
+}
+
+// ttl returns how long the response to the request can be cached, or 0 when
+// it can't be.
+func (c *responseCache) ttl(req *http.Request) time.Duration {
+	if req.Method != http.MethodGet || uncachedRouteRegexp.MatchString(req.URL.Path) {
+		return 0
+	}
+	for _, ttl := range c.ttls {
+		if ttl.Pattern.MatchString(req.URL.Path) {
+			return ttl.TTL
+		}
+	}
+	return 0
+}
+
+// key separates responses by the session token sent to the instance metadata
+// service, so that a request is only answered with a response to the same
+// token, or to another request without one.
+func (c *responseCache) key(req *http.Request) string {
+	return req.Header.Get(tokenHeader) + " " + req.URL.RequestURI()
+}
+
+// withResponseCache answers requests for paths matching the ttls from the
+// responses cached for them, requesting them from the backing service when
+// they're missing or have expired.
+func withResponseCache(backingService http.Handler, ttls []*ProxyCacheTTL) http.Handler {
+	c := &responseCache{ttls: ttls, responses: cache.NewLRUExpireCache(proxyCacheMax)}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ttl := c.ttl(req)
+		if ttl == 0 {
+			backingService.ServeHTTP(w, req)
+			return
+		}
+
+		key := c.key(req)
+		if cached, found := c.responses.Get(key); found {
+			proxyCacheHits.Inc()
+			cached.(*cachedResponse).writeTo(w)
+			return
+		}
+		proxyCacheMisses.Inc()
+
+		recorder := &responseRecorder{header: make(http.Header), status: http.StatusOK}
+		backingService.ServeHTTP(recorder, req)
+
+		for name, values := range recorder.header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(recorder.status)
+		w.Write(recorder.body)
+
+		if recorder.status == http.StatusOK {
+			c.responses.Add(key, &cachedResponse{header: recorder.header, body: recorder.body}, ttl)
+		}
+	})
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metadata
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// countingService responds with the request's token, counting the requests
+// for each path.
+type countingService struct {
+	status int
+	hits   map[string]int
+}
+
+func (s *countingService) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.hits[req.URL.Path]++
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(s.status)
+	w.Write([]byte("token:" + req.Header.Get(tokenHeader)))
+}
+
+func requestCached(handler http.Handler, method, path, token string) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest(method, path, nil)
+	if token != "" {
+		r.Header.Set(tokenHeader, token)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, r)
+	return rr
+}
+
+func TestCachesProxiedResponses(t *testing.T) {
+	service := &countingService{status: http.StatusOK, hits: make(map[string]int)}
+	handler := withResponseCache(service, []*ProxyCacheTTL{{Pattern: regexp.MustCompile("^/latest/meta-data/placement/"), TTL: time.Minute}})
+
+	hitsInitial := readPrometheusSimpleCounterValue("kiam_metadata_proxy_cache_hits_total")
+	for i := 0; i < 3; i++ {
+		rr := requestCached(handler, http.MethodGet, "/latest/meta-data/placement/region", "")
+		if rr.Code != http.StatusOK || rr.Body.String() != "token:" || rr.Header().Get("Content-Type") != "text/plain" {
+			t.Error("unexpected response", rr.Code, rr.Body.String(), rr.Header())
+		}
+	}
+	if hits := service.hits["/latest/meta-data/placement/region"]; hits != 1 {
+		t.Error("expected one request to the backing service, was", hits)
+	}
+	if hits := readPrometheusSimpleCounterValue("kiam_metadata_proxy_cache_hits_total") - hitsInitial; hits != 2 {
+		t.Error("expected 2 cache hits, was", hits)
+	}
+
+	requestCached(handler, http.MethodGet, "/latest/meta-data/instance-id", "")
+	requestCached(handler, http.MethodGet, "/latest/meta-data/instance-id", "")
+	if hits := service.hits["/latest/meta-data/instance-id"]; hits != 2 {
+		t.Error("expected paths without a ttl not cached, requested", hits)
+	}
+}
+
+func TestSeparatesCachedResponsesBySessionToken(t *testing.T) {
+	service := &countingService{status: http.StatusOK, hits: make(map[string]int)}
+	handler := withResponseCache(service, []*ProxyCacheTTL{{Pattern: regexp.MustCompile(".*"), TTL: time.Minute}})
+
+	for _, token := range []string{"", "token-a", "token-b", "token-a", ""} {
+		rr := requestCached(handler, http.MethodGet, "/latest/meta-data/instance-id", token)
+		if rr.Body.String() != "token:"+token {
+			t.Errorf("expected response to token %q, was %s", token, rr.Body.String())
+		}
+	}
+	if hits := service.hits["/latest/meta-data/instance-id"]; hits != 3 {
+		t.Error("expected a request for each token, was", hits)
+	}
+}
+
+func TestDoesntCacheCredentialsOrErrors(t *testing.T) {
+	service := &countingService{status: http.StatusOK, hits: make(map[string]int)}
+	handler := withResponseCache(service, []*ProxyCacheTTL{{Pattern: regexp.MustCompile(".*"), TTL: time.Minute}})
+
+	for _, path := range []string{"/latest/meta-data/iam/security-credentials/role", "/latest/meta-data/identity-credentials/ec2/security-credentials/ec2-instance"} {
+		requestCached(handler, http.MethodGet, path, "")
+		requestCached(handler, http.MethodGet, path, "")
+		if hits := service.hits[path]; hits != 2 {
+			t.Errorf("expected %s not cached, requested %d", path, hits)
+		}
+	}
+
+	requestCached(handler, http.MethodPut, "/latest/api/token", "")
+	requestCached(handler, http.MethodPut, "/latest/api/token", "")
+	if hits := service.hits["/latest/api/token"]; hits != 2 {
+		t.Error("expected token requests not cached, requested", hits)
+	}
+
+	service.status = http.StatusUnauthorized
+	requestCached(handler, http.MethodGet, "/latest/meta-data/instance-id", "")
+	rr := requestCached(handler, http.MethodGet, "/latest/meta-data/instance-id", "")
+	if hits := service.hits["/latest/meta-data/instance-id"]; hits != 2 || rr.Code != http.StatusUnauthorized {
+		t.Error("expected errors not cached, requested", hits, rr.Code)
+	}
+}
+
+func TestParsesProxyCacheTTL(t *testing.T) {
+	ttl, err := ParseProxyCacheTTL("^/latest/meta-data/placement/=1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl.Pattern.String() != "^/latest/meta-data/placement/" || ttl.TTL != time.Hour {
+		t.Error("unexpected ttl", ttl.Pattern, ttl.TTL)
+	}
+
+	for _, invalid := range []string{"^/latest/", "^/latest/=soon", "(=1h", "^/latest/=0s"} {
+		if _, err := ParseProxyCacheTTL(invalid); err == nil {
+			t.Error("expected error parsing", invalid)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// proxyHeaderTimeout bounds how long a trusted proxy has to send the
+	// PROXY protocol header after connecting.
+	proxyHeaderTimeout = 5 * time.Second
+
+	// proxyV1MaxLength is the longest v1 header, including its CRLF.
+	proxyV1MaxLength = 107
+)
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	ErrInvalidProxyHeader = fmt.Errorf("invalid proxy protocol header")
+)
+
+// isTrustedProxy returns whether the ip is in one of the trusted ranges.
+func isTrustedProxy(ip net.IP, trusted []*net.IPNet) bool {
+	for _, cidr := range trusted {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyProtocolListener reads the HAProxy PROXY protocol (v1 or v2) header
+// sent by trusted proxies, so that connections they forward have the address
+// of the original client. Connections from other addresses are used as they
+// are; they can't claim another address by sending a header.
+type proxyProtocolListener struct {
+	net.Listener
+	trusted []*net.IPNet
+}
+
+func newProxyProtocolListener(listener net.Listener, trusted []*net.IPNet) net.Listener {
+	return &proxyProtocolListener{Listener: listener, trusted: trusted}
+}
+
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !isTrustedProxy(addr.IP, l.trusted) {
+		return conn, nil
+	}
+	// the header is read when the connection is first used, so a slow proxy
+	// doesn't block accepting other connections
+	return &proxyProtocolConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// proxyProtocolConn is a connection from a trusted proxy, whose remote address
+// is read from the PROXY protocol header.
+type proxyProtocolConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+func (c *proxyProtocolConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+
+		c.remoteAddr, c.err = readProxyHeader(c.reader)
+		if c.err == nil && c.remoteAddr == nil {
+			// LOCAL connections, such as the proxy's health checks
+			c.remoteAddr = c.Conn.RemoteAddr()
+		}
+	})
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the original client's address. Requests are never read
+// from connections with an invalid header, so the proxy's address is only
+// returned for them.
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.err != nil {
+		return c.Conn.RemoteAddr()
+	}
+	return c.remoteAddr
+}
+
+// readProxyHeader reads a v1 or v2 header, returning the source address or nil
+// when the connection wasn't proxied for a client.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	signature, err := r.Peek(len(proxyV2Signature))
+	if err == nil && bytes.Equal(signature, proxyV2Signature) {
+		return readProxyHeaderV2(r)
+	}
+
+	prefix, err := r.Peek(len(proxyV1Prefix))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(prefix, proxyV1Prefix) {
+		return readProxyHeaderV1(r)
+	}
+
+	return nil, ErrInvalidProxyHeader
+}
+
+// readProxyHeaderV1 reads the text header: PROXY TCP4|TCP6 <src> <dst>
+// <src port> <dst port>, or PROXY UNKNOWN.
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLength {
+			return nil, ErrInvalidProxyHeader
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Fields(strings.TrimSuffix(string(line), "\r\n"))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (ip.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyHeaderV2 reads the binary header: the signature, version and
+// command, address family and protocol, length, then the addresses.
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxyV2Signature)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	versionCommand := header[12]
+	family := header[13]
+	length := binary.BigEndian.Uint16(header[14:16])
+
+	addresses := make([]byte, length)
+	if _, err := io.ReadFull(r, addresses); err != nil {
+		return nil, err
+	}
+
+	if versionCommand>>4 != 2 {
+		return nil, ErrInvalidProxyHeader
+	}
+	switch versionCommand & 0xf {
+	case 0x0: // LOCAL
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, ErrInvalidProxyHeader
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(addresses) < 12 {
+			return nil, ErrInvalidProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(addresses[0:4]), Port: int(binary.BigEndian.Uint16(addresses[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(addresses) < 36 {
+			return nil, ErrInvalidProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(addresses[0:16]), Port: int(binary.BigEndian.Uint16(addresses[32:34]))}, nil
+	case 0x00: // UNSPEC
+		return nil, nil
+	default:
+		return nil, ErrInvalidProxyHeader
+	}
+}
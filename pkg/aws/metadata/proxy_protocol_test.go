@@ -0,0 +1,250 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metadata
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fortytw2/leaktest"
+)
+
+func mustParseCIDRs(t *testing.T, cidrs ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func TestReadsProxyHeaderV1(t *testing.T) {
+	addr, err := readProxyHeader(bufio.NewReader(strings.NewReader("PROXY TCP4 10.0.0.1 169.254.169.254 40000 80\r\nGET / HTTP/1.1\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != "10.0.0.1:40000" {
+		t.Error("unexpected address:", addr)
+	}
+
+	addr, err = readProxyHeader(bufio.NewReader(strings.NewReader("PROXY TCP6 fd00::a fd00:ec2::254 40000 80\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != "[fd00::a]:40000" {
+		t.Error("unexpected address:", addr)
+	}
+}
+
+func TestReadsUnknownProxyHeaderV1(t *testing.T) {
+	addr, err := readProxyHeader(bufio.NewReader(strings.NewReader("PROXY UNKNOWN\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != nil {
+		t.Error("expected no address, was", addr)
+	}
+}
+
+func TestRejectsInvalidProxyHeaders(t *testing.T) {
+	headers := []string{
+		"GET / HTTP/1.1\r\n",
+		"PROXY TCP4 10.0.0.1 169.254.169.254 40000\r\n",
+		"PROXY TCP4 fd00::a 169.254.169.254 40000 80\r\n",
+		"PROXY UDP4 10.0.0.1 169.254.169.254 40000 80\r\n",
+		"PROXY TCP4 10.0.0.1 169.254.169.254 400000 80\r\n",
+		"PROXY " + strings.Repeat("A", 200) + "\r\n",
+	}
+	for _, header := range headers {
+		_, err := readProxyHeader(bufio.NewReader(strings.NewReader(header)))
+		if err == nil {
+			t.Errorf("expected error reading %q", header)
+		}
+	}
+}
+
+func proxyHeaderV2(command, family byte, addresses []byte) string {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, command, family, byte(len(addresses)>>8), byte(len(addresses)))
+	return string(append(header, addresses...))
+}
+
+func TestReadsProxyHeaderV2(t *testing.T) {
+	ipv4 := []byte{10, 0, 0, 1, 169, 254, 169, 254, 0x9c, 0x40, 0, 80}
+	addr, err := readProxyHeader(bufio.NewReader(strings.NewReader(proxyHeaderV2(0x21, 0x11, ipv4))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != "10.0.0.1:40000" {
+		t.Error("unexpected address:", addr)
+	}
+
+	ipv6 := make([]byte, 36)
+	copy(ipv6, net.ParseIP("fd00::a"))
+	copy(ipv6[16:], net.ParseIP("fd00:ec2::254"))
+	ipv6[32], ipv6[33], ipv6[35] = 0x9c, 0x40, 80
+	addr, err = readProxyHeader(bufio.NewReader(strings.NewReader(proxyHeaderV2(0x21, 0x21, ipv6))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != "[fd00::a]:40000" {
+		t.Error("unexpected address:", addr)
+	}
+}
+
+func TestReadsLocalProxyHeaderV2(t *testing.T) {
+	addr, err := readProxyHeader(bufio.NewReader(strings.NewReader(proxyHeaderV2(0x20, 0x00, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != nil {
+		t.Error("expected no address, was", addr)
+	}
+
+	_, err = readProxyHeader(bufio.NewReader(strings.NewReader(proxyHeaderV2(0x31, 0x11, make([]byte, 12)))))
+	if err == nil {
+		t.Error("expected error with unsupported version")
+	}
+}
+
+// acceptOne connects to the listener, sending the data, and returns the
+// accepted connection's remote address and what was read from it.
+func acceptOne(t *testing.T, trusted []*net.IPNet, data string) (string, string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener = newProxyProtocolListener(listener, trusted)
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	client.(*net.TCPConn).CloseWrite()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	body, err := ioutil.ReadAll(conn)
+	return conn.RemoteAddr().String(), string(body), err
+}
+
+func TestUsesProxyHeaderFromTrustedProxies(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	addr, body, err := acceptOne(t, mustParseCIDRs(t, "127.0.0.0/8"), "PROXY TCP4 10.0.0.1 169.254.169.254 40000 80\r\nhello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != "10.0.0.1:40000" {
+		t.Error("unexpected remote address:", addr)
+	}
+	if body != "hello" {
+		t.Error("unexpected body:", body)
+	}
+}
+
+func TestRejectsConnectionsWithoutProxyHeaderFromTrustedProxies(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	addr, _, err := acceptOne(t, mustParseCIDRs(t, "127.0.0.0/8"), "GET / HTTP/1.1\r\n\r\n")
+	if err != ErrInvalidProxyHeader {
+		t.Error("expected invalid header error, was", err)
+	}
+	if !strings.HasPrefix(addr, "127.0.0.1:") {
+		t.Error("expected proxy's address, was", addr)
+	}
+}
+
+func TestIgnoresProxyHeaderFromUntrustedPeers(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	header := "PROXY TCP4 10.0.0.1 169.254.169.254 40000 80\r\n"
+	addr, body, err := acceptOne(t, mustParseCIDRs(t, "192.168.0.0/16"), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(addr, "127.0.0.1:") {
+		t.Error("expected peer's address, was", addr)
+	}
+	if body != header {
+		t.Error("expected header to be read as data, was", body)
+	}
+}
+
+func TestUsesForwardedForFromTrustedProxies(t *testing.T) {
+	config := DefaultOptions()
+	config.TrustForwardedFor = true
+	config.TrustedProxies = mustParseCIDRs(t, "127.0.0.0/8", "10.1.0.0/16")
+	clientIP := buildClientIP(config)
+
+	req := httptest.NewRequest("GET", "/latest/meta-data/iam/security-credentials/", nil)
+	req.RemoteAddr = "127.0.0.1:9000"
+	req.Header.Add("X-Forwarded-For", "192.168.0.1, 10.0.0.1")
+	req.Header.Add("X-Forwarded-For", "10.1.0.1")
+	ip, err := clientIP(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "10.0.0.1" {
+		t.Error("expected nearest untrusted address, was", ip)
+	}
+
+	req.RemoteAddr = "10.0.0.2:9000"
+	ip, err = clientIP(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "10.0.0.2" {
+		t.Error("expected untrusted peer's address, was", ip)
+	}
+}
+
+func TestIgnoresForwardedForByDefault(t *testing.T) {
+	req := httptest.NewRequest("GET", "/latest/meta-data/iam/security-credentials/", nil)
+	req.RemoteAddr = "127.0.0.1:9000"
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+
+	ip, err := buildClientIP(DefaultOptions())(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "127.0.0.1" {
+		t.Error("expected remote address, was", ip)
+	}
+}
+
+func TestRequiresTrustedProxies(t *testing.T) {
+	config := DefaultOptions()
+	config.ProxyProtocol = true
+	_, err := NewWebServer(config, nil)
+	if err == nil {
+		t.Error("expected error without trusted proxies")
+	}
+}
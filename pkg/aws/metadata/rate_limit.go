@@ -0,0 +1,141 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metadata
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/uswitch/kiam/pkg/server"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const (
+	// rateLimitersMax bounds the pods whose limiters are kept, evicting the
+	// least recently used.
+	rateLimitersMax = 4096
+	// rateLimiterIdle is how long a pod's limiter is kept after its last
+	// request. Pods are given a full bucket after being idle for longer.
+	rateLimiterIdle = 10 * time.Minute
+
+	unknownNamespace = "unknown"
+)
+
+// RateLimit limits each pod's requests with a token bucket refilled at QPS,
+// holding up to Burst requests. Throttled requests are counted by the
+// namespace of the pod Pods finds making them, when set.
+type RateLimit struct {
+	QPS   float32
+	Burst int
+	Pods  PodFinder
+}
+
+// namespace returns the namespace of the pod making the request, or
+// unknownNamespace when it can't be found.
+func (l *RateLimit) namespace(req *http.Request, ip string) string {
+	if l.Pods == nil {
+		return unknownNamespace
+	}
+
+	var pod *v1.Pod
+	var err error
+	if uid := server.PodUIDFromContext(req.Context()); uid != "" {
+		pod, err = l.Pods.GetPodByUID(uid)
+	} else {
+		pod, err = l.Pods.GetPodByIP(ip)
+	}
+	if err != nil || pod == nil {
+		return unknownNamespace
+	}
+	return pod.GetNamespace()
+}
+
+// podRateLimiters holds the token bucket of each pod, identified by its uid
+// when known, or its IP address.
+type podRateLimiters struct {
+	config   *RateLimit
+	mu       sync.Mutex
+	limiters *cache.LRUExpireCache
+}
+
+func newPodRateLimiters(config *RateLimit) *podRateLimiters {
+	return &podRateLimiters{config: config, limiters: cache.NewLRUExpireCache(rateLimitersMax)}
+}
+
+func (l *podRateLimiters) limiter(key string) flowcontrol.RateLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var limiter flowcontrol.RateLimiter
+	if cached, found := l.limiters.Get(key); found {
+		limiter = cached.(flowcontrol.RateLimiter)
+	} else {
+		limiter = flowcontrol.NewTokenBucketRateLimiter(l.config.QPS, l.config.Burst)
+	}
+	l.limiters.Add(key, limiter, rateLimiterIdle)
+	return limiter
+}
+
+// limitPodRequests responds with 429 Too Many Requests, as the instance
+// metadata service does, to pods making requests faster than the limit
+// allows. Requests whose client IP can't be found are limited by the address
+// they were received from.
+func limitPodRequests(limit *RateLimit, getClientIP clientIPFunc) mux.MiddlewareFunc {
+	limiters := newPodRateLimiters(limit)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ip, err := requestClientIP(req, getClientIP)
+			if err != nil {
+				log.WithFields(requestFields(req)).Debugf("limiting request by remote address: %s", err.Error())
+				ip = remoteHost(req)
+			}
+
+			key := ip
+			if uid := server.PodUIDFromContext(req.Context()); uid != "" {
+				key = uid
+			}
+			if !limiters.limiter(key).TryAccept() {
+				namespace := limit.namespace(req, ip)
+				requestsThrottled.WithLabelValues(namespace).Inc()
+				log.WithFields(requestFields(req)).WithField("pod.namespace", namespace).Debugf("throttled request")
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+func requestClientIP(req *http.Request, getClientIP clientIPFunc) (string, error) {
+	if err := req.ParseForm(); err != nil {
+		return "", err
+	}
+	return getClientIP(req)
+}
+
+// remoteHost returns the host of the address the request was received from.
+func remoteHost(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,127 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metadata
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/uswitch/kiam/pkg/aws/sts"
+	"github.com/uswitch/kiam/pkg/testutil"
+	st "github.com/uswitch/kiam/pkg/testutil/server"
+)
+
+func getQueryClientIP(req *http.Request) (string, error) {
+	return req.Form.Get("ip"), nil
+}
+
+func TestThrottlesPodsOverRateLimit(t *testing.T) {
+	limit := &RateLimit{
+		QPS:   0.001,
+		Burst: 2,
+		Pods:  stubPodFinder{"10.0.0.1": testutil.NewPod("team", "noisy", "10.0.0.1", "Running")},
+	}
+	router := mux.NewRouter()
+	router.Use(limitPodRequests(limit, getQueryClientIP))
+	router.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})
+
+	request := func(ip string) int {
+		r, _ := http.NewRequest("GET", "/latest/meta-data/instance-id?ip="+ip, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, r)
+		return rr.Code
+	}
+
+	throttledInitial := readPrometheusCounterValue("kiam_metadata_requests_throttled_total", "namespace", "team")
+	for i := 0; i < 2; i++ {
+		if code := request("10.0.0.1"); code != http.StatusOK {
+			t.Fatal("expected requests within burst allowed, was", code)
+		}
+	}
+	if code := request("10.0.0.1"); code != http.StatusTooManyRequests {
+		t.Error("expected request over limit throttled, was", code)
+	}
+	if code := request("10.0.0.2"); code != http.StatusOK {
+		t.Error("expected other pods' requests allowed, was", code)
+	}
+
+	throttled := readPrometheusCounterValue("kiam_metadata_requests_throttled_total", "namespace", "team")
+	if throttled-throttledInitial != 1 {
+		t.Error("expected 1 throttled request counted for namespace, was", throttled-throttledInitial)
+	}
+}
+
+func TestThrottlesRequestsWithoutClientIPByRemoteAddress(t *testing.T) {
+	limit := &RateLimit{QPS: 0.001, Burst: 1}
+	failingClientIP := func(_ *http.Request) (string, error) {
+		return "", fmt.Errorf("no client ip")
+	}
+	router := mux.NewRouter()
+	router.Use(limitPodRequests(limit, failingClientIP))
+	router.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})
+
+	request := func(remoteAddr string) int {
+		r, _ := http.NewRequest("GET", "/latest/meta-data/instance-id", nil)
+		r.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, r)
+		return rr.Code
+	}
+
+	if code := request("10.0.0.1:40000"); code != http.StatusOK {
+		t.Fatal("expected request within burst allowed, was", code)
+	}
+	if code := request("10.0.0.1:40001"); code != http.StatusTooManyRequests {
+		t.Error("expected request over limit from same address throttled, was", code)
+	}
+	if code := request("10.0.0.2:40000"); code != http.StatusOK {
+		t.Error("expected other addresses' requests allowed, was", code)
+	}
+}
+
+func TestThrottlesTokenAndContainerCredentialsRequests(t *testing.T) {
+	backing := httptest.NewServer(http.NotFoundHandler())
+	defer backing.Close()
+
+	client := st.NewStubClient().WithRoles(st.GetRoleResult{Role: "role"}).WithCredentials(st.GetCredentialsResult{Credentials: &sts.Credentials{AccessKeyId: "A1"}})
+	config := &ServerOptions{
+		MetadataEndpoint:     backing.URL,
+		AllowIPQuery:         true,
+		AllowRouteRegexp:     regexp.MustCompile("^$"),
+		IMDSv2:               IMDSv2Optional,
+		ContainerCredentials: stubTokenReviewer{"pod-token": "pod-uid"},
+		RateLimit:            &RateLimit{QPS: 0.001, Burst: 1},
+	}
+	srv, err := buildHTTPServer(config, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expected := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		rr := request(srv.Handler, http.MethodPut, "/latest/api/token?ip=10.0.0.1", "", tokenTTLHeader, "60")
+		if rr.Code != expected {
+			t.Errorf("expected token request %d to be answered with %d, was %d", i, expected, rr.Code)
+		}
+	}
+	for i, expected := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		rr := request(srv.Handler, http.MethodGet, "/v1/credentials?ip=10.0.0.2", "", "Authorization", "pod-token")
+		if rr.Code != expected {
+			t.Errorf("expected container credentials request %d to be answered with %d, was %d", i, expected, rr.Code)
+		}
+	}
+}
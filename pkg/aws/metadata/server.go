@@ -16,10 +16,12 @@ package metadata
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -34,10 +36,48 @@ type Server struct {
 }
 
 type ServerOptions struct {
+	// ListenHost is the address listened on, or empty for all addresses.
+	ListenHost       string
 	ListenPort       int
 	MetadataEndpoint string
 	AllowIPQuery     bool
 	AllowRouteRegexp *regexp.Regexp
+	// AllowRouteRegexpLimit lets pods' and namespaces' annotated routes
+	// replace AllowRouteRegexp for the paths it matches, when set.
+	AllowRouteRegexpLimit *regexp.Regexp
+	IMDSv2                string
+	// IdentifyHostNetworkPods identifies requests from hostNetwork pods by
+	// the pod owning the connection, found through ProcRoot.
+	IdentifyHostNetworkPods bool
+	ProcRoot                string
+	// ProxyProtocol reads the PROXY protocol header sent by TrustedProxies,
+	// such as sidecar proxies capturing requests, to identify the client.
+	ProxyProtocol bool
+	// TrustForwardedFor identifies the client of requests from
+	// TrustedProxies by their X-Forwarded-For header.
+	TrustForwardedFor bool
+	TrustedProxies    []*net.IPNet
+	// PodIP and PodUID identify every request as from the pod, when the
+	// agent runs as a sidecar in it.
+	PodIP  string
+	PodUID string
+	// ContainerCredentials serves credentials to pods authenticated with
+	// their service account token, reviewed by it, when set.
+	ContainerCredentials server.TokenReviewer
+	// NodeCredentials passes the credentials requests of the pods it allows
+	// through to the instance metadata service, when set.
+	NodeCredentials *NodeCredentialsAllowlist
+	// RateLimit limits the requests of each pod, when set.
+	RateLimit *RateLimit
+	// ListAllRoles lists all the pod's roles at iam/security-credentials/,
+	// rather than only its default role.
+	ListAllRoles bool
+	// RegionAnnotation answers placement/region with the requesting pod's, or
+	// its namespace's, region annotation when it has one.
+	RegionAnnotation bool
+	// ProxyCacheTTLs caches the proxied responses for the paths they match,
+	// using the first matching TTL.
+	ProxyCacheTTLs []*ProxyCacheTTL
 }
 
 func DefaultOptions() *ServerOptions {
@@ -46,6 +86,8 @@ func DefaultOptions() *ServerOptions {
 		ListenPort:       3100,
 		AllowIPQuery:     false,
 		AllowRouteRegexp: regexp.MustCompile("^$"),
+		IMDSv2:           IMDSv2Proxy,
+		ProcRoot:         "/proc",
 	}
 }
 
@@ -58,35 +100,122 @@ func NewWebServer(config *ServerOptions, client server.Client) (*Server, error)
 }
 
 func buildHTTPServer(config *ServerOptions, client server.Client) (*http.Server, error) {
+	if (config.ProxyProtocol || config.TrustForwardedFor) && len(config.TrustedProxies) == 0 {
+		return nil, fmt.Errorf("trusted proxies must be specified to identify clients through a proxy")
+	}
+	// SDKs don't send session tokens with container credentials requests
+	if config.ContainerCredentials != nil && config.IMDSv2 == IMDSv2Required {
+		return nil, fmt.Errorf("container credentials can't be served when session tokens are required")
+	}
+
 	router := mux.NewRouter()
 	router.Handle("/ping", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { fmt.Fprint(w, "pong") }))
 
 	h := newHealthHandler(client, config.MetadataEndpoint)
 	h.Install(router)
 
-	r := newRoleHandler(client, buildClientIP(config))
-	r.Install(router)
-
-	c := newCredentialsHandler(client, buildClientIP(config))
-	c.Install(router)
-
 	metadataURL, err := url.Parse(config.MetadataEndpoint)
 	if err != nil {
 		return nil, err
 	}
+	var backingService http.Handler = httputil.NewSingleHostReverseProxy(metadataURL)
+	// proxied responses are cached by the session token sent upstream
+	proxyBackingService := backingService
+	if len(config.ProxyCacheTTLs) > 0 {
+		proxyBackingService = withResponseCache(backingService, config.ProxyCacheTTLs)
+	}
+	// pods are identified, and rate limited, when they request session tokens
+	// as for other requests
+	var podMiddleware []mux.MiddlewareFunc
+	if config.IdentifyHostNetworkPods {
+		podMiddleware = append(podMiddleware, identifyBySocketOwner(newSocketOwners(config.ProcRoot)))
+	}
+	if config.PodUID != "" {
+		podMiddleware = append(podMiddleware, identifyAsPod(config.PodUID))
+	}
+	if config.RateLimit != nil {
+		podMiddleware = append(podMiddleware, limitPodRequests(config.RateLimit, buildClientIP(config)))
+	}
+	var tokenMiddleware []mux.MiddlewareFunc
 
-	p := newProxyHandler(httputil.NewSingleHostReverseProxy(metadataURL), config.AllowRouteRegexp)
-	p.Install(router)
+	switch config.IMDSv2 {
+	case IMDSv2Proxy:
+	case IMDSv2Optional, IMDSv2Required:
+		tokens, err := newSessionTokens()
+		if err != nil {
+			return nil, err
+		}
+
+		tokenRouter := router.NewRoute().Subrouter()
+		tokenRouter.Use(podMiddleware...)
+		t := newTokenHandler(tokens, buildClientIP(config), config.TrustedProxies)
+		t.Install(tokenRouter)
+
+		tokenMiddleware = append(tokenMiddleware, requireSessionToken(tokens, buildClientIP(config), config.IMDSv2 == IMDSv2Required))
+		upstream := newUpstreamToken(config.MetadataEndpoint)
+		backingService = withUpstreamToken(backingService, upstream)
+		proxyBackingService = withUpstreamToken(proxyBackingService, upstream)
+	default:
+		return nil, fmt.Errorf("unknown imdsv2 mode: %s", config.IMDSv2)
+	}
 
-	listen := fmt.Sprintf(":%d", config.ListenPort)
+	// pod routes are installed on a subrouter so they can require session
+	// tokens, which are validated for the identified pod
+	podRouter := router.NewRoute().Subrouter()
+	podRouter.Use(podMiddleware...)
+	podRouter.Use(tokenMiddleware...)
+	if config.NodeCredentials != nil {
+		podRouter.Use(passthroughNodeCredentials(config.NodeCredentials, buildClientIP(config), backingService))
+	}
+
+	r := newRoleHandler(client, buildClientIP(config))
+	if config.ListAllRoles {
+		r.withAllRoles()
+	}
+	r.Install(podRouter)
+
+	// iam/info is answered with the credentials served to the pod
+	served := newServedCredentials()
+	c := newCredentialsHandler(client, buildClientIP(config)).withServedCredentials(served)
+	c.Install(podRouter)
+
+	if config.ContainerCredentials != nil {
+		h := newContainerCredentialsHandler(client, config.ContainerCredentials)
+		h.Install(podRouter)
+	}
+
+	i := newIAMInfoHandler(client, buildClientIP(config)).withServedCredentials(served)
+	i.Install(podRouter)
+
+	p := newProxyHandler(proxyBackingService, config.AllowRouteRegexp)
+	if config.AllowRouteRegexpLimit != nil {
+		p.withPodRoutes(client, buildClientIP(config), config.AllowRouteRegexpLimit)
+	}
+	if config.RegionAnnotation {
+		g := newRegionHandler(client, buildClientIP(config), p)
+		g.Install(podRouter)
+	}
+	p.Install(podRouter)
+
+	listen := net.JoinHostPort(config.ListenHost, strconv.Itoa(config.ListenPort))
 	return &http.Server{Addr: listen, Handler: loggingHandler(router)}, nil
 }
 
 func buildClientIP(config *ServerOptions) clientIPFunc {
+	if config.PodIP != "" {
+		return func(_ *http.Request) (string, error) {
+			return config.PodIP, nil
+		}
+	}
+
 	remote := func(req *http.Request) (string, error) {
 		return ParseClientIP(req.RemoteAddr)
 	}
 
+	if config.TrustForwardedFor {
+		remote = forwardedFor(config.TrustedProxies, remote)
+	}
+
 	if config.AllowIPQuery {
 		return func(req *http.Request) (string, error) {
 			ip := req.Form.Get("ip")
@@ -100,9 +229,61 @@ func buildClientIP(config *ServerOptions) clientIPFunc {
 	return remote
 }
 
+// forwardedFor returns the client of requests from trusted proxies from their
+// X-Forwarded-For header: the nearest address not itself a trusted proxy.
+// Addresses further along the header could be set by the client, so aren't
+// used. Other requests are identified by their remote address.
+func forwardedFor(trusted []*net.IPNet, remote clientIPFunc) clientIPFunc {
+	return func(req *http.Request) (string, error) {
+		ip, err := remote(req)
+		if err != nil {
+			return "", err
+		}
+		if !isTrustedProxy(net.ParseIP(ip), trusted) {
+			return ip, nil
+		}
+
+		var forwarded []string
+		for _, header := range req.Header["X-Forwarded-For"] {
+			for _, addr := range strings.Split(header, ",") {
+				forwarded = append(forwarded, strings.TrimSpace(addr))
+			}
+		}
+		for i := len(forwarded) - 1; i >= 0; i-- {
+			client := net.ParseIP(forwarded[i])
+			if client == nil {
+				return "", fmt.Errorf("incorrect format, expected ip address in X-Forwarded-For, was: %s", forwarded[i])
+			}
+			if !isTrustedProxy(client, trusted) {
+				return client.String(), nil
+			}
+		}
+
+		return ip, nil
+	}
+}
+
 func (s *Server) Serve() error {
-	log.Infof("listening :%d", s.cfg.ListenPort)
-	return s.server.ListenAndServe()
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return err
+	}
+	if s.cfg.ProxyProtocol {
+		listener = newProxyProtocolListener(listener, s.cfg.TrustedProxies)
+	}
+
+	log.Infof("listening %s", s.server.Addr)
+	return s.server.Serve(listener)
+}
+
+// identifyAsPod identifies requests as from the pod with the uid, for agents
+// running as a sidecar in it.
+func identifyAsPod(uid string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			next.ServeHTTP(w, req.WithContext(server.WithPodUID(req.Context(), uid)))
+		})
+	}
 }
 
 func (s *Server) Stop(ctx context.Context) error {
@@ -111,11 +292,19 @@ func (s *Server) Stop(ctx context.Context) error {
 	return s.server.Shutdown(c)
 }
 
+// ParseClientIP returns the IP address from a remote address: ip:port for
+// IPv4 or [ip]:port for IPv6. IPv6 addresses are returned in their canonical
+// form, matching the Pod IPs reported by Kubernetes.
 func ParseClientIP(addr string) (string, error) {
-	parts := strings.Split(addr, ":")
-	if len(parts) < 2 {
-		return "", fmt.Errorf("incorrect format, expected ip:port, was: %s", addr)
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("incorrect format, expected ip:port or [ip]:port, was: %s", addr)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", fmt.Errorf("incorrect format, expected ip address, was: %s", host)
 	}
 
-	return strings.Join(parts[0:len(parts)-1], ":"), nil
+	return ip.String(), nil
 }
@@ -0,0 +1,322 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metadata
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/uswitch/kiam/pkg/server"
+	"k8s.io/apimachinery/pkg/util/cache"
+)
+
+var (
+	ErrSocketNotFound = fmt.Errorf("socket not found")
+	ErrNoSocketOwner  = fmt.Errorf("no process owns socket")
+	ErrNotPodProcess  = fmt.Errorf("process isn't in a pod's cgroup")
+)
+
+// tcpListen is the state of listening sockets in /proc/net/tcp.
+const tcpListen = "0A"
+
+const (
+	// socketOwnersTTL is how long the owners of sockets, and the network
+	// namespaces of processes, are remembered.
+	socketOwnersTTL = time.Minute
+	// socketOwnersMax is the number of sockets whose owners are remembered.
+	socketOwnersMax = 4096
+	// processNamespacesMax is the number of processes whose network
+	// namespaces are remembered.
+	processNamespacesMax = 65536
+)
+
+// podCgroupUID matches the pod UID in the cgroup paths created by the
+// kubelet, with either the cgroupfs (pod<uid>) or systemd
+// (pod<uid with underscores>.slice) driver.
+var podCgroupUID = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+
+// socketOwners finds the pod whose process opened the client end of a TCP
+// connection to the agent: the socket is found in the agent's network
+// namespace, so this only identifies hostNetwork pods, which share their
+// node's IP address. Requires the host's process namespace (hostPID).
+//
+// Only processes in the agent's network namespace are searched for the
+// socket, with those that recently owned sockets searched first.
+type socketOwners struct {
+	procRoot string
+	// owners holds the fd path of the process that owns each socket inode
+	owners *cache.LRUExpireCache
+	// namespaces holds the network namespace of each pid
+	namespaces *cache.LRUExpireCache
+}
+
+func newSocketOwners(procRoot string) *socketOwners {
+	return &socketOwners{
+		procRoot:   procRoot,
+		owners:     cache.NewLRUExpireCache(socketOwnersMax),
+		namespaces: cache.NewLRUExpireCache(processNamespacesMax),
+	}
+}
+
+// PodUID returns the UID of the pod that opened the connection from the
+// remote address.
+func (s *socketOwners) PodUID(remoteAddr string) (string, error) {
+	host, portString, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return "", err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", fmt.Errorf("invalid ip address: %s", host)
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return "", err
+	}
+
+	inode, err := s.socketInode(ip, port)
+	if err != nil {
+		return "", err
+	}
+	pid, err := s.socketProcess(inode)
+	if err != nil {
+		return "", err
+	}
+	return s.processPodUID(pid)
+}
+
+// socketInode returns the inode of the socket bound to the address.
+func (s *socketOwners) socketInode(ip net.IP, port uint64) (string, error) {
+	// IPv4 clients may connect with IPv6 sockets, and the reverse
+	for _, table := range []string{"tcp", "tcp6"} {
+		inode, err := s.findSocket(filepath.Join(s.procRoot, "net", table), ip, port)
+		if err != ErrSocketNotFound {
+			return inode, err
+		}
+	}
+	return "", ErrSocketNotFound
+}
+
+func (s *socketOwners) findSocket(path string, ip net.IP, port uint64) (string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", ErrSocketNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] == tcpListen || fields[9] == "0" {
+			continue
+		}
+
+		localIP, localPort, err := parseProcAddress(fields[1])
+		if err != nil {
+			return "", err
+		}
+		if localPort == port && localIP.Equal(ip) {
+			return fields[9], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", ErrSocketNotFound
+}
+
+// parseProcAddress parses an address from /proc/net/tcp or tcp6: the IP
+// address is hex encoded in 32-bit words in host (little-endian) byte order,
+// the port in network byte order.
+func parseProcAddress(addr string) (net.IP, uint64, error) {
+	parts := strings.SplitN(addr, ":", 2)
+	if len(parts) != 2 {
+		return nil, 0, fmt.Errorf("invalid address: %s", addr)
+	}
+
+	b, err := hex.DecodeString(parts[0])
+	if err != nil || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("invalid address: %s", addr)
+	}
+	for i := 0; i < len(b); i += 4 {
+		b[i], b[i+1], b[i+2], b[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+	}
+
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid address: %s", addr)
+	}
+
+	return net.IP(b), port, nil
+}
+
+// socketProcess returns the pid of a process with the socket open.
+func (s *socketOwners) socketProcess(inode string) (string, error) {
+	target := fmt.Sprintf("socket:[%s]", inode)
+
+	// inodes are reused once sockets are closed, so the owner is checked
+	if fd, ok := s.owners.Get(inode); ok {
+		if link, err := os.Readlink(fd.(string)); err == nil && link == target {
+			return fdProcess(fd.(string)), nil
+		}
+		s.owners.Remove(inode)
+	}
+
+	pids, err := s.candidateProcesses()
+	if err != nil {
+		return "", err
+	}
+	for _, pid := range pids {
+		fd := s.processSocket(pid, target)
+		if fd != "" {
+			s.owners.Add(inode, fd, socketOwnersTTL)
+			return pid, nil
+		}
+	}
+
+	return "", ErrNoSocketOwner
+}
+
+// fdProcess returns the pid from a /proc/<pid>/fd/<fd> path.
+func fdProcess(fd string) string {
+	return filepath.Base(filepath.Dir(filepath.Dir(fd)))
+}
+
+// candidateProcesses returns the pids of the processes in the agent's network
+// namespace, those that recently owned sockets first. All processes are
+// candidates when the agent's namespace can't be read.
+func (s *socketOwners) candidateProcesses() ([]string, error) {
+	entries, err := ioutil.ReadDir(s.procRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	recent := make(map[string]bool)
+	var pids []string
+	for _, key := range s.owners.Keys() {
+		fd, ok := s.owners.Get(key)
+		if !ok {
+			continue
+		}
+		pid := fdProcess(fd.(string))
+		if !recent[pid] {
+			recent[pid] = true
+			pids = append(pids, pid)
+		}
+	}
+
+	agentNamespace, _ := os.Readlink(filepath.Join(s.procRoot, "self", "ns", "net"))
+	for _, entry := range entries {
+		pid := entry.Name()
+		if recent[pid] || !isPID(pid) {
+			continue
+		}
+		if agentNamespace != "" && s.processNamespace(pid) != agentNamespace {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+func isPID(name string) bool {
+	_, err := strconv.ParseUint(name, 10, 64)
+	return err == nil
+}
+
+// processNamespace returns the process's network namespace, or an empty
+// string when it can't be read.
+func (s *socketOwners) processNamespace(pid string) string {
+	if namespace, ok := s.namespaces.Get(pid); ok {
+		return namespace.(string)
+	}
+
+	// processes that have exited, or can't be read, are checked again later
+	namespace, err := os.Readlink(filepath.Join(s.procRoot, pid, "ns", "net"))
+	if err != nil {
+		return ""
+	}
+	s.namespaces.Add(pid, namespace, socketOwnersTTL)
+	return namespace
+}
+
+// processSocket returns the path of the process's fd for the socket, or an
+// empty string when it doesn't have it open.
+func (s *socketOwners) processSocket(pid, target string) string {
+	dir := filepath.Join(s.procRoot, pid, "fd")
+	// processes exit and close files while they're listed
+	fds, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, fd := range fds {
+		path := filepath.Join(dir, fd.Name())
+		link, err := os.Readlink(path)
+		if err == nil && link == target {
+			return path
+		}
+	}
+	return ""
+}
+
+// processPodUID returns the UID of the pod whose cgroup contains the process.
+func (s *socketOwners) processPodUID(pid string) (string, error) {
+	cgroups, err := ioutil.ReadFile(filepath.Join(s.procRoot, pid, "cgroup"))
+	if err != nil {
+		return "", err
+	}
+
+	match := podCgroupUID.FindSubmatch(cgroups)
+	if match == nil {
+		return "", ErrNotPodProcess
+	}
+	return strings.Replace(string(match[1]), "_", "-", -1), nil
+}
+
+// identifyBySocketOwner identifies requests from hostNetwork pods by the UID of
+// the pod that opened the connection. Other requests continue to be identified
+// by IP address.
+func identifyBySocketOwner(owners *socketOwners) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			uid, err := owners.PodUID(req.RemoteAddr)
+			if err != nil {
+				log.WithFields(requestFields(req)).Debugf("not identified by socket owner: %s", err.Error())
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			socketOwnerIdentified.Inc()
+			log.WithFields(requestFields(req)).WithField("pod.uid", uid).Debugf("identified by socket owner")
+			next.ServeHTTP(w, req.WithContext(server.WithPodUID(req.Context(), uid)))
+		})
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metadata
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	procNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100000A:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1000 1 0000000000000000 100 0 0 10 0
+   1: 0100000A:9C40 FEA9FEA9:0050 01 00000000:00000000 00:00000000 00000000     0        0 2000 1 0000000000000000 20 4 30 10 -1
+   2: 0100000A:9C41 FEA9FEA9:0050 01 00000000:00000000 00:00000000 00000000     0        0 3000 1 0000000000000000 20 4 30 10 -1
+`
+	procNetTCP6 = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 000000FD000000000000000001000000:9C42 000000FD000000000000000001000000:1FF5 01 00000000:00000000 00:00000000 00000000     0        0 4000 1 0000000000000000 20 4 30 10 -1
+`
+	cgroupfsCgroup = `12:memory:/kubepods/besteffort/pod0a1b2c3d-0000-1111-2222-333344445555/abcdef
+0::/
+`
+	systemdCgroup = `0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod0a1b2c3d_0000_1111_2222_666677778888.slice/cri-containerd-abcdef.scope
+`
+	nodeCgroup = `0::/system.slice/kubelet.service
+`
+)
+
+// newProcRoot creates a fake /proc with processes 10, 11 and 12 each holding a
+// socket open.
+func newProcRoot(t *testing.T) string {
+	root, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(path, content string) {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	socket := func(pid, fd, inode string) {
+		path := filepath.Join(root, pid, "fd", fd)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("socket:["+inode+"]", path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("net/tcp", procNetTCP)
+	write("net/tcp6", procNetTCP6)
+	write("10/cgroup", cgroupfsCgroup)
+	socket("10", "3", "2000")
+	write("11/cgroup", nodeCgroup)
+	socket("11", "3", "3000")
+	write("12/cgroup", systemdCgroup)
+	socket("12", "4", "4000")
+
+	return root
+}
+
+func TestIdentifiesSocketOwnerPod(t *testing.T) {
+	root := newProcRoot(t)
+	defer os.RemoveAll(root)
+	owners := newSocketOwners(root)
+
+	uid, err := owners.PodUID("10.0.0.1:40000")
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if uid != "0a1b2c3d-0000-1111-2222-333344445555" {
+		t.Error("unexpected uid", uid)
+	}
+
+	uid, err = owners.PodUID("[fd00::1]:40002")
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if uid != "0a1b2c3d-0000-1111-2222-666677778888" {
+		t.Error("unexpected uid", uid)
+	}
+}
+
+func TestDoesNotIdentifyOtherSockets(t *testing.T) {
+	root := newProcRoot(t)
+	defer os.RemoveAll(root)
+	owners := newSocketOwners(root)
+
+	_, err := owners.PodUID("10.0.0.1:40001")
+	if err != ErrNotPodProcess {
+		t.Error("expected process outside a pod, was", err)
+	}
+
+	_, err = owners.PodUID("10.0.0.1:8080")
+	if err != ErrSocketNotFound {
+		t.Error("expected listening socket to be ignored, was", err)
+	}
+
+	_, err = owners.PodUID("10.0.0.2:40000")
+	if err != ErrSocketNotFound {
+		t.Error("expected socket not found, was", err)
+	}
+}
+
+func TestRechecksCachedSocketOwner(t *testing.T) {
+	root := newProcRoot(t)
+	defer os.RemoveAll(root)
+	owners := newSocketOwners(root)
+
+	if _, err := owners.PodUID("10.0.0.1:40000"); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	// the socket is closed and its inode reused by a process outside a pod
+	if err := os.Remove(filepath.Join(root, "10", "fd", "3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("socket:[2000]", filepath.Join(root, "11", "fd", "4")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := owners.PodUID("10.0.0.1:40000")
+	if err != ErrNotPodProcess {
+		t.Error("expected process outside a pod, was", err)
+	}
+}
+
+func TestOnlySearchesProcessesInAgentNetworkNamespace(t *testing.T) {
+	root := newProcRoot(t)
+	defer os.RemoveAll(root)
+
+	namespace := func(pid, ns string) {
+		path := filepath.Join(root, pid, "ns", "net")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(ns, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	namespace("self", "net:[1]")
+	namespace("10", "net:[2]")
+	namespace("12", "net:[1]")
+	owners := newSocketOwners(root)
+
+	_, err := owners.PodUID("10.0.0.1:40000")
+	if err != ErrNoSocketOwner {
+		t.Error("expected process in another namespace to be ignored, was", err)
+	}
+
+	uid, err := owners.PodUID("[fd00::1]:40002")
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if uid != "0a1b2c3d-0000-1111-2222-666677778888" {
+		t.Error("unexpected uid", uid)
+	}
+}
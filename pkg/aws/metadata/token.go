@@ -0,0 +1,198 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metadata
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// IMDSv2Proxy forwards session token requests to the instance's metadata
+	// service and doesn't check tokens.
+	IMDSv2Proxy = "proxy"
+	// IMDSv2Optional issues and validates per-pod session tokens, but also
+	// accepts requests without a token (IMDSv1).
+	IMDSv2Optional = "optional"
+	// IMDSv2Required issues and validates per-pod session tokens, and rejects
+	// requests without a token.
+	IMDSv2Required = "required"
+)
+
+const (
+	tokenHeader    = "X-aws-ec2-metadata-token"
+	tokenTTLHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+
+	maxTokenTTL = 6 * time.Hour
+
+	// upstream tokens are refreshed once they have less than this remaining
+	upstreamTokenRefresh = time.Minute
+)
+
+var (
+	ErrInvalidToken = fmt.Errorf("invalid session token")
+	ErrExpiredToken = fmt.Errorf("expired session token")
+	ErrMissingToken = fmt.Errorf("missing session token")
+)
+
+// sessionTokens issues and validates IMDSv2 session tokens. Tokens are
+// stateless: they contain their expiry and the IP address, and UID when it
+// was identified, of the pod they were issued to, signed with a key generated
+// when the agent starts. hostNetwork pods share their node's IP address, so
+// are told apart by UID. Restarting the agent invalidates previously issued
+// tokens; SDKs request a new token when one is rejected.
+type sessionTokens struct {
+	key []byte
+	now func() time.Time
+}
+
+func newSessionTokens() (*sessionTokens, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("error generating session token key: %s", err)
+	}
+	return &sessionTokens{key: key, now: time.Now}, nil
+}
+
+func (s *sessionTokens) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// Issue returns a token valid for the ip address and uid, which may be empty,
+// until ttl has elapsed.
+func (s *sessionTokens) Issue(ip, uid string, ttl time.Duration) string {
+	payload := fmt.Sprintf("%d|%s|%s", s.now().Add(ttl).Unix(), ip, uid)
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	signature := base64.RawURLEncoding.EncodeToString(s.sign(payload))
+	return fmt.Sprintf("%s.%s", encoded, signature)
+}
+
+// Validate checks the token was issued by this agent to the ip address and
+// uid, and hasn't expired.
+func (s *sessionTokens) Validate(token, ip, uid string) error {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ErrInvalidToken
+	}
+	if !hmac.Equal(signature, s.sign(string(payload))) {
+		return ErrInvalidToken
+	}
+
+	fields := strings.SplitN(string(payload), "|", 3)
+	if len(fields) != 3 || fields[1] != ip || fields[2] != uid {
+		return ErrInvalidToken
+	}
+
+	expiry, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return ErrInvalidToken
+	}
+	if !s.now().Before(time.Unix(expiry, 0)) {
+		return ErrExpiredToken
+	}
+
+	return nil
+}
+
+// upstreamToken requests and caches a session token from the instance's
+// metadata service, used when proxying requests. The token is refreshed by one
+// caller at a time, without holding the lock, so others continue to use the
+// cached token while it's still valid.
+type upstreamToken struct {
+	endpoint string
+	client   *http.Client
+
+	mu         sync.Mutex
+	token      string
+	expires    time.Time
+	refreshing bool
+}
+
+func newUpstreamToken(endpoint string) *upstreamToken {
+	return &upstreamToken{endpoint: endpoint, client: &http.Client{}}
+}
+
+func (u *upstreamToken) Get(ctx context.Context) (string, error) {
+	u.mu.Lock()
+	token := u.token
+	valid := token != "" && time.Now().Before(u.expires)
+	if valid && (u.refreshing || time.Until(u.expires) > upstreamTokenRefresh) {
+		u.mu.Unlock()
+		return token, nil
+	}
+	u.refreshing = true
+	u.mu.Unlock()
+
+	refreshed, err := u.request(ctx)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.refreshing = false
+	if err != nil {
+		if valid {
+			return token, nil
+		}
+		return "", err
+	}
+
+	u.token = refreshed
+	u.expires = time.Now().Add(maxTokenTTL)
+	return refreshed, nil
+}
+
+func (u *upstreamToken) request(ctx context.Context) (string, error) {
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/latest/api/token", u.endpoint), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(tokenTTLHeader, strconv.Itoa(int(maxTokenTTL.Seconds())))
+
+	resp, err := u.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status requesting session token: %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	return string(body), nil
+}
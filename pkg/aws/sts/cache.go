@@ -17,6 +17,8 @@ import (
 	"context"
 	"fmt"
 	"regexp"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/patrickmn/go-cache"
@@ -27,19 +29,29 @@ import (
 type credentialsCache struct {
 	cache           *cache.Cache
 	expiring        chan *CachedCredentials
+	issued          chan *CachedCredentials
 	sessionName     string
 	sessionDuration time.Duration
 	cacheTTL        time.Duration
 	gateway         STSGateway
+	evicting        sync.Map // futures being explicitly evicted, rather than expiring
+	// mu serialises replacing and deleting futures so that an eviction or
+	// failed request never removes a future set concurrently in its place.
+	mu sync.Mutex
 }
 
 type CachedCredentials struct {
 	Identity    *RoleIdentity
 	Credentials *Credentials
+	SessionName string
 }
 
 const (
 	DefaultPurgeInterval = 1 * time.Minute
+
+	// issuedBuffer is the number of issued credentials held before they're
+	// dropped.
+	issuedBuffer = 100
 )
 
 func DefaultCache(
@@ -50,6 +62,7 @@ func DefaultCache(
 ) *credentialsCache {
 	c := &credentialsCache{
 		expiring:        make(chan *CachedCredentials, 1),
+		issued:          make(chan *CachedCredentials, issuedBuffer),
 		sessionName:     sessionName,
 		sessionDuration: sessionDuration,
 		cacheTTL:        sessionDuration - sessionRefresh,
@@ -64,6 +77,12 @@ func DefaultCache(
 func (c *credentialsCache) evicted(key string, item interface{}) {
 	cacheSize.Dec()
 
+	if _, evicting := c.evicting.Load(item); evicting {
+		c.evicting.Delete(item)
+		log.WithField("cache.key", key).Infof("evicted credentials")
+		return
+	}
+
 	f := item.(*future.Future)
 	obj, err := f.Get(context.Background())
 
@@ -86,6 +105,67 @@ func (c *credentialsCache) Expiring() chan *CachedCredentials {
 	return c.expiring
 }
 
+// Issued receives credentials as they're issued, so they can be sent to
+// agents.
+func (c *credentialsCache) Issued() <-chan *CachedCredentials {
+	return c.issued
+}
+
+// List returns the credentials that have been issued and are currently
+// cached. Requests that are still in-flight are omitted.
+func (c *credentialsCache) List() []*CachedCredentials {
+	items := c.cache.Items()
+	cached := make([]*CachedCredentials, 0, len(items))
+
+	for _, item := range items {
+		f := item.Object.(*future.Future)
+		if !f.Done() {
+			continue
+		}
+
+		val, err := f.Get(context.Background())
+		if err != nil {
+			continue
+		}
+		cached = append(cached, val.(*CachedCredentials))
+	}
+
+	sort.Slice(cached, func(i, j int) bool {
+		return cached[i].Identity.String() < cached[j].Identity.String()
+	})
+
+	return cached
+}
+
+// Evict removes the identity's credentials from the cache. Unlike expiry,
+// eviction isn't announced on the Expiring channel so the credentials won't
+// be prefetched again until they're next requested.
+func (c *credentialsCache) Evict(identity *RoleIdentity) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, found := c.cache.Get(identity.String())
+	if !found {
+		return false
+	}
+
+	c.evicting.Store(item, struct{}{})
+	c.cache.Delete(identity.String())
+	return true
+}
+
+// deleteFuture removes the future from the cache, unless it's already been
+// replaced.
+func (c *credentialsCache) deleteFuture(key string, f *future.Future) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, found := c.cache.Get(key)
+	if found && item.(*future.Future) == f {
+		c.cache.Delete(key)
+	}
+}
+
 // CredentialsForRole looks for cached credentials or requests them from the STSGateway. Requested credentials
 // must have their ARN set.
 func (c *credentialsCache) CredentialsForRole(ctx context.Context, identity *RoleIdentity) (*Credentials, error) {
@@ -98,7 +178,7 @@ func (c *credentialsCache) CredentialsForRole(ctx context.Context, identity *Rol
 
 		if err != nil {
 			logger.Errorf("error retrieving credentials in cache from future: %s. will delete", err.Error())
-			c.cache.Delete(identity.String())
+			c.deleteFuture(identity.String(), future)
 			return nil, err
 		}
 
@@ -130,18 +210,23 @@ func (c *credentialsCache) CredentialsForRole(ctx context.Context, identity *Rol
 		cachedCreds := &CachedCredentials{
 			Identity:    identity,
 			Credentials: credentials,
+			SessionName: sessionName,
 		}
 
 		log.WithFields(CredentialsFields(identity, credentials)).Infof("requested new credentials")
+
+		select {
+		case c.issued <- cachedCreds:
+		default:
+			dropIssued.Inc()
+		}
 		return cachedCreds, err
 	}
-	f := future.New(issue)
-	c.cache.Set(identity.String(), f, c.cacheTTL)
-	cacheSize.Inc()
+	f := c.setFuture(identity.String(), issue)
 
 	val, err := f.Get(ctx)
 	if err != nil {
-		c.cache.Delete(identity.String())
+		c.deleteFuture(identity.String(), f)
 		return nil, err
 	}
 
@@ -149,6 +234,22 @@ func (c *credentialsCache) CredentialsForRole(ctx context.Context, identity *Rol
 	return cachedCreds.Credentials, nil
 }
 
+// setFuture caches a future for the issue function, unless another request
+// has cached one since the key was looked up.
+func (c *credentialsCache) setFuture(key string, issue future.FutureFn) *future.Future {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if item, found := c.cache.Get(key); found {
+		return item.(*future.Future)
+	}
+
+	f := future.New(issue)
+	c.cache.Set(key, f, c.cacheTTL)
+	cacheSize.Inc()
+	return f
+}
+
 func (c *credentialsCache) getSessionName(identity *RoleIdentity) string {
 	sessionName := c.sessionName
 
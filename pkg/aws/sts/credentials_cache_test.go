@@ -19,6 +19,7 @@ import (
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uswitch/kiam/pkg/future"
 )
 
 type stubGateway struct {
@@ -109,3 +110,64 @@ func TestRequestsCredentialsWithExternalID(t *testing.T) {
 		t.Error("unexpected external-id, was:", stubGateway.requestedExternalID)
 	}
 }
+
+func TestListsAndEvictsCachedCredentials(t *testing.T) {
+	stubGateway := &stubGateway{c: &Credentials{Code: "foo"}}
+	cache := DefaultCache(stubGateway, "session", 15*time.Minute, 5*time.Minute)
+	ctx := context.Background()
+
+	credentialsIdentity := &RoleIdentity{Role: ResolvedRole{Name: "role", ARN: "arn:account:role"}}
+	cache.CredentialsForRole(ctx, credentialsIdentity)
+
+	cached := cache.List()
+	if len(cached) != 1 {
+		t.Fatal("expected 1 cached credentials, was", len(cached))
+	}
+	if cached[0].Identity.Role.ARN != "arn:account:role" {
+		t.Error("unexpected role, was", cached[0].Identity.Role.ARN)
+	}
+	if cached[0].SessionName != "kiam-session" {
+		t.Error("unexpected session-name, was", cached[0].SessionName)
+	}
+
+	if !cache.Evict(credentialsIdentity) {
+		t.Error("expected credentials to be evicted")
+	}
+	if cache.Evict(credentialsIdentity) {
+		t.Error("didn't expect credentials to be evicted twice")
+	}
+	if len(cache.List()) != 0 {
+		t.Error("expected cache to be empty")
+	}
+
+	select {
+	case <-cache.Expiring():
+		t.Error("evicted credentials shouldn't be announced as expiring")
+	default:
+	}
+
+	cache.CredentialsForRole(ctx, credentialsIdentity)
+	if stubGateway.issueCount != 2 {
+		t.Error("expected evicted credentials to be requested again, issued", stubGateway.issueCount)
+	}
+}
+
+func TestDoesntDeleteReplacedCredentials(t *testing.T) {
+	stubGateway := &stubGateway{c: &Credentials{Code: "foo"}}
+	cache := DefaultCache(stubGateway, "session", 15*time.Minute, 5*time.Minute)
+	ctx := context.Background()
+
+	credentialsIdentity := &RoleIdentity{Role: ResolvedRole{Name: "role", ARN: "arn:account:role"}}
+	cache.CredentialsForRole(ctx, credentialsIdentity)
+
+	// a failed request whose future was replaced by another request
+	replaced := future.New(func() (interface{}, error) { return nil, nil })
+	cache.deleteFuture(credentialsIdentity.String(), replaced)
+
+	if len(cache.List()) != 1 {
+		t.Error("expected credentials to remain cached")
+	}
+	if stubGateway.issueCount != 1 {
+		t.Error("expected credentials to be issued once, issued", stubGateway.issueCount)
+	}
+}
@@ -24,6 +24,22 @@ type CredentialsProvider interface {
 type CredentialsCache interface {
 	CredentialsForRole(ctx context.Context, identity *RoleIdentity) (*Credentials, error)
 	Expiring() chan *CachedCredentials
+	// Issued receives credentials as they're issued, including when they're
+	// refreshed. Credentials are dropped when nothing is receiving.
+	Issued() <-chan *CachedCredentials
+	// Evict removes the identity's credentials from the cache, returning
+	// whether they were present.
+	Evict(identity *RoleIdentity) bool
+}
+
+// CredentialsCacheAdmin allows the contents of the credentials cache to be
+// inspected and invalidated.
+type CredentialsCacheAdmin interface {
+	// List returns the credentials currently held in the cache
+	List() []*CachedCredentials
+	// Evict removes the identity's credentials from the cache, returning
+	// whether they were present.
+	Evict(identity *RoleIdentity) bool
 }
 
 // ARNResolver encapsulates resolution of roles into ARNs.
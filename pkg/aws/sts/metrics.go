@@ -50,6 +50,15 @@ var (
 		},
 	)
 
+	dropIssued = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "kiam",
+			Subsystem: "sts",
+			Name:      "dropped_issued_total",
+			Help:      "Number of issued credentials not announced because of full buffer",
+		},
+	)
+
 	assumeRoleExecuting = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Namespace: "kiam",
@@ -67,4 +76,5 @@ func init() {
 	prometheus.MustRegister(errorIssuing)
 	prometheus.MustRegister(assumeRole)
 	prometheus.MustRegister(assumeRoleExecuting)
+	prometheus.MustRegister(dropIssued)
 }
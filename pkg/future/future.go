@@ -34,6 +34,16 @@ func (f *Future) Get(ctx context.Context) (interface{}, error) {
 	}
 }
 
+// Done returns whether the future has completed, without blocking.
+func (f *Future) Done() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
 func New(f FutureFn) *Future {
 	future := &Future{
 		done: make(chan struct{}),
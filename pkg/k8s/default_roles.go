@@ -0,0 +1,112 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package k8s
+
+import (
+	"context"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/uswitch/kiam/pkg/aws/sts"
+	v1 "k8s.io/api/core/v1"
+)
+
+// DefaultRoles resolves the roles of Pods, giving Pods without a role
+// annotation their Namespace's default role or, when it has none, the
+// cluster's default role. A nil DefaultRoles uses only the Pods' annotations.
+type DefaultRoles struct {
+	namespaces NamespaceFinder
+	cluster    string
+}
+
+// NewDefaultRoles finds Namespaces' default role annotations with the finder,
+// falling back to the cluster role, which may be empty.
+func NewDefaultRoles(namespaces NamespaceFinder, cluster string) *DefaultRoles {
+	return &DefaultRoles{namespaces: namespaces, cluster: cluster}
+}
+
+// WithNamespaceFinder returns a copy of the DefaultRoles that finds
+// Namespaces' default role annotations with the finder.
+func (d *DefaultRoles) WithNamespaceFinder(namespaces NamespaceFinder) *DefaultRoles {
+	if d == nil {
+		return nil
+	}
+	return &DefaultRoles{namespaces: namespaces, cluster: d.cluster}
+}
+
+// DefaultRole returns the role of Pods in the Namespace without a role
+// annotation.
+func (d *DefaultRoles) DefaultRole(namespace string) string {
+	if d == nil {
+		return ""
+	}
+
+	ns, err := d.namespaces.FindNamespace(context.Background(), namespace)
+	if err != nil {
+		log.WithField("namespace.name", namespace).Errorf("error finding namespace default role: %s", err.Error())
+	}
+	return d.NamespaceRole(ns)
+}
+
+// NamespaceRole returns the role of Pods in the Namespace, which may be nil,
+// without a role annotation.
+func (d *DefaultRoles) NamespaceRole(ns *v1.Namespace) string {
+	if d == nil {
+		return ""
+	}
+
+	if ns != nil {
+		if role := strings.TrimSpace(ns.GetAnnotations()[AnnotationDefaultRoleKey]); role != "" {
+			return role
+		}
+	}
+
+	return d.cluster
+}
+
+// PodRole returns the Pod's default IAM role, as PodRole, or its Namespace's
+// default role when it isn't annotated with one.
+func (d *DefaultRoles) PodRole(pod *v1.Pod) string {
+	roles := d.PodRoles(pod)
+	if len(roles) == 0 {
+		return ""
+	}
+	return roles[0]
+}
+
+// PodRoles returns the IAM roles the Pod may assume, as PodRoles, or its
+// Namespace's default role when it isn't annotated with any.
+func (d *DefaultRoles) PodRoles(pod *v1.Pod) []string {
+	roles := PodRoles(pod)
+	if len(roles) != 0 {
+		return roles
+	}
+	if role := d.DefaultRole(pod.GetNamespace()); role != "" {
+		return []string{role}
+	}
+	return nil
+}
+
+// PodRoleIdentity returns the identity that credentials are issued for, as
+// PodRoleIdentity, using the Pod's resolved default role.
+func (d *DefaultRoles) PodRoleIdentity(arnResolver sts.ARNResolver, pod *v1.Pod) (*sts.RoleIdentity, error) {
+	return sts.NewRoleIdentity(arnResolver, d.PodRole(pod), PodSessionName(pod), PodExternalID(pod))
+}
+
+// PodRoleIdentities returns the identities credentials are issued for for
+// each of the Pod's roles, as PodRoleIdentities, using its Namespace's default
+// role when it isn't annotated with any.
+func (d *DefaultRoles) PodRoleIdentities(arnResolver sts.ARNResolver, pod *v1.Pod) ([]*sts.RoleIdentity, error) {
+	return roleIdentities(arnResolver, pod, d.PodRoles(pod))
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/uswitch/kiam/pkg/aws/sts"
+	"github.com/uswitch/kiam/pkg/testutil"
+	v1 "k8s.io/api/core/v1"
+	kt "k8s.io/client-go/tools/cache/testing"
+)
+
+type stubNamespaces map[string]*v1.Namespace
+
+func (n stubNamespaces) FindNamespace(_ context.Context, name string) (*v1.Namespace, error) {
+	return n[name], nil
+}
+
+func namespaceWithDefaultRole(name, role string) *v1.Namespace {
+	namespace := testutil.NewNamespace(name, ".*")
+	namespace.Annotations[AnnotationDefaultRoleKey] = role
+	return namespace
+}
+
+func TestResolvesDefaultRoles(t *testing.T) {
+	defaults := NewDefaultRoles(stubNamespaces{
+		"team":  namespaceWithDefaultRole("team", "team-reader"),
+		"other": testutil.NewNamespace("other", ".*"),
+	}, "cluster-reader")
+
+	cases := []struct {
+		pod      *v1.Pod
+		expected string
+	}{
+		{testutil.NewPodWithRole("team", "name", "192.168.0.1", "Running", "writer"), "writer"},
+		{testutil.NewPod("team", "name", "192.168.0.1", "Running"), "team-reader"},
+		{testutil.NewPod("other", "name", "192.168.0.1", "Running"), "cluster-reader"},
+		{testutil.NewPod("missing", "name", "192.168.0.1", "Running"), "cluster-reader"},
+	}
+	for _, c := range cases {
+		if role := defaults.PodRole(c.pod); role != c.expected {
+			t.Errorf("expected %s for pod in %s, was %s", c.expected, c.pod.Namespace, role)
+		}
+	}
+
+	var annotationsOnly *DefaultRoles
+	if role := annotationsOnly.PodRole(testutil.NewPod("team", "name", "192.168.0.1", "Running")); role != "" {
+		t.Error("expected no role without defaults, was", role)
+	}
+}
+
+func TestAnnouncesAndReleasesPodsWithDefaultRole(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	handler := newTestPodHandler()
+	defer handler.shutdown()
+	handler.defaults = NewDefaultRoles(stubNamespaces{"ns": namespaceWithDefaultRole("ns", "reader")}, "")
+
+	pod := testutil.NewPod("ns", "name", "192.168.0.1", "Running")
+	handler.OnAdd(pod)
+	if handler.announcements.Len() != 1 {
+		t.Error("expected pod with default role to be announced")
+	}
+
+	handler.OnDelete(pod)
+	assertHandlerReleased(t, handler, "reader")
+}
+
+func TestResolvesDefaultRoleWhenNamespaceChanges(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	source.Add(testutil.NewPod("ns", "name", "192.168.0.1", "Running"))
+
+	arnResolver := sts.DefaultResolver("arn:account:")
+	previous := namespaceWithDefaultRole("ns", "reader")
+	namespaces := stubNamespaces{"ns": previous}
+	c := NewPodCache(arnResolver, source, time.Minute, bufferSize).WithDefaultRoles(NewDefaultRoles(namespaces, ""))
+	c.Run(ctx)
+	<-c.Pods()
+
+	reader, _ := sts.NewRoleIdentity(arnResolver, "reader", "", "")
+	writer, _ := sts.NewRoleIdentity(arnResolver, "writer", "", "")
+	if active, _ := c.IsActivePodsForRole(reader); !active {
+		t.Error("expected pod to use namespace's default role")
+	}
+
+	namespaces["ns"] = namespaceWithDefaultRole("ns", "writer")
+	if active, _ := c.IsActivePodsForRole(reader); active {
+		t.Error("expected pod to no longer use previous default role")
+	}
+	if active, _ := c.IsActivePodsForRole(writer); !active {
+		t.Error("expected pod to use changed default role")
+	}
+
+	err := c.RefreshNamespace("ns", previous)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	select {
+	case pod := <-c.Pods():
+		if pod.Name != "name" {
+			t.Error("unexpected pod announced", pod.Name)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected pod to be announced")
+	}
+	assertReleased(t, c, "reader")
+}
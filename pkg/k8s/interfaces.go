@@ -24,11 +24,19 @@ type PodGetter interface {
 	GetPodByIP(ip string) (*v1.Pod, error)
 }
 
+type PodLister interface {
+	ListPodsInNamespace(namespace string) ([]*v1.Pod, error)
+}
+
 type PodAnnouncer interface {
 	// Will receive a Pod whenever there's a change/addition for a Pod with a role.
 	Pods() <-chan *v1.Pod
+	// Will receive the identity of a Pod that's deleted, completed or changed role.
+	Released() <-chan *sts.RoleIdentity
 	// Return whether there are still uncompleted pods in the specified role
 	IsActivePodsForRole(identity *sts.RoleIdentity) (bool, error)
+	// Return all cached Pods, used to resync announcements.
+	ListPods() ([]*v1.Pod, error)
 }
 
 type NamespaceFinder interface {
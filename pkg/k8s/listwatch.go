@@ -17,3 +17,9 @@ const (
 func NewListWatch(client *kubernetes.Clientset, resource string) *cache.ListWatch {
 	return cache.NewListWatchFromClient(client.CoreV1().RESTClient(), resource, "", fields.Everything())
 }
+
+// NewNodeListWatch creates a ListWatch for the specified Resource, restricted to
+// those scheduled to the node
+func NewNodeListWatch(client *kubernetes.Clientset, resource, nodeName string) *cache.ListWatch {
+	return cache.NewListWatchFromClient(client.CoreV1().RESTClient(), resource, "", fields.OneTermEqualSelector("spec.nodeName", nodeName))
+}
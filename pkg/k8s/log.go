@@ -24,7 +24,7 @@ func PodFields(pod *v1.Pod) logrus.Fields {
 		"pod.status.ip":       pod.Status.PodIP,
 		"pod.namespace":       pod.ObjectMeta.Namespace,
 		"pod.name":            pod.ObjectMeta.Name,
-		"pod.iam.role":        pod.ObjectMeta.Annotations[AnnotationIAMRoleKey],
+		"pod.iam.role":        PodRole(pod),
 		"resource.version":    pod.ObjectMeta.ResourceVersion,
 		"generation.metadata": pod.ObjectMeta.Generation,
 	}
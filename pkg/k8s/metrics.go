@@ -5,16 +5,26 @@ import (
 )
 
 var (
-	dropAnnounce = prometheus.NewCounter(
+	queuedAnnouncements = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "kiam",
+			Subsystem: "k8s",
+			Name:      "queued_announcements",
+			Help:      "Number of pods waiting to be announced to the prefetch manager",
+		},
+	)
+	podWaits = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: "kiam",
 			Subsystem: "k8s",
-			Name:      "dropped_pods_total",
-			Help:      "Number of dropped pods because of full buffer",
+			Name:      "pod_waits_total",
+			Help:      "Number of requests that waited for a pod not yet cached, by whether it was then cached, listed from the API server, matched multiple pods, not found or errored listing",
 		},
+		[]string{"result"},
 	)
 )
 
 func init() {
-	prometheus.MustRegister(dropAnnounce)
+	prometheus.MustRegister(queuedAnnouncements)
+	prometheus.MustRegister(podWaits)
 }
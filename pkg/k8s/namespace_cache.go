@@ -15,17 +15,22 @@ package k8s
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"k8s.io/api/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 )
 
 const (
 	// AnnotationPermittedKey hold the name of the annotation for the regex expressing the
 	// roles that can be assumed by pods in that namespace.
 	AnnotationPermittedKey = "iam.amazonaws.com/permitted"
+	// AnnotationDefaultRoleKey holds the name of the annotation for the role
+	// assumed by pods in that namespace without a role annotation.
+	AnnotationDefaultRoleKey = "iam.amazonaws.com/default-role"
 )
 
 // NamespaceCache implements NamespaceFinder interface used to determine which roles
@@ -33,20 +38,49 @@ const (
 type NamespaceCache struct {
 	indexer    cache.Indexer
 	controller cache.Controller
+	changes    chan *NamespaceChange
+	handler    *namespaceLogger
+	start      sync.Once
 }
 
-// NewNamespaceCache creates the cache storing Namespaces
+// NamespaceChange is a change to a Namespace's permitted roles or default
+// role annotations.
+type NamespaceChange struct {
+	Previous  *v1.Namespace
+	Namespace *v1.Namespace
+}
+
+// NewNamespaceCache creates the cache storing Namespaces. Changes to their
+// permitted roles or default role annotations are queued, without duplicates,
+// until they can be delivered.
 func NewNamespaceCache(source cache.ListerWatcher, syncInterval time.Duration) *NamespaceCache {
-	namespaceLogger := &namespaceLogger{}
+	namespaceLogger := &namespaceLogger{previous: make(map[string]*v1.Namespace)}
 	indexer, controller := cache.NewIndexerInformer(source, &v1.Namespace{}, syncInterval, namespaceLogger, cache.Indexers{})
 	return &NamespaceCache{
 		indexer:    indexer,
 		controller: controller,
+		changes:    make(chan *NamespaceChange),
+		handler:    namespaceLogger,
 	}
 }
 
+// PermittedChanges receives changes to Namespaces' permitted roles or default
+// role annotations, so that the roles of their Pods can be checked again.
+func (c *NamespaceCache) PermittedChanges() <-chan *NamespaceChange {
+	return c.changes
+}
+
 // Run starts the cache processing updates. Blocks until cache has synced
 func (c *NamespaceCache) Run(ctx context.Context) error {
+	c.start.Do(func() {
+		c.handler.changes = workqueue.NewNamed("namespace-changes")
+		go func() {
+			<-ctx.Done()
+			c.handler.changes.ShutDown()
+		}()
+		go c.deliverChanges(ctx)
+	})
+
 	go c.controller.Run(ctx.Done())
 	log.Infof("started namespace cache controller")
 
@@ -58,6 +92,35 @@ func (c *NamespaceCache) Run(ctx context.Context) error {
 	return nil
 }
 
+// deliverChanges sends queued changes on the changes channel, blocking until
+// they're received. Namespaces are looked up as they're delivered so the latest
+// version is sent, with the version before the first queued change. Changes
+// that were delivered while they were queued again are skipped.
+func (c *NamespaceCache) deliverChanges(ctx context.Context) {
+	queue := c.handler.changes
+	for {
+		item, shutdown := queue.Get()
+		if shutdown {
+			return
+		}
+
+		name := item.(string)
+		previous := c.handler.takePrevious(name)
+		obj, exists, err := c.indexer.GetByKey(name)
+		if err != nil {
+			log.WithField("namespace.name", name).Errorf("error finding changed namespace: %s", err.Error())
+		}
+		if exists && previous != nil {
+			select {
+			case c.changes <- &NamespaceChange{Previous: previous, Namespace: obj.(*v1.Namespace)}:
+			case <-ctx.Done():
+			}
+		}
+
+		queue.Done(item)
+	}
+}
+
 // FindNamespace finds the Namespace by it's name
 func (c *NamespaceCache) FindNamespace(ctx context.Context, name string) (*v1.Namespace, error) {
 	obj, exists, err := c.indexer.GetByKey(name)
@@ -71,6 +134,20 @@ func (c *NamespaceCache) FindNamespace(ctx context.Context, name string) (*v1.Na
 }
 
 type namespaceLogger struct {
+	changes workqueue.Interface // names of changed namespaces
+
+	mu       sync.Mutex
+	previous map[string]*v1.Namespace // versions before their queued changes
+}
+
+// takePrevious returns the version of the Namespace before its queued change.
+func (o *namespaceLogger) takePrevious(name string) *v1.Namespace {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	previous := o.previous[name]
+	delete(o.previous, name)
+	return previous
 }
 
 func (o *namespaceLogger) OnAdd(obj interface{}) {
@@ -111,4 +188,23 @@ func (o *namespaceLogger) OnUpdate(old, new interface{}) {
 	}
 
 	log.WithFields(namespaceFields(namespace)).Debugf("updated namespace")
+
+	oldNamespace, isNamespace := old.(*v1.Namespace)
+	if !isNamespace {
+		return
+	}
+
+	if oldNamespace.GetAnnotations()[AnnotationPermittedKey] == namespace.GetAnnotations()[AnnotationPermittedKey] &&
+		oldNamespace.GetAnnotations()[AnnotationDefaultRoleKey] == namespace.GetAnnotations()[AnnotationDefaultRoleKey] {
+		return
+	}
+
+	o.mu.Lock()
+	if _, queued := o.previous[namespace.GetName()]; !queued {
+		o.previous[namespace.GetName()] = oldNamespace
+	}
+	o.mu.Unlock()
+
+	o.changes.Add(namespace.GetName())
+	log.WithFields(namespaceFields(namespace)).Infof("namespace permitted or default roles changed")
 }
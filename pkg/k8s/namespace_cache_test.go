@@ -0,0 +1,113 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/uswitch/kiam/pkg/testutil"
+)
+
+func TestAnnouncesPermittedChanges(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	source := newStoppedSource()
+	defer source.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := NewNamespaceCache(source, time.Minute)
+	source.Add(testutil.NewNamespace("ns", ".*"))
+	c.Run(ctx)
+
+	unchanged := testutil.NewNamespace("ns", ".*")
+	unchanged.Labels = map[string]string{"team": "kiam"}
+	source.Modify(unchanged)
+	source.Modify(testutil.NewNamespace("ns", "reader"))
+
+	select {
+	case change := <-c.PermittedChanges():
+		if change.Namespace.GetAnnotations()[AnnotationPermittedKey] != "reader" {
+			t.Error("expected namespace with changed annotation, was", change.Namespace.GetAnnotations())
+		}
+		if change.Previous.GetAnnotations()[AnnotationPermittedKey] != ".*" {
+			t.Error("expected previous namespace annotation, was", change.Previous.GetAnnotations())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected permitted change to be announced")
+	}
+
+	select {
+	case change := <-c.PermittedChanges():
+		t.Error("only expected a single change, but received", change.Namespace.GetAnnotations())
+	default:
+	}
+}
+
+func TestAnnouncesDefaultRoleChanges(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	source := newStoppedSource()
+	defer source.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := NewNamespaceCache(source, time.Minute)
+	source.Add(testutil.NewNamespace("ns", ".*"))
+	c.Run(ctx)
+
+	withDefault := testutil.NewNamespace("ns", ".*")
+	withDefault.Annotations[AnnotationDefaultRoleKey] = "reader"
+	source.Modify(withDefault)
+
+	select {
+	case <-c.PermittedChanges():
+	case <-time.After(time.Second):
+		t.Error("expected default role change to be announced")
+	}
+}
+
+func TestQueuesNamespaceChangesUntilReceived(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	source := newStoppedSource()
+	defer source.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := NewNamespaceCache(source, time.Minute)
+	for i := 0; i < 5; i++ {
+		source.Add(testutil.NewNamespace(fmt.Sprintf("ns-%d", i), ".*"))
+	}
+	c.Run(ctx)
+
+	for i := 0; i < 5; i++ {
+		source.Modify(testutil.NewNamespace(fmt.Sprintf("ns-%d", i), "reader"))
+	}
+
+	for i := 0; i < 5; i++ {
+		select {
+		case <-c.PermittedChanges():
+		case <-time.After(time.Second):
+			t.Fatalf("expected 5 changes to be announced, was %d", i)
+		}
+	}
+}
@@ -16,42 +16,107 @@ package k8s
 import (
 	"context"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/uswitch/kiam/pkg/aws/sts"
 	v1 "k8s.io/api/core/v1"
+	utilcache "k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 )
 
 // PodCache implements a cache, allowing lookups by their IP address
 type PodCache struct {
 	pods       chan *v1.Pod
+	released   chan *sts.RoleIdentity
+	nodes      chan string
 	indexer    cache.Indexer
 	controller cache.Controller
+	handler    *podHandler
+	start      sync.Once
+	podWait    time.Duration
+	client     kubernetes.Interface
+	listMisses *utilcache.LRUExpireCache
 }
 
-// NewPodCache creates the cache object that uses a watcher to listen for Pod events. The cache indexes pods by their
-// IP address so that Kiam can identify which role a Pod should assume. It periodically syncs the list of
-// pods and can announce Pods. When announcing Pods via the channel it will drop events if the buffer
-// is full- bufferSize determines how many.
+// NewPodCache creates the cache object that uses a watcher to listen for Pod
+// events. The cache indexes pods by their IP address so that Kiam can identify
+// which role a Pod should assume. It periodically syncs the list of pods and
+// can announce Pods, the identities of Pods that are deleted, complete or
+// change role, and the names of nodes whose Pods have changed. Announcements
+// are queued, without duplicates, until they can be delivered on the channels;
+// bufferSize determines how many are held by the channels.
 func NewPodCache(arnResolver sts.ARNResolver, source cache.ListerWatcher, syncInterval time.Duration, bufferSize int) *PodCache {
+	podHandler := &podHandler{arnResolver: arnResolver, waiters: newPodWaiters()}
 	indexers := cache.Indexers{
 		indexPodIP:           podIPIndex,
 		indexPodRoleIdentity: podRoleIdentityIndex(arnResolver),
+		indexPodDefaultRole:  podDefaultRoleIndex,
+		indexPodNode:         podNodeIndex,
+		indexPodUID:          podUIDIndex,
+		cache.NamespaceIndex: cache.MetaNamespaceIndexFunc,
 	}
 	pods := make(chan *v1.Pod, bufferSize)
-	podHandler := &podHandler{pods}
+	released := make(chan *sts.RoleIdentity, bufferSize)
+	nodes := make(chan string, bufferSize)
 	indexer, controller := cache.NewIndexerInformer(source, &v1.Pod{}, syncInterval, podHandler, indexers)
 	podCache := &PodCache{
 		pods:       pods,
+		released:   released,
+		nodes:      nodes,
 		indexer:    indexer,
 		controller: controller,
+		handler:    podHandler,
 	}
 
 	return podCache
 }
 
+// WithDefaultRoles resolves the roles of Pods without a role annotation, so
+// that they're announced and indexed by their default role. Must be called
+// before Run.
+func (s *PodCache) WithDefaultRoles(defaults *DefaultRoles) *PodCache {
+	s.handler.defaults = defaults
+	return s
+}
+
+// RefreshNamespace announces the Namespace's Pods without a role annotation,
+// and their nodes' changes, once its default role has changed from the
+// previous version's, which may be nil. The identities they used with the
+// previous default role are released.
+func (s *PodCache) RefreshNamespace(namespace string, previous *v1.Namespace) error {
+	items, err := s.indexer.ByIndex(indexPodDefaultRole, namespace)
+	if err != nil {
+		return err
+	}
+
+	var previousRole string
+	if previous != nil {
+		previousRole = s.handler.defaults.NamespaceRole(previous)
+	}
+	for _, obj := range items {
+		pod := obj.(*v1.Pod)
+		s.handler.announce(pod)
+		s.handler.changed(pod)
+		if previousRole == "" || previousRole == s.handler.defaults.PodRole(pod) || IsPodCompleted(pod) {
+			continue
+		}
+
+		identity, err := sts.NewRoleIdentity(s.handler.arnResolver, previousRole, PodSessionName(pod), PodExternalID(pod))
+		if err != nil {
+			log.WithFields(PodFields(pod)).Errorf("error creating previous default role identity: %s", err.Error())
+			continue
+		}
+		s.handler.releases.Add(*identity)
+	}
+
+	return nil
+}
+
 // ErrMultipleRunningPods indicates that multiple pods were found. This is
 // an error as we expect IP addresses to not overlap
 var ErrMultipleRunningPods = fmt.Errorf("multiple running pods found")
@@ -61,25 +126,60 @@ func IsPodCompleted(pod *v1.Pod) bool {
 	return pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed
 }
 
+// PodIPs returns the Pod's IP addresses: on dual-stack clusters Pods have an
+// address from each family.
+func PodIPs(pod *v1.Pod) []string {
+	ips := make([]string, 0, len(pod.Status.PodIPs)+1)
+	if pod.Status.PodIP != "" {
+		ips = append(ips, pod.Status.PodIP)
+	}
+	for _, podIP := range pod.Status.PodIPs {
+		if podIP.IP != "" && podIP.IP != pod.Status.PodIP {
+			ips = append(ips, podIP.IP)
+		}
+	}
+	return ips
+}
+
+func hasPodIP(pod *v1.Pod, ip string) bool {
+	for _, podIP := range PodIPs(pod) {
+		if podIP == ip {
+			return true
+		}
+	}
+	return false
+}
+
 // Pods can be used to watch pods as they're added to the cache, part
 // of the PodAnnouncer interface
 func (s *PodCache) Pods() <-chan *v1.Pod {
 	return s.pods
 }
 
+// Released can be used to watch the identities of pods that have been
+// deleted, completed or changed role, part of the PodAnnouncer interface.
+// Other Pods may still be using the identity.
+func (s *PodCache) Released() <-chan *sts.RoleIdentity {
+	return s.released
+}
+
+// NodeChanges can be used to watch the names of nodes whose Pods have been
+// added, updated or deleted.
+func (s *PodCache) NodeChanges() <-chan string {
+	return s.nodes
+}
+
 // IsActivePodsForRole returns whether there are any uncompleted pods
 // using the provided role. This is used to identify whether the
 // role credentials should be maintained. Part of the PodAnnouncer
 // interface
 func (s *PodCache) IsActivePodsForRole(identity *sts.RoleIdentity) (bool, error) {
-	items, err := s.indexer.ByIndex(indexPodRoleIdentity, identity.String())
+	pods, err := s.podsForRole(identity)
 	if err != nil {
 		return false, err
 	}
 
-	for _, obj := range items {
-		pod, _ := obj.(*v1.Pod)
-
+	for _, pod := range pods {
 		if !IsPodCompleted(pod) {
 			return true, nil
 		}
@@ -88,6 +188,69 @@ func (s *PodCache) IsActivePodsForRole(identity *sts.RoleIdentity) (bool, error)
 	return false, nil
 }
 
+// NodesForRole returns the names of the nodes running uncompleted pods using
+// the provided role.
+func (s *PodCache) NodesForRole(identity *sts.RoleIdentity) ([]string, error) {
+	pods, err := s.podsForRole(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool)
+	nodes := make([]string, 0)
+	for _, pod := range pods {
+		if IsPodCompleted(pod) || pod.Spec.NodeName == "" || found[pod.Spec.NodeName] {
+			continue
+		}
+		found[pod.Spec.NodeName] = true
+		nodes = append(nodes, pod.Spec.NodeName)
+	}
+
+	return nodes, nil
+}
+
+// podsForRole returns the Pods using the identity: those annotated with its
+// role and, as Namespaces' default roles change, those whose Namespace's
+// default role it currently is.
+func (s *PodCache) podsForRole(identity *sts.RoleIdentity) ([]*v1.Pod, error) {
+	items, err := s.indexer.ByIndex(indexPodRoleIdentity, identity.String())
+	if err != nil {
+		return nil, err
+	}
+	pods := make([]*v1.Pod, 0, len(items))
+	for _, obj := range items {
+		pods = append(pods, obj.(*v1.Pod))
+	}
+
+	defaults := s.handler.defaults
+	if defaults == nil {
+		return pods, nil
+	}
+	for _, namespace := range s.indexer.ListIndexFuncValues(indexPodDefaultRole) {
+		role := defaults.DefaultRole(namespace)
+		if role == "" {
+			continue
+		}
+		resolved, err := s.handler.arnResolver.Resolve(role)
+		if err != nil || resolved.ARN != identity.Role.ARN {
+			continue
+		}
+
+		items, err := s.indexer.ByIndex(indexPodDefaultRole, namespace)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range items {
+			pod := obj.(*v1.Pod)
+			if PodSessionName(pod) == identity.SessionName && PodExternalID(pod) == identity.ExternalID {
+				pods = append(pods, pod)
+			}
+		}
+	}
+
+	return pods, nil
+}
+
 var (
 	// ErrPodNotFound is returned when there's no matching Pod in the cache.
 	ErrPodNotFound = fmt.Errorf("pod not found")
@@ -98,10 +261,10 @@ var (
 
 // findPodForIP returns the Pod identified by the provided IP address. The
 // Pod must be active (i.e. pending or running)
-func (s *PodCache) findPodForIP(ip string) (*v1.Pod, error) {
+func findPodForIP(indexer cache.Indexer, ip string) (*v1.Pod, error) {
 	found := make([]*v1.Pod, 0)
 
-	items, err := s.indexer.ByIndex(indexPodIP, ip)
+	items, err := indexer.ByIndex(indexPodIP, ip)
 	if err != nil {
 		return nil, err
 	}
@@ -113,7 +276,7 @@ func (s *PodCache) findPodForIP(ip string) (*v1.Pod, error) {
 			continue
 		}
 
-		if pod.Status.PodIP == ip {
+		if hasPodIP(pod, ip) {
 			found = append(found, pod)
 		}
 	}
@@ -135,45 +298,150 @@ func (s *PodCache) findPodForIP(ip string) (*v1.Pod, error) {
 
 // GetPodByIP returns the Pod with the provided IP address
 func (s *PodCache) GetPodByIP(ip string) (*v1.Pod, error) {
-	return s.findPodForIP(ip)
+	return findPodForIP(s.indexer, ip)
+}
+
+// GetPodByUID returns the active Pod with the provided UID, used to identify
+// hostNetwork Pods that share their node's IP address.
+func (s *PodCache) GetPodByUID(uid string) (*v1.Pod, error) {
+	return findPodForUID(s.indexer, uid)
+}
+
+// findPodForUID returns the active Pod with the UID.
+func findPodForUID(indexer cache.Indexer, uid string) (*v1.Pod, error) {
+	items, err := indexer.ByIndex(indexPodUID, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, obj := range items {
+		pod := obj.(*v1.Pod)
+		if !IsPodCompleted(pod) {
+			return pod, nil
+		}
+	}
+
+	return nil, ErrPodNotFound
+}
+
+// ListPods returns all cached Pods, including those that have completed.
+// Part of the PodAnnouncer interface.
+func (s *PodCache) ListPods() ([]*v1.Pod, error) {
+	items := s.indexer.List()
+	pods := make([]*v1.Pod, 0, len(items))
+	for _, obj := range items {
+		pods = append(pods, obj.(*v1.Pod))
+	}
+
+	return pods, nil
+}
+
+// ListPodsInNamespace returns all cached Pods in the namespace, including
+// those that have completed.
+func (s *PodCache) ListPodsInNamespace(namespace string) ([]*v1.Pod, error) {
+	items, err := s.indexer.ByIndex(cache.NamespaceIndex, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	pods := make([]*v1.Pod, 0, len(items))
+	for _, obj := range items {
+		pods = append(pods, obj.(*v1.Pod))
+	}
+
+	return pods, nil
+}
+
+// ListPodsOnNode returns all cached Pods scheduled to the node, including
+// those that have completed.
+func (s *PodCache) ListPodsOnNode(nodeName string) ([]*v1.Pod, error) {
+	items, err := s.indexer.ByIndex(indexPodNode, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	pods := make([]*v1.Pod, 0, len(items))
+	for _, obj := range items {
+		pods = append(pods, obj.(*v1.Pod))
+	}
+
+	return pods, nil
 }
 
 const (
 	indexPodIP           = "byIP"
 	indexPodRoleIdentity = "byRoleIdentity"
+	indexPodDefaultRole  = "byDefaultRole"
+	indexPodNode         = "byNode"
+	indexPodUID          = "byUID"
 )
 
 func podIPIndex(obj interface{}) ([]string, error) {
 	pod := obj.(*v1.Pod)
+	return PodIPs(pod), nil
+}
+
+func podNodeIndex(obj interface{}) ([]string, error) {
+	pod := obj.(*v1.Pod)
 
-	if pod.Status.PodIP == "" {
+	if pod.Spec.NodeName == "" {
 		return []string{}, nil
 	}
 
-	return []string{pod.Status.PodIP}, nil
+	return []string{pod.Spec.NodeName}, nil
 }
 
+func podUIDIndex(obj interface{}) ([]string, error) {
+	pod := obj.(*v1.Pod)
+	return []string{string(pod.UID)}, nil
+}
+
+// podRoleIdentityIndex indexes Pods by the identities of all their annotated
+// roles.
 func podRoleIdentityIndex(arnResolver sts.ARNResolver) func(obj interface{}) ([]string, error) {
 	return func(obj interface{}) ([]string, error) {
 		pod := obj.(*v1.Pod)
-		role := PodRole(pod)
-		if role == "" {
-			return []string{}, nil
-		}
-
-		sessionName := PodSessionName(pod)
-		externalID := PodExternalID(pod)
-		identity, err := sts.NewRoleIdentity(arnResolver, role, sessionName, externalID)
+		identities, err := PodRoleIdentities(arnResolver, pod)
 		if err != nil {
 			return nil, err
 		}
 
-		return []string{identity.String()}, nil
+		keys := make([]string, 0, len(identities))
+		for _, identity := range identities {
+			keys = append(keys, identity.String())
+		}
+		return keys, nil
+	}
+}
+
+// podDefaultRoleIndex indexes Pods without a role annotation by their
+// Namespace. Their default role is resolved when they're looked up, as it
+// changes with their Namespace.
+func podDefaultRoleIndex(obj interface{}) ([]string, error) {
+	pod := obj.(*v1.Pod)
+	if PodRole(pod) != "" {
+		return []string{}, nil
 	}
+	return []string{pod.GetNamespace()}, nil
 }
 
 // Run starts the controller processing updates. Blocks until the cache has synced
 func (s *PodCache) Run(ctx context.Context) error {
+	s.start.Do(func() {
+		s.handler.announcements = workqueue.NewNamed("pod-announcements")
+		s.handler.releases = workqueue.NewNamed("pod-releases")
+		s.handler.nodes = workqueue.NewNamed("pod-nodes")
+		go func() {
+			<-ctx.Done()
+			s.handler.announcements.ShutDown()
+			s.handler.releases.ShutDown()
+			s.handler.nodes.ShutDown()
+		}()
+		go s.deliverAnnouncements(ctx)
+		go s.deliverReleases(ctx)
+		go s.deliverNodeChanges(ctx)
+	})
+
 	go s.controller.Run(ctx.Done())
 	log.Infof("started cache controller")
 
@@ -185,9 +453,127 @@ func (s *PodCache) Run(ctx context.Context) error {
 	return nil
 }
 
-// PodRole returns the IAM role specified in the annotation for the Pod
+// deliverAnnouncements sends queued Pods on the pods channel, blocking until
+// they're received. Pods are looked up as they're delivered so the latest
+// version is announced and those since deleted or completed are skipped.
+func (s *PodCache) deliverAnnouncements(ctx context.Context) {
+	queue := s.handler.announcements
+	for {
+		item, shutdown := queue.Get()
+		if shutdown {
+			return
+		}
+		queuedAnnouncements.Set(float64(queue.Len()))
+
+		obj, exists, err := s.indexer.GetByKey(item.(string))
+		if err != nil {
+			log.WithField("pod.key", item).Errorf("error finding announced pod: %s", err.Error())
+		}
+		if exists {
+			pod := obj.(*v1.Pod)
+			if !IsPodCompleted(pod) && s.handler.defaults.PodRole(pod) != "" {
+				select {
+				case s.pods <- pod:
+					log.WithFields(PodFields(pod)).Debugf("announced pod")
+				case <-ctx.Done():
+				}
+			}
+		}
+
+		queue.Done(item)
+	}
+}
+
+// deliverReleases sends queued identities on the released channel, blocking
+// until they're received.
+func (s *PodCache) deliverReleases(ctx context.Context) {
+	queue := s.handler.releases
+	for {
+		item, shutdown := queue.Get()
+		if shutdown {
+			return
+		}
+
+		identity := item.(sts.RoleIdentity)
+		select {
+		case s.released <- &identity:
+		case <-ctx.Done():
+		}
+
+		queue.Done(item)
+	}
+}
+
+// deliverNodeChanges sends queued node names on the nodes channel, blocking
+// until they're received.
+func (s *PodCache) deliverNodeChanges(ctx context.Context) {
+	queue := s.handler.nodes
+	for {
+		item, shutdown := queue.Get()
+		if shutdown {
+			return
+		}
+
+		select {
+		case s.nodes <- item.(string):
+		case <-ctx.Done():
+		}
+
+		queue.Done(item)
+	}
+}
+
+// PodRole returns the Pod's default IAM role: the role annotation or, when
+// only the roles annotation is set, its first role.
 func PodRole(pod *v1.Pod) string {
-	return pod.ObjectMeta.Annotations[AnnotationIAMRoleKey]
+	roles := PodRoles(pod)
+	if len(roles) == 0 {
+		return ""
+	}
+	return roles[0]
+}
+
+// PodRoles returns the IAM roles the Pod may assume: the role annotation
+// followed by the comma separated roles annotation, without duplicates.
+func PodRoles(pod *v1.Pod) []string {
+	annotated := []string{pod.ObjectMeta.Annotations[AnnotationIAMRoleKey]}
+	annotated = append(annotated, strings.Split(pod.ObjectMeta.Annotations[AnnotationIAMRolesKey], ",")...)
+
+	var roles []string
+	seen := make(map[string]bool)
+	for _, role := range annotated {
+		role = strings.TrimSpace(role)
+		if role == "" || seen[role] {
+			continue
+		}
+		seen[role] = true
+		roles = append(roles, role)
+	}
+	return roles
+}
+
+// PodRoleIdentity returns the identity that credentials are issued for, given
+// the Pod's role, session name and external id annotations.
+func PodRoleIdentity(arnResolver sts.ARNResolver, pod *v1.Pod) (*sts.RoleIdentity, error) {
+	return sts.NewRoleIdentity(arnResolver, PodRole(pod), PodSessionName(pod), PodExternalID(pod))
+}
+
+// PodRoleIdentities returns the identities credentials are issued for for each
+// of the Pod's roles, as PodRoles.
+func PodRoleIdentities(arnResolver sts.ARNResolver, pod *v1.Pod) ([]*sts.RoleIdentity, error) {
+	return roleIdentities(arnResolver, pod, PodRoles(pod))
+}
+
+func roleIdentities(arnResolver sts.ARNResolver, pod *v1.Pod, roles []string) ([]*sts.RoleIdentity, error) {
+	identities := make([]*sts.RoleIdentity, 0, len(roles))
+	for _, role := range roles {
+		identity, err := sts.NewRoleIdentity(arnResolver, role, PodSessionName(pod), PodExternalID(pod))
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, nil
 }
 
 // PodSessionName returns the IAM role session-name specified in the annotation for the Pod
@@ -203,6 +589,10 @@ func PodExternalID(pod *v1.Pod) string {
 // AnnotationIAMRoleKey is the key for the annotation specifying the IAM Role
 const AnnotationIAMRoleKey = "iam.amazonaws.com/role"
 
+// AnnotationIAMRolesKey is the key for the annotation specifying additional
+// IAM Roles, separated by commas
+const AnnotationIAMRolesKey = "iam.amazonaws.com/roles"
+
 // AnnotationIAMSessionNameKey is the key for the annotation specifying the session-name
 const AnnotationIAMSessionNameKey = "iam.amazonaws.com/session-name"
 
@@ -210,7 +600,12 @@ const AnnotationIAMSessionNameKey = "iam.amazonaws.com/session-name"
 const AnnotationIAMExternalIDKey = "iam.amazonaws.com/external-id"
 
 type podHandler struct {
-	pods chan<- *v1.Pod
+	announcements workqueue.Interface // keys of pods to announce
+	releases      workqueue.Interface // released role identities
+	nodes         workqueue.Interface // names of nodes whose pods changed
+	arnResolver   sts.ARNResolver
+	defaults      *DefaultRoles
+	waiters       *podWaiters // requests waiting for pods to be cached
 }
 
 func (o *podHandler) announce(pod *v1.Pod) {
@@ -218,17 +613,64 @@ func (o *podHandler) announce(pod *v1.Pod) {
 	if IsPodCompleted(pod) {
 		return
 	}
-	if PodRole(pod) == "" {
+	if o.defaults.PodRole(pod) == "" {
 		return
 	}
 
-	select {
-	case o.pods <- pod:
-		logger.Debugf("announced pod")
-	default:
-		dropAnnounce.Inc()
-		logger.Warnf("pods announcement full, dropping")
+	key, err := cache.MetaNamespaceKeyFunc(pod)
+	if err != nil {
+		logger.Errorf("error creating key for announced pod: %s", err.Error())
+		return
+	}
+
+	o.announcements.Add(key)
+	queuedAnnouncements.Set(float64(o.announcements.Len()))
+	logger.Debugf("queued pod announcement")
+}
+
+// changed announces that the pods on the pod's node have changed.
+func (o *podHandler) changed(pod *v1.Pod) {
+	if pod.Spec.NodeName == "" {
+		return
 	}
+	o.nodes.Add(pod.Spec.NodeName)
+}
+
+// release announces that the pod no longer needs its identities' credentials.
+func (o *podHandler) release(pod *v1.Pod) {
+	logger := log.WithFields(PodFields(pod))
+	identities, err := o.defaults.PodRoleIdentities(o.arnResolver, pod)
+	if err != nil {
+		logger.Errorf("error creating role identity for released pod: %s", err.Error())
+		return
+	}
+
+	for _, identity := range identities {
+		o.releases.Add(*identity)
+	}
+	if len(identities) > 0 {
+		logger.Debugf("released pod roles")
+	}
+}
+
+// roleChanged returns whether the annotations, and defaults, that determine
+// the pod's identities differ.
+func roleChanged(defaults *DefaultRoles, old, new *v1.Pod) bool {
+	return !equalRoles(defaults.PodRoles(old), defaults.PodRoles(new)) ||
+		PodSessionName(old) != PodSessionName(new) ||
+		PodExternalID(old) != PodExternalID(new)
+}
+
+func equalRoles(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
 
 func (o *podHandler) OnAdd(obj interface{}) {
@@ -240,6 +682,8 @@ func (o *podHandler) OnAdd(obj interface{}) {
 	log.WithFields(PodFields(pod)).Debugf("added pod")
 
 	o.announce(pod)
+	o.changed(pod)
+	o.waiters.notify(pod)
 }
 
 func (o *podHandler) OnDelete(obj interface{}) {
@@ -254,13 +698,13 @@ func (o *podHandler) OnDelete(obj interface{}) {
 		pod, isPod = deletedObj.Obj.(*v1.Pod)
 		if !isPod {
 			log.Errorf("OnDelete unexpected DeletedFinalStateUnknown object: %+v", deletedObj.Obj)
+			return
 		}
-		log.WithFields(PodFields(pod)).Debugf("deleted pod")
-		return
 	}
 
 	log.WithFields(PodFields(pod)).Debugf("deleted pod")
-	return
+	o.release(pod)
+	o.changed(pod)
 }
 
 func (o *podHandler) OnUpdate(old, new interface{}) {
@@ -271,4 +715,26 @@ func (o *podHandler) OnUpdate(old, new interface{}) {
 	}
 
 	log.WithFields(PodFields(pod)).Debugf("updated pod")
+
+	o.changed(pod)
+	// pods are often added before they're assigned an ip
+	o.waiters.notify(pod)
+
+	oldPod, isPod := old.(*v1.Pod)
+	if !isPod {
+		return
+	}
+
+	if roleChanged(o.defaults, oldPod, pod) {
+		log.WithFields(PodFields(pod)).Infof("pod role changed from %s", o.defaults.PodRole(oldPod))
+		o.announce(pod)
+		if !IsPodCompleted(oldPod) {
+			o.release(oldPod)
+		}
+		return
+	}
+
+	if !IsPodCompleted(oldPod) && IsPodCompleted(pod) {
+		o.release(pod)
+	}
 }
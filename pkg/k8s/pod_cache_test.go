@@ -16,17 +16,79 @@ package k8s
 import (
 	"context"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/fortytw2/leaktest"
 	"github.com/uswitch/kiam/pkg/aws/sts"
 	"github.com/uswitch/kiam/pkg/testutil"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
 	kt "k8s.io/client-go/tools/cache/testing"
+	"k8s.io/client-go/util/workqueue"
 )
 
 const bufferSize = 10
 
+// stoppedSource is a fake source whose Shutdown waits for the informer's
+// watches to be stopped, once its context is cancelled. The fake's broadcaster
+// races when a watch is stopped while it's shut down.
+type stoppedSource struct {
+	*kt.FakeControllerSource
+	mu      sync.Mutex
+	stopped *sync.Cond
+	watches int
+}
+
+func newStoppedSource() *stoppedSource {
+	s := &stoppedSource{FakeControllerSource: kt.NewFakeControllerSource()}
+	s.stopped = sync.NewCond(&s.mu)
+	return s
+}
+
+func (s *stoppedSource) Watch(options metav1.ListOptions) (watch.Interface, error) {
+	w, err := s.FakeControllerSource.Watch(options)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watches++
+	return &stoppedWatch{Interface: w, source: s}, nil
+}
+
+// Shutdown waits for the watches to be stopped, and then shuts the source
+// down. The context of the caches watching it must be cancelled first.
+func (s *stoppedSource) Shutdown() {
+	s.mu.Lock()
+	for s.watches > 0 {
+		s.stopped.Wait()
+	}
+	s.mu.Unlock()
+
+	s.FakeControllerSource.Shutdown()
+}
+
+type stoppedWatch struct {
+	watch.Interface
+	source *stoppedSource
+	once   sync.Once
+}
+
+func (w *stoppedWatch) Stop() {
+	w.once.Do(func() {
+		w.Interface.Stop()
+
+		w.source.mu.Lock()
+		defer w.source.mu.Unlock()
+		w.source.watches--
+		w.source.stopped.Broadcast()
+	})
+}
+
 func TestFindsRunningPod(t *testing.T) {
 	defer leaktest.Check(t)()
 
@@ -50,6 +112,35 @@ func TestFindsRunningPod(t *testing.T) {
 	}
 }
 
+func dualStack(pod *v1.Pod, ipv6 string) *v1.Pod {
+	pod.Status.PodIPs = []v1.PodIP{{IP: pod.Status.PodIP}, {IP: ipv6}}
+	return pod
+}
+
+func TestFindsDualStackPod(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	arnResolver := sts.DefaultResolver("arn:account:")
+	c := NewPodCache(arnResolver, source, time.Second, bufferSize)
+	source.Add(dualStack(testutil.NewPodWithRole("ns", "name", "192.168.0.1", "Running", "running_role"), "fd00::1"))
+	c.Run(ctx)
+
+	for _, ip := range []string{"192.168.0.1", "fd00::1"} {
+		found, err := c.GetPodByIP(ip)
+		if err != nil {
+			t.Fatal("should have found pod for", ip, err)
+		}
+		if PodRole(found) != "running_role" {
+			t.Error("wrong role found for", ip)
+		}
+	}
+}
+
 func TestFindRoleActive(t *testing.T) {
 	defer leaktest.Check(t)()
 
@@ -78,6 +169,44 @@ func TestFindRoleActive(t *testing.T) {
 	}
 }
 
+func TestFindAdditionalRolesActive(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	source := newStoppedSource()
+	defer source.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	arnResolver := sts.DefaultResolver("arn:account:")
+	c := NewPodCache(arnResolver, source, time.Second, bufferSize)
+	source.Add(testutil.NewPodWithRoles("ns", "name", "192.168.0.1", "Running", "reader", "reader, writer"))
+	c.Run(ctx)
+	<-c.Pods()
+
+	for _, role := range []string{"reader", "writer"} {
+		identity, _ := sts.NewRoleIdentity(arnResolver, role, "", "")
+		active, _ := c.IsActivePodsForRole(identity)
+		if !active {
+			t.Error("expected running pod for", role)
+		}
+	}
+
+	source.Delete(testutil.NewPodWithRoles("ns", "name", "192.168.0.1", "Running", "reader", "reader, writer"))
+	released := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case identity := <-c.Released():
+			released[identity.Role.Name] = true
+		case <-time.After(time.Second):
+			t.Fatal("expected pod's roles to be released")
+		}
+	}
+	if !released["reader"] || !released["writer"] {
+		t.Error("expected all roles released, was", released)
+	}
+}
+
 func TestFindRoleActiveWithSessionName(t *testing.T) {
 	defer leaktest.Check(t)()
 
@@ -132,6 +261,157 @@ func TestFindRoleActiveWithExternalID(t *testing.T) {
 	}
 }
 
+func TestQueuesAnnouncementsWhenBufferFull(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+
+	c := NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Minute, 1)
+	for i := 0; i < 5; i++ {
+		source.Add(testutil.NewPodWithRole("ns", fmt.Sprintf("name-%d", i), fmt.Sprintf("192.168.0.%d", i), "Running", "reader"))
+	}
+	c.Run(ctx)
+
+	for i := 0; i < 5; i++ {
+		select {
+		case <-c.Pods():
+		case <-time.After(time.Second):
+			t.Fatalf("expected 5 pods to be announced, was %d", i)
+		}
+	}
+}
+
+func newTestPodHandler() *podHandler {
+	return &podHandler{
+		announcements: workqueue.New(),
+		releases:      workqueue.New(),
+		nodes:         workqueue.New(),
+		arnResolver:   sts.DefaultResolver("arn:account:"),
+		waiters:       newPodWaiters(),
+	}
+}
+
+func (o *podHandler) shutdown() {
+	o.announcements.ShutDown()
+	o.releases.ShutDown()
+	o.nodes.ShutDown()
+}
+
+func TestPodRolesListsDefaultRoleFirst(t *testing.T) {
+	pod := testutil.NewPodWithRoles("ns", "name", "192.168.0.1", "Running", "reader", " writer, reader,,admin ")
+
+	roles := PodRoles(pod)
+	expected := []string{"reader", "writer", "admin"}
+	if len(roles) != len(expected) {
+		t.Fatal("unexpected roles, was", roles)
+	}
+	for i := range expected {
+		if roles[i] != expected[i] {
+			t.Error("unexpected roles, was", roles)
+		}
+	}
+
+	if PodRole(pod) != "reader" {
+		t.Error("expected default role, was", PodRole(pod))
+	}
+	if roles := PodRoles(testutil.NewPod("ns", "name", "192.168.0.1", "Running")); len(roles) != 0 {
+		t.Error("expected no roles for unannotated pod, was", roles)
+	}
+}
+
+func TestAnnouncesRoleChangesAndReleasedRoles(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	source := newStoppedSource()
+	defer source.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	arnResolver := sts.DefaultResolver("arn:account:")
+	c := NewPodCache(arnResolver, source, time.Minute, bufferSize)
+	source.Add(testutil.NewPodWithRole("ns", "name", "192.168.0.1", "Running", "reader"))
+	c.Run(ctx)
+
+	announced := <-c.Pods()
+	if PodRole(announced) != "reader" {
+		t.Error("expected reader pod to be announced, was", PodRole(announced))
+	}
+
+	source.Modify(testutil.NewPodWithRole("ns", "name", "192.168.0.1", "Running", "writer"))
+	select {
+	case announced = <-c.Pods():
+		if PodRole(announced) != "writer" {
+			t.Error("expected writer pod to be announced, was", PodRole(announced))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected pod with changed role to be announced")
+	}
+	assertReleased(t, c, "reader")
+
+	source.Delete(testutil.NewPodWithRole("ns", "name", "192.168.0.1", "Running", "writer"))
+	assertReleased(t, c, "writer")
+
+	identity, _ := sts.NewRoleIdentity(arnResolver, "writer", "", "")
+	active, _ := c.IsActivePodsForRole(identity)
+	if active {
+		t.Error("expected no active pods for writer after delete")
+	}
+}
+
+func TestReleasesRoleWhenPodCompletes(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	source := newStoppedSource()
+	defer source.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Minute, bufferSize)
+	source.Add(testutil.NewPodWithRole("ns", "name", "192.168.0.1", "Running", "reader"))
+	c.Run(ctx)
+	<-c.Pods()
+
+	source.Modify(testutil.NewPodWithRole("ns", "name", "192.168.0.1", "Succeeded", "reader"))
+	assertReleased(t, c, "reader")
+}
+
+func assertReleased(t *testing.T, c *PodCache, role string) {
+	t.Helper()
+
+	select {
+	case identity := <-c.Released():
+		if identity.Role.Name != role {
+			t.Errorf("expected %s to be released, was %s", role, identity.Role.Name)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected %s to be released", role)
+	}
+}
+
+// assertHandlerReleased checks the next identity queued for release by the
+// handler.
+func assertHandlerReleased(t *testing.T, handler *podHandler, role string) {
+	t.Helper()
+
+	if handler.releases.Len() == 0 {
+		t.Fatalf("expected %s to be released", role)
+	}
+
+	item, _ := handler.releases.Get()
+	defer handler.releases.Done(item)
+
+	identity := item.(sts.RoleIdentity)
+	if identity.Role.Name != role {
+		t.Errorf("expected %s to be released, was %s", role, identity.Role.Name)
+	}
+}
+
 func BenchmarkFindPodsByIP(b *testing.B) {
 	b.StopTimer()
 
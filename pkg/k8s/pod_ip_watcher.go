@@ -0,0 +1,145 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package k8s
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// PodIPWatcher notifies when a Pod's IP address no longer identifies the same
+// Pod and role: the Pod was deleted or completed, its IP changed or its role
+// changed. Used by the agent to invalidate what it has cached for the IP, and
+// to find the Pods making requests.
+type PodIPWatcher struct {
+	indexer    cache.Indexer
+	controller cache.Controller
+}
+
+// NewPodIPWatcher creates a watcher calling released, when set, with the IP
+// address of Pods from source.
+func NewPodIPWatcher(source cache.ListerWatcher, syncInterval time.Duration, released func(ip string)) *PodIPWatcher {
+	indexers := cache.Indexers{
+		indexPodIP:           podIPIndex,
+		indexPodUID:          podUIDIndex,
+		cache.NamespaceIndex: cache.MetaNamespaceIndexFunc,
+	}
+	indexer, controller := cache.NewIndexerInformer(source, &v1.Pod{}, syncInterval, &podIPHandler{released: released}, indexers)
+	return &PodIPWatcher{indexer: indexer, controller: controller}
+}
+
+// GetPodByIP returns the active Pod with the IP address.
+func (w *PodIPWatcher) GetPodByIP(ip string) (*v1.Pod, error) {
+	return findPodForIP(w.indexer, ip)
+}
+
+// GetPodByUID returns the active Pod with the UID.
+func (w *PodIPWatcher) GetPodByUID(uid string) (*v1.Pod, error) {
+	return findPodForUID(w.indexer, uid)
+}
+
+// ListPodsInNamespace returns the watched Pods in the Namespace.
+func (w *PodIPWatcher) ListPodsInNamespace(namespace string) ([]*v1.Pod, error) {
+	items, err := w.indexer.ByIndex(cache.NamespaceIndex, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	pods := make([]*v1.Pod, 0, len(items))
+	for _, obj := range items {
+		pods = append(pods, obj.(*v1.Pod))
+	}
+
+	return pods, nil
+}
+
+// Run starts watching Pods. Blocks until the watch has synced.
+func (w *PodIPWatcher) Run(ctx context.Context) error {
+	go w.controller.Run(ctx.Done())
+
+	ok := cache.WaitForCacheSync(ctx.Done(), w.controller.HasSynced)
+	if !ok {
+		return ErrWaitingForSync
+	}
+
+	return nil
+}
+
+type podIPHandler struct {
+	released func(ip string)
+}
+
+func (h *podIPHandler) release(pod *v1.Pod) {
+	if h.released == nil {
+		return
+	}
+	for _, ip := range PodIPs(pod) {
+		log.WithFields(PodFields(pod)).WithField("pod.ip", ip).Debugf("released pod ip")
+		h.released(ip)
+	}
+}
+
+func (h *podIPHandler) OnAdd(obj interface{}) {}
+
+func (h *podIPHandler) OnDelete(obj interface{}) {
+	pod, isPod := obj.(*v1.Pod)
+	if !isPod {
+		deletedObj, isDeleted := obj.(cache.DeletedFinalStateUnknown)
+		if !isDeleted {
+			log.Errorf("OnDelete unexpected object: %+v", obj)
+			return
+		}
+
+		pod, isPod = deletedObj.Obj.(*v1.Pod)
+		if !isPod {
+			log.Errorf("OnDelete unexpected DeletedFinalStateUnknown object: %+v", deletedObj.Obj)
+			return
+		}
+	}
+
+	h.release(pod)
+}
+
+func (h *podIPHandler) OnUpdate(old, new interface{}) {
+	oldPod, isPod := old.(*v1.Pod)
+	if !isPod {
+		return
+	}
+	pod, isPod := new.(*v1.Pod)
+	if !isPod {
+		log.Errorf("OnUpdate unexpected object: %+v", new)
+		return
+	}
+
+	if podIPsChanged(oldPod, pod) || roleChanged(nil, oldPod, pod) || (!IsPodCompleted(oldPod) && IsPodCompleted(pod)) {
+		h.release(oldPod)
+	}
+}
+
+func podIPsChanged(old, new *v1.Pod) bool {
+	oldIPs, newIPs := PodIPs(old), PodIPs(new)
+	if len(oldIPs) != len(newIPs) {
+		return true
+	}
+	for i := range oldIPs {
+		if oldIPs[i] != newIPs[i] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package k8s
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/uswitch/kiam/pkg/testutil"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	kt "k8s.io/client-go/tools/cache/testing"
+)
+
+func TestReleasesPodIPs(t *testing.T) {
+	var released []string
+	handler := &podIPHandler{released: func(ip string) { released = append(released, ip) }}
+
+	running := testutil.NewPodWithRole("ns", "name", "10.0.0.1", "Running", "role")
+	handler.OnAdd(running)
+	handler.OnUpdate(running, testutil.NewPodWithRole("ns", "name", "10.0.0.1", "Running", "role"))
+	if len(released) != 0 {
+		t.Fatal("expected no ips released, was", released)
+	}
+
+	handler.OnUpdate(running, testutil.NewPodWithRole("ns", "name", "10.0.0.1", "Running", "other"))
+	handler.OnUpdate(running, testutil.NewPodWithRole("ns", "name", "10.0.0.2", "Running", "role"))
+	handler.OnUpdate(running, testutil.NewPodWithRole("ns", "name", "10.0.0.1", "Succeeded", "role"))
+	handler.OnDelete(running)
+	handler.OnDelete(cache.DeletedFinalStateUnknown{Key: "ns/other", Obj: testutil.NewPodWithRole("ns", "other", "10.0.0.3", "Running", "role")})
+	handler.OnDelete(testutil.NewPodWithRole("ns", "pending", "", "Pending", "role"))
+
+	expected := []string{"10.0.0.1", "10.0.0.1", "10.0.0.1", "10.0.0.1", "10.0.0.3"}
+	if !reflect.DeepEqual(released, expected) {
+		t.Error("unexpected released ips", released)
+	}
+}
+
+func TestReleasesDualStackPodIPs(t *testing.T) {
+	var released []string
+	handler := &podIPHandler{released: func(ip string) { released = append(released, ip) }}
+
+	running := dualStack(testutil.NewPodWithRole("ns", "name", "10.0.0.1", "Running", "role"), "fd00::1")
+	handler.OnUpdate(running, dualStack(testutil.NewPodWithRole("ns", "name", "10.0.0.1", "Running", "role"), "fd00::1"))
+	if len(released) != 0 {
+		t.Fatal("expected no ips released, was", released)
+	}
+
+	handler.OnUpdate(running, dualStack(testutil.NewPodWithRole("ns", "name", "10.0.0.1", "Running", "role"), "fd00::2"))
+	handler.OnDelete(running)
+
+	expected := []string{"10.0.0.1", "fd00::1", "10.0.0.1", "fd00::1"}
+	if !reflect.DeepEqual(released, expected) {
+		t.Error("unexpected released ips", released)
+	}
+}
+
+func TestFindsWatchedPods(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	running := testutil.NewPodWithRole("ns", "running", "10.0.0.1", "Running", "role")
+	running.UID = types.UID("uid-1")
+	source.Add(running)
+	source.Add(testutil.NewPodWithRole("ns", "completed", "10.0.0.2", "Succeeded", "role"))
+
+	watcher := NewPodIPWatcher(source, 0, nil)
+	if err := watcher.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if pod, err := watcher.GetPodByIP("10.0.0.1"); err != nil || pod.Name != "running" {
+		t.Error("expected running pod by ip, was", pod, err)
+	}
+	if pod, err := watcher.GetPodByUID("uid-1"); err != nil || pod.Name != "running" {
+		t.Error("expected running pod by uid, was", pod, err)
+	}
+	if _, err := watcher.GetPodByIP("10.0.0.2"); err != ErrPodNotFound {
+		t.Error("expected completed pod not to be found, was", err)
+	}
+	if pods, err := watcher.ListPodsInNamespace("ns"); err != nil || len(pods) != 2 {
+		t.Error("expected pods in namespace, was", pods, err)
+	}
+	if pods, _ := watcher.ListPodsInNamespace("other"); len(pods) != 0 {
+		t.Error("expected no pods in other namespace, was", pods)
+	}
+}
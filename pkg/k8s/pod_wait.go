@@ -0,0 +1,226 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package k8s
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	utilcache "k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// podListMissesMax is the number of IP addresses whose Pods weren't
+	// found by listing that are remembered.
+	podListMissesMax = 4096
+	// podListMissTTL is how long Pods with an IP address aren't listed again
+	// after they weren't found.
+	podListMissTTL = 10 * time.Second
+)
+
+// podWaiters notifies requests waiting for a Pod with an IP address, or a
+// UID, to be cached.
+type podWaiters struct {
+	mu      sync.Mutex
+	waiters map[string]map[chan struct{}]bool
+}
+
+func newPodWaiters() *podWaiters {
+	return &podWaiters{waiters: make(map[string]map[chan struct{}]bool)}
+}
+
+// subscribe returns a channel that receives when a Pod with the IP address, or
+// UID, is added or updated, and a func to unsubscribe.
+func (w *podWaiters) subscribe(key string) (<-chan struct{}, func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// notifications coalesce until the waiter next checks the cache
+	changes := make(chan struct{}, 1)
+	if w.waiters[key] == nil {
+		w.waiters[key] = make(map[chan struct{}]bool)
+	}
+	w.waiters[key][changes] = true
+
+	return changes, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		delete(w.waiters[key], changes)
+		if len(w.waiters[key]) == 0 {
+			delete(w.waiters, key)
+		}
+	}
+}
+
+func (w *podWaiters) notify(pod *v1.Pod) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, key := range append(PodIPs(pod), string(pod.UID)) {
+		for changes := range w.waiters[key] {
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// WithPodWait makes WaitForPodByIP and WaitForPodByUID wait up to timeout for
+// Pods that aren't cached yet and then, when client is set, list those found
+// by IP address from the API server. Must be called before Run.
+func (s *PodCache) WithPodWait(timeout time.Duration, client kubernetes.Interface) *PodCache {
+	s.podWait = timeout
+	s.client = client
+	s.listMisses = utilcache.NewLRUExpireCache(podListMissesMax)
+	return s
+}
+
+// WaitForPodByIP returns the Pod with the IP address, as GetPodByIP. Pods
+// that have just started often make requests before they're cached, so when
+// the Pod isn't found it waits for it to be, within the context's deadline.
+// Pods still not cached are listed from the API server.
+func (s *PodCache) WaitForPodByIP(ctx context.Context, ip string) (*v1.Pod, error) {
+	pod, err := s.GetPodByIP(ip)
+	if err != ErrPodNotFound || s.podWait == 0 {
+		return pod, err
+	}
+
+	log.WithField("pod.ip", ip).Debugf("waiting for pod to be cached")
+	pod, err = s.waitForPod(ctx, ip, s.GetPodByIP)
+	if err != ErrPodNotFound {
+		return pod, err
+	}
+	return s.listPodByIP(ctx, ip)
+}
+
+// WaitForPodByUID returns the Pod with the UID, as GetPodByUID, waiting for it
+// to be cached within the context's deadline as WaitForPodByIP. Pods can't be
+// listed by UID, so aren't listed from the API server.
+func (s *PodCache) WaitForPodByUID(ctx context.Context, uid string) (*v1.Pod, error) {
+	pod, err := s.GetPodByUID(uid)
+	if err != ErrPodNotFound || s.podWait == 0 {
+		return pod, err
+	}
+
+	log.WithField("pod.uid", uid).Debugf("waiting for pod to be cached")
+	pod, err = s.waitForPod(ctx, uid, s.GetPodByUID)
+	if err == ErrPodNotFound {
+		podWaits.WithLabelValues("not_found").Inc()
+	}
+	return pod, err
+}
+
+// waitForPod waits for get to find the Pod with the IP address or UID,
+// returning ErrPodNotFound when it isn't cached in time.
+func (s *PodCache) waitForPod(ctx context.Context, key string, get func(string) (*v1.Pod, error)) (*v1.Pod, error) {
+	changes, unsubscribe := s.handler.waiters.subscribe(key)
+	defer unsubscribe()
+
+	waitCtx, cancel := context.WithTimeout(ctx, podWaitTimeout(ctx, s.podWait))
+	defer cancel()
+
+	for {
+		// the pod may have been cached before subscribing
+		pod, err := get(key)
+		switch err {
+		case nil:
+			podWaits.WithLabelValues("cached").Inc()
+			return pod, nil
+		case ErrMultipleRunningPods:
+			podWaits.WithLabelValues("multiple").Inc()
+			return nil, err
+		case ErrPodNotFound:
+		default:
+			return nil, err
+		}
+
+		select {
+		case <-changes:
+		case <-waitCtx.Done():
+			return nil, ErrPodNotFound
+		}
+	}
+}
+
+// podWaitTimeout returns how long to wait for a Pod to be cached, leaving half
+// of the context's remaining time to list it.
+func podWaitTimeout(ctx context.Context, timeout time.Duration) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return timeout
+	}
+	if remaining := time.Until(deadline) / 2; remaining < timeout {
+		return remaining
+	}
+	return timeout
+}
+
+// listPodByIP returns the active Pod with the IP address listed from the API
+// server. IP addresses whose Pods weren't found aren't listed again for a
+// while, so requests from processes outside Pods don't each list them. Pods
+// can only be selected by their primary IP address, so those requesting from
+// a secondary, dual-stack, address aren't found until they're cached.
+func (s *PodCache) listPodByIP(ctx context.Context, ip string) (*v1.Pod, error) {
+	logger := log.WithField("pod.ip", ip)
+	if s.client == nil {
+		podWaits.WithLabelValues("not_found").Inc()
+		return nil, ErrPodNotFound
+	}
+	if _, missed := s.listMisses.Get(ip); missed {
+		podWaits.WithLabelValues("not_found").Inc()
+		return nil, ErrPodNotFound
+	}
+
+	// a consistent read, as the api server's watch cache lags as the
+	// informer's does; the field selector keeps it cheap
+	list, err := s.client.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("status.podIP", ip).String(),
+	})
+	if err != nil {
+		// errors aren't remembered as misses, the pod may still be listed
+		logger.Warnf("error listing pod not yet cached: %s", err.Error())
+		podWaits.WithLabelValues("error").Inc()
+		return nil, ErrPodNotFound
+	}
+
+	found := make([]*v1.Pod, 0, len(list.Items))
+	for i := range list.Items {
+		pod := &list.Items[i]
+		if !IsPodCompleted(pod) && hasPodIP(pod, ip) {
+			found = append(found, pod)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		podWaits.WithLabelValues("not_found").Inc()
+		s.listMisses.Add(ip, true, podListMissTTL)
+		return nil, ErrPodNotFound
+	case 1:
+		logger.WithFields(PodFields(found[0])).Infof("found pod not yet cached from the api server")
+		podWaits.WithLabelValues("listed").Inc()
+		return found[0], nil
+	default:
+		podWaits.WithLabelValues("multiple").Inc()
+		return nil, ErrMultipleRunningPods
+	}
+}
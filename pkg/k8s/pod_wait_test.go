@@ -0,0 +1,236 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package k8s
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uswitch/kiam/pkg/aws/sts"
+	"github.com/uswitch/kiam/pkg/testutil"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+	kt "k8s.io/client-go/tools/cache/testing"
+)
+
+func TestWaitsForPodToBeCached(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	source := newStoppedSource()
+	defer source.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the pod is created before it's assigned an ip
+	source.Add(testutil.NewPodWithRole("ns", "name", "", "Pending", "role"))
+	c := NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Second, bufferSize).WithPodWait(5*time.Second, nil)
+	c.Run(ctx)
+
+	modified := make(chan struct{})
+	defer func() { <-modified }()
+	go func() {
+		defer close(modified)
+		time.Sleep(100 * time.Millisecond)
+		source.Modify(testutil.NewPodWithRole("ns", "name", "192.168.0.1", "Running", "role"))
+	}()
+
+	found, err := c.WaitForPodByIP(ctx, "192.168.0.1")
+	if err != nil {
+		t.Fatal("expected pod found once cached, was", err)
+	}
+	if found.Name != "name" {
+		t.Error("unexpected pod", found.Name)
+	}
+}
+
+func TestListsPodNotCachedWithinDeadline(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	client := fake.NewSimpleClientset(
+		testutil.NewPodWithRole("ns", "other", "192.168.0.2", "Running", "role"),
+		testutil.NewPodWithRole("ns", "name", "192.168.0.1", "Running", "role"),
+	)
+	c := NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Second, bufferSize).WithPodWait(5*time.Second, client)
+	c.Run(ctx)
+
+	requestCtx, cancelRequest := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancelRequest()
+	found, err := c.WaitForPodByIP(requestCtx, "192.168.0.1")
+	if err != nil {
+		t.Fatal("expected pod listed, was", err)
+	}
+	if found.Name != "name" {
+		t.Error("unexpected pod", found.Name)
+	}
+
+	requestCtx, cancelRequest = context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancelRequest()
+	if _, err := c.WaitForPodByIP(requestCtx, "192.168.0.3"); err != ErrPodNotFound {
+		t.Error("expected pod not found, was", err)
+	}
+}
+
+func TestDoesntWaitWhenDisabled(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	c := NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Second, bufferSize)
+	c.Run(ctx)
+
+	started := time.Now()
+	if _, err := c.WaitForPodByIP(ctx, "192.168.0.1"); err != ErrPodNotFound {
+		t.Error("expected pod not found, was", err)
+	}
+	if time.Since(started) > time.Second {
+		t.Error("expected not to wait")
+	}
+}
+
+func TestDoesntListPodsNotFoundAgain(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	client := fake.NewSimpleClientset()
+	lists := 0
+	client.PrependReactor("list", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+		lists++
+		if restrictions := action.(ktesting.ListAction).GetListRestrictions(); restrictions.Fields.String() != "status.podIP=192.168.0.1" {
+			t.Error("unexpected field selector", restrictions.Fields.String())
+		}
+		return false, nil, nil
+	})
+	c := NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Second, bufferSize).WithPodWait(5*time.Second, client)
+	c.Run(ctx)
+
+	for i := 0; i < 2; i++ {
+		requestCtx, cancelRequest := context.WithTimeout(ctx, 100*time.Millisecond)
+		if _, err := c.WaitForPodByIP(requestCtx, "192.168.0.1"); err != ErrPodNotFound {
+			t.Error("expected pod not found, was", err)
+		}
+		cancelRequest()
+	}
+	if lists != 1 {
+		t.Error("expected pods listed once, was", lists)
+	}
+}
+
+func TestListsPodsAgainAfterErrors(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	client := fake.NewSimpleClientset()
+	lists := 0
+	client.PrependReactor("list", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+		lists++
+		return true, nil, errors.New("unavailable")
+	})
+	c := NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Second, bufferSize).WithPodWait(5*time.Second, client)
+	c.Run(ctx)
+
+	initial := promtestutil.ToFloat64(podWaits.WithLabelValues("error"))
+	for i := 0; i < 2; i++ {
+		requestCtx, cancelRequest := context.WithTimeout(ctx, 100*time.Millisecond)
+		if _, err := c.WaitForPodByIP(requestCtx, "192.168.0.1"); err != ErrPodNotFound {
+			t.Error("expected pod not found, was", err)
+		}
+		cancelRequest()
+	}
+	if lists != 2 {
+		t.Error("expected pods listed after each error, was", lists)
+	}
+	if counted := promtestutil.ToFloat64(podWaits.WithLabelValues("error")) - initial; counted != 2 {
+		t.Error("expected errors counted, was", counted)
+	}
+}
+
+func TestCountsMultiplePodsListed(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	client := fake.NewSimpleClientset(
+		testutil.NewPodWithRole("ns", "name", "192.168.0.1", "Running", "role"),
+		testutil.NewPodWithRole("ns", "other", "192.168.0.1", "Running", "role"),
+	)
+	c := NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Second, bufferSize).WithPodWait(5*time.Second, client)
+	c.Run(ctx)
+
+	initial := promtestutil.ToFloat64(podWaits.WithLabelValues("multiple"))
+	requestCtx, cancelRequest := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancelRequest()
+	if _, err := c.WaitForPodByIP(requestCtx, "192.168.0.1"); err != ErrMultipleRunningPods {
+		t.Error("expected multiple pods, was", err)
+	}
+	if counted := promtestutil.ToFloat64(podWaits.WithLabelValues("multiple")) - initial; counted != 1 {
+		t.Error("expected wait counted, was", counted)
+	}
+}
+
+func TestWaitsForPodWithUIDToBeCached(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	source := newStoppedSource()
+	defer source.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Second, bufferSize).WithPodWait(5*time.Second, nil)
+	c.Run(ctx)
+
+	pod := testutil.NewPodWithRole("ns", "name", "192.168.0.1", "Running", "role")
+	pod.UID = types.UID("uid-1")
+	added := make(chan struct{})
+	defer func() { <-added }()
+	go func() {
+		defer close(added)
+		time.Sleep(100 * time.Millisecond)
+		source.Add(pod)
+	}()
+
+	found, err := c.WaitForPodByUID(ctx, "uid-1")
+	if err != nil {
+		t.Fatal("expected pod found once cached, was", err)
+	}
+	if found.Name != "name" {
+		t.Error("unexpected pod", found.Name)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package k8s
+
+import (
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// AnnotationAllowRouteRegexpKey is the key for the Pod or Namespace annotation
+// holding the regexp of metadata paths the agent proxies for the Pods, in place
+// of its --allow-route-regexp.
+const AnnotationAllowRouteRegexpKey = "iam.amazonaws.com/allow-route-regexp"
+
+// AnnotationRegionKey is the key for the Pod or Namespace annotation holding
+// the region the agent answers placement/region with for the Pods.
+const AnnotationRegionKey = "iam.amazonaws.com/region"
+
+// NamespaceAllowRouteRegexp returns the Namespace's annotated metadata routes.
+// The Namespace may be nil.
+func NamespaceAllowRouteRegexp(namespace *v1.Namespace) string {
+	if namespace == nil {
+		return ""
+	}
+	return strings.TrimSpace(namespace.GetAnnotations()[AnnotationAllowRouteRegexpKey])
+}
+
+// PodAllowRouteRegexp returns the Pod's annotated metadata routes. A Pod's
+// annotation only narrows its Namespace's routes, or the agent's when its
+// Namespace isn't annotated, so paths must match each.
+func PodAllowRouteRegexp(pod *v1.Pod) string {
+	return strings.TrimSpace(pod.GetAnnotations()[AnnotationAllowRouteRegexpKey])
+}
+
+// PodRegion returns the Pod's annotated placement region or, when it isn't
+// annotated, its Namespace's. The Namespace may be nil.
+func PodRegion(pod *v1.Pod, namespace *v1.Namespace) string {
+	return podOrNamespaceAnnotation(pod, namespace, AnnotationRegionKey)
+}
+
+func podOrNamespaceAnnotation(pod *v1.Pod, namespace *v1.Namespace, key string) string {
+	if value := strings.TrimSpace(pod.GetAnnotations()[key]); value != "" {
+		return value
+	}
+	if namespace == nil {
+		return ""
+	}
+	return strings.TrimSpace(namespace.GetAnnotations()[key])
+}
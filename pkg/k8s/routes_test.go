@@ -0,0 +1,58 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package k8s
+
+import (
+	"testing"
+
+	"github.com/uswitch/kiam/pkg/testutil"
+)
+
+func TestPodAnnotationNarrowsNamespaceRoutes(t *testing.T) {
+	namespace := testutil.NewNamespace("team", ".*")
+	namespace.Annotations[AnnotationAllowRouteRegexpKey] = "^/latest/user-data"
+
+	if routes := NamespaceAllowRouteRegexp(namespace); routes != "^/latest/user-data" {
+		t.Error("expected namespace's routes, was", routes)
+	}
+	if routes := NamespaceAllowRouteRegexp(nil); routes != "" {
+		t.Error("expected no routes without namespace, was", routes)
+	}
+
+	annotated := testutil.NewPodWithRole("team", "name", "192.168.0.1", "Running", "role")
+	annotated.Annotations[AnnotationAllowRouteRegexpKey] = " ^/latest/meta-data/placement/ "
+	if routes := PodAllowRouteRegexp(annotated); routes != "^/latest/meta-data/placement/" {
+		t.Errorf("expected pod's routes, was %q", routes)
+	}
+
+	pod := testutil.NewPodWithRole("team", "name", "192.168.0.1", "Running", "role")
+	if routes := PodAllowRouteRegexp(pod); routes != "" {
+		t.Error("expected no routes without pod annotation, was", routes)
+	}
+}
+
+func TestPodAnnotationOverridesNamespaceRegion(t *testing.T) {
+	namespace := testutil.NewNamespace("team", ".*")
+	namespace.Annotations[AnnotationRegionKey] = "eu-west-1"
+
+	pod := testutil.NewPodWithRole("team", "name", "192.168.0.1", "Running", "role")
+	if region := PodRegion(pod, namespace); region != "eu-west-1" {
+		t.Error("expected namespace's region, was", region)
+	}
+
+	pod.Annotations[AnnotationRegionKey] = "us-east-1"
+	if region := PodRegion(pod, namespace); region != "us-east-1" {
+		t.Error("expected pod's region, was", region)
+	}
+}
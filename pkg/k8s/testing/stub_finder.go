@@ -15,6 +15,8 @@ package testing
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
 
 	"github.com/uswitch/kiam/pkg/aws/sts"
 	"github.com/uswitch/kiam/pkg/k8s"
@@ -38,11 +40,31 @@ func (f *StubFinder) GetPodByIP(ip string) (*v1.Pod, error) {
 }
 
 type stubAnnouncer struct {
-	pods chan *v1.Pod
+	pods     chan *v1.Pod
+	released chan *sts.RoleIdentity
+	inactive int32
+
+	mu     sync.Mutex
+	cached []*v1.Pod
 }
 
 func NewStubAnnouncer() *stubAnnouncer {
-	return &stubAnnouncer{pods: make(chan *v1.Pod)}
+	return &stubAnnouncer{pods: make(chan *v1.Pod), released: make(chan *sts.RoleIdentity)}
+}
+
+// Release announces the identity as released, with active determining whether
+// pods are still using it.
+func (f *stubAnnouncer) Release(identity *sts.RoleIdentity, active bool) {
+	var inactive int32
+	if !active {
+		inactive = 1
+	}
+	atomic.StoreInt32(&f.inactive, inactive)
+	f.released <- identity
+}
+
+func (f *stubAnnouncer) Released() <-chan *sts.RoleIdentity {
+	return f.released
 }
 
 func (f *stubAnnouncer) Announce(pod *v1.Pod) {
@@ -53,8 +75,21 @@ func (f *stubAnnouncer) Pods() <-chan *v1.Pod {
 	return f.pods
 }
 
+// Cache adds the pod to those returned by ListPods, without announcing it.
+func (f *stubAnnouncer) Cache(pod *v1.Pod) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cached = append(f.cached, pod)
+}
+
+func (f *stubAnnouncer) ListPods() ([]*v1.Pod, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*v1.Pod(nil), f.cached...), nil
+}
+
 func (f *stubAnnouncer) IsActivePodsForRole(identity *sts.RoleIdentity) (bool, error) {
-	return true, nil
+	return atomic.LoadInt32(&f.inactive) == 0, nil
 }
 
 type stubNSFinder struct {
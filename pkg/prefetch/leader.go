@@ -0,0 +1,108 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package prefetch
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// LeaderElectionConfig controls the Lease used to elect the server replica
+// that prefetches credentials.
+type LeaderElectionConfig struct {
+	Enabled       bool
+	Namespace     string
+	LeaseName     string
+	Identity      string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// RunElected starts the manager's processes but only prefetches and refreshes
+// credentials while this replica holds the Lease. Followers continue to issue
+// credentials on demand and evict released credentials; when elected a
+// replica resyncs all cached pods so their credentials are prefetched. The
+// Lease is released when ctx is cancelled so another replica can take over
+// without waiting for it to expire.
+func (m *CredentialManager) RunElected(ctx context.Context, client kubernetes.Interface, config LeaderElectionConfig, parallelRoutines int) error {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Namespace: config.Namespace,
+			Name:      config.LeaseName,
+		},
+		Client:     client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: config.Identity},
+	}
+
+	logger := log.WithFields(log.Fields{
+		"prefetch.lease":    config.LeaseName,
+		"prefetch.identity": config.Identity,
+	})
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		Name:            config.LeaseName,
+		LeaseDuration:   config.LeaseDuration,
+		RenewDeadline:   config.RenewDeadline,
+		RetryPeriod:     config.RetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				logger.Infof("started leading, prefetching credentials")
+				m.setLeading(true)
+				if err := m.resync(leaderCtx); err != nil {
+					logger.Errorf("error resyncing pods to prefetch: %s", err.Error())
+				}
+				<-leaderCtx.Done()
+				m.setLeading(false)
+			},
+			OnStoppedLeading: func() {
+				if m.setLeading(false) {
+					logger.Infof("stopped leading, no longer prefetching credentials")
+				}
+			},
+			OnNewLeader: func(identity string) {
+				logger.WithField("prefetch.leader", identity).Infof("observed prefetch leader")
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	m.run(ctx, parallelRoutines)
+
+	go func() {
+		for {
+			// Run returns when ctx is cancelled or the lease is lost, in which
+			// case we rejoin the election as a follower.
+			elector.Run(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+		}
+	}()
+
+	return nil
+}
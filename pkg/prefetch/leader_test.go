@@ -0,0 +1,147 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package prefetch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/uswitch/kiam/pkg/aws/sts"
+	kt "github.com/uswitch/kiam/pkg/k8s/testing"
+	"github.com/uswitch/kiam/pkg/testutil"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testLeaderElectionConfig(identity string) LeaderElectionConfig {
+	return LeaderElectionConfig{
+		Enabled:       true,
+		Namespace:     "kube-system",
+		LeaseName:     "kiam-server-prefetch",
+		Identity:      identity,
+		LeaseDuration: time.Minute,
+		RenewDeadline: 500 * time.Millisecond,
+		RetryPeriod:   100 * time.Millisecond,
+	}
+}
+
+func TestElectedManagerPrefetchesWhenLeading(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	requestedRoles := make(chan string, 1)
+	announcer := kt.NewStubAnnouncer()
+	cache := testutil.NewStubCredentialsCache(func(identity *sts.RoleIdentity) (*sts.Credentials, error) {
+		requestedRoles <- identity.Role.Name
+		return &sts.Credentials{}, nil
+	})
+	manager := NewManager(cache, announcer, sts.DefaultResolver("prefix"))
+
+	err := manager.RunElected(ctx, fake.NewSimpleClientset(), testLeaderElectionConfig("server-1"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.After(5 * time.Second)
+	for !manager.IsLeading() {
+		select {
+		case <-deadline:
+			t.Fatal("expected to acquire the lease")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	announcer.Announce(testutil.NewPodWithRole("ns", "name", "ip", "Running", "role"))
+	select {
+	case role := <-requestedRoles:
+		if role != "role" {
+			t.Error("should have requested role, was", role)
+		}
+	case <-time.After(time.Second):
+		t.Error("leader should have prefetched credentials")
+	}
+}
+
+func TestElectedManagerDoesNotPrefetchAsFollower(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	holder := "server-2"
+	leaseDuration := int32(60)
+	now := metav1.NewMicroTime(time.Now())
+	client := fake.NewSimpleClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "kiam-server-prefetch"},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &leaseDuration,
+			AcquireTime:          &now,
+			RenewTime:            &now,
+		},
+	})
+
+	requestedRoles := make(chan string, 1)
+	announcer := kt.NewStubAnnouncer()
+	cache := testutil.NewStubCredentialsCache(func(identity *sts.RoleIdentity) (*sts.Credentials, error) {
+		requestedRoles <- identity.Role.Name
+		return &sts.Credentials{}, nil
+	})
+	manager := NewManager(cache, announcer, sts.DefaultResolver("prefix"))
+
+	err := manager.RunElected(ctx, client, testLeaderElectionConfig("server-1"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	announcer.Announce(testutil.NewPodWithRole("ns", "name", "ip", "Running", "role"))
+	select {
+	case role := <-requestedRoles:
+		t.Error("follower shouldn't prefetch credentials, but requested", role)
+	case <-time.After(time.Second):
+	}
+
+	if manager.IsLeading() {
+		t.Error("follower shouldn't be leading")
+	}
+}
+
+func TestElectedManagerPrefetchesCachedPodsWhenElected(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	requestedRoles := make(chan string, 1)
+	announcer := kt.NewStubAnnouncer()
+	// announced before the manager was elected
+	announcer.Cache(testutil.NewPodWithRole("ns", "name", "ip", "Running", "role"))
+	cache := testutil.NewStubCredentialsCache(func(identity *sts.RoleIdentity) (*sts.Credentials, error) {
+		requestedRoles <- identity.Role.Name
+		return &sts.Credentials{}, nil
+	})
+	manager := NewManager(cache, announcer, sts.DefaultResolver("prefix"))
+
+	err := manager.RunElected(ctx, fake.NewSimpleClientset(), testLeaderElectionConfig("server-1"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case role := <-requestedRoles:
+		if role != "role" {
+			t.Error("should have requested role, was", role)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("elected leader should have prefetched credentials for cached pods")
+	}
+}
@@ -15,93 +15,222 @@ package prefetch
 
 import (
 	"context"
+	"sync/atomic"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/uswitch/kiam/pkg/aws/sts"
 	"github.com/uswitch/kiam/pkg/k8s"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/workqueue"
 )
 
 // CredentialManager watches for Pod changes and prefetches credentials. For any
 // expiring credentials it checks whether pods are still active and requests new
-// ones.
+// ones. Credentials for roles that are released and no longer used by any active
+// pods are evicted.
+//
+// Announced, expiring and released identities are added to a rate-limited
+// workqueue, so that an identity is only processed by one routine at a time
+// and failed requests are retried with exponential backoff while the role
+// remains active.
 type CredentialManager struct {
 	cache       sts.CredentialsCache // where it stores credentials
 	announcer   k8s.PodAnnouncer     // to understand which pods are running
 	arnResolver sts.ARNResolver      // to convert from role names to fully qualified names
+	leading     int32                // non-zero when this manager should prefetch credentials
+	filter      PodFilter            // optionally restricts which pods are prefetched
+	defaults    *k8s.DefaultRoles    // roles of pods without a role annotation
+	// identities waiting to be processed
+	queue workqueue.RateLimitingInterface
 }
 
+// PodFilter decides whether credentials should be prefetched for one of a
+// pod's roles.
+type PodFilter func(ctx context.Context, pod *v1.Pod, role string) (bool, error)
+
 func NewManager(cache sts.CredentialsCache, announcer k8s.PodAnnouncer, resolver sts.ARNResolver) *CredentialManager {
 	return &CredentialManager{cache: cache, announcer: announcer, arnResolver: resolver}
 }
 
-func (m *CredentialManager) fetchCredentials(ctx context.Context, pod *v1.Pod) {
+// WithPodFilter only prefetches credentials for pods the filter allows.
+func (m *CredentialManager) WithPodFilter(filter PodFilter) *CredentialManager {
+	m.filter = filter
+	return m
+}
+
+// WithDefaultRoles prefetches credentials for pods without a role annotation
+// using their default role.
+func (m *CredentialManager) WithDefaultRoles(defaults *k8s.DefaultRoles) *CredentialManager {
+	m.defaults = defaults
+	return m
+}
+
+// IsLeading returns whether the manager is currently prefetching credentials.
+// Managers started with Run always lead; with RunElected only while the Lease is held.
+func (m *CredentialManager) IsLeading() bool {
+	return atomic.LoadInt32(&m.leading) != 0
+}
+
+// setLeading updates the leadership state, returning whether it changed.
+func (m *CredentialManager) setLeading(leading bool) bool {
+	var val int32
+	if leading {
+		val = 1
+	}
+	leader.Set(float64(val))
+	return atomic.SwapInt32(&m.leading, val) != val
+}
+
+func (m *CredentialManager) announced(ctx context.Context, pod *v1.Pod) {
 	logger := log.WithFields(k8s.PodFields(pod))
 	if k8s.IsPodCompleted(pod) {
 		logger.Debugf("ignoring fetch credentials for completed pod")
 		return
 	}
 
-	role := k8s.PodRole(pod)
-	sessionName := k8s.PodSessionName(pod)
-	externalID := k8s.PodExternalID(pod)
-
-	identity, err := sts.NewRoleIdentity(m.arnResolver, role, sessionName, externalID)
+	// credentials are prefetched for each of the pod's roles
+	roles := m.defaults.PodRoles(pod)
+	identities, err := m.defaults.PodRoleIdentities(m.arnResolver, pod)
 	if err != nil {
 		logger.Errorf("error creating role identity: %s", err.Error())
 		return
 	}
 
-	issued, err := m.fetchCredentialsFromCache(ctx, identity)
-	if err != nil {
-		logger.Errorf("error warming credentials: %s", err.Error())
-	} else {
-		logger.WithFields(sts.CredentialsFields(identity, issued)).Infof("fetched credentials")
+	for i, identity := range identities {
+		if m.filter != nil {
+			allowed, err := m.filter(ctx, pod, roles[i])
+			if err != nil {
+				logger.Errorf("error checking whether to prefetch credentials: %s", err.Error())
+				continue
+			}
+			if !allowed {
+				logger.WithField("pod.iam.role", roles[i]).Debugf("ignoring fetch credentials for pod not permitted its role")
+				continue
+			}
+		}
+
+		m.enqueue(identity)
 	}
 }
 
+// enqueue adds the identity to the workqueue. Identities already waiting to be
+// processed are only processed once.
+func (m *CredentialManager) enqueue(identity *sts.RoleIdentity) {
+	m.queue.Add(*identity)
+}
+
 func (m *CredentialManager) fetchCredentialsFromCache(ctx context.Context, identity *sts.RoleIdentity) (*sts.Credentials, error) {
 	return m.cache.CredentialsForRole(ctx, identity)
 }
 
+// resync announces all cached pods, so that a newly elected leader prefetches
+// credentials for pods announced while it was following.
+func (m *CredentialManager) resync(ctx context.Context) error {
+	pods, err := m.announcer.ListPods()
+	if err != nil {
+		return err
+	}
+
+	for _, pod := range pods {
+		m.announced(ctx, pod)
+	}
+	return nil
+}
+
+// Run starts the manager's processes: one watching for announced, expiring
+// and released identities and parallelRoutines processing them. They stop
+// when ctx is cancelled.
 func (m *CredentialManager) Run(ctx context.Context, parallelRoutines int) {
+	m.setLeading(true)
+	m.run(ctx, parallelRoutines)
+}
+
+func (m *CredentialManager) run(ctx context.Context, parallelRoutines int) {
+	m.queue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "prefetch")
+	go func() {
+		<-ctx.Done()
+		m.queue.ShutDown()
+	}()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case pod := <-m.announcer.Pods():
+				m.announced(ctx, pod)
+			case identity := <-m.announcer.Released():
+				m.enqueue(identity)
+			case expiring := <-m.cache.Expiring():
+				m.enqueue(expiring.Identity)
+			}
+		}
+	}()
+
 	for i := 0; i < parallelRoutines; i++ {
 		log.Infof("starting credential manager process %d", i)
 		go func(id int) {
-			for {
-				select {
-				case <-ctx.Done():
-					log.Infof("stopping credential manager process %d", id)
-					return
-				case pod := <-m.announcer.Pods():
-					m.fetchCredentials(ctx, pod)
-				case expiring := <-m.cache.Expiring():
-					m.handleExpiring(ctx, expiring)
-				}
+			for m.processNext(ctx) {
 			}
+			log.Infof("stopping credential manager process %d", id)
 		}(i)
 	}
 }
 
-func (m *CredentialManager) handleExpiring(ctx context.Context, credentials *sts.CachedCredentials) {
-	logger := log.WithFields(sts.CredentialsFields(credentials.Identity, credentials.Credentials))
+// processNext handles the next identity from the queue, returning false once
+// the queue has been shut down.
+func (m *CredentialManager) processNext(ctx context.Context) bool {
+	item, shutdown := m.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer m.queue.Done(item)
 
-	active, err := m.IsRoleActive(credentials.Identity)
+	identity := item.(sts.RoleIdentity)
+
+	err := m.reconcile(ctx, &identity)
+	if err == nil {
+		m.queue.Forget(item)
+		return true
+	}
+
+	retries.Inc()
+	log.WithFields(identity.LogFields()).WithField("prefetch.retries", m.queue.NumRequeues(item)).Errorf("error prefetching credentials, will retry: %s", err.Error())
+	m.queue.AddRateLimited(item)
+	return true
+}
+
+// reconcile evicts credentials for identities no longer used by active pods.
+// While leading it requests credentials for active identities, refreshing any
+// that are expiring.
+func (m *CredentialManager) reconcile(ctx context.Context, identity *sts.RoleIdentity) error {
+	logger := log.WithFields(identity.LogFields())
+
+	active, err := m.IsRoleActive(identity)
 	if err != nil {
-		logger.Errorf("error checking whether role active: %s", err.Error())
-		return
+		return err
 	}
 
 	if !active {
-		logger.Infof("role no longer active")
-		return
+		if m.cache.Evict(identity) {
+			logger.Infof("role no longer active, evicted credentials")
+		}
+		return nil
+	}
+
+	// Each server maintains its own cache so eviction happens whether or not
+	// it's leading, only the leader prefetches.
+	if !m.IsLeading() {
+		return nil
 	}
 
-	logger.Infof("expiring credentials, fetching updated")
-	_, err = m.fetchCredentialsFromCache(ctx, credentials.Identity)
+	issued, err := m.fetchCredentialsFromCache(ctx, identity)
 	if err != nil {
-		logger.Errorf("error fetching updated credentials for expiring: %s", err.Error())
+		return err
 	}
+
+	logger.WithFields(sts.CredentialsFields(identity, issued)).Infof("fetched credentials")
+	return nil
 }
 
 func (m *CredentialManager) IsRoleActive(identity *sts.RoleIdentity) (bool, error) {
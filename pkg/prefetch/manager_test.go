@@ -15,6 +15,7 @@ package prefetch
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
 
@@ -22,6 +23,7 @@ import (
 	"github.com/uswitch/kiam/pkg/aws/sts"
 	kt "github.com/uswitch/kiam/pkg/k8s/testing"
 	"github.com/uswitch/kiam/pkg/testutil"
+	v1 "k8s.io/api/core/v1"
 )
 
 func TestPrefetchRunningPods(t *testing.T) {
@@ -54,6 +56,36 @@ func TestPrefetchRunningPods(t *testing.T) {
 	}
 }
 
+func TestPrefetchesAllPodRoles(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	requestedRoles := make(chan string, 2)
+	announcer := kt.NewStubAnnouncer()
+	cache := testutil.NewStubCredentialsCache(func(identity *sts.RoleIdentity) (*sts.Credentials, error) {
+		requestedRoles <- identity.Role.Name
+		return &sts.Credentials{}, nil
+	})
+	manager := NewManager(cache, announcer, sts.DefaultResolver("prefix"))
+	go manager.Run(ctx, 1)
+
+	announcer.Announce(testutil.NewPodWithRoles("ns", "name", "ip", "Running", "reader", "writer"))
+	requested := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case role := <-requestedRoles:
+			requested[role] = true
+		case <-time.After(time.Second):
+			t.Fatal("expected credentials for all the pod's roles")
+		}
+	}
+	if !requested["reader"] || !requested["writer"] {
+		t.Error("expected all roles requested, was", requested)
+	}
+}
+
 func TestRenewsCredentialsForRunningPod(t *testing.T) {
 	defer leaktest.Check(t)()
 
@@ -128,3 +160,92 @@ func TestPodExternalID(t *testing.T) {
 		t.Error("should have requested external-id")
 	}
 }
+
+func TestEvictsReleasedRoleWithoutActivePods(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	announcer := kt.NewStubAnnouncer()
+	cache := testutil.NewStubCredentialsCache(func(identity *sts.RoleIdentity) (*sts.Credentials, error) {
+		return &sts.Credentials{}, nil
+	})
+	resolver := sts.DefaultResolver("prefix")
+	manager := NewManager(cache, announcer, resolver)
+	go manager.Run(ctx, 1)
+
+	identity, _ := sts.NewRoleIdentity(resolver, "role", "", "")
+	announcer.Release(identity, true)
+	select {
+	case evicted := <-cache.Evicted():
+		t.Error("didn't expect role still in use to be evicted, but was", evicted)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	announcer.Release(identity, false)
+	select {
+	case evicted := <-cache.Evicted():
+		if evicted.String() != identity.String() {
+			t.Error("evicted wrong identity", evicted)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected released role to be evicted")
+	}
+}
+
+func TestDoesNotPrefetchFilteredPods(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	requestedRoles := make(chan string, 1)
+	announcer := kt.NewStubAnnouncer()
+	cache := testutil.NewStubCredentialsCache(func(identity *sts.RoleIdentity) (*sts.Credentials, error) {
+		requestedRoles <- identity.Role.Name
+		return &sts.Credentials{}, nil
+	})
+	filter := func(ctx context.Context, pod *v1.Pod, role string) (bool, error) {
+		return role != "forbidden_role", nil
+	}
+	manager := NewManager(cache, announcer, sts.DefaultResolver("prefix")).WithPodFilter(filter)
+	go manager.Run(ctx, 1)
+
+	announcer.Announce(testutil.NewPodWithRole("ns", "name", "ip", "Running", "forbidden_role"))
+	announcer.Announce(testutil.NewPodWithRole("ns", "name", "ip", "Running", "role"))
+
+	role := <-requestedRoles
+	if role != "role" {
+		t.Error("should only have requested role, was", role)
+	}
+}
+
+func TestRetriesFailedPrefetch(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	attempts := make(chan error, 3)
+	attempts <- fmt.Errorf("throttled")
+	attempts <- fmt.Errorf("throttled")
+	attempts <- nil
+	requested := make(chan string, 3)
+	cache := testutil.NewStubCredentialsCache(func(identity *sts.RoleIdentity) (*sts.Credentials, error) {
+		requested <- identity.Role.Name
+		return &sts.Credentials{}, <-attempts
+	})
+	announcer := kt.NewStubAnnouncer()
+	manager := NewManager(cache, announcer, sts.DefaultResolver("prefix"))
+	go manager.Run(ctx, 1)
+
+	announcer.Announce(testutil.NewPodWithRole("ns", "name", "ip", "Running", "role"))
+	for i := 0; i < 3; i++ {
+		select {
+		case <-requested:
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d requests, was %d", 3, i)
+		}
+	}
+}
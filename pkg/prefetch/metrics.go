@@ -0,0 +1,42 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package prefetch
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	leader = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "kiam",
+			Subsystem: "prefetch",
+			Name:      "leader",
+			Help:      "Whether this server is currently prefetching credentials (1) or only fetching them on demand (0)",
+		},
+	)
+	retries = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "kiam",
+			Subsystem: "prefetch",
+			Name:      "retries_total",
+			Help:      "Number of times prefetching credentials failed and was retried with backoff",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(leader)
+	prometheus.MustRegister(retries)
+}
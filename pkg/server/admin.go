@@ -0,0 +1,159 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/uswitch/kiam/pkg/aws/sts"
+	"github.com/uswitch/kiam/pkg/k8s"
+	pb "github.com/uswitch/kiam/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AdminServer lets operators inspect and invalidate the server's credentials
+// cache. Requests must be made with a client certificate whose name is one of
+// the configured admin identities.
+type AdminServer struct {
+	identities  []string
+	credentials sts.CredentialsCacheAdmin
+	pods        k8s.PodLister
+	arnResolver sts.ARNResolver
+	defaults    *k8s.DefaultRoles
+}
+
+func NewAdminServer(identities []string, credentials sts.CredentialsCacheAdmin, pods k8s.PodLister, arnResolver sts.ARNResolver) *AdminServer {
+	return &AdminServer{
+		identities:  identities,
+		credentials: credentials,
+		pods:        pods,
+		arnResolver: arnResolver,
+	}
+}
+
+// WithDefaultRoles evicts the default role of pods without a role annotation
+// when evicting a namespace.
+func (a *AdminServer) WithDefaultRoles(defaults *k8s.DefaultRoles) *AdminServer {
+	a.defaults = defaults
+	return a
+}
+
+func (a *AdminServer) authorize(ctx context.Context) error {
+	permitted, err := peerHasName(ctx, a.identities)
+	if err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	if !permitted {
+		return status.Error(codes.PermissionDenied, "client certificate isn't an admin identity")
+	}
+	return nil
+}
+
+// ListCachedCredentials returns the identities the server holds credentials for.
+func (a *AdminServer) ListCachedCredentials(ctx context.Context, _ *pb.ListCachedCredentialsRequest) (*pb.CachedCredentialsList, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	cached := a.credentials.List()
+	list := &pb.CachedCredentialsList{Credentials: make([]*pb.CachedCredentials, 0, len(cached))}
+	for _, c := range cached {
+		list.Credentials = append(list.Credentials, &pb.CachedCredentials{
+			RoleArn:     c.Identity.Role.ARN,
+			SessionName: c.SessionName,
+			Expiration:  c.Credentials.Expiration,
+			LastUpdated: c.Credentials.LastUpdated,
+		})
+	}
+
+	return list, nil
+}
+
+// EvictCachedCredentials removes credentials for a role, for the Pods in a
+// namespace, or everything, forcing them to be requested from STS again.
+func (a *AdminServer) EvictCachedCredentials(ctx context.Context, req *pb.EvictCachedCredentialsRequest) (*pb.EvictCachedCredentialsResult, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	selectors := 0
+	for _, set := range []bool{req.Role != "", req.Namespace != "", req.All} {
+		if set {
+			selectors++
+		}
+	}
+	if selectors != 1 {
+		return nil, status.Error(codes.InvalidArgument, "exactly one of role, namespace or all must be specified")
+	}
+
+	identities, err := a.identitiesToEvict(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var evicted int32
+	for _, identity := range identities {
+		if a.credentials.Evict(identity) {
+			evicted++
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"admin.role":      req.Role,
+		"admin.namespace": req.Namespace,
+		"admin.all":       req.All,
+		"admin.evicted":   evicted,
+	}).Infof("evicted cached credentials")
+
+	return &pb.EvictCachedCredentialsResult{Evicted: evicted}, nil
+}
+
+func (a *AdminServer) identitiesToEvict(req *pb.EvictCachedCredentialsRequest) ([]*sts.RoleIdentity, error) {
+	identities := make([]*sts.RoleIdentity, 0)
+
+	if req.Namespace != "" {
+		pods, err := a.pods.ListPodsInNamespace(req.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range pods {
+			podIdentities, err := a.defaults.PodRoleIdentities(a.arnResolver, pod)
+			if err != nil {
+				return nil, err
+			}
+			identities = append(identities, podIdentities...)
+		}
+		return identities, nil
+	}
+
+	var role *sts.ResolvedRole
+	if req.Role != "" {
+		var err error
+		role, err = a.arnResolver.Resolve(req.Role)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	for _, cached := range a.credentials.List() {
+		if role == nil || cached.Identity.Role.Equals(role) {
+			identities = append(identities, cached.Identity)
+		}
+	}
+
+	return identities, nil
+}
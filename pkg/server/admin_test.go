@@ -0,0 +1,131 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+
+	"github.com/uswitch/kiam/pkg/aws/sts"
+	"github.com/uswitch/kiam/pkg/testutil"
+	pb "github.com/uswitch/kiam/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+)
+
+func contextWithPeerCertificate(cert *x509.Certificate) context.Context {
+	info := credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}}
+	return peer.NewContext(context.Background(), &peer.Peer{AuthInfo: info})
+}
+
+type stubCacheAdmin struct {
+	cached  []*sts.CachedCredentials
+	evicted []*sts.RoleIdentity
+}
+
+func (s *stubCacheAdmin) List() []*sts.CachedCredentials {
+	return s.cached
+}
+
+func (s *stubCacheAdmin) Evict(identity *sts.RoleIdentity) bool {
+	s.evicted = append(s.evicted, identity)
+	return true
+}
+
+type stubPodLister struct {
+	pods []*v1.Pod
+}
+
+func (s *stubPodLister) ListPodsInNamespace(namespace string) ([]*v1.Pod, error) {
+	return s.pods, nil
+}
+
+func newTestAdminServer() (*AdminServer, *stubCacheAdmin) {
+	resolver := sts.DefaultResolver("arn:aws:iam::123456789012:role/")
+	identity := func(role string) *sts.RoleIdentity {
+		i, _ := sts.NewRoleIdentity(resolver, role, "", "")
+		return i
+	}
+	cache := &stubCacheAdmin{cached: []*sts.CachedCredentials{
+		{Identity: identity("reader"), Credentials: &sts.Credentials{Expiration: "2020-01-01T00:15:00Z"}, SessionName: "kiam-kiam"},
+		{Identity: identity("writer"), Credentials: &sts.Credentials{Expiration: "2020-01-01T00:15:00Z"}, SessionName: "kiam-kiam"},
+	}}
+	pods := &stubPodLister{pods: []*v1.Pod{
+		testutil.NewPodWithRole("ns", "name", "192.168.0.1", testutil.PhaseRunning, "writer"),
+		testutil.NewPod("ns", "unannotated", "192.168.0.2", testutil.PhaseRunning),
+	}}
+
+	return NewAdminServer([]string{"kiam-admin"}, cache, pods, resolver), cache
+}
+
+func TestAdminRequiresAdminIdentity(t *testing.T) {
+	admin, _ := newTestAdminServer()
+
+	_, err := admin.ListCachedCredentials(context.Background(), &pb.ListCachedCredentialsRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Error("expected unauthenticated without a client certificate, was", err)
+	}
+
+	ctx := contextWithPeerCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "Kiam Agent"}})
+	_, err = admin.ListCachedCredentials(ctx, &pb.ListCachedCredentialsRequest{})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Error("expected agent certificate to be denied, was", err)
+	}
+
+	ctx = contextWithPeerCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "Kiam Admin"}, DNSNames: []string{"kiam-admin"}})
+	list, err := admin.ListCachedCredentials(ctx, &pb.ListCachedCredentialsRequest{})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if len(list.Credentials) != 2 {
+		t.Error("expected 2 cached credentials, was", len(list.Credentials))
+	}
+	if list.Credentials[0].RoleArn != "arn:aws:iam::123456789012:role/reader" {
+		t.Error("unexpected role arn, was", list.Credentials[0].RoleArn)
+	}
+}
+
+func TestAdminEvictsCachedCredentials(t *testing.T) {
+	ctx := contextWithPeerCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "kiam-admin"}})
+
+	admin, _ := newTestAdminServer()
+	_, err := admin.EvictCachedCredentials(ctx, &pb.EvictCachedCredentialsRequest{Role: "reader", All: true})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Error("expected invalid argument with multiple selectors, was", err)
+	}
+
+	admin, cache := newTestAdminServer()
+	result, _ := admin.EvictCachedCredentials(ctx, &pb.EvictCachedCredentialsRequest{Role: "reader"})
+	if result.Evicted != 1 || cache.evicted[0].Role.Name != "reader" {
+		t.Error("expected reader to be evicted, was", cache.evicted)
+	}
+
+	admin, cache = newTestAdminServer()
+	result, _ = admin.EvictCachedCredentials(ctx, &pb.EvictCachedCredentialsRequest{Namespace: "ns"})
+	if result.Evicted != 1 || cache.evicted[0].Role.Name != "writer" {
+		t.Error("expected writer to be evicted, was", cache.evicted)
+	}
+
+	admin, _ = newTestAdminServer()
+	result, _ = admin.EvictCachedCredentials(ctx, &pb.EvictCachedCredentialsRequest{All: true})
+	if result.Evicted != 2 {
+		t.Error("expected everything to be evicted, was", result.Evicted)
+	}
+}
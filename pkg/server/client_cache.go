@@ -0,0 +1,438 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	log "github.com/sirupsen/logrus"
+	"github.com/uswitch/kiam/pkg/aws/sts"
+	"github.com/uswitch/kiam/pkg/future"
+)
+
+const (
+	cacheTypeRole        = "role"
+	cacheTypeCredentials = "credentials"
+
+	credentialsTimeLayout = "2006-01-02T15:04:05Z"
+
+	// clientCachePurgeInterval is how often entries that can no longer be
+	// used are removed
+	clientCachePurgeInterval = time.Minute
+)
+
+// ClientCacheConfig controls how long the agent caches responses from the
+// server.
+type ClientCacheConfig struct {
+	// RoleTTL is how long a pod's role is used before it's requested again.
+	RoleTTL time.Duration
+	// RoleMaxStale is how much longer a pod's role is used while the server
+	// can't be reached.
+	RoleMaxStale time.Duration
+	// CredentialsRefresh is how soon before their expiry credentials are
+	// requested again. Expired credentials are never used.
+	CredentialsRefresh time.Duration
+	// RequestTimeout bounds requests to the server, which are shared by all
+	// callers waiting on them.
+	RequestTimeout time.Duration
+}
+
+// DefaultClientCacheConfig returns the configuration used by the agent unless
+// overridden.
+func DefaultClientCacheConfig() ClientCacheConfig {
+	return ClientCacheConfig{
+		RoleTTL:            10 * time.Second,
+		RoleMaxStale:       5 * time.Minute,
+		CredentialsRefresh: 2 * time.Minute,
+		RequestTimeout:     5 * time.Second,
+	}
+}
+
+type clientCacheKey struct {
+	kind   string
+	ip     string
+	podUID string
+	role   string
+}
+
+type clientCacheEntry struct {
+	roles       *PodRoles
+	credentials *sts.Credentials
+	refresh     time.Time // when the entry should be requested again
+	expires     time.Time // when the entry can no longer be used
+
+	// watched roles are kept up to date by the server while the node is
+	// watched, and don't need to be requested
+	watched    bool
+	generation int
+}
+
+// CachingClient is a Client that caches roles and credentials by pod IP, and
+// UID for hostNetwork pods identified by it, coalescing identical concurrent
+// requests into a single request to the server. Cached entries continue to be
+// used while the server can't be reached, until they expire. Construct with
+// NewCachingClient.
+//
+// With WatchNode the server sends the roles and credentials of the node's
+// pods as they change, so that requests are answered without calling the
+// server.
+type CachingClient struct {
+	client Client
+	config ClientCacheConfig
+	now    func() time.Time
+
+	mu        sync.Mutex
+	entries   map[clientCacheKey]*clientCacheEntry
+	inflight  map[clientCacheKey]*future.Future
+	lastPurge time.Time
+}
+
+// NewCachingClient wraps the client with a cache.
+func NewCachingClient(client Client, config ClientCacheConfig) *CachingClient {
+	return &CachingClient{
+		client:   client,
+		config:   config,
+		now:      time.Now,
+		entries:  make(map[clientCacheKey]*clientCacheEntry),
+		inflight: make(map[clientCacheKey]*future.Future),
+	}
+}
+
+// GetRoles returns the cached roles for the pod, requesting them from the
+// server when they're missing or due to be refreshed.
+func (c *CachingClient) GetRoles(ctx context.Context, ip string) (*PodRoles, error) {
+	key := clientCacheKey{kind: cacheTypeRole, ip: ip, podUID: PodUIDFromContext(ctx)}
+	entry, err := c.get(ctx, key, func(ctx context.Context) (*clientCacheEntry, error) {
+		roles, err := c.client.GetRoles(ctx, ip)
+		if err != nil {
+			return nil, err
+		}
+		now := c.now()
+		return &clientCacheEntry{
+			roles:   roles,
+			refresh: now.Add(c.config.RoleTTL),
+			expires: now.Add(c.config.RoleTTL + c.config.RoleMaxStale),
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry.roles, nil
+}
+
+// GetCredentials returns the cached credentials for the pod and role, requesting
+// them from the server when they're missing or close to expiry.
+func (c *CachingClient) GetCredentials(ctx context.Context, ip, role string) (*sts.Credentials, error) {
+	key := clientCacheKey{kind: cacheTypeCredentials, ip: ip, podUID: PodUIDFromContext(ctx), role: role}
+	entry, err := c.get(ctx, key, func(ctx context.Context) (*clientCacheEntry, error) {
+		credentials, err := c.client.GetCredentials(ctx, ip, role)
+		if err != nil {
+			return nil, err
+		}
+
+		// credentials without a valid expiry are returned but not cached
+		expires, err := time.Parse(credentialsTimeLayout, credentials.Expiration)
+		if err != nil {
+			log.WithField("pod.ip", ip).Warnf("not caching credentials, error parsing expiration: %s", err.Error())
+			expires = c.now()
+		}
+		return &clientCacheEntry{
+			credentials: credentials,
+			refresh:     expires.Add(-c.config.CredentialsRefresh),
+			expires:     expires,
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry.credentials, nil
+}
+
+// Health is always requested from the server.
+func (c *CachingClient) Health(ctx context.Context) (string, error) {
+	return c.client.Health(ctx)
+}
+
+// Invalidate removes all cached entries for the pod IP, for example once the
+// pod is deleted and its IP could be reused.
+func (c *CachingClient) Invalidate(ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.invalidate(ip)
+	log.WithField("pod.ip", ip).Debugf("invalidated cached roles and credentials")
+}
+
+// invalidate must be called with the mutex held.
+func (c *CachingClient) invalidate(ip string) {
+	for key := range c.entries {
+		if key.ip == ip {
+			delete(c.entries, key)
+		}
+	}
+	// in-flight requests complete for their callers but aren't cached
+	for key := range c.inflight {
+		if key.ip == ip {
+			delete(c.inflight, key)
+		}
+	}
+
+	clientCacheInvalidated.Inc()
+}
+
+// invalidateCredentials removes the cached credentials for the pod IP's roles
+// other than those sent. Must be called with the mutex held.
+func (c *CachingClient) invalidateCredentials(ip string, sent map[string]*sts.Credentials) {
+	for key := range c.entries {
+		if key.ip == ip && key.kind == cacheTypeCredentials && sent[key.role] == nil {
+			delete(c.entries, key)
+		}
+	}
+	for key := range c.inflight {
+		if key.ip == ip && key.kind == cacheTypeCredentials && sent[key.role] == nil {
+			delete(c.inflight, key)
+		}
+	}
+}
+
+// WatchNode receives the roles and credentials of the node's pods from the
+// server until the context is cancelled, reconnecting with backoff when the
+// stream fails.
+func (c *CachingClient) WatchNode(ctx context.Context, watcher NodeCredentialsWatcher, nodeName string) {
+	logger := log.WithField("node.name", nodeName)
+
+	strategy := backoff.NewExponentialBackOff()
+	strategy.MaxElapsedTime = 0
+
+	for generation := 1; ; generation++ {
+		err := watcher.WatchNodeCredentials(ctx, nodeName, func(update *NodeCredentialsUpdate) {
+			if update.Synced {
+				strategy.Reset()
+				logger.Infof("watching node credentials")
+			}
+			c.apply(generation, update)
+		})
+		c.unwatch()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		wait := strategy.NextBackOff()
+		logger.Warnf("error watching node credentials, retrying in %s: %v", wait, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// apply caches an update sent by the server while watching the node.
+func (c *CachingClient) apply(generation int, update *NodeCredentialsUpdate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// roles not sent again since the stream (re)connected have gone
+	if update.Synced {
+		for key, entry := range c.entries {
+			if entry.watched && entry.generation != generation {
+				c.invalidate(key.ip)
+			}
+		}
+		return
+	}
+
+	roles := &PodRoles{
+		Roles:     podRoles(update.Role, update.Roles),
+		ARN:       update.RoleARN,
+		Overrides: update.Overrides,
+	}
+
+	roleKey := clientCacheKey{kind: cacheTypeRole, ip: update.IP}
+	if entry, found := c.entries[roleKey]; update.Removed || (found && !equalStrings(entry.roles.Roles, roles.Roles)) {
+		c.invalidate(update.IP)
+	}
+	if update.Removed {
+		return
+	}
+
+	c.entries[roleKey] = &clientCacheEntry{roles: roles, watched: true, generation: generation}
+	delete(c.inflight, roleKey)
+
+	// credentials the server no longer sends, for example once the
+	// namespace's policy forbids the role, are requested from it again
+	sent := make(map[string]*sts.Credentials, len(update.RoleCredentials)+1)
+	for role, credentials := range update.RoleCredentials {
+		sent[role] = credentials
+	}
+	if update.Credentials != nil {
+		sent[update.Role] = update.Credentials
+	}
+	c.invalidateCredentials(update.IP, sent)
+
+	for role, credentials := range sent {
+		expires, err := time.Parse(credentialsTimeLayout, credentials.Expiration)
+		if err != nil {
+			log.WithField("pod.ip", update.IP).Warnf("not caching credentials, error parsing expiration: %s", err.Error())
+			continue
+		}
+		credentialsKey := clientCacheKey{kind: cacheTypeCredentials, ip: update.IP, role: role}
+		c.entries[credentialsKey] = &clientCacheEntry{
+			credentials: credentials,
+			refresh:     expires.Add(-c.config.CredentialsRefresh),
+			expires:     expires,
+		}
+		delete(c.inflight, credentialsKey)
+	}
+}
+
+// unwatch stops relying on the server to update watched roles: they're
+// requested again when next used, and used while the server can't be
+// reached for up to RoleMaxStale.
+func (c *CachingClient) unwatch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for key, entry := range c.entries {
+		if entry.watched {
+			c.entries[key] = &clientCacheEntry{
+				roles:   entry.roles,
+				refresh: now,
+				expires: now.Add(c.config.RoleMaxStale),
+			}
+		}
+	}
+}
+
+func (c *CachingClient) get(ctx context.Context, key clientCacheKey, fetch func(context.Context) (*clientCacheEntry, error)) (*clientCacheEntry, error) {
+	c.mu.Lock()
+	entry, found := c.entries[key]
+	if found && !entry.watched && !c.now().Before(entry.expires) {
+		delete(c.entries, key)
+		found = false
+	}
+	if found && (entry.watched || c.now().Before(entry.refresh)) {
+		c.mu.Unlock()
+		clientCacheHit.WithLabelValues(key.kind).Inc()
+		return entry, nil
+	}
+
+	f, inflight := c.inflight[key]
+	if inflight {
+		clientCacheCoalesced.WithLabelValues(key.kind).Inc()
+	} else {
+		clientCacheMiss.WithLabelValues(key.kind).Inc()
+		f = c.request(key, fetch)
+		c.inflight[key] = f
+	}
+	c.mu.Unlock()
+
+	val, err := f.Get(ctx)
+	if err == nil {
+		return val.(*clientCacheEntry), nil
+	}
+	if isAuthoritative(err) || ctx.Err() != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	entry, found = c.entries[key]
+	c.mu.Unlock()
+	if found && c.now().Before(entry.expires) {
+		clientCacheStale.WithLabelValues(key.kind).Inc()
+		log.WithField("pod.ip", key.ip).Warnf("error requesting %s, using cached: %s", key.kind, err.Error())
+		return entry, nil
+	}
+
+	return nil, err
+}
+
+// request starts fetching the entry, independent of the callers' contexts, and
+// caches the result. Must be called with the mutex held.
+func (c *CachingClient) request(key clientCacheKey, fetch func(context.Context) (*clientCacheEntry, error)) *future.Future {
+	var f *future.Future
+	f = future.New(func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(WithPodUID(context.Background(), key.podUID), c.config.RequestTimeout)
+		defer cancel()
+
+		entry, err := fetch(ctx)
+
+		// the caller holds the mutex until f is assigned and stored
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		current := c.inflight[key] == f
+		if current {
+			delete(c.inflight, key)
+		}
+
+		if err != nil {
+			// the server's answer is authoritative: don't continue using
+			// cached credentials once a pod is forbidden or gone
+			if isAuthoritative(err) {
+				delete(c.entries, key)
+			}
+			return nil, err
+		}
+
+		// invalidated while in-flight, so may be for a previous pod
+		if current {
+			c.purgeExpired()
+			c.entries[key] = entry
+		}
+		return entry, nil
+	})
+	return f
+}
+
+// isAuthoritative returns whether the error is the server's answer, rather
+// than a failure to reach it.
+func isAuthoritative(err error) bool {
+	return err == ErrPolicyForbidden || err == ErrPodNotFound || err == ErrNodeMismatch || err == ErrServiceAccountMismatch
+}
+
+// purgeExpired removes entries that can no longer be used, such as those for
+// pods that were deleted without being invalidated, at most once every
+// clientCachePurgeInterval. Entries are otherwise removed as they're found to
+// have expired. Must be called with the mutex held.
+func (c *CachingClient) purgeExpired() {
+	now := c.now()
+	if now.Sub(c.lastPurge) < clientCachePurgeInterval {
+		return
+	}
+	c.lastPurge = now
+
+	for key, entry := range c.entries {
+		if !entry.watched && !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,448 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/uswitch/kiam/pkg/aws/sts"
+)
+
+type stubServerClient struct {
+	roleCalls        int32
+	credentialsCalls int32
+	getRole          func(ip string) (string, error)
+	getCredentials   func(ip, role string) (*sts.Credentials, error)
+}
+
+func (c *stubServerClient) GetRoles(ctx context.Context, ip string) (*PodRoles, error) {
+	atomic.AddInt32(&c.roleCalls, 1)
+	role, err := c.getRole(ip)
+	if err != nil {
+		return nil, err
+	}
+	return &PodRoles{Roles: podRoles(role, nil)}, nil
+}
+
+func (c *stubServerClient) GetCredentials(ctx context.Context, ip, role string) (*sts.Credentials, error) {
+	atomic.AddInt32(&c.credentialsCalls, 1)
+	return c.getCredentials(ip, role)
+}
+
+func (c *stubServerClient) Health(ctx context.Context) (string, error) {
+	return "ok", nil
+}
+
+// defaultRole returns the pod's default role from the client.
+func defaultRole(ctx context.Context, c Client, ip string) (string, error) {
+	roles, err := c.GetRoles(ctx, ip)
+	if err != nil {
+		return "", err
+	}
+	return roles.Default(), nil
+}
+
+func newTestCachingClient(client Client, now *time.Time) *CachingClient {
+	c := NewCachingClient(client, DefaultClientCacheConfig())
+	c.now = func() time.Time { return *now }
+	return c
+}
+
+func credentialsExpiring(expiry time.Time) *sts.Credentials {
+	return sts.NewCredentials("A1234", "secret", "token", expiry)
+}
+
+func TestCachesCredentialsUntilRefresh(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	client := &stubServerClient{
+		getCredentials: func(ip, role string) (*sts.Credentials, error) {
+			return credentialsExpiring(now.Add(15 * time.Minute)), nil
+		},
+	}
+	c := newTestCachingClient(client, &now)
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.GetCredentials(context.Background(), "10.0.0.1", "role"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if client.credentialsCalls != 1 {
+		t.Error("expected credentials to be cached, requested", client.credentialsCalls)
+	}
+
+	c.GetCredentials(context.Background(), "10.0.0.1", "other")
+	if client.credentialsCalls != 2 {
+		t.Error("expected credentials to be cached by role, requested", client.credentialsCalls)
+	}
+
+	now = now.Add(14 * time.Minute)
+	c.GetCredentials(context.Background(), "10.0.0.1", "role")
+	if client.credentialsCalls != 3 {
+		t.Error("expected credentials to be refreshed before expiry, requested", client.credentialsCalls)
+	}
+}
+
+func TestCoalescesConcurrentRequests(t *testing.T) {
+	release := make(chan struct{})
+	client := &stubServerClient{
+		getRole: func(ip string) (string, error) {
+			<-release
+			return "role", nil
+		},
+	}
+	c := NewCachingClient(client, DefaultClientCacheConfig())
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			role, err := defaultRole(context.Background(), c, "10.0.0.1")
+			if err == nil && role != "role" {
+				err = fmt.Errorf("unexpected role: %s", role)
+			}
+			errs <- err
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if client.roleCalls != 1 {
+		t.Error("expected concurrent requests to be coalesced, requested", client.roleCalls)
+	}
+}
+
+func TestUsesCachedCredentialsWhenServerUnavailable(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	var serverErr error
+	client := &stubServerClient{
+		getCredentials: func(ip, role string) (*sts.Credentials, error) {
+			if serverErr != nil {
+				return nil, serverErr
+			}
+			return credentialsExpiring(now.Add(15 * time.Minute)), nil
+		},
+	}
+	c := newTestCachingClient(client, &now)
+
+	expected, _ := c.GetCredentials(context.Background(), "10.0.0.1", "role")
+
+	serverErr = fmt.Errorf("connection refused")
+	now = now.Add(14 * time.Minute)
+	credentials, err := c.GetCredentials(context.Background(), "10.0.0.1", "role")
+	if err != nil {
+		t.Fatal("expected cached credentials, was", err)
+	}
+	if credentials != expected {
+		t.Error("unexpected credentials", credentials)
+	}
+
+	now = now.Add(time.Minute)
+	_, err = c.GetCredentials(context.Background(), "10.0.0.1", "role")
+	if err != serverErr {
+		t.Error("expected error once credentials expired, was", err)
+	}
+}
+
+func TestDoesNotUseCachedCredentialsWhenForbidden(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	var serverErr error
+	client := &stubServerClient{
+		getCredentials: func(ip, role string) (*sts.Credentials, error) {
+			if serverErr != nil {
+				return nil, serverErr
+			}
+			return credentialsExpiring(now.Add(15 * time.Minute)), nil
+		},
+	}
+	c := newTestCachingClient(client, &now)
+	c.GetCredentials(context.Background(), "10.0.0.1", "role")
+
+	serverErr = ErrPolicyForbidden
+	now = now.Add(14 * time.Minute)
+	_, err := c.GetCredentials(context.Background(), "10.0.0.1", "role")
+	if err != ErrPolicyForbidden {
+		t.Error("expected forbidden, was", err)
+	}
+
+	serverErr = fmt.Errorf("connection refused")
+	_, err = c.GetCredentials(context.Background(), "10.0.0.1", "role")
+	if err != serverErr {
+		t.Error("expected forbidden credentials to have been removed, was", err)
+	}
+}
+
+func TestInvalidatesCachedPod(t *testing.T) {
+	now := time.Now()
+	roles := map[string]string{"10.0.0.1": "first", "10.0.0.2": "other"}
+	client := &stubServerClient{
+		getRole: func(ip string) (string, error) {
+			return roles[ip], nil
+		},
+	}
+	c := newTestCachingClient(client, &now)
+
+	defaultRole(context.Background(), c, "10.0.0.1")
+	defaultRole(context.Background(), c, "10.0.0.2")
+
+	roles["10.0.0.1"] = "second"
+	c.Invalidate("10.0.0.1")
+
+	role, _ := defaultRole(context.Background(), c, "10.0.0.1")
+	if role != "second" {
+		t.Error("expected invalidated role to be requested again, was", role)
+	}
+	defaultRole(context.Background(), c, "10.0.0.2")
+	if client.roleCalls != 3 {
+		t.Error("expected other pods to remain cached, requested", client.roleCalls)
+	}
+}
+
+type stubNodeCredentialsWatcher struct {
+	updates []*NodeCredentialsUpdate
+	sent    chan struct{}
+}
+
+func (w *stubNodeCredentialsWatcher) WatchNodeCredentials(ctx context.Context, nodeName string, received func(*NodeCredentialsUpdate)) error {
+	for _, update := range w.updates {
+		received(update)
+	}
+	close(w.sent)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestAnswersFromWatchedNodeCredentials(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	client := &stubServerClient{
+		getRole: func(ip string) (string, error) {
+			return "", fmt.Errorf("connection refused")
+		},
+		getCredentials: func(ip, role string) (*sts.Credentials, error) {
+			return nil, fmt.Errorf("connection refused")
+		},
+	}
+	c := newTestCachingClient(client, &now)
+
+	// cached before watching, and not sent by the server
+	c.apply(0, &NodeCredentialsUpdate{IP: "10.0.0.9", Role: "gone"})
+
+	watcher := &stubNodeCredentialsWatcher{updates: []*NodeCredentialsUpdate{
+		{IP: "10.0.0.1", Role: "role", Credentials: credentialsExpiring(now.Add(15 * time.Minute))},
+		{IP: "10.0.0.2"},
+		{Synced: true},
+	}, sent: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.WatchNode(ctx, watcher, "node-1")
+		close(done)
+	}()
+
+	<-watcher.sent
+
+	if role, err := defaultRole(context.Background(), c, "10.0.0.2"); err != nil || role != "" {
+		t.Error("expected watched pod without role, was", role, err)
+	}
+	if client.roleCalls != 0 {
+		t.Error("expected no role requests, was", client.roleCalls)
+	}
+	if _, err := defaultRole(context.Background(), c, "10.0.0.9"); err == nil {
+		t.Error("expected role not sent by the server to be removed")
+	}
+
+	now = now.Add(time.Hour)
+	if role, err := defaultRole(context.Background(), c, "10.0.0.1"); err != nil || role != "role" {
+		t.Error("expected watched role, was", role, err)
+	}
+	now = now.Add(-time.Hour)
+	if credentials, err := c.GetCredentials(context.Background(), "10.0.0.1", "role"); err != nil || credentials.AccessKeyId != "A1234" {
+		t.Error("expected watched credentials, was", credentials, err)
+	}
+	if client.credentialsCalls != 0 {
+		t.Error("expected no credentials requests, was", client.credentialsCalls)
+	}
+
+	cancel()
+	<-done
+
+	now = now.Add(time.Minute)
+	if role, err := defaultRole(context.Background(), c, "10.0.0.1"); err != nil || role != "role" {
+		t.Error("expected stale role once no longer watched, was", role, err)
+	}
+	if client.roleCalls == 0 {
+		t.Error("expected role to be requested once no longer watched")
+	}
+}
+
+func TestInvalidatesWatchedPodsWhenRolesChange(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	client := &stubServerClient{
+		getCredentials: func(ip, role string) (*sts.Credentials, error) {
+			return nil, fmt.Errorf("connection refused")
+		},
+	}
+	c := newTestCachingClient(client, &now)
+
+	c.apply(1, &NodeCredentialsUpdate{IP: "10.0.0.1", Role: "role", Roles: []string{"role", "other"}, Credentials: credentialsExpiring(now.Add(15 * time.Minute))})
+	roles, err := c.GetRoles(context.Background(), "10.0.0.1")
+	if err != nil || len(roles.Roles) != 2 || roles.Roles[1] != "other" {
+		t.Error("expected watched roles, was", roles, err)
+	}
+
+	c.apply(1, &NodeCredentialsUpdate{IP: "10.0.0.1", Role: "role", Roles: []string{"role"}})
+	if roles, _ := c.GetRoles(context.Background(), "10.0.0.1"); len(roles.Roles) != 1 {
+		t.Error("expected updated roles, was", roles)
+	}
+	if _, err := c.GetCredentials(context.Background(), "10.0.0.1", "role"); err == nil {
+		t.Error("expected credentials invalidated when roles changed")
+	}
+}
+
+func TestDropsWatchedCredentialsNoLongerSent(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	client := &stubServerClient{
+		getCredentials: func(ip, role string) (*sts.Credentials, error) {
+			return nil, ErrPolicyForbidden
+		},
+	}
+	c := newTestCachingClient(client, &now)
+
+	c.apply(1, &NodeCredentialsUpdate{IP: "10.0.0.1", Role: "role", Credentials: credentialsExpiring(now.Add(15 * time.Minute))})
+	if _, err := c.GetCredentials(context.Background(), "10.0.0.1", "role"); err != nil {
+		t.Error("expected watched credentials, was", err)
+	}
+
+	// the namespace's policy no longer permits the role
+	c.apply(1, &NodeCredentialsUpdate{IP: "10.0.0.1", Role: "role"})
+	if _, err := c.GetCredentials(context.Background(), "10.0.0.1", "role"); err != ErrPolicyForbidden {
+		t.Error("expected credentials requested from the server, was", err)
+	}
+}
+
+func TestCachesWatchedCredentialsForAllRoles(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	client := &stubServerClient{
+		getCredentials: func(ip, role string) (*sts.Credentials, error) {
+			return nil, ErrPolicyForbidden
+		},
+	}
+	c := newTestCachingClient(client, &now)
+
+	c.apply(1, &NodeCredentialsUpdate{
+		IP:              "10.0.0.1",
+		Role:            "role",
+		Roles:           []string{"role", "other"},
+		Credentials:     credentialsExpiring(now.Add(15 * time.Minute)),
+		RoleCredentials: map[string]*sts.Credentials{"other": credentialsExpiring(now.Add(15 * time.Minute))},
+	})
+	for _, role := range []string{"role", "other"} {
+		if _, err := c.GetCredentials(context.Background(), "10.0.0.1", role); err != nil {
+			t.Errorf("expected watched credentials for %s, was %v", role, err)
+		}
+	}
+
+	// the additional role is no longer permitted
+	c.apply(1, &NodeCredentialsUpdate{IP: "10.0.0.1", Role: "role", Roles: []string{"role", "other"}, Credentials: credentialsExpiring(now.Add(15 * time.Minute))})
+	if _, err := c.GetCredentials(context.Background(), "10.0.0.1", "role"); err != nil {
+		t.Error("expected watched credentials for default role, was", err)
+	}
+	if _, err := c.GetCredentials(context.Background(), "10.0.0.1", "other"); err != ErrPolicyForbidden {
+		t.Error("expected credentials requested from the server, was", err)
+	}
+}
+
+func TestPurgesExpiredEntriesPeriodically(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	client := &stubServerClient{
+		getCredentials: func(ip, role string) (*sts.Credentials, error) {
+			return credentialsExpiring(now.Add(15 * time.Minute)), nil
+		},
+	}
+	c := newTestCachingClient(client, &now)
+
+	c.GetCredentials(context.Background(), "10.0.0.1", "role")
+	now = now.Add(14*time.Minute + 30*time.Second)
+	c.GetCredentials(context.Background(), "10.0.0.2", "role")
+
+	// expired, but purged within the interval
+	now = now.Add(30 * time.Second)
+	c.GetCredentials(context.Background(), "10.0.0.3", "role")
+	if _, found := c.entries[clientCacheKey{kind: cacheTypeCredentials, ip: "10.0.0.1", role: "role"}]; !found {
+		t.Error("expected expired entry to be kept until the purge interval")
+	}
+
+	now = now.Add(clientCachePurgeInterval / 2)
+	c.GetCredentials(context.Background(), "10.0.0.4", "role")
+	if _, found := c.entries[clientCacheKey{kind: cacheTypeCredentials, ip: "10.0.0.1", role: "role"}]; found {
+		t.Error("expected expired entry to be purged")
+	}
+	if len(c.entries) != 3 {
+		t.Error("expected unexpired entries to be kept, was", len(c.entries))
+	}
+}
+
+func TestCachesWatchedPodRoutes(t *testing.T) {
+	now := time.Now()
+	c := newTestCachingClient(&stubServerClient{}, &now)
+
+	c.apply(1, &NodeCredentialsUpdate{IP: "10.0.0.1", Role: "role", Overrides: PodOverrides{NamespaceAllowRouteRegexp: "^/latest/", PodAllowRouteRegexp: "^/latest/user-data"}})
+	roles, err := c.GetRoles(context.Background(), "10.0.0.1")
+	if err != nil || roles.Overrides.NamespaceAllowRouteRegexp != "^/latest/" || roles.Overrides.PodAllowRouteRegexp != "^/latest/user-data" {
+		t.Error("expected watched routes, was", roles, err)
+	}
+}
+
+// podUIDRoleClient returns a role named after the pod UID requested.
+type podUIDRoleClient struct {
+	stubServerClient
+}
+
+func (c *podUIDRoleClient) GetRoles(ctx context.Context, ip string) (*PodRoles, error) {
+	atomic.AddInt32(&c.roleCalls, 1)
+	return &PodRoles{Roles: []string{fmt.Sprintf("role-%s", PodUIDFromContext(ctx))}}, nil
+}
+
+func TestCachesHostNetworkPodsByUID(t *testing.T) {
+	now := time.Now()
+	client := &podUIDRoleClient{}
+	c := newTestCachingClient(client, &now)
+
+	for _, uid := range []string{"uid-1", "uid-2", "uid-1"} {
+		role, err := defaultRole(WithPodUID(context.Background(), uid), c, "10.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if role != "role-"+uid {
+			t.Error("unexpected role for", uid, role)
+		}
+	}
+	if client.roleCalls != 2 {
+		t.Error("expected roles to be cached by uid, requested", client.roleCalls)
+	}
+}
@@ -10,4 +10,13 @@ var (
 	// ErrPolicyForbidden returned when credentials can't be issued
 	// because of a policy
 	ErrPolicyForbidden = fmt.Errorf("forbidden by policy")
+	// ErrNodeMismatch returned when the pod isn't on the requesting agent's
+	// node
+	ErrNodeMismatch = fmt.Errorf("pod not on agent's node")
+	// ErrNodeIdentityRequired returned when an agent watches node credentials
+	// but the server doesn't bind agents to their nodes
+	ErrNodeIdentityRequired = fmt.Errorf("watching node credentials requires --agent-node-identity")
+	// ErrServiceAccountMismatch returned when a sidecar agent's service
+	// account token wasn't issued for the pod
+	ErrServiceAccountMismatch = fmt.Errorf("pod not identified by agent's service account token")
 )
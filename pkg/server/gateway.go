@@ -25,15 +25,64 @@ import (
 
 // Client is the Server's client interface
 type Client interface {
-	GetRole(ctx context.Context, ip string) (string, error)
+	GetRoles(ctx context.Context, ip string) (*PodRoles, error)
 	GetCredentials(ctx context.Context, ip, role string) (*sts.Credentials, error)
 	Health(ctx context.Context) (string, error)
 }
 
+// PodRoles are the roles a Pod may assume, its default role first, and the
+// metadata the agent serves for it.
+type PodRoles struct {
+	Roles []string
+	// ARN is the default role's ARN, as resolved by the server. Empty from
+	// servers that don't send it.
+	ARN string
+	// Overrides are the Pod's annotated overrides of the metadata served.
+	Overrides PodOverrides
+}
+
+// PodOverrides are the Pod's, and its Namespace's, annotated overrides of the
+// instance metadata the agent serves.
+type PodOverrides struct {
+	// NamespaceAllowRouteRegexp and PodAllowRouteRegexp are the Namespace's
+	// and the Pod's annotated regexps of metadata paths to proxy; paths must
+	// match each that's annotated. Empty when not annotated.
+	NamespaceAllowRouteRegexp string
+	PodAllowRouteRegexp       string
+	// Region is the Pod's, or its Namespace's, annotated placement region.
+	// Empty when neither is annotated.
+	Region string
+}
+
+// Default returns the Pod's default role, or an empty string when it has none.
+func (r *PodRoles) Default() string {
+	if r == nil || len(r.Roles) == 0 {
+		return ""
+	}
+	return r.Roles[0]
+}
+
+type podUIDKey struct{}
+
+// WithPodUID returns a context identifying the requesting Pod by its UID as
+// well as its IP. hostNetwork Pods share their node's IP, so can only be told
+// apart by UID.
+func WithPodUID(ctx context.Context, uid string) context.Context {
+	return context.WithValue(ctx, podUIDKey{}, uid)
+}
+
+// PodUIDFromContext returns the UID of the requesting Pod, or an empty string
+// when it's identified only by IP.
+func PodUIDFromContext(ctx context.Context) string {
+	uid, _ := ctx.Value(podUIDKey{}).(string)
+	return uid
+}
+
 // KiamGateway is the client to interact with KiamServer
 type KiamGateway struct {
 	conn          *grpc.ClientConn
 	client        pb.KiamServiceClient
+	admin         pb.KiamAdminServiceClient
 	tlsConfig     *dynamicTLSConfig
 	retryInterval time.Time
 }
@@ -41,33 +90,66 @@ type KiamGateway struct {
 // Close disconnects the connection
 func (g *KiamGateway) Close() {
 	g.conn.Close()
-	g.tlsConfig.Close()
+	if g.tlsConfig != nil {
+		g.tlsConfig.Close()
+	}
 }
 
-// GetRole returns the role for the identified Pod
-func (g *KiamGateway) GetRole(ctx context.Context, ip string) (string, error) {
-	role, err := g.client.GetPodRole(ctx, &pb.GetPodRoleRequest{Ip: ip})
+// GetRoles returns all the roles the identified Pod is annotated with, the
+// default role first, and its annotated metadata routes.
+func (g *KiamGateway) GetRoles(ctx context.Context, ip string) (*PodRoles, error) {
+	role, err := g.client.GetPodRole(ctx, &pb.GetPodRoleRequest{Ip: ip, PodUid: PodUIDFromContext(ctx)})
 	if err != nil {
-		return "", err
+		return nil, translateError(err)
 	}
-	return role.GetName(), nil
+	return &PodRoles{
+		Roles: podRoles(role.GetName(), role.GetRoles()),
+		ARN:   role.GetArn(),
+		Overrides: PodOverrides{
+			NamespaceAllowRouteRegexp: role.GetNamespaceAllowRouteRegexp(),
+			PodAllowRouteRegexp:       role.GetPodAllowRouteRegexp(),
+			Region:                    role.GetRegion(),
+		},
+	}, nil
+}
+
+// podRoles returns the roles sent by the server: servers before multiple
+// roles only send the default.
+func podRoles(role string, roles []string) []string {
+	if len(roles) == 0 && role != "" {
+		return []string{role}
+	}
+	return roles
 }
 
 // GetCredentials returns the credentials for the identified Pod
 func (g *KiamGateway) GetCredentials(ctx context.Context, ip, role string) (*sts.Credentials, error) {
-	credentials, err := g.client.GetPodCredentials(ctx, &pb.GetPodCredentialsRequest{Ip: ip, Role: role})
+	credentials, err := g.client.GetPodCredentials(ctx, &pb.GetPodCredentialsRequest{Ip: ip, Role: role, PodUid: PodUIDFromContext(ctx)})
 	if err != nil {
-		if grpcStatus, ok := status.FromError(err); ok {
-			switch grpcStatus.Message() {
-			case ErrPolicyForbidden.Error():
-				return nil, ErrPolicyForbidden
-			case ErrPodNotFound.Error():
-				return nil, ErrPodNotFound
-			}
-		}
+		return nil, translateError(err)
+	}
+	return translateCredentialsFromProto(credentials), nil
+}
 
-		return nil, err
+// translateError returns the server's errors that callers handle specifically.
+func translateError(err error) error {
+	if grpcStatus, ok := status.FromError(err); ok {
+		switch grpcStatus.Message() {
+		case ErrPolicyForbidden.Error():
+			return ErrPolicyForbidden
+		case ErrPodNotFound.Error():
+			return ErrPodNotFound
+		case ErrNodeMismatch.Error():
+			return ErrNodeMismatch
+		case ErrServiceAccountMismatch.Error():
+			return ErrServiceAccountMismatch
+		}
 	}
+
+	return err
+}
+
+func translateCredentialsFromProto(credentials *pb.Credentials) *sts.Credentials {
 	return &sts.Credentials{
 		Code:            credentials.Code,
 		Type:            credentials.Type,
@@ -76,7 +158,91 @@ func (g *KiamGateway) GetCredentials(ctx context.Context, ip, role string) (*sts
 		Token:           credentials.Token,
 		Expiration:      credentials.Expiration,
 		LastUpdated:     credentials.LastUpdated,
-	}, nil
+	}
+}
+
+// NodeCredentialsUpdate is the role, and credentials when they could be issued,
+// of the Pod with the IP address. Roles lists all the Pod's roles, Role first;
+// RoleARN and Overrides are as in PodRoles.
+// Removed indicates the IP address no longer identifies a Pod; Synced that all
+// the node's Pods have been sent.
+type NodeCredentialsUpdate struct {
+	IP          string
+	Role        string
+	Roles       []string
+	RoleARN     string
+	Overrides   PodOverrides
+	Credentials *sts.Credentials
+	Removed     bool
+	Synced      bool
+	// RoleCredentials are the credentials of the Pod's other permitted roles,
+	// by role.
+	RoleCredentials map[string]*sts.Credentials
+}
+
+// NodeCredentialsWatcher streams the roles and credentials of Pods on a node.
+type NodeCredentialsWatcher interface {
+	WatchNodeCredentials(ctx context.Context, nodeName string, received func(*NodeCredentialsUpdate)) error
+}
+
+// WatchNodeCredentials calls received with updates for the Pods on the node
+// until the context is cancelled or the stream fails.
+func (g *KiamGateway) WatchNodeCredentials(ctx context.Context, nodeName string, received func(*NodeCredentialsUpdate)) error {
+	stream, err := g.client.WatchNodeCredentials(ctx, &pb.WatchNodeCredentialsRequest{NodeName: nodeName})
+	if err != nil {
+		return err
+	}
+
+	for {
+		update, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		var credentials *sts.Credentials
+		if update.Credentials != nil {
+			credentials = translateCredentialsFromProto(update.Credentials)
+		}
+		roleCredentials := make(map[string]*sts.Credentials, len(update.RoleCredentials))
+		for role, c := range update.RoleCredentials {
+			roleCredentials[role] = translateCredentialsFromProto(c)
+		}
+		received(&NodeCredentialsUpdate{
+			IP:      update.Ip,
+			Role:    update.Role,
+			Roles:   update.Roles,
+			RoleARN: update.RoleArn,
+			Overrides: PodOverrides{
+				NamespaceAllowRouteRegexp: update.NamespaceAllowRouteRegexp,
+				PodAllowRouteRegexp:       update.PodAllowRouteRegexp,
+				Region:                    update.Region,
+			},
+			Credentials:     credentials,
+			RoleCredentials: roleCredentials,
+			Removed:         update.Removed,
+			Synced:          update.Synced,
+		})
+	}
+}
+
+// ListCachedCredentials returns the credentials held in the server's cache.
+// Requires the gateway to use an admin client certificate.
+func (g *KiamGateway) ListCachedCredentials(ctx context.Context) ([]*pb.CachedCredentials, error) {
+	list, err := g.admin.ListCachedCredentials(ctx, &pb.ListCachedCredentialsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return list.Credentials, nil
+}
+
+// EvictCachedCredentials removes credentials from the server's cache, returning
+// how many were evicted. Requires the gateway to use an admin client certificate.
+func (g *KiamGateway) EvictCachedCredentials(ctx context.Context, req *pb.EvictCachedCredentialsRequest) (int, error) {
+	result, err := g.admin.EvictCachedCredentials(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+	return int(result.Evicted), nil
 }
 
 // Health is used to check the gRPC client connection
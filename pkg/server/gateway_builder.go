@@ -44,6 +44,7 @@ type KiamGatewayBuilder struct {
 	keepaliveParams keepalive.ClientParameters
 	tlsConfig       *dynamicTLSConfig
 	dialOptions     []grpc.DialOption
+	perRPCCreds     credentials.PerRPCCredentials
 	retryInterval   time.Duration
 	maxRetries      uint
 }
@@ -105,6 +106,31 @@ func (b *KiamGatewayBuilder) WithTLS(cert, key, ca string) (*KiamGatewayBuilder,
 	return b, nil
 }
 
+// WithServerCA configures the gRPC client with TLS verifying the server, but
+// without a client certificate. Used by sidecar agents, which authenticate
+// with WithServiceAccountToken.
+func (b *KiamGatewayBuilder) WithServerCA(ca string) (*KiamGatewayBuilder, error) {
+	hostName, _, err := net.SplitHostPort(b.address)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing hostname: %v", err)
+	}
+
+	creds, err := credentials.NewClientTLSFromFile(ca, hostName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading tls ca: %v", err)
+	}
+
+	b.transportCreds = creds
+	return b, nil
+}
+
+// WithServiceAccountToken sends the pod's projected service account token,
+// read from the path, with each request.
+func (b *KiamGatewayBuilder) WithServiceAccountToken(path string) *KiamGatewayBuilder {
+	b.perRPCCreds = &serviceAccountTokenCredentials{path: path}
+	return b
+}
+
 func (b *KiamGatewayBuilder) WithKeepAlive(parameters keepalive.ClientParameters) *KiamGatewayBuilder {
 	b.keepaliveParams = parameters
 	return b
@@ -126,6 +152,9 @@ func (b *KiamGatewayBuilder) Build(ctx context.Context) (*KiamGateway, error) {
 		grpc.WithBlock(),
 		grpc.WithStreamInterceptor(grpc_prometheus.StreamClientInterceptor),
 	}
+	if b.perRPCCreds != nil {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(b.perRPCCreds))
+	}
 	if b.dialOptions != nil {
 		dialOpts = append(dialOpts, b.dialOptions...)
 	}
@@ -137,6 +166,7 @@ func (b *KiamGatewayBuilder) Build(ctx context.Context) (*KiamGateway, error) {
 	gw := &KiamGateway{
 		conn:      conn,
 		client:    pb.NewKiamServiceClient(conn),
+		admin:     pb.NewKiamAdminServiceClient(conn),
 		tlsConfig: b.tlsConfig,
 	}
 	return gw, nil
@@ -0,0 +1,124 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	// NodeIdentityNone doesn't bind agents to nodes: any agent can request
+	// roles and credentials for any pod.
+	NodeIdentityNone = "none"
+	// NodeIdentityCommonName reads the agent's node name from its client
+	// certificate's common name.
+	NodeIdentityCommonName = "cn"
+	// NodeIdentityDNSName reads the agent's node name from its client
+	// certificate's DNS subject alternative names.
+	NodeIdentityDNSName = "san"
+)
+
+// NodeIdentityConfig controls how the node an agent runs on is identified
+// from its client certificate.
+type NodeIdentityConfig struct {
+	// Source is one of NodeIdentityNone, NodeIdentityCommonName or
+	// NodeIdentityDNSName.
+	Source string
+	// Prefix is removed from the certificate's names; names without it
+	// don't identify a node.
+	Prefix string
+}
+
+// Enabled returns whether agents are bound to their nodes.
+func (c NodeIdentityConfig) Enabled() bool {
+	return c.Source != "" && c.Source != NodeIdentityNone
+}
+
+// ErrNoPeerCertificate is returned when the request wasn't made with a
+// verified client certificate.
+var ErrNoPeerCertificate = fmt.Errorf("no verified client certificate")
+
+// peerCertificate returns the leaf client certificate verified during the
+// TLS handshake.
+func peerCertificate(ctx context.Context) (*x509.Certificate, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, ErrNoPeerCertificate
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return nil, ErrNoPeerCertificate
+	}
+
+	return tlsInfo.State.PeerCertificates[0], nil
+}
+
+// peerNodeNames returns the names of the nodes identified by the verified
+// client certificate.
+func peerNodeNames(ctx context.Context, config NodeIdentityConfig) ([]string, error) {
+	cert, err := peerCertificate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var certNames []string
+	switch config.Source {
+	case NodeIdentityCommonName:
+		certNames = []string{cert.Subject.CommonName}
+	case NodeIdentityDNSName:
+		certNames = cert.DNSNames
+	default:
+		return nil, fmt.Errorf("unknown node identity source: %s", config.Source)
+	}
+
+	names := make([]string, 0, len(certNames))
+	for _, certName := range certNames {
+		if !strings.HasPrefix(certName, config.Prefix) {
+			continue
+		}
+		if name := strings.TrimPrefix(certName, config.Prefix); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+// peerHasName returns whether the verified client certificate's common name
+// or any of its DNS subject alternative names is one of names.
+func peerHasName(ctx context.Context, names []string) (bool, error) {
+	cert, err := peerCertificate(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	certNames := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	for _, certName := range certNames {
+		for _, name := range names {
+			if certName != "" && certName == name {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
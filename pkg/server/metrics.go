@@ -0,0 +1,102 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	clientCacheHit = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "kiam",
+			Subsystem: "agent_cache",
+			Name:      "hits_total",
+			Help:      "Number of role and credentials requests answered from the agent's cache",
+		},
+		[]string{"type"},
+	)
+	clientCacheMiss = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "kiam",
+			Subsystem: "agent_cache",
+			Name:      "misses_total",
+			Help:      "Number of role and credentials requests forwarded to the server",
+		},
+		[]string{"type"},
+	)
+	clientCacheCoalesced = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "kiam",
+			Subsystem: "agent_cache",
+			Name:      "coalesced_total",
+			Help:      "Number of role and credentials requests that waited on an identical in-flight request",
+		},
+		[]string{"type"},
+	)
+	clientCacheStale = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "kiam",
+			Subsystem: "agent_cache",
+			Name:      "stale_total",
+			Help:      "Number of role and credentials requests answered from the agent's cache because the server couldn't be reached",
+		},
+		[]string{"type"},
+	)
+	clientCacheInvalidated = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "kiam",
+			Subsystem: "agent_cache",
+			Name:      "invalidations_total",
+			Help:      "Number of times cached entries for a pod were invalidated",
+		},
+	)
+	nodeCredentialsStreams = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "kiam",
+			Subsystem: "server",
+			Name:      "node_credentials_streams",
+			Help:      "Number of agents watching the credentials of their node's pods",
+		},
+	)
+	nodeMismatch = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "kiam",
+			Subsystem: "server",
+			Name:      "node_mismatch_total",
+			Help:      "Number of requests denied because the pod wasn't on the requesting agent's node",
+		},
+		[]string{"rpc"},
+	)
+	serviceAccountDenied = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "kiam",
+			Subsystem: "server",
+			Name:      "service_account_denied_total",
+			Help:      "Number of requests from sidecar agents denied because their service account token wasn't valid or wasn't issued for the pod",
+		},
+		[]string{"rpc"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(clientCacheHit)
+	prometheus.MustRegister(clientCacheMiss)
+	prometheus.MustRegister(clientCacheCoalesced)
+	prometheus.MustRegister(clientCacheStale)
+	prometheus.MustRegister(clientCacheInvalidated)
+	prometheus.MustRegister(nodeCredentialsStreams)
+	prometheus.MustRegister(nodeMismatch)
+	prometheus.MustRegister(serviceAccountDenied)
+}
@@ -0,0 +1,172 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/uswitch/kiam/pkg/aws/sts"
+	"github.com/uswitch/kiam/pkg/k8s"
+	v1 "k8s.io/api/core/v1"
+)
+
+// isPrefetchAllowed checks policy before credentials are prefetched for one of
+// a Pod's roles, so that credentials aren't maintained for roles the Pod
+// couldn't assume.
+func (k *KiamServer) isPrefetchAllowed(ctx context.Context, pod *v1.Pod, role string) (bool, error) {
+	decision, err := k.assumePolicy.IsAllowedAssumeRole(ctx, role, pod)
+	if err != nil {
+		return false, err
+	}
+	return decision.IsAllowed(), nil
+}
+
+// watchNamespacePolicy re-evaluates policy for the Pods in Namespaces whose
+// permitted roles or default role annotations change.
+func (k *KiamServer) watchNamespacePolicy(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case change := <-k.namespaces.PermittedChanges():
+			namespace := change.Namespace.GetName()
+			err := k.pods.RefreshNamespace(namespace, change.Previous)
+			if err != nil {
+				log.WithField("namespace.name", namespace).Errorf("error refreshing pod roles: %s", err.Error())
+			}
+			k.reevaluateNamespace(ctx, namespace, change.Previous)
+		}
+	}
+}
+
+// reevaluateNamespace runs the assume role policy for the Namespace's active
+// Pods. Pods that were allowed their role by the previous version of the
+// Namespace but are now forbidden are sent a warning event. Unless an allowed
+// Pod in the Namespace shares it, forbidden Pods' identity's credentials are
+// evicted so they're no longer prefetched. Pods in other Namespaces that use
+// the same identity will request the credentials again when they next need them.
+// Agents watching the nodes of the Namespace's Pods are sent their changes, so
+// they stop using credentials that are no longer permitted.
+func (k *KiamServer) reevaluateNamespace(ctx context.Context, namespace string, previous *v1.Namespace) {
+	logger := log.WithField("namespace.name", namespace)
+
+	pods, err := k.pods.ListPodsInNamespace(namespace)
+	if err != nil {
+		logger.Errorf("error listing pods to re-evaluate policy: %s", err.Error())
+		return
+	}
+
+	allowed := make(map[string]bool)
+	forbidden := make(map[string]*sts.RoleIdentity)
+	nodes := make(map[string]bool)
+	defer func() {
+		for nodeName := range nodes {
+			k.nodeWatchers.notify(nodeName)
+		}
+	}()
+
+	for _, pod := range pods {
+		if pod.Spec.NodeName != "" {
+			nodes[pod.Spec.NodeName] = true
+		}
+
+		if k8s.IsPodCompleted(pod) {
+			continue
+		}
+
+		podLogger := log.WithFields(k8s.PodFields(pod))
+		roles := k.defaultRoles.PodRoles(pod)
+		identities, err := k.defaultRoles.PodRoleIdentities(k.arnResolver, pod)
+		if err != nil {
+			podLogger.Errorf("error creating role identity: %s", err.Error())
+			continue
+		}
+
+		for i, identity := range identities {
+			role := roles[i]
+			decision, err := k.assumePolicy.IsAllowedAssumeRole(ctx, role, pod)
+			if err != nil {
+				podLogger.Errorf("error checking policy: %s", err.Error())
+				continue
+			}
+
+			if decision.IsAllowed() {
+				allowed[identity.String()] = true
+				continue
+			}
+
+			forbidden[identity.String()] = identity
+			if !k.wasAllowed(ctx, previous, pod, role) {
+				continue
+			}
+
+			podLogger.WithField("policy.explanation", decision.Explanation()).Warnf("pod role forbidden after namespace policy changed")
+			k.recordEvent(pod, v1.EventTypeWarning, "KiamRoleForbidden", fmt.Sprintf("role %q no longer permitted: %s", role, decision.Explanation()))
+		}
+	}
+
+	for key, identity := range forbidden {
+		if allowed[key] {
+			continue
+		}
+		if k.credentialsCache.Evict(identity) {
+			log.WithFields(identity.LogFields()).Infof("evicted credentials for forbidden role")
+		}
+	}
+}
+
+// wasAllowed returns whether the assume role policy allowed the Pod the role,
+// or it had none, with the previous version of its Namespace. A role the Pod
+// didn't have before, as its Namespace's default role changed, is compared
+// with its previous default role. Pods are assumed to have been allowed when
+// the previous version is unknown.
+func (k *KiamServer) wasAllowed(ctx context.Context, previous *v1.Namespace, pod *v1.Pod, role string) bool {
+	if previous == nil || k.policyForNamespaces == nil {
+		return true
+	}
+
+	namespaces := &previousNamespaceFinder{namespaces: k.namespaces, previous: previous}
+	defaults := k.defaultRoles.WithNamespaceFinder(namespaces)
+	previousRoles := defaults.PodRoles(pod)
+	if len(previousRoles) == 0 {
+		return true
+	}
+	if !containsString(previousRoles, role) {
+		role = previousRoles[0]
+	}
+
+	decision, err := k.policyForNamespaces(namespaces, defaults).IsAllowedAssumeRole(ctx, role, pod)
+	if err != nil {
+		log.WithFields(k8s.PodFields(pod)).Errorf("error checking policy before namespace changed: %s", err.Error())
+		return true
+	}
+	return decision.IsAllowed()
+}
+
+// previousNamespaceFinder finds the previous version of a changed Namespace,
+// and the current version of others.
+type previousNamespaceFinder struct {
+	namespaces k8s.NamespaceFinder
+	previous   *v1.Namespace
+}
+
+func (f *previousNamespaceFinder) FindNamespace(ctx context.Context, name string) (*v1.Namespace, error) {
+	if name == f.previous.GetName() {
+		return f.previous, nil
+	}
+	return f.namespaces.FindNamespace(ctx, name)
+}
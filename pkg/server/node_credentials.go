@@ -0,0 +1,368 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/uswitch/kiam/pkg/aws/sts"
+	"github.com/uswitch/kiam/pkg/k8s"
+	pb "github.com/uswitch/kiam/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// nodeCredentialsResync is how often node credentials streams are checked
+	// for changes, in case a notification was dropped.
+	nodeCredentialsResync = time.Minute
+	// nodeCredentialsConcurrency bounds how many of a node's pods have their
+	// credentials fetched at once, so pods whose credentials aren't cached
+	// don't each wait for the previous pod's to be issued.
+	nodeCredentialsConcurrency = 8
+)
+
+// nodeWatchers notifies the streams watching a node that its pods, or their
+// credentials, have changed.
+type nodeWatchers struct {
+	mu       sync.Mutex
+	watchers map[string]map[chan struct{}]bool
+}
+
+func newNodeWatchers() *nodeWatchers {
+	return &nodeWatchers{watchers: make(map[string]map[chan struct{}]bool)}
+}
+
+// subscribe returns a channel that receives when the node changes, and a func
+// to unsubscribe.
+func (w *nodeWatchers) subscribe(nodeName string) (<-chan struct{}, func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// notifications coalesce until the stream next checks the node
+	changes := make(chan struct{}, 1)
+	if w.watchers[nodeName] == nil {
+		w.watchers[nodeName] = make(map[chan struct{}]bool)
+	}
+	w.watchers[nodeName][changes] = true
+	nodeCredentialsStreams.Inc()
+
+	return changes, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		delete(w.watchers[nodeName], changes)
+		if len(w.watchers[nodeName]) == 0 {
+			delete(w.watchers, nodeName)
+		}
+		nodeCredentialsStreams.Dec()
+	}
+}
+
+func (w *nodeWatchers) notify(nodeName string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for changes := range w.watchers[nodeName] {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// watchNodeChanges notifies node credentials streams as pods change and
+// credentials are issued.
+func (k *KiamServer) watchNodeChanges(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case nodeName := <-k.pods.NodeChanges():
+			k.nodeWatchers.notify(nodeName)
+		case issued := <-k.credentialsCache.Issued():
+			nodes, err := k.pods.NodesForRole(issued.Identity)
+			if err != nil {
+				log.WithFields(issued.Identity.LogFields()).Errorf("error finding nodes for issued credentials: %s", err.Error())
+				continue
+			}
+			for _, nodeName := range nodes {
+				k.nodeWatchers.notify(nodeName)
+			}
+		}
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// sentCredentials records what was last sent to an agent for a pod ip.
+type sentCredentials struct {
+	role        string
+	roles       string
+	roleARN     string
+	routes      string
+	region      string
+	accessKeyID string
+	expiration  string
+	// roleCredentials identifies the credentials sent for the pod's other
+	// roles, so that they're sent again when any of them are refreshed
+	roleCredentials string
+}
+
+// roleCredentialsKey returns the roles' access key ids and expirations as a
+// string, sorted by role, to compare with those last sent.
+func roleCredentialsKey(credentials map[string]*pb.Credentials) string {
+	keys := make([]string, 0, len(credentials))
+	for role, c := range credentials {
+		keys = append(keys, role+"="+c.GetAccessKeyId()+"@"+c.GetExpiration())
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// WatchNodeCredentials streams the roles and credentials of the pods on the
+// node, sending them again as they change, so that the agent can answer
+// requests without calling the server.
+func (k *KiamServer) WatchNodeCredentials(req *pb.WatchNodeCredentialsRequest, stream pb.KiamService_WatchNodeCredentialsServer) error {
+	if req.NodeName == "" {
+		return status.Error(codes.InvalidArgument, "node name must be specified")
+	}
+
+	ctx := stream.Context()
+	logger := log.WithField("node.name", req.NodeName)
+
+	// sidecar agents only act for their own pod
+	if k.tokenReviewer != nil {
+		if _, err := peerCertificate(ctx); err != nil {
+			return status.Error(codes.Unauthenticated, "watching node credentials requires a client certificate")
+		}
+	}
+
+	// streaming every pod's credentials to any agent would expose the whole
+	// cluster's credentials to a single node, so agents must be bound
+	if !k.nodeIdentity.Enabled() {
+		return ErrNodeIdentityRequired
+	}
+	nodeNames, err := k.agentNodeNames(ctx, "WatchNodeCredentials")
+	if err != nil {
+		return err
+	}
+	if !containsString(nodeNames, req.NodeName) {
+		nodeMismatch.WithLabelValues("WatchNodeCredentials").Inc()
+		logger.WithField("agent.nodes", nodeNames).Warnf("denied WatchNodeCredentials for another node")
+		return ErrNodeMismatch
+	}
+
+	changes, unsubscribe := k.nodeWatchers.subscribe(req.NodeName)
+	defer unsubscribe()
+
+	sent := make(map[string]sentCredentials)
+	err = k.syncNodeCredentials(ctx, req.NodeName, sent, stream)
+	if err != nil {
+		return err
+	}
+	err = stream.Send(&pb.NodeCredentialsUpdate{Synced: true})
+	if err != nil {
+		return err
+	}
+	logger.Infof("agent watching node credentials")
+
+	resync := time.NewTicker(nodeCredentialsResync)
+	defer resync.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Infof("agent stopped watching node credentials")
+			return nil
+		case <-changes:
+		case <-resync.C:
+		}
+
+		err = k.syncNodeCredentials(ctx, req.NodeName, sent, stream)
+		if err != nil {
+			logger.Warnf("error sending node credentials: %s", err.Error())
+			return err
+		}
+	}
+}
+
+// syncNodeCredentials sends updates for the pods on the node that have changed
+// since they were last sent, and removals for those that have gone.
+func (k *KiamServer) syncNodeCredentials(ctx context.Context, nodeName string, sent map[string]sentCredentials, stream pb.KiamService_WatchNodeCredentialsServer) error {
+	pods, err := k.pods.ListPodsOnNode(nodeName)
+	if err != nil {
+		return err
+	}
+
+	// pods sharing an ip can't be told apart; their requests continue to be
+	// made to the server, which rejects them
+	byIP := make(map[string][]*v1.Pod)
+	for _, pod := range pods {
+		if k8s.IsPodCompleted(pod) || pod.Spec.HostNetwork {
+			continue
+		}
+		for _, ip := range k8s.PodIPs(pod) {
+			byIP[ip] = append(byIP[ip], pod)
+		}
+	}
+
+	var ips []string
+	for ip, pods := range byIP {
+		if len(pods) == 1 {
+			ips = append(ips, ip)
+		}
+	}
+	updates := k.nodeCredentialsUpdates(ctx, ips, byIP)
+
+	for _, update := range updates {
+		ip := update.Ip
+		current := sentCredentials{
+			role:    update.Role,
+			roles:   strings.Join(update.Roles, ","),
+			roleARN: update.RoleArn,
+			routes:  update.NamespaceAllowRouteRegexp + "\n" + update.PodAllowRouteRegexp,
+			region:  update.Region,
+		}
+		if update.Credentials != nil {
+			current.accessKeyID = update.Credentials.AccessKeyId
+			current.expiration = update.Credentials.Expiration
+		}
+		current.roleCredentials = roleCredentialsKey(update.RoleCredentials)
+
+		if previous, found := sent[ip]; found && previous == current {
+			continue
+		}
+		err = stream.Send(update)
+		if err != nil {
+			return err
+		}
+		sent[ip] = current
+	}
+
+	for ip := range sent {
+		if len(byIP[ip]) == 1 {
+			continue
+		}
+		err = stream.Send(&pb.NodeCredentialsUpdate{Ip: ip, Removed: true})
+		if err != nil {
+			return err
+		}
+		delete(sent, ip)
+	}
+
+	return nil
+}
+
+// nodeCredentialsUpdates returns the updates for the pods with the ips,
+// fetching up to nodeCredentialsConcurrency pods' credentials at once.
+func (k *KiamServer) nodeCredentialsUpdates(ctx context.Context, ips []string, byIP map[string][]*v1.Pod) []*pb.NodeCredentialsUpdate {
+	updates := make([]*pb.NodeCredentialsUpdate, len(ips))
+	limit := make(chan struct{}, nodeCredentialsConcurrency)
+
+	var wg sync.WaitGroup
+	for i, ip := range ips {
+		limit <- struct{}{}
+		wg.Add(1)
+		go func(i int, ip string) {
+			defer wg.Done()
+			defer func() { <-limit }()
+			updates[i] = k.nodeCredentialsUpdate(ctx, byIP[ip][0], ip)
+		}(i, ip)
+	}
+	wg.Wait()
+
+	return updates
+}
+
+// nodeCredentialsUpdate returns the pod's roles for one of its ips and, for
+// each role that's permitted and can be issued, its credentials. Otherwise the
+// agent requests credentials from the server, which reports why they can't be
+// returned.
+func (k *KiamServer) nodeCredentialsUpdate(ctx context.Context, pod *v1.Pod, ip string) *pb.NodeCredentialsUpdate {
+	namespace := k.podNamespace(ctx, pod)
+	update := &pb.NodeCredentialsUpdate{
+		Ip:                        ip,
+		Role:                      k.defaultRoles.PodRole(pod),
+		Roles:                     k.defaultRoles.PodRoles(pod),
+		NamespaceAllowRouteRegexp: k8s.NamespaceAllowRouteRegexp(namespace),
+		PodAllowRouteRegexp:       k8s.PodAllowRouteRegexp(pod),
+		Region:                    k8s.PodRegion(pod, namespace),
+	}
+	update.RoleArn = k.roleARN(update.Role)
+	if update.Role == "" {
+		return update
+	}
+
+	logger := log.WithFields(k8s.PodFields(pod))
+
+	identities, err := k.defaultRoles.PodRoleIdentities(k.arnResolver, pod)
+	if err != nil {
+		logger.Errorf("error creating role identity: %s", err.Error())
+		return update
+	}
+
+	for i, identity := range identities {
+		role := update.Roles[i]
+		credentials := k.permittedCredentials(ctx, pod, role, identity)
+		if credentials == nil {
+			continue
+		}
+		if role == update.Role {
+			update.Credentials = credentials
+			continue
+		}
+		if update.RoleCredentials == nil {
+			update.RoleCredentials = make(map[string]*pb.Credentials)
+		}
+		update.RoleCredentials[role] = credentials
+	}
+	return update
+}
+
+// permittedCredentials returns the credentials of one of the pod's roles, or
+// nil when the role isn't permitted or they can't be issued.
+func (k *KiamServer) permittedCredentials(ctx context.Context, pod *v1.Pod, role string, identity *sts.RoleIdentity) *pb.Credentials {
+	logger := log.WithFields(k8s.PodFields(pod)).WithField("pod.iam.role", role)
+
+	decision, err := k.assumePolicy.IsAllowedAssumeRole(ctx, role, pod)
+	if err != nil {
+		logger.Errorf("error checking policy: %s", err.Error())
+		return nil
+	}
+	if !decision.IsAllowed() {
+		return nil
+	}
+
+	credentials, err := k.credentialsProvider.CredentialsForRole(ctx, identity)
+	if err != nil {
+		logger.Errorf("error retrieving credentials: %s", err.Error())
+		return nil
+	}
+
+	return translateCredentialsToProto(credentials)
+}
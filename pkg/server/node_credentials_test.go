@@ -0,0 +1,352 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/uswitch/kiam/pkg/aws/sts"
+	"github.com/uswitch/kiam/pkg/k8s"
+	"github.com/uswitch/kiam/pkg/testutil"
+	pb "github.com/uswitch/kiam/proto"
+	"google.golang.org/grpc"
+	v1 "k8s.io/api/core/v1"
+	kt "k8s.io/client-go/tools/cache/testing"
+)
+
+type stubNodeCredentialsStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	updates chan *pb.NodeCredentialsUpdate
+}
+
+func (s *stubNodeCredentialsStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *stubNodeCredentialsStream) Send(update *pb.NodeCredentialsUpdate) error {
+	s.updates <- update
+	return nil
+}
+
+func podOnNode(pod *v1.Pod, nodeName string) *v1.Pod {
+	pod.Spec.NodeName = nodeName
+	return pod
+}
+
+func receiveUntilSynced(t *testing.T, updates <-chan *pb.NodeCredentialsUpdate) map[string]*pb.NodeCredentialsUpdate {
+	received := make(map[string]*pb.NodeCredentialsUpdate)
+	for {
+		select {
+		case update := <-updates:
+			if update.Synced {
+				return received
+			}
+			received[update.Ip] = update
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for node credentials")
+		}
+	}
+}
+
+func TestStreamsNodeCredentials(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	source.Add(podOnNode(testutil.NewPodWithRole("ns", "reader", "192.168.0.1", "Running", "reader"), "node-1"))
+	source.Add(podOnNode(testutil.NewPod("ns", "norole", "192.168.0.2", "Running"), "node-1"))
+	source.Add(podOnNode(testutil.NewPodWithRole("ns", "completed", "192.168.0.3", "Succeeded", "reader"), "node-1"))
+	source.Add(podOnNode(testutil.NewPodWithRole("ns", "other", "192.168.0.4", "Running", "reader"), "node-2"))
+
+	podCache := k8s.NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Second, defaultBuffer)
+	podCache.Run(ctx)
+
+	credentialsProvider := &stubCredentialsProvider{accessKey: "A1234"}
+	server := &KiamServer{pods: podCache, assumePolicy: &allowPolicy{}, credentialsProvider: credentialsProvider, arnResolver: sts.DefaultResolver("arn:account:"), nodeWatchers: newNodeWatchers(), nodeIdentity: NodeIdentityConfig{Source: NodeIdentityCommonName}}
+
+	agentCtx := contextWithPeerCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "node-1"}})
+	streamCtx, closeStream := context.WithCancel(agentCtx)
+	stream := &stubNodeCredentialsStream{ctx: streamCtx, updates: make(chan *pb.NodeCredentialsUpdate, defaultBuffer)}
+	done := make(chan error)
+	go func() {
+		done <- server.WatchNodeCredentials(&pb.WatchNodeCredentialsRequest{NodeName: "node-1"}, stream)
+	}()
+
+	received := receiveUntilSynced(t, stream.updates)
+	if len(received) != 2 {
+		t.Error("expected updates for the active pods on the node, was", received)
+	}
+	if update := received["192.168.0.1"]; update.GetRole() != "reader" || update.GetCredentials().GetAccessKeyId() != "A1234" {
+		t.Error("expected role and credentials, was", update)
+	}
+	if update := received["192.168.0.2"]; update == nil || update.Role != "" || update.Credentials != nil {
+		t.Error("expected pod without role, was", update)
+	}
+
+	credentialsProvider.accessKey = "A5678"
+	server.nodeWatchers.notify("node-1")
+
+	select {
+	case update := <-stream.updates:
+		if update.Ip != "192.168.0.1" || update.GetCredentials().GetAccessKeyId() != "A5678" {
+			t.Error("expected refreshed credentials, was", update)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for refreshed credentials")
+	}
+
+	closeStream()
+	if err := <-done; err != nil {
+		t.Error("unexpected error:", err)
+	}
+}
+
+// rolePolicy only allows the roles it's given.
+type rolePolicy map[string]bool
+
+func (p rolePolicy) IsAllowedAssumeRole(ctx context.Context, roleName string, pod *v1.Pod) (Decision, error) {
+	return &decision{allowed: p[roleName]}, nil
+}
+
+func TestStreamsCredentialsForAllPodRoles(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	source.Add(podOnNode(testutil.NewPodWithRoles("ns", "reader", "192.168.0.1", "Running", "reader", "writer, admin"), "node-1"))
+
+	podCache := k8s.NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Second, defaultBuffer)
+	podCache.Run(ctx)
+
+	policy := rolePolicy{"reader": true, "writer": true}
+	server := &KiamServer{pods: podCache, assumePolicy: policy, credentialsProvider: &stubCredentialsProvider{accessKey: "A1234"}, arnResolver: sts.DefaultResolver("arn:account:"), nodeWatchers: newNodeWatchers(), nodeIdentity: NodeIdentityConfig{Source: NodeIdentityCommonName}}
+
+	agentCtx := contextWithPeerCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "node-1"}})
+	streamCtx, closeStream := context.WithCancel(agentCtx)
+	defer closeStream()
+	stream := &stubNodeCredentialsStream{ctx: streamCtx, updates: make(chan *pb.NodeCredentialsUpdate, defaultBuffer)}
+	done := make(chan error)
+	go func() {
+		done <- server.WatchNodeCredentials(&pb.WatchNodeCredentialsRequest{NodeName: "node-1"}, stream)
+	}()
+
+	update := receiveUntilSynced(t, stream.updates)["192.168.0.1"]
+	if update.GetCredentials().GetAccessKeyId() != "A1234" {
+		t.Error("expected default role's credentials, was", update)
+	}
+	if update.GetRoleCredentials()["writer"].GetAccessKeyId() != "A1234" {
+		t.Error("expected additional role's credentials, was", update)
+	}
+	if _, sent := update.GetRoleCredentials()["admin"]; sent || len(update.GetRoleCredentials()) != 1 {
+		t.Error("expected only permitted roles' credentials, was", update.GetRoleCredentials())
+	}
+
+	closeStream()
+	if err := <-done; err != nil {
+		t.Error("unexpected error:", err)
+	}
+}
+
+// roleCredentialsProvider issues credentials with each role's access key.
+type roleCredentialsProvider struct {
+	mu         sync.Mutex
+	accessKeys map[string]string
+}
+
+func (c *roleCredentialsProvider) CredentialsForRole(ctx context.Context, identity *sts.RoleIdentity) (*sts.Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &sts.Credentials{AccessKeyId: c.accessKeys[identity.Role.Name]}, nil
+}
+
+func (c *roleCredentialsProvider) refresh(role, accessKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.accessKeys[role] = accessKey
+}
+
+func TestSendsRefreshedCredentialsForAdditionalRoles(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	source.Add(podOnNode(testutil.NewPodWithRoles("ns", "reader", "192.168.0.1", "Running", "reader", "writer"), "node-1"))
+
+	podCache := k8s.NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Second, defaultBuffer)
+	podCache.Run(ctx)
+
+	provider := &roleCredentialsProvider{accessKeys: map[string]string{"reader": "A1234", "writer": "B1234"}}
+	server := &KiamServer{pods: podCache, assumePolicy: rolePolicy{"reader": true, "writer": true}, credentialsProvider: provider, arnResolver: sts.DefaultResolver("arn:account:")}
+	stream := &stubNodeCredentialsStream{ctx: ctx, updates: make(chan *pb.NodeCredentialsUpdate, defaultBuffer)}
+
+	sent := make(map[string]sentCredentials)
+	if err := server.syncNodeCredentials(ctx, "node-1", sent, stream); err != nil {
+		t.Fatal(err)
+	}
+	if update := <-stream.updates; update.GetRoleCredentials()["writer"].GetAccessKeyId() != "B1234" {
+		t.Fatal("expected additional role's credentials, was", update)
+	}
+
+	provider.refresh("writer", "B5678")
+	if err := server.syncNodeCredentials(ctx, "node-1", sent, stream); err != nil {
+		t.Fatal(err)
+	}
+	close(stream.updates)
+	update, sentAgain := <-stream.updates
+	if !sentAgain {
+		t.Fatal("expected update sent again when additional role's credentials were refreshed")
+	}
+	if update.GetCredentials().GetAccessKeyId() != "A1234" || update.GetRoleCredentials()["writer"].GetAccessKeyId() != "B5678" {
+		t.Error("expected refreshed credentials, was", update)
+	}
+}
+
+func TestRemovesNodeCredentialsForGonePods(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	source.Add(podOnNode(testutil.NewPodWithRole("ns", "reader", "192.168.0.1", "Running", "reader"), "node-1"))
+
+	podCache := k8s.NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Second, defaultBuffer)
+	podCache.Run(ctx)
+
+	server := &KiamServer{pods: podCache, assumePolicy: &forbidPolicy{}, arnResolver: sts.DefaultResolver("arn:account:")}
+	stream := &stubNodeCredentialsStream{ctx: ctx, updates: make(chan *pb.NodeCredentialsUpdate, defaultBuffer)}
+
+	sent := map[string]sentCredentials{"192.168.0.9": {role: "reader"}}
+	err := server.syncNodeCredentials(ctx, "node-1", sent, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	close(stream.updates)
+	updates := make([]*pb.NodeCredentialsUpdate, 0)
+	for update := range stream.updates {
+		updates = append(updates, update)
+	}
+	if len(updates) != 2 {
+		t.Fatal("expected 2 updates, was", updates)
+	}
+	if updates[0].Ip != "192.168.0.1" || updates[0].Role != "reader" || updates[0].Credentials != nil {
+		t.Error("expected forbidden role without credentials, was", updates[0])
+	}
+	if updates[1].Ip != "192.168.0.9" || !updates[1].Removed {
+		t.Error("expected gone pod to be removed, was", updates[1])
+	}
+	if _, found := sent["192.168.0.9"]; found {
+		t.Error("expected removed pod to be forgotten")
+	}
+}
+
+// concurrentCredentialsProvider issues credentials once the expected number
+// of calls are in flight together.
+type concurrentCredentialsProvider struct {
+	mu       sync.Mutex
+	expected int
+	inflight chan struct{}
+}
+
+func (c *concurrentCredentialsProvider) CredentialsForRole(ctx context.Context, identity *sts.RoleIdentity) (*sts.Credentials, error) {
+	c.mu.Lock()
+	c.expected--
+	if c.expected == 0 {
+		close(c.inflight)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-c.inflight:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return &sts.Credentials{AccessKeyId: "A1234"}, nil
+}
+
+func TestFetchesNodeCredentialsConcurrently(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	source.Add(podOnNode(testutil.NewPodWithRole("ns", "reader", "192.168.0.1", "Running", "reader"), "node-1"))
+	source.Add(podOnNode(testutil.NewPodWithRole("ns", "writer", "192.168.0.2", "Running", "writer"), "node-1"))
+
+	podCache := k8s.NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Second, defaultBuffer)
+	podCache.Run(ctx)
+
+	// each call waits for the other, so fetching one at a time would time out
+	credentialsProvider := &concurrentCredentialsProvider{expected: 2, inflight: make(chan struct{})}
+	server := &KiamServer{pods: podCache, assumePolicy: &allowPolicy{}, credentialsProvider: credentialsProvider, arnResolver: sts.DefaultResolver("arn:account:")}
+	stream := &stubNodeCredentialsStream{ctx: ctx, updates: make(chan *pb.NodeCredentialsUpdate, defaultBuffer)}
+
+	syncCtx, cancelSync := context.WithTimeout(ctx, 5*time.Second)
+	defer cancelSync()
+	err := server.syncNodeCredentials(syncCtx, "node-1", make(map[string]sentCredentials), stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	close(stream.updates)
+	for update := range stream.updates {
+		if update.GetCredentials().GetAccessKeyId() != "A1234" {
+			t.Error("expected credentials, was", update)
+		}
+	}
+}
+
+func TestDeniesWatchingOtherNodes(t *testing.T) {
+	server := &KiamServer{nodeWatchers: newNodeWatchers(), nodeIdentity: NodeIdentityConfig{Source: NodeIdentityCommonName}}
+
+	agentCtx := contextWithPeerCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "node-1"}})
+	stream := &stubNodeCredentialsStream{ctx: agentCtx, updates: make(chan *pb.NodeCredentialsUpdate, defaultBuffer)}
+
+	err := server.WatchNodeCredentials(&pb.WatchNodeCredentialsRequest{NodeName: "node-2"}, stream)
+	if err != ErrNodeMismatch {
+		t.Error("expected node mismatch, was", err)
+	}
+}
+
+func TestRequiresNodeIdentityToWatchNodeCredentials(t *testing.T) {
+	server := &KiamServer{nodeWatchers: newNodeWatchers()}
+
+	agentCtx := contextWithPeerCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "node-1"}})
+	stream := &stubNodeCredentialsStream{ctx: agentCtx, updates: make(chan *pb.NodeCredentialsUpdate, defaultBuffer)}
+
+	err := server.WatchNodeCredentials(&pb.WatchNodeCredentialsRequest{NodeName: "node-1"}, stream)
+	if err != ErrNodeIdentityRequired {
+		t.Error("expected node identity to be required, was", err)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"regexp"
+	"strings"
 
 	v1 "k8s.io/api/core/v1"
 
@@ -57,32 +58,43 @@ func Policies(p ...AssumeRolePolicy) *CompositeAssumeRolePolicy {
 	}
 }
 
-// RequestingAnnotatedRolePolicy ensures the pod is requesting the role that it's
-// currently annotated with.
+// RequestingAnnotatedRolePolicy ensures the pod is requesting one of the roles
+// that it's currently annotated with.
 type RequestingAnnotatedRolePolicy struct {
 	pods     k8s.PodGetter
 	resolver sts.ARNResolver
+	defaults *k8s.DefaultRoles
 }
 
 func NewRequestingAnnotatedRolePolicy(p k8s.PodGetter, resolver sts.ARNResolver) *RequestingAnnotatedRolePolicy {
 	return &RequestingAnnotatedRolePolicy{pods: p, resolver: resolver}
 }
 
+// WithDefaultRoles permits pods without a role annotation their default role.
+func (p *RequestingAnnotatedRolePolicy) WithDefaultRoles(defaults *k8s.DefaultRoles) *RequestingAnnotatedRolePolicy {
+	p.defaults = defaults
+	return p
+}
+
 func (p *RequestingAnnotatedRolePolicy) IsAllowedAssumeRole(ctx context.Context, role string, pod *v1.Pod) (Decision, error) {
-	annotatedIdentiy, err := p.resolver.Resolve(k8s.PodRole(pod))
-	if err != nil {
-		return nil, err
-	}
 	requestedIdentity, err := p.resolver.Resolve(role)
 	if err != nil {
 		return nil, err
 	}
 
-	if annotatedIdentiy.Equals(requestedIdentity) {
-		return &allowed{}, nil
+	var annotated []string
+	for _, annotatedRole := range p.defaults.PodRoles(pod) {
+		annotatedIdentity, err := p.resolver.Resolve(annotatedRole)
+		if err != nil {
+			return nil, err
+		}
+		if annotatedIdentity.Equals(requestedIdentity) {
+			return &allowed{}, nil
+		}
+		annotated = append(annotated, annotatedIdentity.Name)
 	}
 
-	return &forbidden{requested: role, annotated: annotatedIdentiy.Name}, nil
+	return &forbidden{requested: role, annotated: strings.Join(annotated, ", ")}, nil
 }
 
 // NamespacePermittedRoleNamePolicy ensures the pod is requesting a role that
@@ -18,6 +18,7 @@ import (
 	"testing"
 
 	"github.com/uswitch/kiam/pkg/aws/sts"
+	"github.com/uswitch/kiam/pkg/k8s"
 	kt "github.com/uswitch/kiam/pkg/k8s/testing"
 	"github.com/uswitch/kiam/pkg/testutil"
 	v1 "k8s.io/api/core/v1"
@@ -72,6 +73,59 @@ func TestRequestedRolePolicy(t *testing.T) {
 	}
 }
 
+func TestRequestedRolePolicyWithAdditionalRoles(t *testing.T) {
+	p := testutil.NewPodWithRoles("namespace", "name", "192.168.0.1", testutil.PhaseRunning, "myrole", "other, /third")
+	f := kt.NewStubFinder(p)
+
+	arnResolver := sts.DefaultResolver("arn:aws:iam::123456789012:role/")
+	policy := NewRequestingAnnotatedRolePolicy(f, arnResolver)
+	for _, role := range []string{"myrole", "other", "third"} {
+		decision, err := policy.IsAllowedAssumeRole(context.Background(), role, p)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if !decision.IsAllowed() {
+			t.Error("role was annotated, should have been permitted:", decision.Explanation())
+		}
+	}
+
+	decision, _ := policy.IsAllowedAssumeRole(context.Background(), "wrongrole", p)
+	if decision.IsAllowed() {
+		t.Error("role is different, should be denied", decision.Explanation())
+	}
+	if decision.Explanation() != "requested 'wrongrole' but annotated with 'myrole, other, third', forbidden" {
+		t.Error("unexpected explanation, was", decision.Explanation())
+	}
+}
+
+func TestRequestedRolePolicyWithDefaultRole(t *testing.T) {
+	p := testutil.NewPod("namespace", "name", "192.168.0.1", testutil.PhaseRunning)
+	f := kt.NewStubFinder(p)
+	namespace := testutil.NewNamespace("namespace", ".*")
+	namespace.Annotations[k8s.AnnotationDefaultRoleKey] = "reader"
+
+	arnResolver := sts.DefaultResolver("arn:aws:iam::123456789012:role/")
+	policy := NewRequestingAnnotatedRolePolicy(f, arnResolver)
+	decision, _ := policy.IsAllowedAssumeRole(context.Background(), "reader", p)
+	if decision.IsAllowed() {
+		t.Error("expected unannotated pod to be denied without default roles")
+	}
+
+	policy = NewRequestingAnnotatedRolePolicy(f, arnResolver).WithDefaultRoles(k8s.NewDefaultRoles(kt.NewNamespaceFinder(namespace), ""))
+	decision, err := policy.IsAllowedAssumeRole(context.Background(), "reader", p)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !decision.IsAllowed() {
+		t.Error("namespace default role should have been permitted:", decision.Explanation())
+	}
+
+	decision, _ = policy.IsAllowedAssumeRole(context.Background(), "writer", p)
+	if decision.IsAllowed() {
+		t.Error("role is different, should be denied", decision.Explanation())
+	}
+}
+
 func TestRequestedRolePolicyWithSlash(t *testing.T) {
 	arnResolver := sts.DefaultResolver("arn:aws:iam::123456789012:role/")
 	p := testutil.NewPodWithRole("namespace", "name", "192.168.0.1", testutil.PhaseRunning, "/myrole")
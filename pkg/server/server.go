@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -27,9 +28,12 @@ import (
 	"github.com/uswitch/kiam/pkg/prefetch"
 	pb "github.com/uswitch/kiam/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/record"
 )
 
@@ -38,18 +42,24 @@ type Config struct {
 	BindAddress                  string
 	KubeConfig                   string
 	PodSyncInterval              time.Duration
+	PodWaitTimeout               time.Duration
 	SessionName                  string
 	SessionDuration              time.Duration
 	SessionRefresh               time.Duration
 	RoleBaseARN                  string
 	AutoDetectBaseARN            bool
 	DisableStrictNamespaceRegexp bool
+	DefaultRole                  string
 	TLS                          TLSConfig
 	ParallelFetcherProcesses     int
 	PrefetchBufferSize           int
 	AssumeRoleArn                string
 	Region                       string
 	KeepaliveParams              keepalive.ServerParameters
+	LeaderElection               prefetch.LeaderElectionConfig
+	AdminIdentities              []string
+	NodeIdentity                 NodeIdentityConfig
+	ServiceAccountToken          ServiceAccountTokenConfig
 }
 
 // TLSConfig controls TLS
@@ -66,12 +76,22 @@ type KiamServer struct {
 	server              *grpc.Server
 	pods                *k8s.PodCache
 	namespaces          *k8s.NamespaceCache
+	defaultRoles        *k8s.DefaultRoles
 	eventRecorder       record.EventRecorder
 	manager             *prefetch.CredentialManager
+	leaderElection      prefetch.LeaderElectionConfig
+	kubeClient          kubernetes.Interface
 	credentialsProvider sts.CredentialsProvider
+	credentialsCache    sts.CredentialsCache
 	assumePolicy        AssumeRolePolicy
+	// builds the assume role policy with other Namespaces, to check policy
+	// before a Namespace changed
+	policyForNamespaces func(k8s.NamespaceFinder, *k8s.DefaultRoles) AssumeRolePolicy
 	parallelFetchers    int
 	arnResolver         sts.ARNResolver
+	nodeWatchers        *nodeWatchers
+	nodeIdentity        NodeIdentityConfig
+	tokenReviewer       TokenReviewer
 }
 
 func simplifyAWSErrorMessage(err error) string {
@@ -86,7 +106,12 @@ func simplifyAWSErrorMessage(err error) string {
 // GetPodCredentials returns credentials for the Pod, according to the role it's
 // annotated with. It will additionally check policy before returning credentials.
 func (k *KiamServer) GetPodCredentials(ctx context.Context, req *pb.GetPodCredentialsRequest) (*pb.Credentials, error) {
-	pod, err := k.pods.GetPodByIP(req.Ip)
+	agent, err := k.authenticateAgent(ctx, "GetPodCredentials")
+	if err != nil {
+		return nil, err
+	}
+
+	pod, err := k.findPod(ctx, req.Ip, req.PodUid)
 	if err != nil {
 		if err == k8s.ErrPodNotFound {
 			return nil, ErrPodNotFound
@@ -96,6 +121,11 @@ func (k *KiamServer) GetPodCredentials(ctx context.Context, req *pb.GetPodCreden
 	}
 	logger := log.WithFields(k8s.PodFields(pod)).WithField("pod.iam.requestedRole", req.Role)
 
+	err = k.authorizeAgent(agent, pod, "GetPodCredentials")
+	if err != nil {
+		return nil, err
+	}
+
 	decision, err := k.assumePolicy.IsAllowedAssumeRole(ctx, req.Role, pod)
 	if err != nil {
 		logger.Errorf("error checking policy: %s", err.Error())
@@ -128,22 +158,100 @@ func (k *KiamServer) GetPodCredentials(ctx context.Context, req *pb.GetPodCreden
 
 // GetHealth returns ok to allow a command to ensure the sever is operating well
 func (k *KiamServer) GetHealth(ctx context.Context, _ *pb.GetHealthRequest) (*pb.HealthStatus, error) {
+	// sidecar agents check health with their service account token
+	if _, err := peerCertificate(ctx); err != nil && k.tokenReviewer != nil {
+		if _, err := k.authenticateAgent(ctx, "GetHealth"); err != nil {
+			return nil, err
+		}
+	}
 	return &pb.HealthStatus{Message: "ok"}, nil
 }
 
 // GetPodRole determines which role a Pod is annotated with
 func (k *KiamServer) GetPodRole(ctx context.Context, req *pb.GetPodRoleRequest) (*pb.Role, error) {
 	logger := log.WithField("pod.ip", req.Ip)
-	pod, err := k.pods.GetPodByIP(req.Ip)
+	agent, err := k.authenticateAgent(ctx, "GetPodRole")
+	if err != nil {
+		return nil, err
+	}
+
+	pod, err := k.findPod(ctx, req.Ip, req.PodUid)
 	if err != nil {
 		logger.Errorf("error finding pod: %s", err.Error())
 		return nil, err
 	}
 
-	role := k8s.PodRole(pod)
+	err = k.authorizeAgent(agent, pod, "GetPodRole")
+	if err != nil {
+		return nil, err
+	}
+
+	role := k.defaultRoles.PodRole(pod)
 
 	logger.WithField("pod.iam.role", role).Infof("found role")
-	return &pb.Role{Name: role}, nil
+	namespace := k.podNamespace(ctx, pod)
+	return &pb.Role{
+		Name:                      role,
+		Roles:                     k.defaultRoles.PodRoles(pod),
+		Arn:                       k.roleARN(role),
+		NamespaceAllowRouteRegexp: k8s.NamespaceAllowRouteRegexp(namespace),
+		PodAllowRouteRegexp:       k8s.PodAllowRouteRegexp(pod),
+		Region:                    k8s.PodRegion(pod, namespace),
+	}, nil
+}
+
+// podNamespace returns the Pod's Namespace, whose annotations the agent uses
+// for Pods without their own, or nil when it can't be found.
+func (k *KiamServer) podNamespace(ctx context.Context, pod *v1.Pod) *v1.Namespace {
+	if k.namespaces == nil {
+		return nil
+	}
+	namespace, err := k.namespaces.FindNamespace(ctx, pod.GetNamespace())
+	if err != nil {
+		log.WithFields(k8s.PodFields(pod)).Errorf("error finding namespace: %s", err.Error())
+	}
+	return namespace
+}
+
+// roleARN returns the role's ARN, or an empty string when it can't be
+// resolved.
+func (k *KiamServer) roleARN(role string) string {
+	if role == "" || k.arnResolver == nil {
+		return ""
+	}
+	resolved, err := k.arnResolver.Resolve(role)
+	if err != nil {
+		log.WithField("pod.iam.role", role).Warnf("error resolving role arn: %s", err.Error())
+		return ""
+	}
+	return resolved.ARN
+}
+
+// findPod returns the pod with the ip or, when the agent identified it, the
+// uid. hostNetwork pods share their node's ip, so are only told apart by uid.
+// Pods the agent identified by their service account token, rather than a
+// connection, are found by uid alone. Pods that aren't cached yet are waited
+// for within the request's deadline.
+func (k *KiamServer) findPod(ctx context.Context, ip, uid string) (*v1.Pod, error) {
+	if uid == "" {
+		return k.pods.WaitForPodByIP(ctx, ip)
+	}
+
+	pod, err := k.pods.WaitForPodByUID(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	if ip == "" {
+		return pod, nil
+	}
+	// the pod must have the ip the request came from
+	for _, podIP := range k8s.PodIPs(pod) {
+		if podIP == ip {
+			return pod, nil
+		}
+	}
+	log.WithFields(k8s.PodFields(pod)).WithField("pod.ip", ip).Warnf("pod identified by uid doesn't have the request's ip")
+	return nil, k8s.ErrPodNotFound
 }
 
 func translateCredentialsToProto(credentials *sts.Credentials) *pb.Credentials {
@@ -160,15 +268,25 @@ func translateCredentialsToProto(credentials *sts.Credentials) *pb.Credentials {
 
 // Serve starts the server, starting all components and listening for gRPC
 func (k *KiamServer) Serve(ctx context.Context) {
-	k.manager.Run(ctx, k.parallelFetchers)
-	err := k.pods.Run(ctx)
-	if err != nil {
-		log.Fatalf("error starting pod cache: %s", err)
+	if k.leaderElection.Enabled {
+		err := k.manager.RunElected(ctx, k.kubeClient, k.leaderElection, k.parallelFetchers)
+		if err != nil {
+			log.Fatalf("error starting prefetch leader election: %s", err)
+		}
+	} else {
+		k.manager.Run(ctx, k.parallelFetchers)
 	}
-	err = k.namespaces.Run(ctx)
+	// namespaces are synced first so that pods are indexed by their default role
+	err := k.namespaces.Run(ctx)
 	if err != nil {
 		log.Fatalf("error starting namespace cache: %s", err)
 	}
+	err = k.pods.Run(ctx)
+	if err != nil {
+		log.Fatalf("error starting pod cache: %s", err)
+	}
+	go k.watchNamespacePolicy(ctx)
+	go k.watchNodeChanges(ctx)
 	log.Infof("listening")
 	k.server.Serve(k.listener)
 }
@@ -182,6 +300,99 @@ func (k *KiamServer) Stop() {
 	}
 }
 
+// agentIdentity identifies the agent making a request: agents with a client
+// certificate by their nodes, when agents are bound to their nodes, and sidecar
+// agents by the pod their service account token was issued for.
+type agentIdentity struct {
+	nodeNames []string
+	podUID    string
+	sidecar   bool
+}
+
+// authenticateAgent identifies the requesting agent. Agents are identified
+// before their pods are looked up, so unauthenticated requests don't wait for
+// pods.
+func (k *KiamServer) authenticateAgent(ctx context.Context, rpc string) (*agentIdentity, error) {
+	if _, err := peerCertificate(ctx); err == nil || k.tokenReviewer == nil {
+		if !k.nodeIdentity.Enabled() {
+			return &agentIdentity{}, nil
+		}
+		nodeNames, err := k.agentNodeNames(ctx, rpc)
+		if err != nil {
+			return nil, err
+		}
+		return &agentIdentity{nodeNames: nodeNames}, nil
+	}
+
+	token, err := bearerToken(ctx)
+	if err != nil {
+		serviceAccountDenied.WithLabelValues(rpc).Inc()
+		log.Warnf("denied %s without a client certificate or token", rpc)
+		return nil, status.Error(codes.Unauthenticated, "client certificate or service account token required")
+	}
+	uid, err := k.tokenReviewer.PodUID(ctx, token)
+	if err != nil {
+		serviceAccountDenied.WithLabelValues(rpc).Inc()
+		log.Warnf("denied %s, error reviewing service account token: %s", rpc, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return &agentIdentity{podUID: uid, sidecar: true}, nil
+}
+
+// authorizeAgent checks the requesting agent can act for the pod: agents with a
+// client certificate are checked against their node, and sidecar agents
+// against the pod their service account token was issued for.
+func (k *KiamServer) authorizeAgent(agent *agentIdentity, pod *v1.Pod, rpc string) error {
+	if agent.sidecar {
+		return k.authorizeServiceAccount(agent, pod, rpc)
+	}
+	return k.authorizeNode(agent, pod, rpc)
+}
+
+// authorizeServiceAccount checks the pod is the one the requesting sidecar
+// agent's service account token was issued for.
+func (k *KiamServer) authorizeServiceAccount(agent *agentIdentity, pod *v1.Pod, rpc string) error {
+	if agent.podUID == string(pod.UID) {
+		return nil
+	}
+
+	serviceAccountDenied.WithLabelValues(rpc).Inc()
+	log.WithFields(k8s.PodFields(pod)).WithField("agent.pod.uid", agent.podUID).Warnf("denied %s for pod not identified by token", rpc)
+	k.recordEvent(pod, v1.EventTypeWarning, "KiamServiceAccountMismatch", fmt.Sprintf("sidecar agent in pod %q denied %s for this pod", agent.podUID, rpc))
+	return ErrServiceAccountMismatch
+}
+
+// authorizeNode checks the pod is on the node identified by the requesting
+// agent's client certificate, when agents are bound to their nodes.
+func (k *KiamServer) authorizeNode(agent *agentIdentity, pod *v1.Pod, rpc string) error {
+	if !k.nodeIdentity.Enabled() {
+		return nil
+	}
+
+	for _, nodeName := range agent.nodeNames {
+		if nodeName == pod.Spec.NodeName {
+			return nil
+		}
+	}
+
+	nodeMismatch.WithLabelValues(rpc).Inc()
+	log.WithFields(k8s.PodFields(pod)).WithField("agent.nodes", agent.nodeNames).Warnf("denied %s for pod on another node", rpc)
+	k.recordEvent(pod, v1.EventTypeWarning, "KiamNodeMismatch", fmt.Sprintf("agent on node %q denied %s for pod on node %q", strings.Join(agent.nodeNames, ","), rpc, pod.Spec.NodeName))
+	return ErrNodeMismatch
+}
+
+// agentNodeNames returns the nodes identified by the requesting agent's client
+// certificate.
+func (k *KiamServer) agentNodeNames(ctx context.Context, rpc string) ([]string, error) {
+	nodeNames, err := peerNodeNames(ctx, k.nodeIdentity)
+	if err != nil {
+		nodeMismatch.WithLabelValues(rpc).Inc()
+		log.Warnf("denied %s, error identifying agent's node: %s", rpc, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return nodeNames, nil
+}
+
 func (k *KiamServer) recordEvent(object runtime.Object, eventtype, reason, message string) {
 	if k.eventRecorder == nil {
 		return
@@ -45,9 +45,11 @@ type KiamServerBuilder struct {
 	podCache             *k8s.PodCache
 	namespaceCache       *k8s.NamespaceCache
 	eventRecorder        record.EventRecorder
+	kubeClient           kubernetes.Interface
 	transportCredentials credentials.TransportCredentials
 	tlsConfig            *dynamicTLSConfig
 	grpcServer           *grpc.Server
+	tokenReviewer        TokenReviewer
 }
 
 func NewKiamServerBuilder(c *Config) *KiamServerBuilder {
@@ -123,12 +125,14 @@ func (b *KiamServerBuilder) WithKubernetesClient() (*KiamServerBuilder, error) {
 		return nil, err
 	}
 
-	podCache := k8s.NewPodCache(arnResolver, k8s.NewListWatch(client, k8s.ResourcePods), b.config.PodSyncInterval, b.config.PrefetchBufferSize)
+	podCache := k8s.NewPodCache(arnResolver, k8s.NewListWatch(client, k8s.ResourcePods), b.config.PodSyncInterval, b.config.PrefetchBufferSize).
+		WithPodWait(b.config.PodWaitTimeout, client)
 	nsCache := k8s.NewNamespaceCache(k8s.NewListWatch(client, k8s.ResourceNamespaces), time.Minute)
 
 	b.WithCaches(podCache, nsCache)
 
 	b.eventRecorder = eventRecorder(client)
+	b.kubeClient = client
 
 	return b, nil
 }
@@ -155,34 +159,64 @@ func (b *KiamServerBuilder) WithTLS() (*KiamServerBuilder, error) {
 		}
 	}()
 
-	creds, err := advancedtls.NewServerCreds(&advancedtls.ServerOptions{
-		GetCertificate: func(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
-			return tlsConfig.LoadCert(), nil
-		},
-		RootCertificateOptions: advancedtls.RootCertificateOptions{
-			GetRootCAs: func(_ *advancedtls.GetRootCAsParams) (*advancedtls.GetRootCAsResults, error) {
-				return &advancedtls.GetRootCAsResults{TrustCerts: tlsConfig.LoadCACerts()}, nil
+	var creds credentials.TransportCredentials
+	if b.config.ServiceAccountToken.Enabled() {
+		// sidecar agents authenticate with their service account token, so
+		// client certificates are verified when sent but not required
+		creds = credentials.NewTLS(&tls.Config{
+			GetConfigForClient: func(_ *tls.ClientHelloInfo) (*tls.Config, error) {
+				return &tls.Config{
+					Certificates: []tls.Certificate{*tlsConfig.LoadCert()},
+					ClientCAs:    tlsConfig.LoadCACerts(),
+					ClientAuth:   tls.VerifyClientCertIfGiven,
+					NextProtos:   []string{"h2"},
+				}, nil
 			},
-		},
-		RequireClientCert: true,
-	})
-	if err != nil {
-		return nil, err
+		})
+	} else {
+		creds, err = advancedtls.NewServerCreds(&advancedtls.ServerOptions{
+			GetCertificate: func(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return tlsConfig.LoadCert(), nil
+			},
+			RootCertificateOptions: advancedtls.RootCertificateOptions{
+				GetRootCAs: func(_ *advancedtls.GetRootCAsParams) (*advancedtls.GetRootCAsResults, error) {
+					return &advancedtls.GetRootCAsResults{TrustCerts: tlsConfig.LoadCACerts()}, nil
+				},
+			},
+			RequireClientCert: true,
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	b.transportCredentials = creds
 	b.tlsConfig = tlsConfig
 
+	streamInterceptors := []grpc.StreamServerInterceptor{grpc_prometheus.StreamServerInterceptor}
+	unaryInterceptors := []grpc.UnaryServerInterceptor{grpc_prometheus.UnaryServerInterceptor}
+	if b.config.ServiceAccountToken.Enabled() {
+		streamInterceptors = append(streamInterceptors, requireClientCertificateStream)
+		unaryInterceptors = append(unaryInterceptors, requireClientCertificateUnary)
+	}
+
 	b.grpcServer = grpc.NewServer(
 		grpc.Creds(b.transportCredentials),
-		grpc.StreamInterceptor(grpc_prometheus.StreamServerInterceptor),
-		grpc.UnaryInterceptor(grpc_prometheus.UnaryServerInterceptor),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
 		grpc.KeepaliveParams(b.config.KeepaliveParams),
 	)
 
 	return b, nil
 }
 
+// WithTokenReviewer controls how sidecar agents' service account tokens are
+// reviewed. Defaults to the Kubernetes TokenReview API.
+func (b *KiamServerBuilder) WithTokenReviewer(reviewer TokenReviewer) *KiamServerBuilder {
+	b.tokenReviewer = reviewer
+	return b
+}
+
 // WithGRPCServer controls the gRPC Server that the KiamServer will use to listen.
 func (b *KiamServerBuilder) WithGRPCServer(server *grpc.Server) *KiamServerBuilder {
 	b.grpcServer = server
@@ -190,6 +224,17 @@ func (b *KiamServerBuilder) WithGRPCServer(server *grpc.Server) *KiamServerBuild
 }
 
 func (b *KiamServerBuilder) Build() (*KiamServer, error) {
+	if b.config.LeaderElection.Enabled && b.kubeClient == nil {
+		return nil, fmt.Errorf("prefetch leader election requires a Kubernetes client")
+	}
+
+	if b.config.ServiceAccountToken.Enabled() && b.tokenReviewer == nil {
+		if b.kubeClient == nil {
+			return nil, fmt.Errorf("service account token authentication requires a Kubernetes client")
+		}
+		b.tokenReviewer = NewKubernetesTokenReviewer(b.kubeClient, b.config.ServiceAccountToken.Audience)
+	}
+
 	arnResolver, err := newRoleARNResolver(b.config)
 	if err != nil {
 		return nil, err
@@ -202,27 +247,48 @@ func (b *KiamServerBuilder) Build() (*KiamServer, error) {
 		b.config.SessionRefresh,
 	)
 
+	defaultRoles := k8s.NewDefaultRoles(b.namespaceCache, b.config.DefaultRole)
+	b.podCache.WithDefaultRoles(defaultRoles)
+
 	listener, err := net.Listen("tcp", b.config.BindAddress)
 	if err != nil {
 		return nil, err
 	}
 
+	policyForNamespaces := func(namespaces k8s.NamespaceFinder, defaults *k8s.DefaultRoles) AssumeRolePolicy {
+		return Policies(
+			NewRequestingAnnotatedRolePolicy(b.podCache, arnResolver).WithDefaultRoles(defaults),
+			NewNamespacePermittedRoleNamePolicy(!b.config.DisableStrictNamespaceRegexp, namespaces, arnResolver),
+		)
+	}
+
 	srv := &KiamServer{
 		tlsConfig:           b.tlsConfig,
 		listener:            listener,
 		server:              b.grpcServer,
 		pods:                b.podCache,
 		namespaces:          b.namespaceCache,
+		defaultRoles:        defaultRoles,
 		eventRecorder:       b.eventRecorder,
-		manager:             prefetch.NewManager(credentialsCache, b.podCache, arnResolver),
+		leaderElection:      b.config.LeaderElection,
+		kubeClient:          b.kubeClient,
 		credentialsProvider: credentialsCache,
-		assumePolicy: Policies(
-			NewRequestingAnnotatedRolePolicy(b.podCache, arnResolver),
-			NewNamespacePermittedRoleNamePolicy(!b.config.DisableStrictNamespaceRegexp, b.namespaceCache, arnResolver),
-		),
-		parallelFetchers: b.config.ParallelFetcherProcesses,
-		arnResolver:      arnResolver,
+		credentialsCache:    credentialsCache,
+		assumePolicy:        policyForNamespaces(b.namespaceCache, defaultRoles),
+		policyForNamespaces: policyForNamespaces,
+		parallelFetchers:    b.config.ParallelFetcherProcesses,
+		arnResolver:         arnResolver,
+		nodeWatchers:        newNodeWatchers(),
+		nodeIdentity:        b.config.NodeIdentity,
+		tokenReviewer:       b.tokenReviewer,
 	}
+	srv.manager = prefetch.NewManager(credentialsCache, b.podCache, arnResolver).WithPodFilter(srv.isPrefetchAllowed).WithDefaultRoles(defaultRoles)
 	pb.RegisterKiamServiceServer(b.grpcServer, srv)
+
+	if len(b.config.AdminIdentities) > 0 {
+		admin := NewAdminServer(b.config.AdminIdentities, credentialsCache, b.podCache, arnResolver).WithDefaultRoles(defaultRoles)
+		pb.RegisterKiamAdminServiceServer(b.grpcServer, admin)
+	}
+
 	return srv, nil
 }
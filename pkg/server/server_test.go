@@ -2,7 +2,10 @@ package server
 
 import (
 	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,7 +17,11 @@ import (
 	"github.com/uswitch/kiam/pkg/k8s"
 	"github.com/uswitch/kiam/pkg/testutil"
 	pb "github.com/uswitch/kiam/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/types"
 	kt "k8s.io/client-go/tools/cache/testing"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
@@ -91,6 +98,108 @@ func TestReturnsAnnotatedPodRole(t *testing.T) {
 	if r.GetName() != "running_role" {
 		t.Error("expected running_role, was", r.GetName())
 	}
+	if len(r.GetRoles()) != 1 || r.GetRoles()[0] != "running_role" {
+		t.Error("expected only running_role, was", r.GetRoles())
+	}
+}
+
+func TestReturnsAdditionalPodRoles(t *testing.T) {
+	defer leaktest.Check(t)()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	source.Add(testutil.NewPodWithRoles("ns", "name", "192.168.0.1", "Running", "running_role", "other_role,running_role"))
+
+	podCache := k8s.NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Second, defaultBuffer)
+	podCache.Run(ctx)
+
+	server := &KiamServer{pods: podCache, assumePolicy: &allowPolicy{}, credentialsProvider: &stubCredentialsProvider{accessKey: "A1234"}}
+
+	r, err := server.GetPodRole(ctx, &pb.GetPodRoleRequest{Ip: "192.168.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.GetName() != "running_role" {
+		t.Error("expected running_role as default, was", r.GetName())
+	}
+	expected := []string{"running_role", "other_role"}
+	if len(r.GetRoles()) != len(expected) || r.GetRoles()[0] != expected[0] || r.GetRoles()[1] != expected[1] {
+		t.Error("unexpected roles, was", r.GetRoles())
+	}
+}
+
+func TestReturnsPodMetadataOverrides(t *testing.T) {
+	defer leaktest.Check(t)()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	pod := testutil.NewPodWithRole("ns", "name", "192.168.0.1", "Running", "running_role")
+	pod.Annotations[k8s.AnnotationAllowRouteRegexpKey] = "^/latest/user-data"
+	pod.Annotations[k8s.AnnotationRegionKey] = "eu-west-1"
+	source.Add(pod)
+
+	podCache := k8s.NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Second, defaultBuffer)
+	podCache.Run(ctx)
+
+	server := &KiamServer{pods: podCache, assumePolicy: &allowPolicy{}, credentialsProvider: &stubCredentialsProvider{accessKey: "A1234"}, arnResolver: sts.DefaultResolver("arn:aws:iam::123456789012:role/")}
+
+	r, err := server.GetPodRole(ctx, &pb.GetPodRoleRequest{Ip: "192.168.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.GetPodAllowRouteRegexp() != "^/latest/user-data" || r.GetNamespaceAllowRouteRegexp() != "" {
+		t.Errorf("expected pod's annotated routes, was %q and %q", r.GetNamespaceAllowRouteRegexp(), r.GetPodAllowRouteRegexp())
+	}
+	if r.GetRegion() != "eu-west-1" {
+		t.Error("expected pod's annotated region, was", r.GetRegion())
+	}
+	if r.GetArn() != "arn:aws:iam::123456789012:role/running_role" {
+		t.Error("expected resolved role arn, was", r.GetArn())
+	}
+}
+
+func TestReturnsDefaultRoleForUnannotatedPod(t *testing.T) {
+	defer leaktest.Check(t)()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	source.Add(testutil.NewPod("ns", "name", "192.168.0.1", "Running"))
+
+	podCache := k8s.NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Second, defaultBuffer)
+	podCache.Run(ctx)
+
+	namespace := testutil.NewNamespace("ns", ".*")
+	namespace.Annotations[k8s.AnnotationDefaultRoleKey] = "namespace_role"
+	server := &KiamServer{pods: podCache, assumePolicy: &allowPolicy{}, credentialsProvider: &stubCredentialsProvider{accessKey: "A1234"}}
+
+	r, _ := server.GetPodRole(ctx, &pb.GetPodRoleRequest{Ip: "192.168.0.1"})
+	if r.GetName() != "" {
+		t.Error("expected no role without defaults, was", r.GetName())
+	}
+
+	server.defaultRoles = k8s.NewDefaultRoles(stubNamespaceFinder{"ns": namespace}, "cluster_role")
+	r, _ = server.GetPodRole(ctx, &pb.GetPodRoleRequest{Ip: "192.168.0.1"})
+	if r.GetName() != "namespace_role" {
+		t.Error("expected namespace_role, was", r.GetName())
+	}
+
+	server.defaultRoles = k8s.NewDefaultRoles(stubNamespaceFinder{}, "cluster_role")
+	r, _ = server.GetPodRole(ctx, &pb.GetPodRoleRequest{Ip: "192.168.0.1"})
+	if r.GetName() != "cluster_role" {
+		t.Error("expected cluster_role, was", r.GetName())
+	}
+}
+
+type stubNamespaceFinder map[string]*v1.Namespace
+
+func (f stubNamespaceFinder) FindNamespace(_ context.Context, name string) (*v1.Namespace, error) {
+	return f[name], nil
 }
 
 func TestReturnsErrorFromGetPodRoleWhenPodNotFound(t *testing.T) {
@@ -215,6 +324,128 @@ func (c *stubCredentialsProvider) CredentialsForRole(ctx context.Context, identi
 	}, nil
 }
 
+func TestEvictsForbiddenRolesWhenNamespacePolicyChanges(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	pod := testutil.NewPodWithRole("ns", "name", "192.168.0.1", "Running", "running_role")
+	pod.Spec.NodeName = "node-1"
+	source.Add(pod)
+	source.Add(testutil.NewPodWithRole("ns", "completed", "192.168.0.2", "Succeeded", "completed_role"))
+	source.Add(testutil.NewPod("ns", "unannotated", "192.168.0.3", "Running"))
+
+	podCache := k8s.NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Second, defaultBuffer)
+	podCache.Run(ctx)
+	credentialsCache := testutil.NewStubCredentialsCache(func(identity *sts.RoleIdentity) (*sts.Credentials, error) {
+		return &sts.Credentials{}, nil
+	})
+	recorder := record.NewFakeRecorder(defaultBuffer)
+	server := &KiamServer{pods: podCache, assumePolicy: &forbidPolicy{}, credentialsCache: credentialsCache, eventRecorder: recorder, arnResolver: sts.DefaultResolver("prefix"), nodeWatchers: newNodeWatchers()}
+	nodeChanges, unsubscribe := server.nodeWatchers.subscribe("node-1")
+	defer unsubscribe()
+
+	server.reevaluateNamespace(ctx, "ns", nil)
+
+	select {
+	case <-nodeChanges:
+	default:
+		t.Error("expected agents watching the pod's node to be notified")
+	}
+
+	select {
+	case identity := <-credentialsCache.Evicted():
+		if identity.Role.Name != "running_role" {
+			t.Error("unexpected role evicted", identity.Role.Name)
+		}
+	default:
+		t.Error("expected forbidden role to be evicted")
+	}
+
+	if len(recorder.Events) != 1 {
+		t.Fatal("expected a single warning event, was", len(recorder.Events))
+	}
+	event := <-recorder.Events
+	if !strings.HasPrefix(event, "Warning KiamRoleForbidden") {
+		t.Error("unexpected event", event)
+	}
+}
+
+func TestOnlyWarnsPodsNewlyForbiddenWhenNamespacePolicyChanges(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	source.Add(testutil.NewPodWithRole("ns", "allowed", "192.168.0.1", "Running", "running_role"))
+	source.Add(testutil.NewPodWithRole("ns", "forbidden", "192.168.0.2", "Running", "forbidden_role"))
+
+	podCache := k8s.NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Second, defaultBuffer)
+	podCache.Run(ctx)
+	credentialsCache := testutil.NewStubCredentialsCache(func(identity *sts.RoleIdentity) (*sts.Credentials, error) {
+		return &sts.Credentials{}, nil
+	})
+	recorder := record.NewFakeRecorder(defaultBuffer)
+	server := &KiamServer{pods: podCache, assumePolicy: &forbidPolicy{}, credentialsCache: credentialsCache, eventRecorder: recorder, arnResolver: sts.DefaultResolver("prefix"), nodeWatchers: newNodeWatchers()}
+	server.policyForNamespaces = func(namespaces k8s.NamespaceFinder, defaults *k8s.DefaultRoles) AssumeRolePolicy {
+		return NewNamespacePermittedRoleNamePolicy(true, namespaces, server.arnResolver)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.reevaluateNamespace(ctx, "ns", testutil.NewNamespace("ns", ".*running_role"))
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-credentialsCache.Evicted():
+		case <-time.After(time.Second):
+			t.Fatal("expected both forbidden roles to be evicted, was", i)
+		}
+	}
+	<-done
+
+	if len(recorder.Events) != 1 {
+		t.Fatal("expected a single warning event, was", len(recorder.Events))
+	}
+	event := <-recorder.Events
+	if !strings.Contains(event, "running_role") {
+		t.Error("expected warning for the previously allowed pod, was", event)
+	}
+}
+
+func TestDoesNotEvictAllowedRolesWhenNamespacePolicyChanges(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	source.Add(testutil.NewPodWithRole("ns", "name", "192.168.0.1", "Running", "running_role"))
+
+	podCache := k8s.NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Second, defaultBuffer)
+	podCache.Run(ctx)
+	credentialsCache := testutil.NewStubCredentialsCache(func(identity *sts.RoleIdentity) (*sts.Credentials, error) {
+		return &sts.Credentials{}, nil
+	})
+	server := &KiamServer{pods: podCache, assumePolicy: &allowPolicy{}, credentialsCache: credentialsCache, arnResolver: sts.DefaultResolver("prefix"), nodeWatchers: newNodeWatchers()}
+
+	server.reevaluateNamespace(ctx, "ns", nil)
+
+	select {
+	case identity := <-credentialsCache.Evicted():
+		t.Error("didn't expect allowed role to be evicted", identity.Role.Name)
+	default:
+	}
+}
+
 type forbidPolicy struct {
 }
 
@@ -241,3 +472,143 @@ func (d *decision) IsAllowed() bool {
 func (d *decision) Explanation() string {
 	return d.explanation
 }
+
+func TestDeniesPodsOnOtherNodes(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	source.Add(podOnNode(testutil.NewPodWithRole("ns", "name", "192.168.0.1", "Running", "running_role"), "node-2"))
+
+	podCache := k8s.NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Second, defaultBuffer)
+	podCache.Run(ctx)
+	recorder := record.NewFakeRecorder(defaultBuffer)
+	server := &KiamServer{pods: podCache, assumePolicy: &allowPolicy{}, credentialsProvider: &stubCredentialsProvider{accessKey: "A1234"}, arnResolver: sts.DefaultResolver("prefix"), eventRecorder: recorder, nodeIdentity: NodeIdentityConfig{Source: NodeIdentityCommonName}}
+
+	agentCtx := contextWithPeerCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "node-1"}})
+
+	_, err := server.GetPodCredentials(agentCtx, &pb.GetPodCredentialsRequest{Ip: "192.168.0.1", Role: "running_role"})
+	if err != ErrNodeMismatch {
+		t.Error("expected node mismatch, was", err)
+	}
+	_, err = server.GetPodRole(agentCtx, &pb.GetPodRoleRequest{Ip: "192.168.0.1"})
+	if err != ErrNodeMismatch {
+		t.Error("expected node mismatch, was", err)
+	}
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, "KiamNodeMismatch") {
+			t.Error("unexpected event:", event)
+		}
+	default:
+		t.Error("expected event recorded for pod")
+	}
+
+	_, err = server.GetPodRole(context.Background(), &pb.GetPodRoleRequest{Ip: "192.168.0.1"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Error("expected unauthenticated without a client certificate, was", err)
+	}
+}
+
+func TestReturnsCredentialsForPodsOnAgentsNode(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	source.Add(podOnNode(testutil.NewPodWithRole("ns", "name", "192.168.0.1", "Running", "running_role"), "node-1"))
+
+	podCache := k8s.NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Second, defaultBuffer)
+	podCache.Run(ctx)
+	server := &KiamServer{pods: podCache, assumePolicy: &allowPolicy{}, credentialsProvider: &stubCredentialsProvider{accessKey: "A1234"}, arnResolver: sts.DefaultResolver("prefix"), nodeIdentity: NodeIdentityConfig{Source: NodeIdentityDNSName, Prefix: "kiam-agent."}}
+
+	agentCtx := contextWithPeerCertificate(&x509.Certificate{DNSNames: []string{"node-2", "kiam-agent.node-1"}})
+
+	creds, err := server.GetPodCredentials(agentCtx, &pb.GetPodCredentialsRequest{Ip: "192.168.0.1", Role: "running_role"})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if creds.AccessKeyId != "A1234" {
+		t.Error("unexpected credentials", creds)
+	}
+}
+
+func hostNetworkPod(name, uid, role string) *v1.Pod {
+	pod := testutil.NewPodWithRole("ns", name, "10.0.0.1", "Running", role)
+	pod.UID = types.UID(uid)
+	pod.Spec.HostNetwork = true
+	return pod
+}
+
+func TestFindsHostNetworkPodsByUID(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	source.Add(hostNetworkPod("first", "uid-1", "first_role"))
+	source.Add(hostNetworkPod("second", "uid-2", "second_role"))
+
+	podCache := k8s.NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Second, defaultBuffer)
+	podCache.Run(ctx)
+	server := &KiamServer{pods: podCache, assumePolicy: &allowPolicy{}, credentialsProvider: &stubCredentialsProvider{accessKey: "A1234"}, arnResolver: sts.DefaultResolver("prefix")}
+
+	_, err := server.GetPodRole(ctx, &pb.GetPodRoleRequest{Ip: "10.0.0.1"})
+	if err != k8s.ErrMultipleRunningPods {
+		t.Error("expected multiple pods for the ip, was", err)
+	}
+
+	role, err := server.GetPodRole(ctx, &pb.GetPodRoleRequest{Ip: "10.0.0.1", PodUid: "uid-2"})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if role.GetName() != "second_role" {
+		t.Error("unexpected role", role.GetName())
+	}
+
+	_, err = server.GetPodCredentials(ctx, &pb.GetPodCredentialsRequest{Ip: "10.0.0.1", PodUid: "uid-1", Role: "first_role"})
+	if err != nil {
+		t.Error("unexpected error", err)
+	}
+
+	_, err = server.GetPodCredentials(ctx, &pb.GetPodCredentialsRequest{Ip: "10.0.0.2", PodUid: "uid-1", Role: "first_role"})
+	if err != ErrPodNotFound {
+		t.Error("expected pod not found for another ip, was", err)
+	}
+}
+
+func TestFindsPodsByUIDWithoutIP(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	source.Add(hostNetworkPod("first", "uid-1", "first_role"))
+
+	podCache := k8s.NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Second, defaultBuffer)
+	podCache.Run(ctx)
+	server := &KiamServer{pods: podCache, assumePolicy: &allowPolicy{}, credentialsProvider: &stubCredentialsProvider{accessKey: "A1234"}, arnResolver: sts.DefaultResolver("prefix")}
+
+	role, err := server.GetPodRole(ctx, &pb.GetPodRoleRequest{PodUid: "uid-1"})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if role.GetName() != "first_role" {
+		t.Error("unexpected role", role.GetName())
+	}
+
+	_, err = server.GetPodRole(ctx, &pb.GetPodRoleRequest{PodUid: "uid-2"})
+	if err != k8s.ErrPodNotFound {
+		t.Error("expected pod not found, was", err)
+	}
+}
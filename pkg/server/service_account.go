@@ -0,0 +1,182 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	authv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// podUIDExtra is the user info extra holding the UID of the pod a bound
+	// service account token was issued for.
+	podUIDExtra = "authentication.kubernetes.io/pod-uid"
+
+	// tokenReviewTTL is how long successful token reviews are reused, so
+	// that each request doesn't need a call to the API server.
+	tokenReviewTTL = time.Minute
+	tokenReviewMax = 4096
+)
+
+// ServiceAccountTokenConfig controls whether agents running as a sidecar, without
+// a client certificate, can authenticate with their pod's service account
+// token.
+type ServiceAccountTokenConfig struct {
+	// Audience the tokens must be issued for, such as kiam. Empty disables
+	// token authentication.
+	Audience string
+}
+
+// Enabled returns whether agents can authenticate with tokens.
+func (c ServiceAccountTokenConfig) Enabled() bool {
+	return c.Audience != ""
+}
+
+// TokenReviewer returns the UID of the pod a service account token was issued
+// for.
+type TokenReviewer interface {
+	PodUID(ctx context.Context, token string) (string, error)
+}
+
+// KubernetesTokenReviewer reviews tokens with the Kubernetes TokenReview API,
+// caching successful reviews.
+type KubernetesTokenReviewer struct {
+	client   kubernetes.Interface
+	audience string
+	reviews  *cache.LRUExpireCache
+}
+
+func NewKubernetesTokenReviewer(client kubernetes.Interface, audience string) *KubernetesTokenReviewer {
+	return &KubernetesTokenReviewer{
+		client:   client,
+		audience: audience,
+		reviews:  cache.NewLRUExpireCache(tokenReviewMax),
+	}
+}
+
+func (r *KubernetesTokenReviewer) PodUID(ctx context.Context, token string) (string, error) {
+	// reviews are cached by the token's hash, as tokens are credentials
+	key := sha256.Sum256([]byte(token))
+	if uid, found := r.reviews.Get(key); found {
+		return uid.(string), nil
+	}
+
+	review, err := r.client.AuthenticationV1().TokenReviews().Create(ctx, &authv1.TokenReview{
+		Spec: authv1.TokenReviewSpec{Token: token, Audiences: []string{r.audience}},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error reviewing token: %s", err.Error())
+	}
+	if !review.Status.Authenticated {
+		return "", fmt.Errorf("token not authenticated: %s", review.Status.Error)
+	}
+	if !containsString(review.Status.Audiences, r.audience) {
+		return "", fmt.Errorf("token not issued for audience %q", r.audience)
+	}
+	uids := review.Status.User.Extra[podUIDExtra]
+	if len(uids) != 1 || uids[0] == "" {
+		return "", fmt.Errorf("token for %s isn't bound to a pod", review.Status.User.Username)
+	}
+
+	r.reviews.Add(key, uids[0], tokenReviewTTL)
+	return uids[0], nil
+}
+
+// tokenAuthenticatedMethods authenticate agents with a client certificate or
+// a service account token themselves. All other methods require a client
+// certificate once certificates are no longer required by the TLS handshake.
+var tokenAuthenticatedMethods = map[string]bool{
+	"/kiam.KiamService/GetPodRole":        true,
+	"/kiam.KiamService/GetPodCredentials": true,
+	"/kiam.KiamService/GetHealth":         true,
+}
+
+// requireClientCertificate rejects calls to methods that don't authenticate
+// service account tokens unless the client sent a verified certificate.
+func requireClientCertificate(ctx context.Context, method string) error {
+	if tokenAuthenticatedMethods[method] {
+		return nil
+	}
+	if _, err := peerCertificate(ctx); err != nil {
+		serviceAccountDenied.WithLabelValues(path.Base(method)).Inc()
+		return status.Error(codes.Unauthenticated, "client certificate required")
+	}
+	return nil
+}
+
+func requireClientCertificateUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := requireClientCertificate(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func requireClientCertificateStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := requireClientCertificate(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+// bearerToken returns the token sent in the request's authorization metadata.
+func bearerToken(ctx context.Context) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", fmt.Errorf("no bearer token")
+	}
+	for _, value := range md.Get("authorization") {
+		if strings.HasPrefix(value, "Bearer ") {
+			return strings.TrimPrefix(value, "Bearer "), nil
+		}
+	}
+	return "", fmt.Errorf("no bearer token")
+}
+
+// serviceAccountTokenCredentials sends the token in the file with each
+// request. The file is read every time, as the kubelet rotates projected
+// tokens before they expire.
+type serviceAccountTokenCredentials struct {
+	path string
+}
+
+func (c *serviceAccountTokenCredentials) GetRequestMetadata(ctx context.Context, _ ...string) (map[string]string, error) {
+	token, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading service account token: %s", err.Error())
+	}
+	return map[string]string{"authorization": "Bearer " + strings.TrimSpace(string(token))}, nil
+}
+
+// RequireTransportSecurity prevents tokens being sent in the clear.
+func (c *serviceAccountTokenCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+var _ credentials.PerRPCCredentials = &serviceAccountTokenCredentials{}
@@ -0,0 +1,283 @@
+// Copyright 2017 uSwitch
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/uswitch/kiam/pkg/aws/sts"
+	"github.com/uswitch/kiam/pkg/k8s"
+	"github.com/uswitch/kiam/pkg/testutil"
+	pb "github.com/uswitch/kiam/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	authv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+	kt "k8s.io/client-go/tools/cache/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+// stubTokenReviewer maps tokens to the UIDs of the pods they were issued for.
+type stubTokenReviewer map[string]string
+
+func (r stubTokenReviewer) PodUID(_ context.Context, token string) (string, error) {
+	uid, ok := r[token]
+	if !ok {
+		return "", fmt.Errorf("token not authenticated")
+	}
+	return uid, nil
+}
+
+func contextWithToken(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+func newSidecarTestServer(ctx context.Context, source *kt.FakeControllerSource, recorder record.EventRecorder) *KiamServer {
+	pod := testutil.NewPodWithRole("ns", "name", "192.168.0.1", "Running", "running_role")
+	pod.UID = types.UID("pod-uid")
+	source.Add(pod)
+
+	podCache := k8s.NewPodCache(sts.DefaultResolver("arn:account:"), source, time.Second, defaultBuffer)
+	podCache.Run(ctx)
+	return &KiamServer{
+		pods:                podCache,
+		assumePolicy:        &allowPolicy{},
+		credentialsProvider: &stubCredentialsProvider{accessKey: "A1234"},
+		arnResolver:         sts.DefaultResolver("prefix"),
+		eventRecorder:       recorder,
+		nodeIdentity:        NodeIdentityConfig{Source: NodeIdentityCommonName},
+		tokenReviewer:       stubTokenReviewer{"own-token": "pod-uid", "other-token": "other-uid"},
+	}
+}
+
+func TestReturnsCredentialsForSidecarsOwnPod(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	server := newSidecarTestServer(ctx, source, nil)
+
+	creds, err := server.GetPodCredentials(contextWithToken("own-token"), &pb.GetPodCredentialsRequest{Ip: "192.168.0.1", Role: "running_role"})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if creds.AccessKeyId != "A1234" {
+		t.Error("unexpected credentials", creds)
+	}
+}
+
+func TestDeniesSidecarsOtherPods(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	recorder := record.NewFakeRecorder(defaultBuffer)
+	server := newSidecarTestServer(ctx, source, recorder)
+
+	_, err := server.GetPodRole(contextWithToken("other-token"), &pb.GetPodRoleRequest{Ip: "192.168.0.1"})
+	if err != ErrServiceAccountMismatch {
+		t.Error("expected service account mismatch, was", err)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, "KiamServiceAccountMismatch") {
+			t.Error("unexpected event:", event)
+		}
+	default:
+		t.Error("expected event recorded for pod")
+	}
+
+	_, err = server.GetPodRole(contextWithToken("unknown-token"), &pb.GetPodRoleRequest{Ip: "192.168.0.1"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Error("expected unauthenticated with invalid token, was", err)
+	}
+	_, err = server.GetPodRole(context.Background(), &pb.GetPodRoleRequest{Ip: "192.168.0.1"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Error("expected unauthenticated without token, was", err)
+	}
+}
+
+func TestAuthenticatesSidecarsBeforeFindingPods(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	server := newSidecarTestServer(ctx, source, nil)
+
+	// requests for uncached pods would otherwise wait for them to be cached
+	requestCtx, cancelRequest := context.WithTimeout(contextWithToken("unknown-token"), 5*time.Second)
+	defer cancelRequest()
+	_, err := server.GetPodCredentials(requestCtx, &pb.GetPodCredentialsRequest{Ip: "192.168.0.2", Role: "running_role"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Error("expected unauthenticated, was", err)
+	}
+	if requestCtx.Err() != nil {
+		t.Error("expected request to be denied without waiting for pod")
+	}
+}
+
+func TestChecksAgentsWithCertificatesByNode(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	server := newSidecarTestServer(ctx, source, nil)
+
+	// the pod has no node, so no agent's certificate matches it
+	agentCtx := contextWithPeerCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "node-1"}})
+	_, err := server.GetPodRole(agentCtx, &pb.GetPodRoleRequest{Ip: "192.168.0.1"})
+	if err != ErrNodeMismatch {
+		t.Error("expected node mismatch, was", err)
+	}
+}
+
+func TestReviewsTokensWithKubernetes(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	var reviews int
+	client.PrependReactor("create", "tokenreviews", func(action ktesting.Action) (bool, runtime.Object, error) {
+		reviews++
+		review := action.(ktesting.CreateAction).GetObject().(*authv1.TokenReview)
+		switch review.Spec.Token {
+		case "bound-token":
+			review.Status = authv1.TokenReviewStatus{
+				Authenticated: true,
+				Audiences:     review.Spec.Audiences,
+				User: authv1.UserInfo{
+					Username: "system:serviceaccount:ns:name",
+					Extra:    map[string]authv1.ExtraValue{podUIDExtra: {"pod-uid"}},
+				},
+			}
+		case "unbound-token":
+			review.Status = authv1.TokenReviewStatus{
+				Authenticated: true,
+				Audiences:     review.Spec.Audiences,
+				User:          authv1.UserInfo{Username: "system:serviceaccount:ns:name"},
+			}
+		default:
+			review.Status = authv1.TokenReviewStatus{Error: "invalid token"}
+		}
+		return true, review, nil
+	})
+	reviewer := NewKubernetesTokenReviewer(client, "kiam")
+
+	for i := 0; i < 2; i++ {
+		uid, err := reviewer.PodUID(context.Background(), "bound-token")
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+		if uid != "pod-uid" {
+			t.Error("unexpected pod uid", uid)
+		}
+	}
+	if reviews != 1 {
+		t.Error("expected review to be cached, reviewed", reviews)
+	}
+
+	if _, err := reviewer.PodUID(context.Background(), "unbound-token"); err == nil {
+		t.Error("expected error for token not bound to a pod")
+	}
+	if _, err := reviewer.PodUID(context.Background(), "invalid-token"); err == nil {
+		t.Error("expected error for invalid token")
+	}
+}
+
+func TestRequiresCertificatesForMethodsWithoutTokenAuthentication(t *testing.T) {
+	services := pb.File_service_proto.Services()
+	for i := 0; i < services.Len(); i++ {
+		methods := services.Get(i).Methods()
+		for j := 0; j < methods.Len(); j++ {
+			method := fmt.Sprintf("/%s/%s", services.Get(i).FullName(), methods.Get(j).Name())
+			if tokenAuthenticatedMethods[method] {
+				continue
+			}
+
+			err := requireClientCertificate(contextWithToken("own-token"), method)
+			if status.Code(err) != codes.Unauthenticated {
+				t.Errorf("expected %s to reject peer without certificate, was %v", method, err)
+			}
+			agentCtx := contextWithPeerCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "node-1"}})
+			if err := requireClientCertificate(agentCtx, method); err != nil {
+				t.Errorf("expected %s to accept peer with certificate, was %v", method, err)
+			}
+		}
+	}
+}
+
+func TestTokenAuthenticatedMethodsRejectUnauthenticatedPeers(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := kt.NewFakeControllerSource()
+	defer source.Shutdown()
+	server := newSidecarTestServer(ctx, source, nil)
+
+	calls := map[string]func(context.Context) error{
+		"/kiam.KiamService/GetPodRole": func(ctx context.Context) error {
+			_, err := server.GetPodRole(ctx, &pb.GetPodRoleRequest{Ip: "192.168.0.1"})
+			return err
+		},
+		"/kiam.KiamService/GetPodCredentials": func(ctx context.Context) error {
+			_, err := server.GetPodCredentials(ctx, &pb.GetPodCredentialsRequest{Ip: "192.168.0.1", Role: "running_role"})
+			return err
+		},
+		"/kiam.KiamService/GetHealth": func(ctx context.Context) error {
+			_, err := server.GetHealth(ctx, &pb.GetHealthRequest{})
+			return err
+		},
+	}
+	for method := range tokenAuthenticatedMethods {
+		call, ok := calls[method]
+		if !ok {
+			t.Fatal("no call for token authenticated method", method)
+		}
+		if err := requireClientCertificate(context.Background(), method); err != nil {
+			t.Errorf("expected %s to be left to token authentication, was %v", method, err)
+		}
+		if err := call(context.Background()); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("expected %s to reject peer without token, was %v", method, err)
+		}
+		if err := call(contextWithToken("unknown-token")); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("expected %s to reject invalid token, was %v", method, err)
+		}
+	}
+	if _, err := server.GetHealth(contextWithToken("own-token"), &pb.GetHealthRequest{}); err != nil {
+		t.Error("expected health check with valid token, was", err)
+	}
+}
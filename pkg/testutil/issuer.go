@@ -21,6 +21,8 @@ import (
 
 type stubCache struct {
 	expiring chan *sts.CachedCredentials
+	issued   chan *sts.CachedCredentials
+	evicted  chan *sts.RoleIdentity
 	issue    func(identity *sts.RoleIdentity) (*sts.Credentials, error)
 }
 
@@ -36,6 +38,20 @@ func (i *stubCache) Expire(credentials *sts.CachedCredentials) {
 	i.expiring <- credentials
 }
 
+func (i *stubCache) Issued() <-chan *sts.CachedCredentials {
+	return i.issued
+}
+
+func (i *stubCache) Evict(identity *sts.RoleIdentity) bool {
+	i.evicted <- identity
+	return true
+}
+
+// Evicted receives the identities evicted from the cache
+func (i *stubCache) Evicted() <-chan *sts.RoleIdentity {
+	return i.evicted
+}
+
 func NewStubCredentialsCache(issueFunc func(identity *sts.RoleIdentity) (*sts.Credentials, error)) *stubCache {
-	return &stubCache{issue: issueFunc, expiring: make(chan *sts.CachedCredentials)}
+	return &stubCache{issue: issueFunc, expiring: make(chan *sts.CachedCredentials), issued: make(chan *sts.CachedCredentials), evicted: make(chan *sts.RoleIdentity, 1)}
 }
@@ -60,6 +60,12 @@ func NewPodWithRole(namespace, name, ip, phase, role string) *v1.Pod {
 	return pod
 }
 
+func NewPodWithRoles(namespace, name, ip, phase, role, roles string) *v1.Pod {
+	pod := NewPodWithRole(namespace, name, ip, phase, role)
+	pod.ObjectMeta.Annotations["iam.amazonaws.com/roles"] = roles
+	return pod
+}
+
 func NewPodWithSessionName(namespace, name, ip, phase, role, sessionName string) *v1.Pod {
 	pod := NewPodWithRole(namespace, name, ip, phase, role)
 	pod.ObjectMeta.Annotations["iam.amazonaws.com/session-name"] = sessionName
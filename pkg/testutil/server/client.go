@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"github.com/uswitch/kiam/pkg/aws/sts"
+	"github.com/uswitch/kiam/pkg/server"
 )
 
 // StubClient returns fake server responses
@@ -11,26 +12,36 @@ type StubClient struct {
 	credentialsCallCount int
 	roles                []GetRoleResult
 	rolesCallCount       int
+	arn                  string
+	namespaceRoutes      string
+	podRoutes            string
+	region               string
 	health               string
 }
 
-// GetRoleResult is a return value from GetRole
+// GetRoleResult is a return value from GetRoles
 type GetRoleResult struct {
 	Role  string
 	Error error
 }
 
-func (c *StubClient) GetRole(ctx context.Context, ip string) (string, error) {
-	if c.rolesCallCount == len(c.roles) {
-		v := c.roles[len(c.roles)-1]
-		return v.Role, v.Error
+func (c *StubClient) GetRoles(ctx context.Context, ip string) (*server.PodRoles, error) {
+	v := c.roles[len(c.roles)-1]
+	if c.rolesCallCount < len(c.roles) {
+		v = c.roles[c.rolesCallCount]
+		c.rolesCallCount = c.rolesCallCount + 1
+	}
+	if v.Error != nil {
+		return nil, v.Error
 	}
 
-	currentVal := c.roles[c.rolesCallCount]
-	c.rolesCallCount = c.rolesCallCount + 1
-
-	return currentVal.Role, currentVal.Error
+	roles := &server.PodRoles{ARN: c.arn, Overrides: server.PodOverrides{NamespaceAllowRouteRegexp: c.namespaceRoutes, PodAllowRouteRegexp: c.podRoutes, Region: c.region}}
+	if v.Role != "" {
+		roles.Roles = []string{v.Role}
+	}
+	return roles, nil
 }
+
 func (c *StubClient) GetCredentials(ctx context.Context, ip, role string) (*sts.Credentials, error) {
 	if c.credentialsCallCount == len(c.credentials) {
 		v := c.credentials[len(c.credentials)-1]
@@ -51,6 +62,26 @@ func (c *StubClient) WithRoles(roles ...GetRoleResult) *StubClient {
 	return c
 }
 
+// WithAllowRouteRegexp sets the namespace's and pod's annotated metadata
+// routes returned with roles.
+func (c *StubClient) WithAllowRouteRegexp(namespaceRoutes, podRoutes string) *StubClient {
+	c.namespaceRoutes = namespaceRoutes
+	c.podRoutes = podRoutes
+	return c
+}
+
+// WithARN sets the resolved ARN returned with roles.
+func (c *StubClient) WithARN(arn string) *StubClient {
+	c.arn = arn
+	return c
+}
+
+// WithRegion sets the annotated placement region returned with roles.
+func (c *StubClient) WithRegion(region string) *StubClient {
+	c.region = region
+	return c
+}
+
 func (c *StubClient) WithHealth(health string) *StubClient {
 	c.health = health
 	return c
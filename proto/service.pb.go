@@ -37,6 +37,8 @@ type GetPodCredentialsRequest struct {
 
 	Ip   string `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
 	Role string `protobuf:"bytes,2,opt,name=role,proto3" json:"role,omitempty"`
+	// pod_uid identifies hostNetwork pods, which share the node's ip
+	PodUid string `protobuf:"bytes,3,opt,name=pod_uid,json=podUid,proto3" json:"pod_uid,omitempty"`
 }
 
 func (x *GetPodCredentialsRequest) Reset() {
@@ -85,12 +87,20 @@ func (x *GetPodCredentialsRequest) GetRole() string {
 	return ""
 }
 
+func (x *GetPodCredentialsRequest) GetPodUid() string {
+	if x != nil {
+		return x.PodUid
+	}
+	return ""
+}
+
 type GetPodRoleRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Ip string `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	Ip     string `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	PodUid string `protobuf:"bytes,2,opt,name=pod_uid,json=podUid,proto3" json:"pod_uid,omitempty"`
 }
 
 func (x *GetPodRoleRequest) Reset() {
@@ -132,12 +142,30 @@ func (x *GetPodRoleRequest) GetIp() string {
 	return ""
 }
 
+func (x *GetPodRoleRequest) GetPodUid() string {
+	if x != nil {
+		return x.PodUid
+	}
+	return ""
+}
+
 type Role struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// name is the pod's default role, the first of roles.
+	Name  string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Roles []string `protobuf:"bytes,2,rep,name=roles,proto3" json:"roles,omitempty"`
+	// namespace_allow_route_regexp and pod_allow_route_regexp are the
+	// namespace's and the pod's annotated regexps of metadata paths to proxy;
+	// paths must match each that's annotated. Empty when not annotated.
+	NamespaceAllowRouteRegexp string `protobuf:"bytes,3,opt,name=namespace_allow_route_regexp,json=namespaceAllowRouteRegexp,proto3" json:"namespace_allow_route_regexp,omitempty"`
+	PodAllowRouteRegexp       string `protobuf:"bytes,6,opt,name=pod_allow_route_regexp,json=podAllowRouteRegexp,proto3" json:"pod_allow_route_regexp,omitempty"`
+	// arn is the default role's ARN, as resolved by the server.
+	Arn string `protobuf:"bytes,4,opt,name=arn,proto3" json:"arn,omitempty"`
+	// region is the pod's, or its namespace's, annotated placement region.
+	Region string `protobuf:"bytes,5,opt,name=region,proto3" json:"region,omitempty"`
 }
 
 func (x *Role) Reset() {
@@ -179,6 +207,41 @@ func (x *Role) GetName() string {
 	return ""
 }
 
+func (x *Role) GetRoles() []string {
+	if x != nil {
+		return x.Roles
+	}
+	return nil
+}
+
+func (x *Role) GetNamespaceAllowRouteRegexp() string {
+	if x != nil {
+		return x.NamespaceAllowRouteRegexp
+	}
+	return ""
+}
+
+func (x *Role) GetPodAllowRouteRegexp() string {
+	if x != nil {
+		return x.PodAllowRouteRegexp
+	}
+	return ""
+}
+
+func (x *Role) GetArn() string {
+	if x != nil {
+		return x.Arn
+	}
+	return ""
+}
+
+func (x *Role) GetRegion() string {
+	if x != nil {
+		return x.Region
+	}
+	return ""
+}
+
 type Credentials struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -359,50 +422,595 @@ func (x *HealthStatus) GetMessage() string {
 	return ""
 }
 
+type WatchNodeCredentialsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	NodeName string `protobuf:"bytes,1,opt,name=node_name,json=nodeName,proto3" json:"node_name,omitempty"`
+}
+
+func (x *WatchNodeCredentialsRequest) Reset() {
+	*x = WatchNodeCredentialsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_service_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WatchNodeCredentialsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchNodeCredentialsRequest) ProtoMessage() {}
+
+func (x *WatchNodeCredentialsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_service_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchNodeCredentialsRequest.ProtoReflect.Descriptor instead.
+func (*WatchNodeCredentialsRequest) Descriptor() ([]byte, []int) {
+	return file_service_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *WatchNodeCredentialsRequest) GetNodeName() string {
+	if x != nil {
+		return x.NodeName
+	}
+	return ""
+}
+
+// NodeCredentialsUpdate describes the roles and credentials of a pod on the
+// node. Credentials are omitted when the pod has no role, or when they can't
+// be issued; those of the pod's other roles are sent in role_credentials.
+// After the pods on the node have been sent, an update with synced set is
+// sent; subsequent updates are sent as pods and credentials change.
+type NodeCredentialsUpdate struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Ip          string       `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	Role        string       `protobuf:"bytes,2,opt,name=role,proto3" json:"role,omitempty"`
+	Credentials *Credentials `protobuf:"bytes,3,opt,name=credentials,proto3" json:"credentials,omitempty"`
+	Removed     bool         `protobuf:"varint,4,opt,name=removed,proto3" json:"removed,omitempty"`
+	Synced      bool         `protobuf:"varint,5,opt,name=synced,proto3" json:"synced,omitempty"`
+	// roles are all the pod's roles, role first.
+	Roles                     []string `protobuf:"bytes,6,rep,name=roles,proto3" json:"roles,omitempty"`
+	NamespaceAllowRouteRegexp string   `protobuf:"bytes,7,opt,name=namespace_allow_route_regexp,json=namespaceAllowRouteRegexp,proto3" json:"namespace_allow_route_regexp,omitempty"`
+	PodAllowRouteRegexp       string   `protobuf:"bytes,11,opt,name=pod_allow_route_regexp,json=podAllowRouteRegexp,proto3" json:"pod_allow_route_regexp,omitempty"`
+	RoleArn                   string   `protobuf:"bytes,8,opt,name=role_arn,json=roleArn,proto3" json:"role_arn,omitempty"`
+	Region                    string   `protobuf:"bytes,9,opt,name=region,proto3" json:"region,omitempty"`
+	// role_credentials are the credentials of the pod's other permitted roles,
+	// by role.
+	RoleCredentials map[string]*Credentials `protobuf:"bytes,10,rep,name=role_credentials,json=roleCredentials,proto3" json:"role_credentials,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (x *NodeCredentialsUpdate) Reset() {
+	*x = NodeCredentialsUpdate{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_service_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *NodeCredentialsUpdate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NodeCredentialsUpdate) ProtoMessage() {}
+
+func (x *NodeCredentialsUpdate) ProtoReflect() protoreflect.Message {
+	mi := &file_service_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NodeCredentialsUpdate.ProtoReflect.Descriptor instead.
+func (*NodeCredentialsUpdate) Descriptor() ([]byte, []int) {
+	return file_service_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *NodeCredentialsUpdate) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+func (x *NodeCredentialsUpdate) GetRole() string {
+	if x != nil {
+		return x.Role
+	}
+	return ""
+}
+
+func (x *NodeCredentialsUpdate) GetCredentials() *Credentials {
+	if x != nil {
+		return x.Credentials
+	}
+	return nil
+}
+
+func (x *NodeCredentialsUpdate) GetRemoved() bool {
+	if x != nil {
+		return x.Removed
+	}
+	return false
+}
+
+func (x *NodeCredentialsUpdate) GetSynced() bool {
+	if x != nil {
+		return x.Synced
+	}
+	return false
+}
+
+func (x *NodeCredentialsUpdate) GetRoles() []string {
+	if x != nil {
+		return x.Roles
+	}
+	return nil
+}
+
+func (x *NodeCredentialsUpdate) GetNamespaceAllowRouteRegexp() string {
+	if x != nil {
+		return x.NamespaceAllowRouteRegexp
+	}
+	return ""
+}
+
+func (x *NodeCredentialsUpdate) GetPodAllowRouteRegexp() string {
+	if x != nil {
+		return x.PodAllowRouteRegexp
+	}
+	return ""
+}
+
+func (x *NodeCredentialsUpdate) GetRoleArn() string {
+	if x != nil {
+		return x.RoleArn
+	}
+	return ""
+}
+
+func (x *NodeCredentialsUpdate) GetRegion() string {
+	if x != nil {
+		return x.Region
+	}
+	return ""
+}
+
+func (x *NodeCredentialsUpdate) GetRoleCredentials() map[string]*Credentials {
+	if x != nil {
+		return x.RoleCredentials
+	}
+	return nil
+}
+
+type ListCachedCredentialsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *ListCachedCredentialsRequest) Reset() {
+	*x = ListCachedCredentialsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_service_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListCachedCredentialsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListCachedCredentialsRequest) ProtoMessage() {}
+
+func (x *ListCachedCredentialsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_service_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListCachedCredentialsRequest.ProtoReflect.Descriptor instead.
+func (*ListCachedCredentialsRequest) Descriptor() ([]byte, []int) {
+	return file_service_proto_rawDescGZIP(), []int{8}
+}
+
+type CachedCredentials struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	RoleArn     string `protobuf:"bytes,1,opt,name=role_arn,json=roleArn,proto3" json:"role_arn,omitempty"`
+	SessionName string `protobuf:"bytes,2,opt,name=session_name,json=sessionName,proto3" json:"session_name,omitempty"`
+	Expiration  string `protobuf:"bytes,3,opt,name=expiration,proto3" json:"expiration,omitempty"`
+	LastUpdated string `protobuf:"bytes,4,opt,name=last_updated,json=lastUpdated,proto3" json:"last_updated,omitempty"`
+}
+
+func (x *CachedCredentials) Reset() {
+	*x = CachedCredentials{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_service_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CachedCredentials) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CachedCredentials) ProtoMessage() {}
+
+func (x *CachedCredentials) ProtoReflect() protoreflect.Message {
+	mi := &file_service_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CachedCredentials.ProtoReflect.Descriptor instead.
+func (*CachedCredentials) Descriptor() ([]byte, []int) {
+	return file_service_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *CachedCredentials) GetRoleArn() string {
+	if x != nil {
+		return x.RoleArn
+	}
+	return ""
+}
+
+func (x *CachedCredentials) GetSessionName() string {
+	if x != nil {
+		return x.SessionName
+	}
+	return ""
+}
+
+func (x *CachedCredentials) GetExpiration() string {
+	if x != nil {
+		return x.Expiration
+	}
+	return ""
+}
+
+func (x *CachedCredentials) GetLastUpdated() string {
+	if x != nil {
+		return x.LastUpdated
+	}
+	return ""
+}
+
+type CachedCredentialsList struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Credentials []*CachedCredentials `protobuf:"bytes,1,rep,name=credentials,proto3" json:"credentials,omitempty"`
+}
+
+func (x *CachedCredentialsList) Reset() {
+	*x = CachedCredentialsList{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_service_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CachedCredentialsList) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CachedCredentialsList) ProtoMessage() {}
+
+func (x *CachedCredentialsList) ProtoReflect() protoreflect.Message {
+	mi := &file_service_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CachedCredentialsList.ProtoReflect.Descriptor instead.
+func (*CachedCredentialsList) Descriptor() ([]byte, []int) {
+	return file_service_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *CachedCredentialsList) GetCredentials() []*CachedCredentials {
+	if x != nil {
+		return x.Credentials
+	}
+	return nil
+}
+
+type EvictCachedCredentialsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Role      string `protobuf:"bytes,1,opt,name=role,proto3" json:"role,omitempty"`
+	Namespace string `protobuf:"bytes,2,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	All       bool   `protobuf:"varint,3,opt,name=all,proto3" json:"all,omitempty"`
+}
+
+func (x *EvictCachedCredentialsRequest) Reset() {
+	*x = EvictCachedCredentialsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_service_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *EvictCachedCredentialsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EvictCachedCredentialsRequest) ProtoMessage() {}
+
+func (x *EvictCachedCredentialsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_service_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EvictCachedCredentialsRequest.ProtoReflect.Descriptor instead.
+func (*EvictCachedCredentialsRequest) Descriptor() ([]byte, []int) {
+	return file_service_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *EvictCachedCredentialsRequest) GetRole() string {
+	if x != nil {
+		return x.Role
+	}
+	return ""
+}
+
+func (x *EvictCachedCredentialsRequest) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *EvictCachedCredentialsRequest) GetAll() bool {
+	if x != nil {
+		return x.All
+	}
+	return false
+}
+
+type EvictCachedCredentialsResult struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Evicted int32 `protobuf:"varint,1,opt,name=evicted,proto3" json:"evicted,omitempty"`
+}
+
+func (x *EvictCachedCredentialsResult) Reset() {
+	*x = EvictCachedCredentialsResult{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_service_proto_msgTypes[12]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *EvictCachedCredentialsResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EvictCachedCredentialsResult) ProtoMessage() {}
+
+func (x *EvictCachedCredentialsResult) ProtoReflect() protoreflect.Message {
+	mi := &file_service_proto_msgTypes[12]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EvictCachedCredentialsResult.ProtoReflect.Descriptor instead.
+func (*EvictCachedCredentialsResult) Descriptor() ([]byte, []int) {
+	return file_service_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *EvictCachedCredentialsResult) GetEvicted() int32 {
+	if x != nil {
+		return x.Evicted
+	}
+	return 0
+}
+
 var File_service_proto protoreflect.FileDescriptor
 
 var file_service_proto_rawDesc = []byte{
 	0x0a, 0x0d, 0x73, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12,
-	0x04, 0x6b, 0x69, 0x61, 0x6d, 0x22, 0x3e, 0x0a, 0x18, 0x47, 0x65, 0x74, 0x50, 0x6f, 0x64, 0x43,
+	0x04, 0x6b, 0x69, 0x61, 0x6d, 0x22, 0x57, 0x0a, 0x18, 0x47, 0x65, 0x74, 0x50, 0x6f, 0x64, 0x43,
 	0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
 	0x74, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69,
 	0x70, 0x12, 0x12, 0x0a, 0x04, 0x72, 0x6f, 0x6c, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52,
-	0x04, 0x72, 0x6f, 0x6c, 0x65, 0x22, 0x23, 0x0a, 0x11, 0x47, 0x65, 0x74, 0x50, 0x6f, 0x64, 0x52,
-	0x6f, 0x6c, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x70,
-	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x70, 0x22, 0x1a, 0x0a, 0x04, 0x52, 0x6f,
-	0x6c, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
-	0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x22, 0xde, 0x01, 0x0a, 0x0b, 0x43, 0x72, 0x65, 0x64, 0x65,
-	0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x63, 0x6f, 0x64, 0x65, 0x18, 0x01,
-	0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x63, 0x6f, 0x64, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x79,
-	0x70, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x22,
-	0x0a, 0x0d, 0x61, 0x63, 0x63, 0x65, 0x73, 0x73, 0x5f, 0x6b, 0x65, 0x79, 0x5f, 0x69, 0x64, 0x18,
-	0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x61, 0x63, 0x63, 0x65, 0x73, 0x73, 0x4b, 0x65, 0x79,
-	0x49, 0x64, 0x12, 0x2a, 0x0a, 0x11, 0x73, 0x65, 0x63, 0x72, 0x65, 0x74, 0x5f, 0x61, 0x63, 0x63,
-	0x65, 0x73, 0x73, 0x5f, 0x6b, 0x65, 0x79, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0f, 0x73,
-	0x65, 0x63, 0x72, 0x65, 0x74, 0x41, 0x63, 0x63, 0x65, 0x73, 0x73, 0x4b, 0x65, 0x79, 0x12, 0x14,
-	0x0a, 0x05, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x74,
-	0x6f, 0x6b, 0x65, 0x6e, 0x12, 0x1e, 0x0a, 0x0a, 0x65, 0x78, 0x70, 0x69, 0x72, 0x61, 0x74, 0x69,
-	0x6f, 0x6e, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x65, 0x78, 0x70, 0x69, 0x72, 0x61,
-	0x74, 0x69, 0x6f, 0x6e, 0x12, 0x21, 0x0a, 0x0c, 0x6c, 0x61, 0x73, 0x74, 0x5f, 0x75, 0x70, 0x64,
-	0x61, 0x74, 0x65, 0x64, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x6c, 0x61, 0x73, 0x74,
-	0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x64, 0x22, 0x12, 0x0a, 0x10, 0x47, 0x65, 0x74, 0x48, 0x65,
-	0x61, 0x6c, 0x74, 0x68, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x28, 0x0a, 0x0c, 0x48,
-	0x65, 0x61, 0x6c, 0x74, 0x68, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x18, 0x0a, 0x07, 0x6d,
-	0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6d, 0x65,
-	0x73, 0x73, 0x61, 0x67, 0x65, 0x32, 0xc7, 0x01, 0x0a, 0x0b, 0x4b, 0x69, 0x61, 0x6d, 0x53, 0x65,
-	0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x33, 0x0a, 0x0a, 0x47, 0x65, 0x74, 0x50, 0x6f, 0x64, 0x52,
-	0x6f, 0x6c, 0x65, 0x12, 0x17, 0x2e, 0x6b, 0x69, 0x61, 0x6d, 0x2e, 0x47, 0x65, 0x74, 0x50, 0x6f,
-	0x64, 0x52, 0x6f, 0x6c, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0a, 0x2e, 0x6b,
-	0x69, 0x61, 0x6d, 0x2e, 0x52, 0x6f, 0x6c, 0x65, 0x22, 0x00, 0x12, 0x48, 0x0a, 0x11, 0x47, 0x65,
-	0x74, 0x50, 0x6f, 0x64, 0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x12,
-	0x1e, 0x2e, 0x6b, 0x69, 0x61, 0x6d, 0x2e, 0x47, 0x65, 0x74, 0x50, 0x6f, 0x64, 0x43, 0x72, 0x65,
-	0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a,
-	0x11, 0x2e, 0x6b, 0x69, 0x61, 0x6d, 0x2e, 0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61,
-	0x6c, 0x73, 0x22, 0x00, 0x12, 0x39, 0x0a, 0x09, 0x47, 0x65, 0x74, 0x48, 0x65, 0x61, 0x6c, 0x74,
-	0x68, 0x12, 0x16, 0x2e, 0x6b, 0x69, 0x61, 0x6d, 0x2e, 0x47, 0x65, 0x74, 0x48, 0x65, 0x61, 0x6c,
-	0x74, 0x68, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x12, 0x2e, 0x6b, 0x69, 0x61, 0x6d,
-	0x2e, 0x48, 0x65, 0x61, 0x6c, 0x74, 0x68, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x22, 0x00, 0x62,
-	0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x04, 0x72, 0x6f, 0x6c, 0x65, 0x12, 0x17, 0x0a, 0x07, 0x70, 0x6f, 0x64, 0x5f, 0x75, 0x69, 0x64,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x70, 0x6f, 0x64, 0x55, 0x69, 0x64, 0x22, 0x3c,
+	0x0a, 0x11, 0x47, 0x65, 0x74, 0x50, 0x6f, 0x64, 0x52, 0x6f, 0x6c, 0x65, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x02, 0x69, 0x70, 0x12, 0x17, 0x0a, 0x07, 0x70, 0x6f, 0x64, 0x5f, 0x75, 0x69, 0x64, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x70, 0x6f, 0x64, 0x55, 0x69, 0x64, 0x22, 0xd0, 0x01, 0x0a,
+	0x04, 0x52, 0x6f, 0x6c, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x72, 0x6f, 0x6c,
+	0x65, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x05, 0x72, 0x6f, 0x6c, 0x65, 0x73, 0x12,
+	0x3f, 0x0a, 0x1c, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x5f, 0x61, 0x6c, 0x6c,
+	0x6f, 0x77, 0x5f, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x5f, 0x72, 0x65, 0x67, 0x65, 0x78, 0x70, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x19, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65,
+	0x41, 0x6c, 0x6c, 0x6f, 0x77, 0x52, 0x6f, 0x75, 0x74, 0x65, 0x52, 0x65, 0x67, 0x65, 0x78, 0x70,
+	0x12, 0x33, 0x0a, 0x16, 0x70, 0x6f, 0x64, 0x5f, 0x61, 0x6c, 0x6c, 0x6f, 0x77, 0x5f, 0x72, 0x6f,
+	0x75, 0x74, 0x65, 0x5f, 0x72, 0x65, 0x67, 0x65, 0x78, 0x70, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x13, 0x70, 0x6f, 0x64, 0x41, 0x6c, 0x6c, 0x6f, 0x77, 0x52, 0x6f, 0x75, 0x74, 0x65, 0x52,
+	0x65, 0x67, 0x65, 0x78, 0x70, 0x12, 0x10, 0x0a, 0x03, 0x61, 0x72, 0x6e, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x03, 0x61, 0x72, 0x6e, 0x12, 0x16, 0x0a, 0x06, 0x72, 0x65, 0x67, 0x69, 0x6f,
+	0x6e, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x22,
+	0xde, 0x01, 0x0a, 0x0b, 0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x12,
+	0x12, 0x0a, 0x04, 0x63, 0x6f, 0x64, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x63,
+	0x6f, 0x64, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x22, 0x0a, 0x0d, 0x61, 0x63, 0x63, 0x65, 0x73,
+	0x73, 0x5f, 0x6b, 0x65, 0x79, 0x5f, 0x69, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b,
+	0x61, 0x63, 0x63, 0x65, 0x73, 0x73, 0x4b, 0x65, 0x79, 0x49, 0x64, 0x12, 0x2a, 0x0a, 0x11, 0x73,
+	0x65, 0x63, 0x72, 0x65, 0x74, 0x5f, 0x61, 0x63, 0x63, 0x65, 0x73, 0x73, 0x5f, 0x6b, 0x65, 0x79,
+	0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0f, 0x73, 0x65, 0x63, 0x72, 0x65, 0x74, 0x41, 0x63,
+	0x63, 0x65, 0x73, 0x73, 0x4b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x74, 0x6f, 0x6b, 0x65, 0x6e,
+	0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x12, 0x1e, 0x0a,
+	0x0a, 0x65, 0x78, 0x70, 0x69, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x06, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x0a, 0x65, 0x78, 0x70, 0x69, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x21, 0x0a,
+	0x0c, 0x6c, 0x61, 0x73, 0x74, 0x5f, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x64, 0x18, 0x07, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0b, 0x6c, 0x61, 0x73, 0x74, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x64,
+	0x22, 0x12, 0x0a, 0x10, 0x47, 0x65, 0x74, 0x48, 0x65, 0x61, 0x6c, 0x74, 0x68, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x22, 0x28, 0x0a, 0x0c, 0x48, 0x65, 0x61, 0x6c, 0x74, 0x68, 0x53, 0x74,
+	0x61, 0x74, 0x75, 0x73, 0x12, 0x18, 0x0a, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x22, 0x3a,
+	0x0a, 0x1b, 0x57, 0x61, 0x74, 0x63, 0x68, 0x4e, 0x6f, 0x64, 0x65, 0x43, 0x72, 0x65, 0x64, 0x65,
+	0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1b, 0x0a,
+	0x09, 0x6e, 0x6f, 0x64, 0x65, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x08, 0x6e, 0x6f, 0x64, 0x65, 0x4e, 0x61, 0x6d, 0x65, 0x22, 0x95, 0x04, 0x0a, 0x15, 0x4e,
+	0x6f, 0x64, 0x65, 0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x55, 0x70,
+	0x64, 0x61, 0x74, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x02, 0x69, 0x70, 0x12, 0x12, 0x0a, 0x04, 0x72, 0x6f, 0x6c, 0x65, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x04, 0x72, 0x6f, 0x6c, 0x65, 0x12, 0x33, 0x0a, 0x0b, 0x63, 0x72, 0x65, 0x64,
+	0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x11, 0x2e,
+	0x6b, 0x69, 0x61, 0x6d, 0x2e, 0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73,
+	0x52, 0x0b, 0x63, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x12, 0x18, 0x0a,
+	0x07, 0x72, 0x65, 0x6d, 0x6f, 0x76, 0x65, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07,
+	0x72, 0x65, 0x6d, 0x6f, 0x76, 0x65, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x79, 0x6e, 0x63, 0x65,
+	0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08, 0x52, 0x06, 0x73, 0x79, 0x6e, 0x63, 0x65, 0x64, 0x12,
+	0x14, 0x0a, 0x05, 0x72, 0x6f, 0x6c, 0x65, 0x73, 0x18, 0x06, 0x20, 0x03, 0x28, 0x09, 0x52, 0x05,
+	0x72, 0x6f, 0x6c, 0x65, 0x73, 0x12, 0x3f, 0x0a, 0x1c, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61,
+	0x63, 0x65, 0x5f, 0x61, 0x6c, 0x6c, 0x6f, 0x77, 0x5f, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x5f, 0x72,
+	0x65, 0x67, 0x65, 0x78, 0x70, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x19, 0x6e, 0x61, 0x6d,
+	0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x41, 0x6c, 0x6c, 0x6f, 0x77, 0x52, 0x6f, 0x75, 0x74, 0x65,
+	0x52, 0x65, 0x67, 0x65, 0x78, 0x70, 0x12, 0x33, 0x0a, 0x16, 0x70, 0x6f, 0x64, 0x5f, 0x61, 0x6c,
+	0x6c, 0x6f, 0x77, 0x5f, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x5f, 0x72, 0x65, 0x67, 0x65, 0x78, 0x70,
+	0x18, 0x0b, 0x20, 0x01, 0x28, 0x09, 0x52, 0x13, 0x70, 0x6f, 0x64, 0x41, 0x6c, 0x6c, 0x6f, 0x77,
+	0x52, 0x6f, 0x75, 0x74, 0x65, 0x52, 0x65, 0x67, 0x65, 0x78, 0x70, 0x12, 0x19, 0x0a, 0x08, 0x72,
+	0x6f, 0x6c, 0x65, 0x5f, 0x61, 0x72, 0x6e, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x72,
+	0x6f, 0x6c, 0x65, 0x41, 0x72, 0x6e, 0x12, 0x16, 0x0a, 0x06, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e,
+	0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x12, 0x5b,
+	0x0a, 0x10, 0x72, 0x6f, 0x6c, 0x65, 0x5f, 0x63, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61,
+	0x6c, 0x73, 0x18, 0x0a, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x30, 0x2e, 0x6b, 0x69, 0x61, 0x6d, 0x2e,
+	0x4e, 0x6f, 0x64, 0x65, 0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x55,
+	0x70, 0x64, 0x61, 0x74, 0x65, 0x2e, 0x52, 0x6f, 0x6c, 0x65, 0x43, 0x72, 0x65, 0x64, 0x65, 0x6e,
+	0x74, 0x69, 0x61, 0x6c, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x0f, 0x72, 0x6f, 0x6c, 0x65,
+	0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x1a, 0x55, 0x0a, 0x14, 0x52,
+	0x6f, 0x6c, 0x65, 0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x45, 0x6e,
+	0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x27, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x11, 0x2e, 0x6b, 0x69, 0x61, 0x6d, 0x2e, 0x43, 0x72, 0x65, 0x64,
+	0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02,
+	0x38, 0x01, 0x22, 0x1e, 0x0a, 0x1c, 0x4c, 0x69, 0x73, 0x74, 0x43, 0x61, 0x63, 0x68, 0x65, 0x64,
+	0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x22, 0x94, 0x01, 0x0a, 0x11, 0x43, 0x61, 0x63, 0x68, 0x65, 0x64, 0x43, 0x72, 0x65,
+	0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x12, 0x19, 0x0a, 0x08, 0x72, 0x6f, 0x6c, 0x65,
+	0x5f, 0x61, 0x72, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x72, 0x6f, 0x6c, 0x65,
+	0x41, 0x72, 0x6e, 0x12, 0x21, 0x0a, 0x0c, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x5f, 0x6e,
+	0x61, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x73, 0x65, 0x73, 0x73, 0x69,
+	0x6f, 0x6e, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x1e, 0x0a, 0x0a, 0x65, 0x78, 0x70, 0x69, 0x72, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x65, 0x78, 0x70, 0x69,
+	0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x21, 0x0a, 0x0c, 0x6c, 0x61, 0x73, 0x74, 0x5f, 0x75,
+	0x70, 0x64, 0x61, 0x74, 0x65, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x6c, 0x61,
+	0x73, 0x74, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x64, 0x22, 0x52, 0x0a, 0x15, 0x43, 0x61, 0x63,
+	0x68, 0x65, 0x64, 0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x4c, 0x69,
+	0x73, 0x74, 0x12, 0x39, 0x0a, 0x0b, 0x63, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c,
+	0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x17, 0x2e, 0x6b, 0x69, 0x61, 0x6d, 0x2e, 0x43,
+	0x61, 0x63, 0x68, 0x65, 0x64, 0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73,
+	0x52, 0x0b, 0x63, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x22, 0x63, 0x0a,
+	0x1d, 0x45, 0x76, 0x69, 0x63, 0x74, 0x43, 0x61, 0x63, 0x68, 0x65, 0x64, 0x43, 0x72, 0x65, 0x64,
+	0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x12,
+	0x0a, 0x04, 0x72, 0x6f, 0x6c, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x72, 0x6f,
+	0x6c, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65,
+	0x12, 0x10, 0x0a, 0x03, 0x61, 0x6c, 0x6c, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x03, 0x61,
+	0x6c, 0x6c, 0x22, 0x38, 0x0a, 0x1c, 0x45, 0x76, 0x69, 0x63, 0x74, 0x43, 0x61, 0x63, 0x68, 0x65,
+	0x64, 0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x52, 0x65, 0x73, 0x75,
+	0x6c, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x65, 0x76, 0x69, 0x63, 0x74, 0x65, 0x64, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x05, 0x52, 0x07, 0x65, 0x76, 0x69, 0x63, 0x74, 0x65, 0x64, 0x32, 0xa3, 0x02, 0x0a,
+	0x0b, 0x4b, 0x69, 0x61, 0x6d, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x33, 0x0a, 0x0a,
+	0x47, 0x65, 0x74, 0x50, 0x6f, 0x64, 0x52, 0x6f, 0x6c, 0x65, 0x12, 0x17, 0x2e, 0x6b, 0x69, 0x61,
+	0x6d, 0x2e, 0x47, 0x65, 0x74, 0x50, 0x6f, 0x64, 0x52, 0x6f, 0x6c, 0x65, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x1a, 0x0a, 0x2e, 0x6b, 0x69, 0x61, 0x6d, 0x2e, 0x52, 0x6f, 0x6c, 0x65, 0x22,
+	0x00, 0x12, 0x48, 0x0a, 0x11, 0x47, 0x65, 0x74, 0x50, 0x6f, 0x64, 0x43, 0x72, 0x65, 0x64, 0x65,
+	0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x12, 0x1e, 0x2e, 0x6b, 0x69, 0x61, 0x6d, 0x2e, 0x47, 0x65,
+	0x74, 0x50, 0x6f, 0x64, 0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x11, 0x2e, 0x6b, 0x69, 0x61, 0x6d, 0x2e, 0x43, 0x72,
+	0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x22, 0x00, 0x12, 0x39, 0x0a, 0x09, 0x47,
+	0x65, 0x74, 0x48, 0x65, 0x61, 0x6c, 0x74, 0x68, 0x12, 0x16, 0x2e, 0x6b, 0x69, 0x61, 0x6d, 0x2e,
+	0x47, 0x65, 0x74, 0x48, 0x65, 0x61, 0x6c, 0x74, 0x68, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x1a, 0x12, 0x2e, 0x6b, 0x69, 0x61, 0x6d, 0x2e, 0x48, 0x65, 0x61, 0x6c, 0x74, 0x68, 0x53, 0x74,
+	0x61, 0x74, 0x75, 0x73, 0x22, 0x00, 0x12, 0x5a, 0x0a, 0x14, 0x57, 0x61, 0x74, 0x63, 0x68, 0x4e,
+	0x6f, 0x64, 0x65, 0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x12, 0x21,
+	0x2e, 0x6b, 0x69, 0x61, 0x6d, 0x2e, 0x57, 0x61, 0x74, 0x63, 0x68, 0x4e, 0x6f, 0x64, 0x65, 0x43,
+	0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x1a, 0x1b, 0x2e, 0x6b, 0x69, 0x61, 0x6d, 0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x43, 0x72, 0x65,
+	0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x22, 0x00,
+	0x30, 0x01, 0x32, 0xd3, 0x01, 0x0a, 0x10, 0x4b, 0x69, 0x61, 0x6d, 0x41, 0x64, 0x6d, 0x69, 0x6e,
+	0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x5a, 0x0a, 0x15, 0x4c, 0x69, 0x73, 0x74, 0x43,
+	0x61, 0x63, 0x68, 0x65, 0x64, 0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73,
+	0x12, 0x22, 0x2e, 0x6b, 0x69, 0x61, 0x6d, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x43, 0x61, 0x63, 0x68,
+	0x65, 0x64, 0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x1a, 0x1b, 0x2e, 0x6b, 0x69, 0x61, 0x6d, 0x2e, 0x43, 0x61, 0x63, 0x68,
+	0x65, 0x64, 0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x4c, 0x69, 0x73,
+	0x74, 0x22, 0x00, 0x12, 0x63, 0x0a, 0x16, 0x45, 0x76, 0x69, 0x63, 0x74, 0x43, 0x61, 0x63, 0x68,
+	0x65, 0x64, 0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x12, 0x23, 0x2e,
+	0x6b, 0x69, 0x61, 0x6d, 0x2e, 0x45, 0x76, 0x69, 0x63, 0x74, 0x43, 0x61, 0x63, 0x68, 0x65, 0x64,
+	0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x22, 0x2e, 0x6b, 0x69, 0x61, 0x6d, 0x2e, 0x45, 0x76, 0x69, 0x63, 0x74, 0x43,
+	0x61, 0x63, 0x68, 0x65, 0x64, 0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73,
+	0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x22, 0x00, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -417,27 +1025,45 @@ func file_service_proto_rawDescGZIP() []byte {
 	return file_service_proto_rawDescData
 }
 
-var file_service_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_service_proto_msgTypes = make([]protoimpl.MessageInfo, 14)
 var file_service_proto_goTypes = []interface{}{
-	(*GetPodCredentialsRequest)(nil), // 0: kiam.GetPodCredentialsRequest
-	(*GetPodRoleRequest)(nil),        // 1: kiam.GetPodRoleRequest
-	(*Role)(nil),                     // 2: kiam.Role
-	(*Credentials)(nil),              // 3: kiam.Credentials
-	(*GetHealthRequest)(nil),         // 4: kiam.GetHealthRequest
-	(*HealthStatus)(nil),             // 5: kiam.HealthStatus
+	(*GetPodCredentialsRequest)(nil),      // 0: kiam.GetPodCredentialsRequest
+	(*GetPodRoleRequest)(nil),             // 1: kiam.GetPodRoleRequest
+	(*Role)(nil),                          // 2: kiam.Role
+	(*Credentials)(nil),                   // 3: kiam.Credentials
+	(*GetHealthRequest)(nil),              // 4: kiam.GetHealthRequest
+	(*HealthStatus)(nil),                  // 5: kiam.HealthStatus
+	(*WatchNodeCredentialsRequest)(nil),   // 6: kiam.WatchNodeCredentialsRequest
+	(*NodeCredentialsUpdate)(nil),         // 7: kiam.NodeCredentialsUpdate
+	(*ListCachedCredentialsRequest)(nil),  // 8: kiam.ListCachedCredentialsRequest
+	(*CachedCredentials)(nil),             // 9: kiam.CachedCredentials
+	(*CachedCredentialsList)(nil),         // 10: kiam.CachedCredentialsList
+	(*EvictCachedCredentialsRequest)(nil), // 11: kiam.EvictCachedCredentialsRequest
+	(*EvictCachedCredentialsResult)(nil),  // 12: kiam.EvictCachedCredentialsResult
+	nil,                                   // 13: kiam.NodeCredentialsUpdate.RoleCredentialsEntry
 }
 var file_service_proto_depIdxs = []int32{
-	1, // 0: kiam.KiamService.GetPodRole:input_type -> kiam.GetPodRoleRequest
-	0, // 1: kiam.KiamService.GetPodCredentials:input_type -> kiam.GetPodCredentialsRequest
-	4, // 2: kiam.KiamService.GetHealth:input_type -> kiam.GetHealthRequest
-	2, // 3: kiam.KiamService.GetPodRole:output_type -> kiam.Role
-	3, // 4: kiam.KiamService.GetPodCredentials:output_type -> kiam.Credentials
-	5, // 5: kiam.KiamService.GetHealth:output_type -> kiam.HealthStatus
-	3, // [3:6] is the sub-list for method output_type
-	0, // [0:3] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	3,  // 0: kiam.NodeCredentialsUpdate.credentials:type_name -> kiam.Credentials
+	13, // 1: kiam.NodeCredentialsUpdate.role_credentials:type_name -> kiam.NodeCredentialsUpdate.RoleCredentialsEntry
+	9,  // 2: kiam.CachedCredentialsList.credentials:type_name -> kiam.CachedCredentials
+	3,  // 3: kiam.NodeCredentialsUpdate.RoleCredentialsEntry.value:type_name -> kiam.Credentials
+	1,  // 4: kiam.KiamService.GetPodRole:input_type -> kiam.GetPodRoleRequest
+	0,  // 5: kiam.KiamService.GetPodCredentials:input_type -> kiam.GetPodCredentialsRequest
+	4,  // 6: kiam.KiamService.GetHealth:input_type -> kiam.GetHealthRequest
+	6,  // 7: kiam.KiamService.WatchNodeCredentials:input_type -> kiam.WatchNodeCredentialsRequest
+	8,  // 8: kiam.KiamAdminService.ListCachedCredentials:input_type -> kiam.ListCachedCredentialsRequest
+	11, // 9: kiam.KiamAdminService.EvictCachedCredentials:input_type -> kiam.EvictCachedCredentialsRequest
+	2,  // 10: kiam.KiamService.GetPodRole:output_type -> kiam.Role
+	3,  // 11: kiam.KiamService.GetPodCredentials:output_type -> kiam.Credentials
+	5,  // 12: kiam.KiamService.GetHealth:output_type -> kiam.HealthStatus
+	7,  // 13: kiam.KiamService.WatchNodeCredentials:output_type -> kiam.NodeCredentialsUpdate
+	10, // 14: kiam.KiamAdminService.ListCachedCredentials:output_type -> kiam.CachedCredentialsList
+	12, // 15: kiam.KiamAdminService.EvictCachedCredentials:output_type -> kiam.EvictCachedCredentialsResult
+	10, // [10:16] is the sub-list for method output_type
+	4,  // [4:10] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
 }
 
 func init() { file_service_proto_init() }
@@ -518,6 +1144,90 @@ func file_service_proto_init() {
 				return nil
 			}
 		}
+		file_service_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WatchNodeCredentialsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_service_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*NodeCredentialsUpdate); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_service_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListCachedCredentialsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_service_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CachedCredentials); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_service_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CachedCredentialsList); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_service_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*EvictCachedCredentialsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_service_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*EvictCachedCredentialsResult); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -525,9 +1235,9 @@ func file_service_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_service_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   6,
+			NumMessages:   14,
 			NumExtensions: 0,
-			NumServices:   1,
+			NumServices:   2,
 		},
 		GoTypes:           file_service_proto_goTypes,
 		DependencyIndexes: file_service_proto_depIdxs,
@@ -554,6 +1264,7 @@ type KiamServiceClient interface {
 	GetPodRole(ctx context.Context, in *GetPodRoleRequest, opts ...grpc.CallOption) (*Role, error)
 	GetPodCredentials(ctx context.Context, in *GetPodCredentialsRequest, opts ...grpc.CallOption) (*Credentials, error)
 	GetHealth(ctx context.Context, in *GetHealthRequest, opts ...grpc.CallOption) (*HealthStatus, error)
+	WatchNodeCredentials(ctx context.Context, in *WatchNodeCredentialsRequest, opts ...grpc.CallOption) (KiamService_WatchNodeCredentialsClient, error)
 }
 
 type kiamServiceClient struct {
@@ -591,11 +1302,44 @@ func (c *kiamServiceClient) GetHealth(ctx context.Context, in *GetHealthRequest,
 	return out, nil
 }
 
+func (c *kiamServiceClient) WatchNodeCredentials(ctx context.Context, in *WatchNodeCredentialsRequest, opts ...grpc.CallOption) (KiamService_WatchNodeCredentialsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_KiamService_serviceDesc.Streams[0], "/kiam.KiamService/WatchNodeCredentials", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &kiamServiceWatchNodeCredentialsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type KiamService_WatchNodeCredentialsClient interface {
+	Recv() (*NodeCredentialsUpdate, error)
+	grpc.ClientStream
+}
+
+type kiamServiceWatchNodeCredentialsClient struct {
+	grpc.ClientStream
+}
+
+func (x *kiamServiceWatchNodeCredentialsClient) Recv() (*NodeCredentialsUpdate, error) {
+	m := new(NodeCredentialsUpdate)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // KiamServiceServer is the server API for KiamService service.
 type KiamServiceServer interface {
 	GetPodRole(context.Context, *GetPodRoleRequest) (*Role, error)
 	GetPodCredentials(context.Context, *GetPodCredentialsRequest) (*Credentials, error)
 	GetHealth(context.Context, *GetHealthRequest) (*HealthStatus, error)
+	WatchNodeCredentials(*WatchNodeCredentialsRequest, KiamService_WatchNodeCredentialsServer) error
 }
 
 // UnimplementedKiamServiceServer can be embedded to have forward compatible implementations.
@@ -611,6 +1355,9 @@ func (*UnimplementedKiamServiceServer) GetPodCredentials(context.Context, *GetPo
 func (*UnimplementedKiamServiceServer) GetHealth(context.Context, *GetHealthRequest) (*HealthStatus, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetHealth not implemented")
 }
+func (*UnimplementedKiamServiceServer) WatchNodeCredentials(*WatchNodeCredentialsRequest, KiamService_WatchNodeCredentialsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchNodeCredentials not implemented")
+}
 
 func RegisterKiamServiceServer(s *grpc.Server, srv KiamServiceServer) {
 	s.RegisterService(&_KiamService_serviceDesc, srv)
@@ -670,6 +1417,27 @@ func _KiamService_GetHealth_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _KiamService_WatchNodeCredentials_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchNodeCredentialsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KiamServiceServer).WatchNodeCredentials(m, &kiamServiceWatchNodeCredentialsServer{stream})
+}
+
+type KiamService_WatchNodeCredentialsServer interface {
+	Send(*NodeCredentialsUpdate) error
+	grpc.ServerStream
+}
+
+type kiamServiceWatchNodeCredentialsServer struct {
+	grpc.ServerStream
+}
+
+func (x *kiamServiceWatchNodeCredentialsServer) Send(m *NodeCredentialsUpdate) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 var _KiamService_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "kiam.KiamService",
 	HandlerType: (*KiamServiceServer)(nil),
@@ -687,6 +1455,120 @@ var _KiamService_serviceDesc = grpc.ServiceDesc{
 			Handler:    _KiamService_GetHealth_Handler,
 		},
 	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchNodeCredentials",
+			Handler:       _KiamService_WatchNodeCredentials_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "service.proto",
+}
+
+// KiamAdminServiceClient is the client API for KiamAdminService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type KiamAdminServiceClient interface {
+	ListCachedCredentials(ctx context.Context, in *ListCachedCredentialsRequest, opts ...grpc.CallOption) (*CachedCredentialsList, error)
+	EvictCachedCredentials(ctx context.Context, in *EvictCachedCredentialsRequest, opts ...grpc.CallOption) (*EvictCachedCredentialsResult, error)
+}
+
+type kiamAdminServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewKiamAdminServiceClient(cc grpc.ClientConnInterface) KiamAdminServiceClient {
+	return &kiamAdminServiceClient{cc}
+}
+
+func (c *kiamAdminServiceClient) ListCachedCredentials(ctx context.Context, in *ListCachedCredentialsRequest, opts ...grpc.CallOption) (*CachedCredentialsList, error) {
+	out := new(CachedCredentialsList)
+	err := c.cc.Invoke(ctx, "/kiam.KiamAdminService/ListCachedCredentials", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kiamAdminServiceClient) EvictCachedCredentials(ctx context.Context, in *EvictCachedCredentialsRequest, opts ...grpc.CallOption) (*EvictCachedCredentialsResult, error) {
+	out := new(EvictCachedCredentialsResult)
+	err := c.cc.Invoke(ctx, "/kiam.KiamAdminService/EvictCachedCredentials", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// KiamAdminServiceServer is the server API for KiamAdminService service.
+type KiamAdminServiceServer interface {
+	ListCachedCredentials(context.Context, *ListCachedCredentialsRequest) (*CachedCredentialsList, error)
+	EvictCachedCredentials(context.Context, *EvictCachedCredentialsRequest) (*EvictCachedCredentialsResult, error)
+}
+
+// UnimplementedKiamAdminServiceServer can be embedded to have forward compatible implementations.
+type UnimplementedKiamAdminServiceServer struct {
+}
+
+func (*UnimplementedKiamAdminServiceServer) ListCachedCredentials(context.Context, *ListCachedCredentialsRequest) (*CachedCredentialsList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListCachedCredentials not implemented")
+}
+func (*UnimplementedKiamAdminServiceServer) EvictCachedCredentials(context.Context, *EvictCachedCredentialsRequest) (*EvictCachedCredentialsResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EvictCachedCredentials not implemented")
+}
+
+func RegisterKiamAdminServiceServer(s *grpc.Server, srv KiamAdminServiceServer) {
+	s.RegisterService(&_KiamAdminService_serviceDesc, srv)
+}
+
+func _KiamAdminService_ListCachedCredentials_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListCachedCredentialsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KiamAdminServiceServer).ListCachedCredentials(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kiam.KiamAdminService/ListCachedCredentials",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KiamAdminServiceServer).ListCachedCredentials(ctx, req.(*ListCachedCredentialsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KiamAdminService_EvictCachedCredentials_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EvictCachedCredentialsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KiamAdminServiceServer).EvictCachedCredentials(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kiam.KiamAdminService/EvictCachedCredentials",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KiamAdminServiceServer).EvictCachedCredentials(ctx, req.(*EvictCachedCredentialsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _KiamAdminService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "kiam.KiamAdminService",
+	HandlerType: (*KiamAdminServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListCachedCredentials",
+			Handler:    _KiamAdminService_ListCachedCredentials_Handler,
+		},
+		{
+			MethodName: "EvictCachedCredentials",
+			Handler:    _KiamAdminService_EvictCachedCredentials_Handler,
+		},
+	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "service.proto",
 }